@@ -115,3 +115,155 @@ func (rc *RedisClient) Close() error {
 func (rc *RedisClient) Ping(ctx context.Context) error {
 	return rc.client.Ping(ctx).Err()
 }
+
+// Incr 将 key 中储存的数字值加一
+// 参数:
+//
+//	ctx: 上下文对象
+//	key: 键名
+//
+// 返回:
+//
+//	int64: 加一之后的值
+//	error: 操作失败时返回错误
+func (rc *RedisClient) Incr(ctx context.Context, key string) (int64, error) {
+	return rc.client.Incr(ctx, key).Result()
+}
+
+// Expire 设置 key 的过期时间
+// 参数:
+//
+//	ctx: 上下文对象
+//	key: 键名
+//	expiration: 过期时间
+//
+// 返回:
+//
+//	bool: key 不存在时返回 false
+//	error: 操作失败时返回错误
+func (rc *RedisClient) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	return rc.client.Expire(ctx, key, expiration).Result()
+}
+
+// HSet 设置 hash 中指定字段的值
+// 参数:
+//
+//	ctx: 上下文对象
+//	key: 键名
+//	field: 字段名
+//	value: 值
+//
+// 返回:
+//
+//	error: 设置失败时返回错误
+func (rc *RedisClient) HSet(ctx context.Context, key, field string, value interface{}) error {
+	return rc.client.HSet(ctx, key, field, value).Err()
+}
+
+// HGetAll 获取 hash 中的所有字段和值
+// 参数:
+//
+//	ctx: 上下文对象
+//	key: 键名
+//
+// 返回:
+//
+//	map[string]string: 字段到值的映射，key 不存在时为空 map
+//	error: 获取失败时返回错误
+func (rc *RedisClient) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return rc.client.HGetAll(ctx, key).Result()
+}
+
+// HDel 删除 hash 中的一个或多个字段
+// 参数:
+//
+//	ctx: 上下文对象
+//	key: 键名
+//	fields: 要删除的字段列表
+//
+// 返回:
+//
+//	int64: 实际删除的字段数量
+//	error: 删除失败时返回错误
+func (rc *RedisClient) HDel(ctx context.Context, key string, fields ...string) (int64, error) {
+	return rc.client.HDel(ctx, key, fields...).Result()
+}
+
+// Eval 执行 Lua 脚本，脚本在 Redis 中原子执行
+// 参数:
+//
+//	ctx: 上下文对象
+//	script: Lua 脚本
+//	keys: 脚本中通过 KEYS 访问的键名列表
+//	args: 脚本中通过 ARGV 访问的参数列表
+//
+// 返回:
+//
+//	interface{}: 脚本返回值
+//	error: 执行失败时返回错误
+func (rc *RedisClient) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return rc.client.Eval(ctx, script, keys, args...).Result()
+}
+
+// RPush 将一个或多个值追加到列表尾部
+// 参数:
+//
+//	ctx: 上下文对象
+//	key: 列表键名
+//	values: 要追加的值
+//
+// 返回:
+//
+//	error: 操作失败时返回错误
+func (rc *RedisClient) RPush(ctx context.Context, key string, values ...interface{}) error {
+	return rc.client.RPush(ctx, key, values...).Err()
+}
+
+// LRange 获取列表指定区间内的元素
+// 参数:
+//
+//	ctx: 上下文对象
+//	key: 列表键名
+//	start: 起始下标，从 0 开始
+//	stop: 结束下标（包含），-1 表示最后一个元素
+//
+// 返回:
+//
+//	[]string: 区间内的元素
+//	error: 获取失败时返回错误
+func (rc *RedisClient) LRange(ctx context.Context, key string, start, stop int64) ([]string, error) {
+	return rc.client.LRange(ctx, key, start, stop).Result()
+}
+
+// LRem 从列表中删除与 value 相等的元素
+// 参数:
+//
+//	ctx: 上下文对象
+//	key: 列表键名
+//	count: 删除数量，0 表示删除全部相等的元素
+//	value: 要删除的值
+//
+// 返回:
+//
+//	int64: 实际删除的元素数量
+//	error: 删除失败时返回错误
+func (rc *RedisClient) LRem(ctx context.Context, key string, count int64, value interface{}) (int64, error) {
+	return rc.client.LRem(ctx, key, count, value).Result()
+}
+
+// ZRangeByScore 按分数从小到大获取有序集合中分数在 [min, max] 区间内的成员
+// 参数:
+//
+//	ctx: 上下文对象
+//	key: 有序集合键名
+//	min: 最小分数，"-inf" 表示不限
+//	max: 最大分数，"+inf" 表示不限
+//	count: 最多返回的成员数量，0 表示不限
+//
+// 返回:
+//
+//	[]string: 区间内的成员
+//	error: 获取失败时返回错误
+func (rc *RedisClient) ZRangeByScore(ctx context.Context, key, min, max string, count int64) ([]string, error) {
+	return rc.client.ZRangeByScore(ctx, key, &redis.ZRangeBy{Min: min, Max: max, Count: count}).Result()
+}
@@ -0,0 +1,538 @@
+package router
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const (
+	AdminRoutesPath           = "/admin/v1/routes"
+	AdminRouteDeletePath      = "/admin/v1/routes/delete"
+	AdminSequencesPath        = "/admin/v1/sequences"
+	AdminMessagesPath         = "/admin/v1/messages"
+	AdminMessageReplayPath    = "/admin/v1/messages/replay"
+	AdminDeadLettersPath      = "/admin/v1/deadletters"
+	AdminDeadLetterReplayPath = "/admin/v1/deadletters/replay"
+	AdminDelayedCancelPath    = "/admin/v1/delayed/cancel"
+
+	AdminTokenHeader = "X-Admin-Token"
+
+	defaultAdminListLimit = 20
+	// adminReplayScanLimit 重放时只在最近的这些消息中按 MsgId 查找
+	adminReplayScanLimit = 500
+	maxRecentSeqPerUser  = 50
+	maxRecentSeqUsers    = 10000
+)
+
+// StoredReliableMsg 消息存储中的一条消息
+type StoredReliableMsg struct {
+	Seq              int64
+	DeviceIdentifier string
+	MsgId            string
+	MsgData          string // InsertMsg 写入的内容
+}
+
+// AdminTokenRequiredErr 管理接口必须配置 token
+var AdminTokenRequiredErr = errors.New("admin token is required")
+
+// ReliableMsgReader 可选，MsgDB 实现后管理接口可以查看和重放已存储的消息，
+// ListMsgs 按序列号从新到旧返回最多 limit 条消息
+type ReliableMsgReader interface {
+	ListMsgs(ctx context.Context, appID, userID int, limit int) ([]*StoredReliableMsg, error)
+}
+
+// decodeStoredMsg 把 InsertMsg 写入的内容解密后还原成请求
+func (s *RouterServer) decodeStoredMsg(b MsgBinding, msgData string) (*TransferMessageRequest, error) {
+	msgData, err := s.OpenStoredMsg(b, msgData)
+	if err != nil {
+		return nil, err
+	}
+	raw, err := base64.StdEncoding.DecodeString(msgData)
+	if err != nil {
+		return nil, err
+	}
+	in := &TransferMessageRequest{}
+	if err := proto.Unmarshal(raw, in); err != nil {
+		return nil, err
+	}
+	return in, nil
+}
+
+// RecentSeq 最近生成的消息序列号
+type RecentSeq struct {
+	Seq       int64
+	MsgId     string
+	CreatedAt int64
+}
+
+// recentSeqLog 按用户保存最近生成的序列号，只用于排查问题
+type recentSeqLog struct {
+	mu   sync.Mutex
+	seqs map[string][]*RecentSeq
+}
+
+func newRecentSeqLog() *recentSeqLog {
+	return &recentSeqLog{seqs: make(map[string][]*RecentSeq)}
+}
+
+// Record createdAt 使用 RouterServer 的时钟
+func (l *recentSeqLog) Record(appID, userID string, seq int64, msgID string, createdAt time.Time) {
+	key := appID + RedisInterval + userID
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list, ok := l.seqs[key]
+	if !ok && len(l.seqs) >= maxRecentSeqUsers {
+		// 用户数超限时随机淘汰一个
+		for k := range l.seqs {
+			delete(l.seqs, k)
+			break
+		}
+	}
+	list = append(list, &RecentSeq{Seq: seq, MsgId: msgID, CreatedAt: createdAt.UnixNano() / 1000000})
+	if len(list) > maxRecentSeqPerUser {
+		list = list[len(list)-maxRecentSeqPerUser:]
+	}
+	l.seqs[key] = list
+}
+
+func (l *recentSeqLog) List(appID, userID string) []*RecentSeq {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	list := l.seqs[appID+RedisInterval+userID]
+	res := make([]*RecentSeq, len(list))
+	copy(res, list)
+	return res
+}
+
+// AdminRoute 路由查看结果
+type AdminRoute struct {
+	DeviceID string
+	Locale   string
+	Source   string
+	Addr     string
+	UA       *UserAgent
+}
+
+type AdminRouteDeleteRequest struct {
+	AppName  string
+	UserId   string
+	DeviceID string
+	Source   string
+	Addr     string // 非空时只删除仍指向该 connector 的路由
+}
+
+type AdminRouteDeleteReply struct {
+	Deleted int64
+}
+
+type AdminReplayRequest struct {
+	AppName  string
+	UserId   string
+	MsgId    string
+	DeviceID string
+}
+
+type AdminReplayReply struct {
+	DeviceIdentifiers []*DeviceIdentifier
+}
+
+// AdminDeadLetterReplayRequest 选择要重放的死信，Ids 和 MsgId 都为空时重放全部
+type AdminDeadLetterReplayRequest struct {
+	Ids   []string
+	MsgId string
+}
+
+type AdminDeadLetterReplayReply struct {
+	Replayed int
+}
+
+// AdminDelayedCancelRequest 按 AppName 和 MsgId 取消定时消息
+type AdminDelayedCancelRequest struct {
+	AppName string
+	MsgId   string
+}
+
+type AdminDelayedCancelReply struct {
+	Cancelled bool
+}
+
+// AdminAPI 排查问题用的管理接口，要求请求头 X-Admin-Token 与 token 匹配；
+// 开启多租户后也可以使用租户的 AdminTokens，只能管理该租户的 app
+type AdminAPI struct {
+	server *RouterServer
+	token  string
+	mux    *http.ServeMux
+}
+
+// NewAdminAPI token 为空时返回 AdminTokenRequiredErr
+func NewAdminAPI(server *RouterServer, token string) (*AdminAPI, error) {
+	if len(token) == 0 {
+		return nil, AdminTokenRequiredErr
+	}
+	a := &AdminAPI{
+		server: server,
+		token:  token,
+		mux:    http.NewServeMux(),
+	}
+	a.mux.HandleFunc(AdminRoutesPath, a.handleListRoutes)
+	a.mux.HandleFunc(AdminRouteDeletePath, a.handleDeleteRoute)
+	a.mux.HandleFunc(AdminSequencesPath, a.handleListSequences)
+	a.mux.HandleFunc(AdminMessagesPath, a.handleListMessages)
+	a.mux.HandleFunc(AdminMessageReplayPath, a.handleReplay)
+	a.mux.HandleFunc(AdminDeadLettersPath, a.handleListDeadLetters)
+	a.mux.HandleFunc(AdminDeadLetterReplayPath, a.handleReplayDeadLetters)
+	a.mux.HandleFunc(AdminDelayedCancelPath, a.handleCancelDelayed)
+	return a, nil
+}
+
+func (a *AdminAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	appName, ok := a.authorize(r)
+	if !ok {
+		writeJSON(w, http.StatusUnauthorized, &HTTPErrorBody{Code: CodeUnauthenticated.String(), Message: "invalid admin token"})
+		return
+	}
+	if len(appName) > 0 {
+		r = r.WithContext(context.WithValue(r.Context(), adminAppKey{}, appName))
+	}
+	a.mux.ServeHTTP(w, r)
+}
+
+// authorize 返回 token 可以管理的 app，全局 token 返回空字符串表示所有 app；
+// 未配置 token 时拒绝所有请求，比较 token 时使用常量时间
+func (a *AdminAPI) authorize(r *http.Request) (appName string, ok bool) {
+	if a == nil || len(a.token) == 0 {
+		return "", false
+	}
+	token := r.Header.Get(AdminTokenHeader)
+	if subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+		return "", true
+	}
+	if a.server == nil {
+		return "", false
+	}
+	return a.server.Tenants.adminApp(token)
+}
+
+type adminAppKey struct{}
+
+// checkAdminApp 租户的 token 只能管理该租户的 app
+func checkAdminApp(ctx context.Context, appName string) error {
+	scope, _ := ctx.Value(adminAppKey{}).(string)
+	if len(scope) == 0 || scope == appName {
+		return nil
+	}
+	return NewStatusError(CodePermissionDenied, fmt.Sprintf("admin token is not allowed for app %v", appName))
+}
+
+// checkGet 查询接口只允许 GET
+func checkGet(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		w.Header().Set("Allow", http.MethodGet)
+		writeJSON(w, http.StatusMethodNotAllowed, &HTTPErrorBody{Code: "MethodNotAllowed", Message: "only GET is allowed"})
+		return false
+	}
+	return true
+}
+
+// handleListRoutes GET ?app=&user=&device=，返回 PickConnectors 看到的路由
+func (a *AdminAPI) handleListRoutes(w http.ResponseWriter, r *http.Request) {
+	if !checkGet(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	appName, userID := q.Get("app"), q.Get("user")
+	if len(appName) == 0 || len(userID) == 0 {
+		writeHTTPError(w, &InvalidRequestError{Reason: "app and user are required"})
+		return
+	}
+	if err := checkAdminApp(r.Context(), appName); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	wrappers := a.server.router.PickConnectors(r.Context(), appName, userID, q.Get("device"), nil)
+	routes := make([]*AdminRoute, 0, len(wrappers))
+	for _, w := range wrappers {
+		routes = append(routes, &AdminRoute{
+			DeviceID: w.DeviceID,
+			Locale:   w.Locale,
+			Source:   w.Source,
+			Addr:     w.Addr,
+			UA:       w.UA,
+		})
+	}
+	writeJSON(w, http.StatusOK, routes)
+}
+
+// handleDeleteRoute POST AdminRouteDeleteRequest，通过 HCAD/HCADSR 强制删除路由
+func (a *AdminAPI) handleDeleteRoute(w http.ResponseWriter, r *http.Request) {
+	if !checkJSONPost(w, r) {
+		return
+	}
+	req := &AdminRouteDeleteRequest{}
+	if err := decodeJSONBody(r, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if len(req.AppName) == 0 || len(req.UserId) == 0 || len(req.DeviceID) == 0 {
+		writeHTTPError(w, &InvalidRequestError{Reason: "AppName, UserId and DeviceID are required"})
+		return
+	}
+	if err := checkAdminApp(r.Context(), req.AppName); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	n, err := a.server.compareAndDeleteRoute(r.Context(), req.AppName, req.UserId, req.DeviceID, req.Source, req.Addr)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	Applog.Infof("delete router info by admin, uid: %v, deviceID %v, source: %v, deleted: %v", req.UserId, req.DeviceID, req.Source, n)
+	writeJSON(w, http.StatusOK, &AdminRouteDeleteReply{Deleted: n})
+}
+
+// handleListSequences GET ?app=&user=，返回本实例最近生成的序列号
+func (a *AdminAPI) handleListSequences(w http.ResponseWriter, r *http.Request) {
+	if !checkGet(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	appName, userID := q.Get("app"), q.Get("user")
+	if len(appName) == 0 || len(userID) == 0 {
+		writeHTTPError(w, &InvalidRequestError{Reason: "app and user are required"})
+		return
+	}
+	if err := checkAdminApp(r.Context(), appName); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	var seqs []*RecentSeq
+	if a.server.recentSeqs != nil {
+		seqs = a.server.recentSeqs.List(appName, userID)
+	}
+	writeJSON(w, http.StatusOK, seqs)
+}
+
+// handleListMessages GET ?app=&user=&limit=，返回已存储的消息
+func (a *AdminAPI) handleListMessages(w http.ResponseWriter, r *http.Request) {
+	if !checkGet(w, r) {
+		return
+	}
+	q := r.URL.Query()
+	appName, userID := q.Get("app"), q.Get("user")
+	limit := defaultAdminListLimit
+	if s := q.Get("limit"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeHTTPError(w, &InvalidRequestError{Reason: "invalid limit"})
+			return
+		}
+		limit = n
+	}
+	msgs, err := a.listStoredMsgs(r.Context(), appName, userID, limit)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	userIdInt, _ := strconv.Atoi(userID)
+	res := make([]*TransferMessageRequest, 0, len(msgs))
+	for _, m := range msgs {
+		in, err := a.server.decodeStoredMsg(storedMsgBinding(appName, userIdInt, m.MsgId), m.MsgData)
+		if err != nil {
+			Applog.Errorf("decode stored msg err:%+v msgId: %v seq: %v", err, m.MsgId, m.Seq)
+			continue
+		}
+		res = append(res, in)
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// handleReplay POST AdminReplayRequest，把已存储的消息通过投递调度重新下发到指定设备
+func (a *AdminAPI) handleReplay(w http.ResponseWriter, r *http.Request) {
+	if !checkJSONPost(w, r) {
+		return
+	}
+	req := &AdminReplayRequest{}
+	if err := decodeJSONBody(r, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if len(req.MsgId) == 0 || len(req.DeviceID) == 0 {
+		writeHTTPError(w, &InvalidRequestError{Reason: "MsgId and DeviceID are required"})
+		return
+	}
+	msgs, err := a.listStoredMsgs(r.Context(), req.AppName, req.UserId, adminReplayScanLimit)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	userIdInt, _ := strconv.Atoi(req.UserId)
+	var in *TransferMessageRequest
+	for _, m := range msgs {
+		if m.MsgId == req.MsgId {
+			if in, err = a.server.decodeStoredMsg(storedMsgBinding(req.AppName, userIdInt, m.MsgId), m.MsgData); err != nil {
+				writeHTTPError(w, err)
+				return
+			}
+			break
+		}
+	}
+	if in == nil {
+		writeJSON(w, http.StatusNotFound, &HTTPErrorBody{Code: "NotFound", Message: fmt.Sprintf("msg %v not found", req.MsgId)})
+		return
+	}
+	ctx := Tracing.PropagateContextWithServiceContext(context.WithoutCancel(r.Context()))
+	wrappers := a.server.router.PickConnectors(ctx, in.AppName, in.ReceiverId, req.DeviceID, nil)
+	rpl := &AdminReplayReply{}
+	for _, w := range wrappers {
+		rpl.DeviceIdentifiers = append(rpl.DeviceIdentifiers, &DeviceIdentifier{Identifer: w.DeviceID, IsOnline: true})
+	}
+	if len(wrappers) > 0 {
+		Applog.Infof("replay msg by admin, msgId: %v, uid: %v, deviceID: %v", in.MsgId, in.ReceiverId, req.DeviceID)
+		if err := a.server.dispatch(ctx, in, wrappers); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, rpl)
+}
+
+func (a *AdminAPI) listStoredMsgs(ctx context.Context, appName, userID string, limit int) ([]*StoredReliableMsg, error) {
+	reader, ok := a.server.MsgDB.(ReliableMsgReader)
+	if !ok {
+		return nil, NewStatusError(CodeUnavailable, "msg db does not support listing messages")
+	}
+	if len(appName) == 0 || len(userID) == 0 {
+		return nil, &InvalidRequestError{Reason: "app and user are required"}
+	}
+	if err := checkAdminApp(ctx, appName); err != nil {
+		return nil, err
+	}
+	userIdInt, err := strconv.Atoi(userID)
+	if err != nil {
+		return nil, &InvalidRequestError{Reason: "user id is not valid"}
+	}
+	return reader.ListMsgs(ctx, a.server.appIndex(appName), userIdInt, limit)
+}
+
+// adminDeadLetterVisible 租户的 token 只能看到和重放该租户的死信
+func adminDeadLetterVisible(ctx context.Context, dl *DeadLetter) bool {
+	return dl.Request != nil && checkAdminApp(ctx, dl.Request.AppName) == nil
+}
+
+// handleListDeadLetters GET ?limit=，查看死信
+func (a *AdminAPI) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !checkGet(w, r) {
+		return
+	}
+	if a.server.DeadLetters == nil {
+		writeHTTPError(w, NewStatusError(CodeUnavailable, "dead letter sink is not configured"))
+		return
+	}
+	limit := defaultAdminListLimit
+	if s := r.URL.Query().Get("limit"); len(s) > 0 {
+		n, err := strconv.Atoi(s)
+		if err != nil || n <= 0 {
+			writeHTTPError(w, &InvalidRequestError{Reason: "invalid limit"})
+			return
+		}
+		limit = n
+	}
+	scoped := checkAdminApp(r.Context(), "") != nil
+	listLimit := limit
+	if scoped {
+		listLimit = 0
+	}
+	dls, err := a.server.DeadLetters.List(r.Context(), listLimit)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	for i, dl := range dls {
+		// 解密失败时返回只有路由字段的死信
+		if dls[i], err = a.server.openDeadLetter(dl); err != nil {
+			Applog.Errorf("decrypt dead letter err:%+v id: %v", err, dl.Id)
+		}
+	}
+	if scoped {
+		visible := make([]*DeadLetter, 0, len(dls))
+		for _, dl := range dls {
+			if adminDeadLetterVisible(r.Context(), dl) && len(visible) < limit {
+				visible = append(visible, dl)
+			}
+		}
+		dls = visible
+	}
+	writeJSON(w, http.StatusOK, dls)
+}
+
+// handleReplayDeadLetters POST AdminDeadLetterReplayRequest，通过 RouterServer 重新提交死信
+func (a *AdminAPI) handleReplayDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if !checkJSONPost(w, r) {
+		return
+	}
+	if a.server.DeadLetters == nil {
+		writeHTTPError(w, NewStatusError(CodeUnavailable, "dead letter sink is not configured"))
+		return
+	}
+	req := &AdminDeadLetterReplayRequest{}
+	if err := decodeJSONBody(r, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	ids := make(map[string]bool, len(req.Ids))
+	for _, id := range req.Ids {
+		ids[id] = true
+	}
+	scope := r.Context()
+	filter := func(dl *DeadLetter) bool {
+		if !adminDeadLetterVisible(scope, dl) {
+			return false
+		}
+		if len(ids) > 0 && !ids[dl.Id] {
+			return false
+		}
+		return len(req.MsgId) == 0 || dl.Request.GetMsgId() == req.MsgId
+	}
+	ctx := Tracing.PropagateContextWithServiceContext(context.WithoutCancel(r.Context()))
+	n, err := a.server.ReplayDeadLetters(ctx, filter)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	Applog.Infof("replay dead letters by admin, replayed: %v", n)
+	writeJSON(w, http.StatusOK, &AdminDeadLetterReplayReply{Replayed: n})
+}
+
+// handleCancelDelayed POST AdminDelayedCancelRequest，取消尚未下发的定时消息
+func (a *AdminAPI) handleCancelDelayed(w http.ResponseWriter, r *http.Request) {
+	if !checkJSONPost(w, r) {
+		return
+	}
+	req := &AdminDelayedCancelRequest{}
+	if err := decodeJSONBody(r, req); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if len(req.AppName) == 0 || len(req.MsgId) == 0 {
+		writeHTTPError(w, &InvalidRequestError{Reason: "AppName and MsgId are required"})
+		return
+	}
+	if err := checkAdminApp(r.Context(), req.AppName); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	ok, err := a.server.CancelDelayedMessage(r.Context(), req.AppName, req.MsgId)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	Applog.Infof("cancel delayed msg by admin, app: %v, msgId: %v, cancelled: %v", req.AppName, req.MsgId, ok)
+	writeJSON(w, http.StatusOK, &AdminDelayedCancelReply{Cancelled: ok})
+}
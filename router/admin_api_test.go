@@ -0,0 +1,318 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testAdminToken = "admin-secret"
+
+func newTestAdminAPI(t *testing.T, s *RouterServer) *AdminAPI {
+	t.Helper()
+	a, err := NewAdminAPI(s, testAdminToken)
+	assert.NoError(t, err)
+	return a
+}
+
+// adminDo 带上 token 调用管理接口，body 不为空时以 JSON POST
+func adminDo(h http.Handler, token, path string, body interface{}) *httptest.ResponseRecorder {
+	method, reader := http.MethodGet, &bytes.Buffer{}
+	if body != nil {
+		method = http.MethodPost
+		json.NewEncoder(reader).Encode(body)
+	}
+	r := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		r.Header.Set("Content-Type", httpContentTypeJSON)
+	}
+	if token != "" {
+		r.Header.Set(AdminTokenHeader, token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+	return w
+}
+
+func TestNewAdminAPIRequiresToken(t *testing.T) {
+	a, err := NewAdminAPI(newTestServer(t).RouterServer, "")
+	assert.Nil(t, a)
+	assert.Equal(t, AdminTokenRequiredErr, err)
+}
+
+func TestAdminAPIAuth(t *testing.T) {
+	ts := newTestServer(t, iosDevice("d1", &fakeConnector{}))
+	testCases := []struct {
+		name       string
+		handler    http.Handler
+		token      string
+		wantStatus int
+	}{
+		{name: "valid-token", handler: newTestAdminAPI(t, ts.RouterServer), token: testAdminToken, wantStatus: http.StatusOK},
+		{name: "missing-token", handler: newTestAdminAPI(t, ts.RouterServer), wantStatus: http.StatusUnauthorized},
+		{name: "wrong-token", handler: newTestAdminAPI(t, ts.RouterServer), token: testAdminToken + "x", wantStatus: http.StatusUnauthorized},
+		{name: "prefix-token", handler: newTestAdminAPI(t, ts.RouterServer), token: "admin", wantStatus: http.StatusUnauthorized},
+		{name: "zero-value-refuses", handler: &AdminAPI{}, token: testAdminToken, wantStatus: http.StatusUnauthorized},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := adminDo(tc.handler, tc.token, AdminRoutesPath+"?app=im&user=42", nil)
+			assert.Equal(t, tc.wantStatus, w.Code)
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var routes []*AdminRoute
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &routes))
+			if assert.Len(t, routes, 1) {
+				assert.Equal(t, "d1", routes[0].DeviceID)
+			}
+		})
+	}
+}
+
+func TestAdminAPIReplay(t *testing.T) {
+	testCases := []struct {
+		name       string
+		msgID      string
+		deviceID   string
+		fillQueue  bool
+		wantStatus int
+		wantQueued bool
+	}{
+		{name: "dispatched-through-scheduler", msgID: "m1", deviceID: "d1", wantStatus: http.StatusOK, wantQueued: true},
+		{name: "device-offline", msgID: "m1", deviceID: "d2", wantStatus: http.StatusOK},
+		{name: "not-found", msgID: "m2", deviceID: "d1", wantStatus: http.StatusNotFound},
+		{name: "queue-full", msgID: "m1", deviceID: "d1", fillQueue: true, wantStatus: http.StatusServiceUnavailable},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withServiceConfig(t, func(c *config) { c.Service.IsStoreReliableMsg = true })
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			_, err := ts.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+			assert.NoError(t, err)
+			ts.observer.next(t, EventMsgDelivered)
+
+			ds, _ := newTestScheduler(t, 1, []DeliveryLaneConfig{{Priority: MSG_PRIORITY_NORMAL, QueueSize: 1, Weight: 1}})
+			ts.Scheduler = ds
+			release := blockWorker(t, ds)
+			if tc.fillQueue {
+				assert.NoError(t, ds.Submit(MSG_PRIORITY_NORMAL, func() {}))
+			}
+
+			w := adminDo(newTestAdminAPI(t, ts.RouterServer), testAdminToken, AdminMessageReplayPath,
+				&AdminReplayRequest{AppName: "im", UserId: "42", MsgId: tc.msgID, DeviceID: tc.deviceID})
+			assert.Equal(t, tc.wantStatus, w.Code)
+			assert.Equal(t, []int{adminReplayScanLimit}, ts.db.limits)
+			if !tc.wantQueued {
+				release()
+				assert.Len(t, conn.requests(), 1)
+				return
+			}
+			// 重放进入调度队列，由 worker 下发而不是在请求中同步下发
+			assert.Equal(t, 1, ds.QueueLen(MSG_PRIORITY_NORMAL))
+			assert.Len(t, conn.requests(), 1)
+			var rpl AdminReplayReply
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rpl))
+			assert.Equal(t, []*DeviceIdentifier{{Identifer: "d1", IsOnline: true}}, rpl.DeviceIdentifiers)
+
+			release()
+			ts.observer.next(t, EventMsgDelivered)
+			if reqs := conn.requests(); assert.Len(t, reqs, 2) {
+				assert.Equal(t, "m1", reqs[1].MsgId)
+			}
+		})
+	}
+}
+
+func TestAdminAPIListSequences(t *testing.T) {
+	ts := newTestServer(t, iosDevice("d1", &fakeConnector{}))
+	_, err := ts.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+	assert.NoError(t, err)
+
+	w := adminDo(newTestAdminAPI(t, ts.RouterServer), testAdminToken, AdminSequencesPath+"?app=im&user=42", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var seqs []*RecentSeq
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &seqs))
+	if assert.Len(t, seqs, 1) {
+		assert.Equal(t, "m1", seqs[0].MsgId)
+		// 使用 RouterServer 的时钟
+		assert.Equal(t, testNowMs(), seqs[0].CreatedAt)
+	}
+}
+
+func TestAdminAPIGetOnly(t *testing.T) {
+	a := newTestAdminAPI(t, newTestServer(t).RouterServer)
+	paths := []string{AdminRoutesPath, AdminSequencesPath, AdminMessagesPath, AdminDeadLettersPath}
+	for _, path := range paths {
+		for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodDelete, http.MethodHead} {
+			t.Run(method+path, func(t *testing.T) {
+				r := httptest.NewRequest(method, path+"?app=im&user=42", nil)
+				r.Header.Set(AdminTokenHeader, testAdminToken)
+				w := httptest.NewRecorder()
+				a.ServeHTTP(w, r)
+				assert.Equal(t, http.StatusMethodNotAllowed, w.Code)
+				assert.Equal(t, http.MethodGet, w.Header().Get("Allow"))
+			})
+		}
+	}
+}
+
+func TestAdminAPIListMessages(t *testing.T) {
+	withServiceConfig(t, func(c *config) { c.Service.IsStoreReliableMsg = true })
+	ts := newTestServer(t, iosDevice("d1", &fakeConnector{}))
+	for _, id := range []string{"m1", "m2", "m3"} {
+		in := newTestRequest()
+		in.MsgId = id
+		_, err := ts.TransferOnlineReliableMessage(context.Background(), in)
+		assert.NoError(t, err)
+	}
+	a := newTestAdminAPI(t, ts.RouterServer)
+
+	testCases := []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantIDs    []string
+	}{
+		{name: "default-limit", query: "?app=im&user=42", wantStatus: http.StatusOK, wantIDs: []string{"m3", "m2", "m1"}},
+		{name: "limit", query: "?app=im&user=42&limit=2", wantStatus: http.StatusOK, wantIDs: []string{"m3", "m2"}},
+		{name: "invalid-limit", query: "?app=im&user=42&limit=0", wantStatus: http.StatusBadRequest},
+		{name: "missing-user", query: "?app=im", wantStatus: http.StatusBadRequest},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := adminDo(a, testAdminToken, AdminMessagesPath+tc.query, nil)
+			assert.Equal(t, tc.wantStatus, w.Code)
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var msgs []*TransferMessageRequest
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &msgs))
+			var ids []string
+			for _, m := range msgs {
+				ids = append(ids, m.MsgId)
+			}
+			assert.Equal(t, tc.wantIDs, ids)
+		})
+	}
+}
+
+func TestAdminAPIDeleteRoute(t *testing.T) {
+	ts := newTestServer(t)
+	store := &cadStore{deleted: 1}
+	ts.Store = store
+	a := newTestAdminAPI(t, ts.RouterServer)
+
+	w := adminDo(a, testAdminToken, AdminRouteDeletePath, &AdminRouteDeleteRequest{AppName: "im", UserId: "42"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminDo(a, testAdminToken, AdminRouteDeletePath, &AdminRouteDeleteRequest{AppName: "im", UserId: "42", DeviceID: "d1", Addr: "10.0.0.1:80"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, []cadCall{{op: "HCADSR", userId: "42", deviceID: "d1", addr: "10.0.0.1:80"}}, store.recorded())
+}
+
+// newTestTenants 注册 im 和 live 两个租户，各自有只能管理本租户的 admin token
+func newTestTenants(t *testing.T) *TenantRegistry {
+	t.Helper()
+	r, err := NewTenantRegistry([]*TenantConfig{
+		{AppName: "im", AppIndex: 1, KeyPrefix: "{im}", AdminTokens: []string{"im-admin"}},
+		{AppName: "live", AppIndex: 2, KeyPrefix: "{live}", AdminTokens: []string{"live-admin"}},
+	})
+	assert.NoError(t, err)
+	return r
+}
+
+func TestAdminAPITenantScope(t *testing.T) {
+	testCases := []struct {
+		name       string
+		token      string
+		path       string
+		body       interface{}
+		wantStatus int
+	}{
+		{name: "global-token-any-app", token: testAdminToken, path: AdminRoutesPath + "?app=live&user=42", wantStatus: http.StatusOK},
+		{name: "tenant-token-own-app", token: "im-admin", path: AdminRoutesPath + "?app=im&user=42", wantStatus: http.StatusOK},
+		{name: "tenant-token-other-app", token: "live-admin", path: AdminRoutesPath + "?app=im&user=42", wantStatus: http.StatusForbidden},
+		{name: "unknown-token", token: "other-admin", path: AdminRoutesPath + "?app=im&user=42", wantStatus: http.StatusUnauthorized},
+		{name: "sequences-other-app", token: "live-admin", path: AdminSequencesPath + "?app=im&user=42", wantStatus: http.StatusForbidden},
+		{name: "messages-other-app", token: "live-admin", path: AdminMessagesPath + "?app=im&user=42", wantStatus: http.StatusForbidden},
+		{name: "delete-route-other-app", token: "live-admin", path: AdminRouteDeletePath,
+			body: &AdminRouteDeleteRequest{AppName: "im", UserId: "42", DeviceID: "d1"}, wantStatus: http.StatusForbidden},
+		{name: "replay-other-app", token: "live-admin", path: AdminMessageReplayPath,
+			body: &AdminReplayRequest{AppName: "im", UserId: "42", MsgId: "m1", DeviceID: "d1"}, wantStatus: http.StatusForbidden},
+		{name: "cancel-delayed-other-app", token: "live-admin", path: AdminDelayedCancelPath,
+			body: &AdminDelayedCancelRequest{AppName: "im", MsgId: "m1"}, wantStatus: http.StatusForbidden},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t, iosDevice("d1", &fakeConnector{}))
+			ts.Tenants = newTestTenants(t)
+			newTestDelayed(t, ts)
+
+			w := adminDo(newTestAdminAPI(t, ts.RouterServer), tc.token, tc.path, tc.body)
+			assert.Equal(t, tc.wantStatus, w.Code)
+		})
+	}
+}
+
+func TestAdminAPITenantDeadLetters(t *testing.T) {
+	ts := newTestServer(t, iosDevice("d1", &fakeConnector{}))
+	ts.Tenants = newTestTenants(t)
+	ts.DeadLetters = NewFileDeadLetterSink(filepath.Join(t.TempDir(), "dead_letters.jsonl"))
+	ctx := context.Background()
+	for i, app := range []string{"live", "im", "live", "im"} {
+		in := newTestRequest()
+		in.AppName = app
+		in.MsgId = fmt.Sprintf("m%d", i)
+		assert.NoError(t, ts.DeadLetters.Put(ctx, newDeadLetter(in, "d1", DeadLetterStageDeliver, errors.New("boom"), 0, testNow.Add(time.Duration(i)))))
+	}
+	a := newTestAdminAPI(t, ts.RouterServer)
+	testCases := []struct {
+		name  string
+		token string
+		limit int
+		want  []string
+	}{
+		{name: "global-token-sees-all", token: testAdminToken, want: []string{"m0", "m1", "m2", "m3"}},
+		{name: "tenant-token-sees-own", token: "im-admin", want: []string{"m1", "m3"}},
+		{name: "limit-applies-after-filter", token: "im-admin", limit: 1, want: []string{"m1"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := AdminDeadLettersPath
+			if tc.limit > 0 {
+				path += fmt.Sprintf("?limit=%d", tc.limit)
+			}
+			w := adminDo(a, tc.token, path, nil)
+			assert.Equal(t, http.StatusOK, w.Code)
+			var dls []*DeadLetter
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &dls))
+			var ids []string
+			for _, dl := range dls {
+				ids = append(ids, dl.Request.MsgId)
+			}
+			assert.Equal(t, tc.want, ids)
+		})
+	}
+
+	// 租户的 token 只重放本租户的死信
+	w := adminDo(a, "im-admin", AdminDeadLetterReplayPath, &AdminDeadLetterReplayRequest{})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Replayed":2}`, w.Body.String())
+	dls, err := ts.DeadLetters.List(ctx, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, dls, 2) {
+		assert.Equal(t, "live", dls[0].Request.AppName)
+		assert.Equal(t, "live", dls[1].Request.AppName)
+	}
+}
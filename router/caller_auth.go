@@ -0,0 +1,354 @@
+package router
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// 签名请求的 metadata，gRPC metadata 和 HTTP 请求头使用同一个名字
+	CallerIDHeader        = "x-caller-id"
+	CallerTimestampHeader = "x-caller-timestamp"
+	CallerNonceHeader     = "x-caller-nonce"
+	CallerSignatureHeader = "x-caller-signature"
+
+	DefaultMaxClockSkew = 5 * time.Minute
+
+	MetricCallerUnauthenticated = "router_caller_unauthenticated"
+	MetricCallerForbidden       = "router_caller_forbidden"
+	MetricCallerReplayed        = "router_caller_replayed"
+
+	// CallerNonceKeyPrefix 共享 nonce 的 Redis key 前缀，key 为 前缀_callerID_nonce
+	CallerNonceKeyPrefix = "router_caller_nonce"
+
+	callerAllApps = "*"
+)
+
+// CallerPolicy 一个调用方的凭证和权限
+type CallerPolicy struct {
+	CallerID string
+	// APIKey 静态 key，通过 x-api-key 传递
+	APIKey string
+	// HMACSecret 签名密钥，请求通过 x-caller-id、x-caller-timestamp、x-caller-nonce、x-caller-signature 传递签名
+	HMACSecret string
+	// Apps 允许发送的 app，"*" 表示全部
+	Apps []string
+	// MsgTypes 允许发送的 MsgType，为空表示全部
+	MsgTypes []int32
+	Disabled bool
+}
+
+func (p *CallerPolicy) allowApp(appName string) bool {
+	for _, a := range p.Apps {
+		if a == callerAllApps || a == appName {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *CallerPolicy) allowMsgType(msgType int32) bool {
+	if len(p.MsgTypes) == 0 {
+		return true
+	}
+	for _, t := range p.MsgTypes {
+		if t == msgType {
+			return true
+		}
+	}
+	return false
+}
+
+// CallerAuthenticator 在本地校验调用方身份并检查权限；
+// 签名请求的 nonce 在时钟偏差窗口内只能使用一次，Store 实现 NonceRecorder 时记录在 Redis 中由所有实例共享，
+// 否则每个实例各自记录，请求被路由到其它实例时仍可以重放
+type CallerAuthenticator struct {
+	// Store 可选，实现 NonceRecorder 时使用 SET NX PX 记录 nonce
+	Store RouterRedisClient
+
+	maxClockSkew time.Duration
+	now          func() time.Time
+
+	mu       sync.RWMutex
+	byID     map[string]*CallerPolicy
+	byAPIKey map[string]*CallerPolicy
+
+	nonceMu   sync.Mutex
+	nonces    map[string]time.Time // callerID + nonce -> 过期时间
+	nextSweep time.Time
+}
+
+func NewCallerAuthenticator(policies []*CallerPolicy, maxClockSkew time.Duration) (*CallerAuthenticator, error) {
+	if maxClockSkew <= 0 {
+		maxClockSkew = DefaultMaxClockSkew
+	}
+	a := &CallerAuthenticator{maxClockSkew: maxClockSkew, now: time.Now, nonces: make(map[string]time.Time)}
+	if err := a.Replace(policies); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// LoadCallerAuthenticator 从 JSON 文件加载调用方，文件内容为 CallerPolicy 数组
+func LoadCallerAuthenticator(path string, maxClockSkew time.Duration) (*CallerAuthenticator, error) {
+	policies, err := readCallerPolicies(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewCallerAuthenticator(policies, maxClockSkew)
+}
+
+// Reload 重新读取调用方文件，文件不合法时保留原有配置
+func (a *CallerAuthenticator) Reload(path string) error {
+	policies, err := readCallerPolicies(path)
+	if err != nil {
+		return err
+	}
+	return a.Replace(policies)
+}
+
+func readCallerPolicies(path string) ([]*CallerPolicy, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var policies []*CallerPolicy
+	if err := json.Unmarshal(data, &policies); err != nil {
+		return nil, fmt.Errorf("parse caller policies %v: %v", path, err)
+	}
+	return policies, nil
+}
+
+// Replace 校验 CallerID 和 APIKey 不重复后整体替换
+func (a *CallerAuthenticator) Replace(policies []*CallerPolicy) error {
+	byID := make(map[string]*CallerPolicy, len(policies))
+	byAPIKey := make(map[string]*CallerPolicy, len(policies))
+	for _, p := range policies {
+		if p == nil || len(p.CallerID) == 0 {
+			return fmt.Errorf("caller id is empty")
+		}
+		if len(p.APIKey) == 0 && len(p.HMACSecret) == 0 {
+			return fmt.Errorf("caller %v has neither api key nor hmac secret", p.CallerID)
+		}
+		if _, ok := byID[p.CallerID]; ok {
+			return fmt.Errorf("duplicate caller %v", p.CallerID)
+		}
+		byID[p.CallerID] = p
+		if len(p.APIKey) > 0 {
+			if other, ok := byAPIKey[p.APIKey]; ok {
+				return fmt.Errorf("caller %v and %v share the same api key", other.CallerID, p.CallerID)
+			}
+			byAPIKey[p.APIKey] = p
+		}
+	}
+	a.mu.Lock()
+	a.byID = byID
+	a.byAPIKey = byAPIKey
+	a.mu.Unlock()
+	return nil
+}
+
+// Authenticate 优先校验签名，没有签名时校验 API key
+func (a *CallerAuthenticator) Authenticate(ctx context.Context, in *TransferMessageRequest) (*CallerPolicy, error) {
+	md, _ := MetadataFromIncomingContext(ctx)
+	if callerID := md.Get(CallerIDHeader); len(callerID) > 0 {
+		body, err := transferRequestBody(ctx, in)
+		if err != nil {
+			return nil, NewStatusError(CodeUnauthenticated, fmt.Sprintf("encode request for signature: %v", err))
+		}
+		return a.authenticateSignature(ctx, md, callerID, body)
+	}
+	key := md.Get(APIKeyHeader)
+	if len(key) == 0 {
+		return nil, NewStatusError(CodeUnauthenticated, "missing caller credentials")
+	}
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	// map 查找不是常量时间，再做一次常量时间比较确认
+	p, ok := a.byAPIKey[key]
+	if !ok || subtle.ConstantTimeCompare([]byte(p.APIKey), []byte(key)) != 1 {
+		return nil, NewStatusError(CodeUnauthenticated, "invalid api key")
+	}
+	return p, nil
+}
+
+func (a *CallerAuthenticator) authenticateSignature(ctx context.Context, md Metadata, callerID string, body []byte) (*CallerPolicy, error) {
+	a.mu.RLock()
+	p, ok := a.byID[callerID]
+	a.mu.RUnlock()
+	if !ok || len(p.HMACSecret) == 0 {
+		return nil, NewStatusError(CodeUnauthenticated, fmt.Sprintf("unknown caller %v", callerID))
+	}
+	ts, err := strconv.ParseInt(md.Get(CallerTimestampHeader), 10, 64)
+	if err != nil {
+		return nil, NewStatusError(CodeUnauthenticated, "invalid caller timestamp")
+	}
+	now := a.now()
+	signedAt := time.Unix(0, ts*int64(time.Millisecond))
+	skew := now.Sub(signedAt)
+	if skew > a.maxClockSkew || skew < -a.maxClockSkew {
+		return nil, NewStatusError(CodeUnauthenticated, "caller timestamp out of range")
+	}
+	nonce := md.Get(CallerNonceHeader)
+	if len(nonce) == 0 {
+		return nil, NewStatusError(CodeUnauthenticated, "missing caller nonce")
+	}
+	mac := transferRequestMAC(p.HMACSecret, callerID, ts, nonce, body)
+	sig, err := hex.DecodeString(md.Get(CallerSignatureHeader))
+	if err != nil || !hmac.Equal(sig, mac) {
+		return nil, NewStatusError(CodeUnauthenticated, "invalid caller signature")
+	}
+	// 签名校验通过后才记录 nonce，避免伪造的请求占满缓存
+	fresh, err := a.useNonce(ctx, callerID, nonce, now, signedAt.Add(a.maxClockSkew))
+	if err != nil {
+		// 无法确认是否重放时拒绝请求，调用方重新签名后重试
+		Applog.Errorf("record caller nonce failed, caller: %v, err: %v", callerID, err)
+		return nil, NewStatusError(CodeUnavailable, "record caller nonce failed")
+	}
+	if !fresh {
+		Metrics.Counter(MetricCallerReplayed, 1)
+		return nil, NewStatusError(CodeUnauthenticated, "caller nonce already used")
+	}
+	return p, nil
+}
+
+// useNonce nonce 在 expireAt 之前第一次使用时返回 true，时间戳超出窗口的请求已经被拒绝，
+// 所以过期的 nonce 可以直接清理
+func (a *CallerAuthenticator) useNonce(ctx context.Context, callerID, nonce string, now, expireAt time.Time) (bool, error) {
+	key := callerID + RedisInterval + nonce
+	if recorder, ok := a.Store.(NonceRecorder); ok {
+		ttl := expireAt.Sub(now)
+		if ttl < time.Millisecond {
+			ttl = time.Millisecond
+		}
+		return recorder.SetNX(ctx, CallerNonceKeyPrefix+RedisInterval+key, ttl)
+	}
+	a.nonceMu.Lock()
+	defer a.nonceMu.Unlock()
+	if !now.Before(a.nextSweep) {
+		for k, exp := range a.nonces {
+			if !now.Before(exp) {
+				delete(a.nonces, k)
+			}
+		}
+		a.nextSweep = now.Add(a.maxClockSkew)
+	}
+	if exp, ok := a.nonces[key]; ok && now.Before(exp) {
+		return false, nil
+	}
+	a.nonces[key] = expireAt
+	return true, nil
+}
+
+// Authorize 检查调用方是否可以给该 app 发送该 MsgType 的消息
+func (a *CallerAuthenticator) Authorize(p *CallerPolicy, in *TransferMessageRequest) error {
+	if p.Disabled {
+		return NewStatusError(CodePermissionDenied, fmt.Sprintf("caller %v is disabled", p.CallerID))
+	}
+	if !p.allowApp(in.AppName) {
+		return NewStatusError(CodePermissionDenied, fmt.Sprintf("caller %v is not allowed to send to app %v", p.CallerID, in.AppName))
+	}
+	if !p.allowMsgType(in.GetMsgType()) {
+		return NewStatusError(CodePermissionDenied, fmt.Sprintf("caller %v is not allowed to send msg type %d", p.CallerID, in.GetMsgType()))
+	}
+	return nil
+}
+
+type signedBodyKey struct{}
+
+// withSignedBody 记录请求在传输层的原始字节，签名覆盖这些字节而不是解码后的结构体，
+// 解码时丢失的差异（例如空 map 和 nil）不会影响签名校验
+func withSignedBody(ctx context.Context, body []byte) context.Context {
+	return context.WithValue(ctx, signedBodyKey{}, body)
+}
+
+// transferRequestBody 签名覆盖的请求字节：HTTP 为原始请求体，gRPC 为 deterministic 编码的 proto；
+// 进程内直接调用时没有传输层字节，使用与 gRPC 相同的编码
+func transferRequestBody(ctx context.Context, in *TransferMessageRequest) ([]byte, error) {
+	if body, ok := ctx.Value(signedBodyKey{}).([]byte); ok {
+		return body, nil
+	}
+	return marshalRequestPB(requestToPB(in))
+}
+
+// transferRequestMAC 签名覆盖时间戳、nonce 和完整的请求体
+func transferRequestMAC(secret, callerID string, ts int64, nonce string, body []byte) []byte {
+	sum := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strings.Join([]string{
+		callerID,
+		strconv.FormatInt(ts, 10),
+		nonce,
+		hex.EncodeToString(sum[:]),
+	}, "\n")))
+	return mac.Sum(nil)
+}
+
+// SignTransferRequest gRPC 调用方使用，签名覆盖 deterministic 编码的 proto，
+// 返回需要放入 gRPC metadata 的签名信息；每次调用生成新的 nonce，重试时需要重新签名
+func SignTransferRequest(secret, callerID string, in *TransferMessageRequest) (Metadata, error) {
+	body, err := marshalRequestPB(requestToPB(in))
+	if err != nil {
+		return nil, err
+	}
+	return SignTransferBody(secret, callerID, body)
+}
+
+// SignTransferBody HTTP 调用方使用，body 必须与发送的请求体逐字节一致，批量请求中为单条消息的 JSON；
+// 返回需要放入 HTTP 请求头或 HTTPBatchTransferRequest.Signatures 的签名信息
+func SignTransferBody(secret, callerID string, body []byte) (Metadata, error) {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return signTransferBody(secret, callerID, time.Now().UnixNano()/1000000, hex.EncodeToString(nonce), body), nil
+}
+
+func signTransferBody(secret, callerID string, ts int64, nonce string, body []byte) Metadata {
+	return Metadata{
+		CallerIDHeader:        {callerID},
+		CallerTimestampHeader: {strconv.FormatInt(ts, 10)},
+		CallerNonceHeader:     {nonce},
+		CallerSignatureHeader: {hex.EncodeToString(transferRequestMAC(secret, callerID, ts, nonce, body))},
+	}
+}
+
+type signedCallerKey struct{}
+
+// isSignedCall 调用方已经通过签名认证，签名请求不携带 x-api-key，不再校验租户的 API key
+func isSignedCall(ctx context.Context) bool {
+	v, _ := ctx.Value(signedCallerKey{}).(bool)
+	return v
+}
+
+// authorizeCaller 内部重放的请求在第一次进入时已经校验过，不再校验；
+// 通过签名认证的请求返回带有标记的 ctx
+func (s *RouterServer) authorizeCaller(ctx context.Context, in *TransferMessageRequest) (context.Context, error) {
+	if s.Auth == nil || isInternalCall(ctx) {
+		return ctx, nil
+	}
+	p, err := s.Auth.Authenticate(ctx, in)
+	if err != nil {
+		Metrics.Counter(MetricCallerUnauthenticated, 1)
+		return ctx, err
+	}
+	if err := s.Auth.Authorize(p, in); err != nil {
+		Metrics.Counter(MetricCallerForbidden, 1)
+		return ctx, err
+	}
+	if md, _ := MetadataFromIncomingContext(ctx); len(md.Get(CallerIDHeader)) > 0 {
+		ctx = context.WithValue(ctx, signedCallerKey{}, true)
+	}
+	return ctx, nil
+}
@@ -0,0 +1,264 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testCallerSecret = "caller-secret"
+
+func newTestCallerAuth(t *testing.T) *CallerAuthenticator {
+	t.Helper()
+	a, err := NewCallerAuthenticator([]*CallerPolicy{
+		{CallerID: "signer", HMACSecret: testCallerSecret, Apps: []string{"im"}, MsgTypes: []int32{0, 1}},
+		{CallerID: "keyed", APIKey: "caller-key", Apps: []string{callerAllApps}},
+	}, time.Minute)
+	assert.NoError(t, err)
+	a.now = func() time.Time { return testNow }
+	return a
+}
+
+// signRequest 返回 signer 对 in 的 proto 编码的签名，与 SignTransferRequest 一致
+func signRequest(t *testing.T, ts time.Time, nonce string, in *TransferMessageRequest) Metadata {
+	t.Helper()
+	body, err := marshalRequestPB(requestToPB(in))
+	assert.NoError(t, err)
+	return signTransferBody(testCallerSecret, "signer", ts.UnixNano()/1000000, nonce, body)
+}
+
+// signedContext 返回带有 signer 签名的 ctx，ts 为签名时间
+func signedContext(t *testing.T, ts time.Time, nonce string, in *TransferMessageRequest) context.Context {
+	t.Helper()
+	return NewIncomingContext(context.Background(), signRequest(t, ts, nonce, in))
+}
+
+func TestCallerAuthenticateSignature(t *testing.T) {
+	testCases := []struct {
+		name     string
+		signedAt time.Time
+		nonce    string
+		tamper   func(in *TransferMessageRequest)
+		wantErr  bool
+	}{
+		{name: "valid", signedAt: testNow, nonce: "n1"},
+		{name: "within-skew", signedAt: testNow.Add(-50 * time.Second), nonce: "n1"},
+		{name: "expired", signedAt: testNow.Add(-2 * time.Minute), nonce: "n1", wantErr: true},
+		{name: "missing-nonce", signedAt: testNow, wantErr: true},
+		{name: "push-tampered", signedAt: testNow, nonce: "n1", tamper: func(in *TransferMessageRequest) { in.Push.Title = &I18N{Value: "phishing"} }, wantErr: true},
+		{name: "msg-data-tampered", signedAt: testNow, nonce: "n1", tamper: func(in *TransferMessageRequest) { in.MsgData = &Any{TypeUrl: "t", Value: []byte("x")} }, wantErr: true},
+		{name: "empty-filters-same-as-nil", signedAt: testNow, nonce: "n1", tamper: func(in *TransferMessageRequest) { in.Filters, in.ForceLangs = map[string]string{}, []string{} }},
+		{name: "filters-tampered", signedAt: testNow, nonce: "n1", tamper: func(in *TransferMessageRequest) { in.Filters = map[string]string{"os": "ios"} }, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			a := newTestCallerAuth(t)
+			in := newTestRequest()
+			ctx := signedContext(t, tc.signedAt, tc.nonce, in)
+			if tc.tamper != nil {
+				tc.tamper(in)
+			}
+
+			p, err := a.Authenticate(ctx, in)
+			if tc.wantErr {
+				assert.Equal(t, CodeUnauthenticated, StatusCode(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "signer", p.CallerID)
+		})
+	}
+}
+
+func TestCallerAuthenticateNonceReplay(t *testing.T) {
+	a := newTestCallerAuth(t)
+	in := newTestRequest()
+	ctx := signedContext(t, testNow, "n1", in)
+	replayed := Metrics.Value(MetricCallerReplayed)
+
+	_, err := a.Authenticate(ctx, in)
+	assert.NoError(t, err)
+	_, err = a.Authenticate(ctx, in)
+	assert.Equal(t, CodeUnauthenticated, StatusCode(err))
+	assert.Equal(t, replayed+1, Metrics.Value(MetricCallerReplayed))
+
+	// 不同的 nonce 不受影响
+	_, err = a.Authenticate(signedContext(t, testNow, "n2", in), in)
+	assert.NoError(t, err)
+
+	// 签名过期后 nonce 被清理，重放的请求因时间戳被拒绝
+	now := testNow.Add(2 * time.Minute)
+	a.now = func() time.Time { return now }
+	_, err = a.Authenticate(signedContext(t, now, "n3", in), in)
+	assert.NoError(t, err)
+	assert.Len(t, a.nonces, 1)
+	_, err = a.Authenticate(ctx, in)
+	assert.Equal(t, CodeUnauthenticated, StatusCode(err))
+}
+
+func TestCallerAuthenticateAPIKey(t *testing.T) {
+	testCases := []struct {
+		name    string
+		md      Metadata
+		wantErr bool
+	}{
+		{name: "valid", md: Metadata{APIKeyHeader: {"caller-key"}}},
+		{name: "wrong-key", md: Metadata{APIKeyHeader: {"caller-key2"}}, wantErr: true},
+		{name: "missing", md: Metadata{}, wantErr: true},
+		{name: "unknown-signer", md: Metadata{CallerIDHeader: {"keyed"}}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			p, err := newTestCallerAuth(t).Authenticate(NewIncomingContext(context.Background(), tc.md), newTestRequest())
+			if tc.wantErr {
+				assert.Equal(t, CodeUnauthenticated, StatusCode(err))
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "keyed", p.CallerID)
+		})
+	}
+}
+
+func TestTransferCallerAuth(t *testing.T) {
+	testCases := []struct {
+		name       string
+		ctx        func(t *testing.T, in *TransferMessageRequest) context.Context
+		tenantKeys []string
+		msgType    int32
+		wantCode   Code
+	}{
+		{name: "signed", ctx: func(t *testing.T, in *TransferMessageRequest) context.Context {
+			return signedContext(t, testNow, "n1", in)
+		}},
+		{name: "signed-skips-tenant-api-key", tenantKeys: []string{"tenant-key"}, ctx: func(t *testing.T, in *TransferMessageRequest) context.Context {
+			return signedContext(t, testNow, "n1", in)
+		}},
+		{name: "signed-msg-type-forbidden", msgType: 7, wantCode: CodePermissionDenied, ctx: func(t *testing.T, in *TransferMessageRequest) context.Context {
+			return signedContext(t, testNow, "n1", in)
+		}},
+		{name: "api-key-still-checks-tenant", tenantKeys: []string{"tenant-key"}, wantCode: CodePermissionDenied, ctx: func(t *testing.T, in *TransferMessageRequest) context.Context {
+			return NewIncomingContext(context.Background(), Metadata{APIKeyHeader: {"caller-key"}})
+		}},
+		{name: "unauthenticated", wantCode: CodeUnauthenticated, ctx: func(t *testing.T, in *TransferMessageRequest) context.Context {
+			return context.Background()
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			ts.Auth = newTestCallerAuth(t)
+			tenants, err := NewTenantRegistry([]*TenantConfig{{AppName: "im", AppIndex: 1, KeyPrefix: "{im}", APIKeys: tc.tenantKeys}})
+			assert.NoError(t, err)
+			ts.Tenants = tenants
+			in := newTestRequest()
+			in.MsgType = tc.msgType
+
+			_, err = ts.TransferOnlineReliableMessage(tc.ctx(t, in), in)
+			if tc.wantCode != CodeOK {
+				assert.Equal(t, tc.wantCode, StatusCode(err))
+				assert.Empty(t, conn.requests())
+				return
+			}
+			assert.NoError(t, err)
+			ts.observer.next(t, EventMsgDelivered)
+			assert.Len(t, conn.requests(), 1)
+		})
+	}
+}
+
+func TestCallerSignatureAcrossTransports(t *testing.T) {
+	// 空的 Filters、ForceLangs 和 Locales 经过 requestFromPB 后变成 nil，签名仍然覆盖调用方发送的字节
+	newRequest := func() *TransferMessageRequest {
+		in := newTestRequest()
+		in.Filters = map[string]string{}
+		in.ForceLangs = []string{}
+		in.Push.Title.Locales = map[string]string{}
+		return in
+	}
+	testCases := []struct {
+		name     string
+		send     func(t *testing.T, ts *testServer, in *TransferMessageRequest) error
+		wantCode Code
+	}{
+		{name: "grpc", send: func(t *testing.T, ts *testServer, in *TransferMessageRequest) error {
+			client := NewGRPCRouterClient(newBufconnClient(t, NewGRPCServer(ts.RouterServer)))
+			_, err := client.TransferOnlineReliableMessage(NewOutgoingContext(context.Background(), signRequest(t, testNow, "n1", in)), in)
+			return err
+		}},
+		{name: "http-raw-body", send: func(t *testing.T, ts *testServer, in *TransferMessageRequest) error {
+			body, _ := json.Marshal(in)
+			return httpTransferRaw(ts, body, signTransferBody(testCallerSecret, "signer", testNowMs(), "n1", body))
+		}},
+		{name: "http-body-reencoded", wantCode: CodeUnauthenticated, send: func(t *testing.T, ts *testServer, in *TransferMessageRequest) error {
+			body, _ := json.Marshal(in)
+			indented, _ := json.MarshalIndent(in, "", "  ")
+			return httpTransferRaw(ts, indented, signTransferBody(testCallerSecret, "signer", testNowMs(), "n1", body))
+		}},
+		{name: "http-proto-signature", wantCode: CodeUnauthenticated, send: func(t *testing.T, ts *testServer, in *TransferMessageRequest) error {
+			body, _ := json.Marshal(in)
+			return httpTransferRaw(ts, body, signRequest(t, testNow, "n1", in))
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			ts.Auth = newTestCallerAuth(t)
+
+			err := tc.send(t, ts, newRequest())
+			assert.Equal(t, tc.wantCode, StatusCode(err))
+			if tc.wantCode != CodeOK {
+				assert.Empty(t, conn.requests())
+				return
+			}
+			ts.observer.next(t, EventMsgDelivered)
+			assert.Len(t, conn.requests(), 1)
+		})
+	}
+}
+
+// httpTransferRaw 通过 HTTP 网关发送 body，401 还原成 Unauthenticated，其它失败返回 Internal
+func httpTransferRaw(ts *testServer, body []byte, header Metadata) error {
+	r := httptest.NewRequest(http.MethodPost, HTTPTransferPath, bytes.NewReader(body))
+	for k, v := range header {
+		r.Header.Set(k, v[0])
+	}
+	w := httptest.NewRecorder()
+	NewHTTPGateway(ts.RouterServer).ServeHTTP(w, r)
+	if w.Code == http.StatusOK {
+		return nil
+	}
+	if w.Code == http.StatusUnauthorized {
+		return NewStatusError(CodeUnauthenticated, w.Body.String())
+	}
+	return NewStatusError(CodeInternal, "status %d: %v", w.Code, w.Body.String())
+}
+
+func TestCallerAuthenticateSharedNonce(t *testing.T) {
+	mr, store := newTestRedisStore(t, testNow)
+	// 两个实例共享同一个 Redis，请求被路由到另一个实例时也不能重放
+	a1, a2 := newTestCallerAuth(t), newTestCallerAuth(t)
+	a1.Store, a2.Store = store, store
+	in := newTestRequest()
+	ctx := signedContext(t, testNow, "n1", in)
+
+	_, err := a1.Authenticate(ctx, in)
+	assert.NoError(t, err)
+	_, err = a2.Authenticate(ctx, in)
+	assert.Equal(t, CodeUnauthenticated, StatusCode(err))
+	assert.Empty(t, a1.nonces)
+	assert.Equal(t, time.Minute, mr.TTL(CallerNonceKeyPrefix+"_signer_n1"))
+
+	// Redis 不可用时无法确认是否重放，拒绝请求
+	a1.Store = NewRedisStore(errRedisCommander{})
+	_, err = a1.Authenticate(signedContext(t, testNow, "n2", in), in)
+	assert.Equal(t, CodeUnavailable, StatusCode(err))
+}
@@ -0,0 +1,148 @@
+package router
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+)
+
+const MetricPushCollapsed = "router_push_collapsed"
+
+// collapsedMsg 窗口内最新的一条消息
+type collapsedMsg struct {
+	ctx      context.Context
+	in       *TransferMessageRequest
+	wrappers []*ConnectorClientWrapper
+	count    int
+}
+
+// PushCollapser 按 CollapseKey 合并推送：同一个用户同一个 key 的第一条消息开启一个窗口，
+// 窗口结束时只下发窗口内最新的一条，合并了多条消息时把消息数追加到 Ticker 的 Params 末尾，
+// 模板中没有对应的占位符时忽略；被合并的消息不再下发到设备，MsgData 由客户端从存储同步拉取，
+// 因此未开启消息存储时不合并
+type PushCollapser struct {
+	window time.Duration
+	// afterFunc 默认为 time.AfterFunc
+	afterFunc func(d time.Duration, f func()) deliveryTimer
+
+	mu      sync.Mutex
+	pending map[string]*collapsedMsg
+	stopped bool
+}
+
+func NewPushCollapser(window time.Duration) *PushCollapser {
+	return &PushCollapser{
+		window:    window,
+		afterFunc: func(d time.Duration, f func()) deliveryTimer { return time.AfterFunc(d, f) },
+		pending:   make(map[string]*collapsedMsg),
+	}
+}
+
+// collapseKeyOf 合并窗口的 key，开启多租户时加上租户的 KeyPrefix
+func collapseKeyOf(prefix string, in *TransferMessageRequest) string {
+	return prefix + in.AppName + RedisInterval + in.ReceiverId + RedisInterval + in.CollapseKey
+}
+
+// add 返回 key 对应窗口中被替换掉的消息，窗口结束时调用 fire 下发最新的消息
+func (c *PushCollapser) add(key string, m *collapsedMsg, fire func(m *collapsedMsg)) *collapsedMsg {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.stopped {
+		m.count = 1
+		go fire(m)
+		return nil
+	}
+	old, ok := c.pending[key]
+	if ok {
+		m.count = old.count + 1
+		c.pending[key] = m
+		return old
+	}
+	m.count = 1
+	c.pending[key] = m
+	c.afterFunc(c.window, func() {
+		c.mu.Lock()
+		latest, ok := c.pending[key]
+		delete(c.pending, key)
+		c.mu.Unlock()
+		if ok {
+			fire(latest)
+		}
+	})
+	return nil
+}
+
+// Stop 立即下发所有窗口内的消息，之后的消息不再合并
+func (c *PushCollapser) Stop(fire func(m *collapsedMsg)) {
+	c.mu.Lock()
+	c.stopped = true
+	pending := c.pending
+	c.pending = make(map[string]*collapsedMsg)
+	c.mu.Unlock()
+	for _, m := range pending {
+		fire(m)
+	}
+}
+
+// StopCollapser 停止合并并下发窗口内的消息，需要在 Scheduler 停止之前调用
+func (s *RouterServer) StopCollapser() {
+	if s.Collapser != nil {
+		s.Collapser.Stop(s.fireCollapsed)
+	}
+}
+
+// collapseOrDispatch 设置了 CollapseKey 并且消息已经同步存储成功时进入合并窗口，其余消息直接下发，
+// 避免被合并的消息的 MsgData 丢失
+func (s *RouterServer) collapseOrDispatch(ctx context.Context, in *TransferMessageRequest, wrappers []*ConnectorClientWrapper, stored bool) error {
+	if s.Collapser == nil || len(in.CollapseKey) == 0 || !stored {
+		return s.dispatch(ctx, in, wrappers)
+	}
+	key := collapseKeyOf(s.Tenants.KeyPrefix(in.AppName), in)
+	old := s.Collapser.add(key, &collapsedMsg{ctx: ctx, in: in, wrappers: wrappers}, s.fireCollapsed)
+	if old != nil {
+		Metrics.Counter(MetricPushCollapsed, 1)
+		for _, w := range old.wrappers {
+			s.emitDeviceSkipped(old.ctx, old.in, w, SkipReasonCollapsed)
+		}
+	}
+	return nil
+}
+
+func (s *RouterServer) fireCollapsed(m *collapsedMsg) {
+	in := withCollapsedCount(m.in, m.count)
+	if err := s.dispatch(m.ctx, in, m.wrappers); err != nil {
+		Applog.Errorf("dispatch collapsed msg err:%+v msgId: %v collapseKey: %v", err, in.MsgId, in.CollapseKey)
+		s.putDeadLetter(m.ctx, m.in, "", DeadLetterStageDeliver, err, 0)
+	}
+}
+
+// withCollapsedCount 合并了多条消息时把消息数追加到通用 Push 和定向 Push 的 Ticker Params 中，
+// 不修改原始请求中的推送内容
+func withCollapsedCount(in *TransferMessageRequest, count int) *TransferMessageRequest {
+	if count <= 1 {
+		return in
+	}
+	n := strconv.Itoa(count)
+	out := *in
+	out.Push = appendTickerParam(in.Push, n)
+	if len(in.DeviceIdPushes) > 0 {
+		out.DeviceIdPushes = make([]*DeviceIdPush, len(in.DeviceIdPushes))
+		for i, d := range in.DeviceIdPushes {
+			out.DeviceIdPushes[i] = &DeviceIdPush{DeviceIds: d.GetDeviceIds(), Push: appendTickerParam(d.GetPush(), n)}
+		}
+	}
+	return &out
+}
+
+func appendTickerParam(push *PushContent, param string) *PushContent {
+	if push == nil || push.Ticker == nil {
+		return push
+	}
+	p := *push
+	ticker := *push.Ticker
+	ticker.Params = append(append(make([]string, 0, len(push.Ticker.Params)+1), push.Ticker.Params...), param)
+	ticker.optionalParams++
+	p.Ticker = &ticker
+	return &p
+}
@@ -0,0 +1,153 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountFormatVerbs(t *testing.T) {
+	testCases := []struct {
+		format string
+		want   int
+	}{
+		{format: "", want: 0},
+		{format: "New messages", want: 0},
+		{format: "%s new messages", want: 1},
+		{format: "%s sent %d messages", want: 2},
+		{format: "100%% done", want: 0},
+		{format: "%s is 100%%", want: 1},
+		{format: "trailing %", want: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.format, func(t *testing.T) {
+			assert.Equal(t, tc.want, countFormatVerbs(tc.format))
+		})
+	}
+}
+
+func TestWithCollapsedCount(t *testing.T) {
+	in := newTestRequest()
+	in.Push.Ticker = &I18N{Value: "%s sent %s messages", Params: []string{"Bob"}}
+	in.DeviceIdPushes = []*DeviceIdPush{{DeviceIds: []string{"d1"}, Push: &PushContent{Ticker: &I18N{Value: "%s"}}}}
+
+	assert.Same(t, in, withCollapsedCount(in, 1), "single msg is not rewritten")
+
+	out := withCollapsedCount(in, 3)
+	assert.Equal(t, []string{"Bob", "3"}, out.Push.Ticker.Params)
+	assert.Equal(t, []string{"3"}, out.DeviceIdPushes[0].Push.Ticker.Params)
+	assert.Equal(t, in.Push.Title, out.Push.Title)
+	// 原始请求不变
+	assert.Equal(t, []string{"Bob"}, in.Push.Ticker.Params)
+	assert.Empty(t, in.DeviceIdPushes[0].Push.Ticker.Params)
+}
+
+func TestParseI18nCollapsedCount(t *testing.T) {
+	testCases := []struct {
+		name   string
+		ticker *I18N
+		count  int
+		want   string
+	}{
+		{name: "slot-for-count", ticker: &I18N{Value: "%s new messages"}, count: 3, want: "3 new messages"},
+		{name: "no-slot", ticker: &I18N{Value: "New messages"}, count: 3, want: "New messages"},
+		{name: "slot-only-for-caller-params", ticker: &I18N{Value: "%s sent a message", Params: []string{"Bob"}}, count: 3, want: "Bob sent a message"},
+		{name: "caller-params-and-count", ticker: &I18N{Value: "%s sent %s messages", Params: []string{"Bob"}}, count: 3, want: "Bob sent 3 messages"},
+		{name: "locale-without-slot", ticker: &I18N{Value: "%s new messages", Locales: map[string]string{DefaultLocale: "New messages"}}, count: 3, want: "New messages"},
+		{name: "single-msg", ticker: &I18N{Value: "%s sent a message", Params: []string{"Bob"}}, count: 1, want: "Bob sent a message"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			in := newTestRequest()
+			in.Push.Ticker = tc.ticker
+			push := processPush(*withCollapsedCount(in, tc.count).Push, DefaultLocale, nil)
+			assert.Equal(t, tc.want, push.Ticker.Value)
+			assert.NotContains(t, push.Ticker.Value, "%!")
+		})
+	}
+}
+
+func TestTransferCollapse(t *testing.T) {
+	testCases := []struct {
+		name         string
+		store        bool
+		async        bool
+		wantSent     []string
+		wantCollapse bool
+	}{
+		{name: "collapsed-when-stored", store: true, wantSent: []string{"m2"}, wantCollapse: true},
+		{name: "not-collapsed-without-storage", wantSent: []string{"m1", "m2"}},
+		// 异步存储还没有确认写入成功
+		{name: "not-collapsed-with-async-storage", store: true, async: true, wantSent: []string{"m1", "m2"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withServiceConfig(t, func(c *config) {
+				c.Service.IsStoreReliableMsg = tc.store
+				c.Service.AsyncStoreReliableMsg = tc.async
+			})
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			clock := newManualClock()
+			ts.Collapser = NewPushCollapser(time.Second)
+			ts.Collapser.afterFunc = clock.afterFunc
+			collapsed := Metrics.Value(MetricPushCollapsed)
+
+			for _, id := range []string{"m1", "m2"} {
+				in := newTestRequest()
+				in.MsgId = id
+				in.CollapseKey = "chat"
+				in.MsgData = &Any{TypeUrl: "t", Value: []byte(id)}
+				in.Push.Ticker = &I18N{Value: "%s new messages"}
+				_, err := ts.TransferOnlineReliableMessage(context.Background(), in)
+				assert.NoError(t, err)
+			}
+
+			if tc.wantCollapse {
+				ev := ts.observer.next(t, EventDeviceSkipped)
+				assert.Equal(t, SkipReasonCollapsed, ev.Reason)
+				assert.Equal(t, "m1", ev.MsgId)
+				assert.Equal(t, collapsed+1, Metrics.Value(MetricPushCollapsed))
+				// 被合并的消息已经落库，客户端可以同步拉取
+				assert.Len(t, ts.db.stored(), 2)
+				tm := clock.next(t)
+				assert.Equal(t, time.Second, tm.delay)
+				tm.fire()
+			} else {
+				assert.Empty(t, clock.timers)
+			}
+			for range tc.wantSent {
+				ts.observer.next(t, EventMsgDelivered)
+			}
+
+			var sent []string
+			for _, r := range conn.requests() {
+				sent = append(sent, r.MsgId)
+			}
+			assert.ElementsMatch(t, tc.wantSent, sent)
+			if tc.wantCollapse {
+				assert.Equal(t, "2 new messages", conn.requests()[0].Push.Ticker.Value)
+			}
+		})
+	}
+}
+
+func TestCollapseKeyOfTenant(t *testing.T) {
+	in := newTestRequest()
+	in.CollapseKey = "chat"
+	testCases := []struct {
+		name   string
+		prefix string
+		want   string
+	}{
+		{name: "no-tenant", want: "im" + RedisInterval + "42" + RedisInterval + "chat"},
+		{name: "tenant-prefix", prefix: "{im}", want: "{im}im" + RedisInterval + "42" + RedisInterval + "chat"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, collapseKeyOf(tc.prefix, in))
+		})
+	}
+}
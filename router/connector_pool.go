@@ -0,0 +1,444 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// ConnectorUnreachableErr connector 持续不可用，调用方应删除指向它的路由
+var ConnectorUnreachableErr = fmt.Errorf("%w: connector unreachable", NoConnectionErr)
+
+// ConnectorCircuitOpenErr connector 熔断中，请求没有发出，熔断结束之前重试没有意义
+var ConnectorCircuitOpenErr = fmt.Errorf("%w: connector circuit open", NoConnectionErr)
+
+// ConnectorDialer 根据地址建立到 connector 的连接
+type ConnectorDialer func(ctx context.Context, addr string) (ConnectorClient, error)
+
+// ConnectorHealthChecker 可选，ConnectorClient 实现后连接池会定期做健康检查
+type ConnectorHealthChecker interface {
+	HealthCheck(ctx context.Context) error
+}
+
+type ConnectorPoolConfig struct {
+	DialTimeout         time.Duration
+	HealthCheckInterval time.Duration
+	// FailureThreshold 连续失败次数达到该值后熔断
+	FailureThreshold int
+	// OpenTimeout 熔断持续时间，过后放行一个探测请求
+	OpenTimeout time.Duration
+	// UnreachableAfter 熔断持续超过该时间视为 connector 持续不可用
+	UnreachableAfter time.Duration
+	// IdleTimeout 超过该时间没有使用的连接会被关闭并移出连接池，0 表示不清理
+	IdleTimeout time.Duration
+}
+
+var DefaultConnectorPoolConfig = ConnectorPoolConfig{
+	DialTimeout:         3 * time.Second,
+	HealthCheckInterval: 10 * time.Second,
+	FailureThreshold:    5,
+	OpenTimeout:         5 * time.Second,
+	UnreachableAfter:    time.Minute,
+	IdleTimeout:         10 * time.Minute,
+}
+
+type breakerState int
+
+const (
+	breakerClosed breakerState = iota
+	breakerOpen
+	breakerHalfOpen
+)
+
+// ConnectorPool 按 connector 地址复用连接，首次使用时才建连，
+// 每个 connector 单独熔断，实现了 ConnectorResolver
+type ConnectorPool struct {
+	cfg  ConnectorPoolConfig
+	dial ConnectorDialer
+
+	mu        sync.Mutex
+	conns     map[string]*pooledConnector
+	lastSweep time.Time
+	// now 默认为 time.Now，测试中替换为固定时钟
+	now func() time.Time
+}
+
+func NewConnectorPool(cfg ConnectorPoolConfig, dial ConnectorDialer) *ConnectorPool {
+	return &ConnectorPool{
+		cfg:   cfg,
+		dial:  dial,
+		conns: make(map[string]*pooledConnector),
+		now:   time.Now,
+	}
+}
+
+// Resolve 返回 addr 对应的连接，每隔 IdleTimeout 顺带清理一次空闲连接，不依赖健康检查
+func (p *ConnectorPool) Resolve(addr string) (ConnectorClient, error) {
+	if len(addr) == 0 {
+		return nil, NoConnectionErr
+	}
+	now := p.now()
+	p.mu.Lock()
+	c, ok := p.conns[addr]
+	if !ok {
+		c = &pooledConnector{pool: p, addr: addr, lastUsed: now}
+		p.conns[addr] = c
+	}
+	sweep := p.cfg.IdleTimeout > 0 && now.Sub(p.lastSweep) >= p.cfg.IdleTimeout
+	if sweep {
+		p.lastSweep = now
+	}
+	p.mu.Unlock()
+	if sweep {
+		p.evictIdle(now)
+	}
+	return c, nil
+}
+
+// evictIdle 关闭并移除超过 IdleTimeout 没有使用的连接
+func (p *ConnectorPool) evictIdle(now time.Time) {
+	if p.cfg.IdleTimeout <= 0 {
+		return
+	}
+	p.mu.Lock()
+	var idle []*pooledConnector
+	for addr, c := range p.conns {
+		if c.evictIfIdle(now, p.cfg.IdleTimeout) {
+			idle = append(idle, c)
+			delete(p.conns, addr)
+		}
+	}
+	p.mu.Unlock()
+	for _, c := range idle {
+		Applog.Infof("evict idle connector, addr: %v", c.addr)
+		c.close()
+	}
+}
+
+// Len 返回连接池中的 connector 数量
+func (p *ConnectorPool) Len() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.conns)
+}
+
+// Remove 关闭并移除 addr 对应的连接
+func (p *ConnectorPool) Remove(addr string) {
+	p.mu.Lock()
+	c, ok := p.conns[addr]
+	delete(p.conns, addr)
+	p.mu.Unlock()
+	if ok {
+		c.mu.Lock()
+		c.evicted = true
+		c.mu.Unlock()
+		c.close()
+	}
+}
+
+// StartHealthCheck 定期检查所有连接，ctx 取消后退出
+func (p *ConnectorPool) StartHealthCheck(ctx context.Context) {
+	if p.cfg.HealthCheckInterval <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(p.cfg.HealthCheckInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				p.checkAll(ctx)
+			}
+		}
+	}()
+}
+
+func (p *ConnectorPool) checkAll(ctx context.Context) {
+	p.evictIdle(p.now())
+	p.mu.Lock()
+	conns := make([]*pooledConnector, 0, len(p.conns))
+	for _, c := range p.conns {
+		conns = append(conns, c)
+	}
+	p.mu.Unlock()
+	for _, c := range conns {
+		c.healthCheck(ctx)
+	}
+}
+
+// pooledConnector 连接池中的单个 connector，带熔断
+type pooledConnector struct {
+	pool *ConnectorPool
+	addr string
+
+	mu       sync.Mutex
+	client   ConnectorClient
+	state    breakerState
+	failures int
+	openedAt time.Time // 首次熔断的时间
+	retryAt  time.Time // 下一次允许探测的时间
+	probing  bool
+	lastUsed time.Time
+	dialing  *dialCall // 正在进行的建连，并发的请求等待同一次建连的结果
+	evicted  bool      // 已经移出连接池，仍持有它的请求改用连接池中新的连接
+}
+
+type dialCall struct {
+	done   chan struct{}
+	client ConnectorClient
+	err    error
+}
+
+func (c *pooledConnector) TransmitMessage(ctx context.Context, req *TransmitMessageRequest) error {
+	c.mu.Lock()
+	evicted := c.evicted
+	c.mu.Unlock()
+	if evicted {
+		fresh, err := c.pool.Resolve(c.addr)
+		if err != nil {
+			return err
+		}
+		return fresh.TransmitMessage(ctx, req)
+	}
+	client, err := c.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.TransmitMessage(ctx, req)
+	if err != nil && !IsErrUserNotExist(err) {
+		c.recordFailure()
+		return err
+	}
+	c.recordSuccess()
+	return err
+}
+
+// acquire 检查熔断状态并按需建连，建连在锁外进行
+func (c *pooledConnector) acquire(ctx context.Context) (ConnectorClient, error) {
+	c.mu.Lock()
+	now := c.pool.now()
+	c.lastUsed = now
+	if err := c.admitLocked(now); err != nil {
+		c.mu.Unlock()
+		return nil, err
+	}
+	if c.client != nil {
+		client := c.client
+		c.mu.Unlock()
+		return client, nil
+	}
+	call := c.dialing
+	if call == nil {
+		call = &dialCall{done: make(chan struct{})}
+		c.dialing = call
+		c.mu.Unlock()
+		c.dialAndSet(ctx, call)
+	} else {
+		c.mu.Unlock()
+		select {
+		case <-call.done:
+		case <-ctx.Done():
+			return nil, fmt.Errorf("%w: wait dial %v: %v", NoConnectionErr, c.addr, ctx.Err())
+		}
+	}
+	return call.client, call.err
+}
+
+// admitLocked 熔断期间拒绝请求；到了探测时间后放行一个探测请求，
+// 熔断超过 UnreachableAfter 时被拒绝的请求返回 ConnectorUnreachableErr，但仍然会定期探测
+func (c *pooledConnector) admitLocked(now time.Time) error {
+	switch c.state {
+	case breakerOpen:
+		if !now.Before(c.retryAt) {
+			c.state = breakerHalfOpen
+			c.probing = true
+			return nil
+		}
+		return c.rejectErrLocked(now)
+	case breakerHalfOpen:
+		// 半开状态只放行一个探测请求
+		if c.probing {
+			return c.rejectErrLocked(now)
+		}
+		c.probing = true
+	}
+	return nil
+}
+
+func (c *pooledConnector) rejectErrLocked(now time.Time) error {
+	if c.pool.cfg.UnreachableAfter > 0 && now.Sub(c.openedAt) >= c.pool.cfg.UnreachableAfter {
+		return ConnectorUnreachableErr
+	}
+	return ConnectorCircuitOpenErr
+}
+
+// dialAndSet 建连并把结果通知给等待同一次建连的请求
+func (c *pooledConnector) dialAndSet(ctx context.Context, call *dialCall) {
+	client, err := c.dialClient(ctx)
+	var stale ConnectorClient
+	c.mu.Lock()
+	if err != nil {
+		stale = c.recordFailureLocked()
+		call.err = fmt.Errorf("%w: dial %v err: %v", NoConnectionErr, c.addr, err)
+	} else {
+		c.client = client
+		call.client = client
+	}
+	c.dialing = nil
+	c.mu.Unlock()
+	closeConnectorClient(stale, c.addr)
+	close(call.done)
+}
+
+func (c *pooledConnector) dialClient(ctx context.Context) (ConnectorClient, error) {
+	if c.pool.cfg.DialTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.pool.cfg.DialTimeout)
+		defer cancel()
+	}
+	return c.pool.dial(ctx, c.addr)
+}
+
+// evictIfIdle 超过 timeout 没有使用时标记为已移出，正在建连的不移出
+func (c *pooledConnector) evictIfIdle(now time.Time, timeout time.Duration) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.dialing != nil || now.Sub(c.lastUsed) < timeout {
+		return false
+	}
+	c.evicted = true
+	return true
+}
+
+func (c *pooledConnector) healthCheck(ctx context.Context) {
+	c.mu.Lock()
+	client := c.client
+	c.mu.Unlock()
+	if client == nil {
+		c.redial(ctx)
+		return
+	}
+	checker, ok := client.(ConnectorHealthChecker)
+	if !ok {
+		return
+	}
+	if err := checker.HealthCheck(ctx); err != nil {
+		Applog.Warnf("connector health check failed, addr: %v, err: %v", c.addr, err)
+		c.recordFailure()
+		return
+	}
+	c.recordSuccess()
+}
+
+// redial 熔断后连接已被丢弃，由健康检查负责重新建连；新连接通过健康检查才恢复，
+// 不支持健康检查的连接进入半开状态，由下一个请求探测
+func (c *pooledConnector) redial(ctx context.Context) {
+	c.mu.Lock()
+	if c.state == breakerClosed || c.client != nil || c.dialing != nil {
+		c.mu.Unlock()
+		return
+	}
+	call := &dialCall{done: make(chan struct{})}
+	c.dialing = call
+	c.mu.Unlock()
+
+	client, err := c.dialClient(ctx)
+	if err == nil {
+		if checker, ok := client.(ConnectorHealthChecker); ok {
+			err = checker.HealthCheck(ctx)
+			if err != nil {
+				closeConnectorClient(client, c.addr)
+			}
+		}
+	}
+
+	c.mu.Lock()
+	defer func() {
+		c.dialing = nil
+		c.mu.Unlock()
+		close(call.done)
+	}()
+	if err != nil {
+		Applog.Warnf("connector redial failed, addr: %v, err: %v", c.addr, err)
+		call.err = fmt.Errorf("%w: redial %v err: %v", NoConnectionErr, c.addr, err)
+		c.retryAt = c.pool.now().Add(c.pool.cfg.OpenTimeout)
+		return
+	}
+	c.client = client
+	call.client = client
+	if _, ok := client.(ConnectorHealthChecker); ok {
+		c.failures = 0
+		c.state = breakerClosed
+		c.probing = false
+		return
+	}
+	c.state = breakerHalfOpen
+	c.probing = false
+}
+
+func (c *pooledConnector) recordSuccess() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.failures = 0
+	c.state = breakerClosed
+	c.probing = false
+}
+
+func (c *pooledConnector) recordFailure() {
+	c.mu.Lock()
+	stale := c.recordFailureLocked()
+	c.mu.Unlock()
+	closeConnectorClient(stale, c.addr)
+}
+
+// recordFailureLocked 返回熔断时换下的旧连接，由调用方在释放锁之后关闭
+func (c *pooledConnector) recordFailureLocked() ConnectorClient {
+	c.failures++
+	c.probing = false
+	switch c.state {
+	case breakerHalfOpen:
+		// 探测失败，重新熔断但保留最初的熔断时间，用于判断是否持续不可用
+		c.state = breakerOpen
+		c.retryAt = c.pool.now().Add(c.pool.cfg.OpenTimeout)
+	case breakerClosed:
+		if c.failures >= c.pool.cfg.FailureThreshold {
+			c.state = breakerOpen
+			c.openedAt = c.pool.now()
+			c.retryAt = c.openedAt.Add(c.pool.cfg.OpenTimeout)
+			Applog.Warnf("connector circuit opened, addr: %v, failures: %v", c.addr, c.failures)
+		}
+	}
+	// 熔断后丢弃旧连接，下次探测时重新建连
+	if c.state == breakerOpen {
+		return c.detachClientLocked()
+	}
+	return nil
+}
+
+// close 在锁外关闭连接，Close 可能阻塞，不能影响其它请求读取熔断状态
+func (c *pooledConnector) close() {
+	c.mu.Lock()
+	client := c.detachClientLocked()
+	c.mu.Unlock()
+	closeConnectorClient(client, c.addr)
+}
+
+// detachClientLocked 换下当前连接并返回，由调用方在释放锁之后关闭
+func (c *pooledConnector) detachClientLocked() ConnectorClient {
+	client := c.client
+	c.client = nil
+	return client
+}
+
+// closeConnectorClient client 为 nil 或者不支持关闭时什么也不做
+func closeConnectorClient(client ConnectorClient, addr string) {
+	if closer, ok := client.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			Applog.Warnf("close connector err:%+v addr: %v", err, addr)
+		}
+	}
+}
@@ -0,0 +1,324 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// poolClient 连接池测试用的 connector 连接，不支持健康检查
+type poolClient struct {
+	fakeConnector
+	closed bool
+	// onClose 不为空时在 Close 中调用，用于检查锁
+	onClose func()
+}
+
+func (c *poolClient) Close() error {
+	if c.onClose != nil {
+		c.onClose()
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.closed = true
+	return nil
+}
+
+func (c *poolClient) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+// checkedPoolClient 支持健康检查的连接
+type checkedPoolClient struct {
+	poolClient
+	healthErr error
+}
+
+func (c *checkedPoolClient) HealthCheck(ctx context.Context) error {
+	return c.healthErr
+}
+
+// testDialer 依次返回 clients 中的连接，用完后返回 err
+type testDialer struct {
+	mu      sync.Mutex
+	clients []ConnectorClient
+	err     error
+	dials   int
+	// before 不为空时在返回之前调用，用于阻塞建连或检查锁
+	before func()
+}
+
+func (d *testDialer) dial(ctx context.Context, addr string) (ConnectorClient, error) {
+	if d.before != nil {
+		d.before()
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.dials++
+	if len(d.clients) == 0 {
+		return nil, d.err
+	}
+	c := d.clients[0]
+	d.clients = d.clients[1:]
+	return c, nil
+}
+
+func (d *testDialer) count() int {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.dials
+}
+
+var testPoolConfig = ConnectorPoolConfig{
+	FailureThreshold: 2,
+	OpenTimeout:      5 * time.Second,
+	UnreachableAfter: time.Minute,
+	IdleTimeout:      10 * time.Minute,
+}
+
+func newTestPool(cfg ConnectorPoolConfig, d *testDialer) (*ConnectorPool, *time.Time) {
+	p := NewConnectorPool(cfg, d.dial)
+	now := testNow
+	p.now = func() time.Time { return now }
+	return p, &now
+}
+
+func resolvePooled(t *testing.T, p *ConnectorPool, addr string) *pooledConnector {
+	t.Helper()
+	c, err := p.Resolve(addr)
+	assert.NoError(t, err)
+	return c.(*pooledConnector)
+}
+
+func TestConnectorPoolResolve(t *testing.T) {
+	client := &poolClient{}
+	d := &testDialer{clients: []ConnectorClient{client}}
+	p, _ := newTestPool(testPoolConfig, d)
+	ctx := context.Background()
+
+	_, err := p.Resolve("")
+	assert.Equal(t, NoConnectionErr, err)
+
+	c1 := resolvePooled(t, p, "a:1")
+	c2 := resolvePooled(t, p, "a:1")
+	assert.Same(t, c1, c2)
+	assert.Equal(t, 0, d.count(), "dial lazily")
+
+	assert.NoError(t, c1.TransmitMessage(ctx, &TransmitMessageRequest{MsgId: "1"}))
+	assert.NoError(t, c2.TransmitMessage(ctx, &TransmitMessageRequest{MsgId: "2"}))
+	assert.Equal(t, 1, d.count())
+	assert.Len(t, client.requests(), 2)
+
+	p.Remove("a:1")
+	assert.True(t, client.isClosed())
+	assert.Equal(t, 0, p.Len())
+}
+
+func TestConnectorPoolConcurrentDialOutsideLock(t *testing.T) {
+	release := make(chan struct{})
+	dialing := make(chan struct{})
+	d := &testDialer{clients: []ConnectorClient{&poolClient{}}}
+	p, _ := newTestPool(testPoolConfig, d)
+	c := resolvePooled(t, p, "a:1")
+	var once sync.Once
+	d.before = func() {
+		once.Do(func() { close(dialing) })
+		<-release
+	}
+
+	var wg sync.WaitGroup
+	errs := make([]error, 5)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			errs[i] = c.TransmitMessage(context.Background(), &TransmitMessageRequest{})
+		}(i)
+	}
+	<-dialing
+	// 建连期间不持有连接的锁
+	assert.True(t, c.mu.TryLock())
+	c.mu.Unlock()
+	close(release)
+	wg.Wait()
+	for _, err := range errs {
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, 1, d.count())
+}
+
+func TestConnectorPoolDialError(t *testing.T) {
+	dialErr := errors.New("refused")
+	d := &testDialer{err: dialErr}
+	p, _ := newTestPool(testPoolConfig, d)
+	c := resolvePooled(t, p, "a:1")
+
+	err := c.TransmitMessage(context.Background(), &TransmitMessageRequest{})
+	assert.True(t, errors.Is(err, NoConnectionErr))
+	assert.Contains(t, err.Error(), "refused")
+	assert.Equal(t, 1, c.failures)
+}
+
+func TestConnectorPoolBreaker(t *testing.T) {
+	transmitErr := errors.New("broken pipe")
+	first := &poolClient{fakeConnector: fakeConnector{err: transmitErr}}
+	second := &poolClient{}
+	d := &testDialer{clients: []ConnectorClient{first, second}}
+	cfg := testPoolConfig
+	cfg.UnreachableAfter = 3 * time.Second
+	p, now := newTestPool(cfg, d)
+	c := resolvePooled(t, p, "a:1")
+	ctx := context.Background()
+
+	// 连续失败达到阈值后熔断并丢弃连接
+	assert.Equal(t, transmitErr, c.TransmitMessage(ctx, &TransmitMessageRequest{}))
+	assert.Equal(t, transmitErr, c.TransmitMessage(ctx, &TransmitMessageRequest{}))
+	assert.Equal(t, breakerOpen, c.state)
+	assert.True(t, first.isClosed())
+
+	// 熔断期间直接拒绝，超过 UnreachableAfter 后返回 ConnectorUnreachableErr
+	assert.Equal(t, ConnectorCircuitOpenErr, c.TransmitMessage(ctx, &TransmitMessageRequest{}))
+	*now = now.Add(4 * time.Second)
+	assert.Equal(t, ConnectorUnreachableErr, c.TransmitMessage(ctx, &TransmitMessageRequest{}))
+
+	// 到了探测时间，请求本身就是探测，不需要健康检查
+	*now = now.Add(time.Second)
+	assert.NoError(t, c.TransmitMessage(ctx, &TransmitMessageRequest{}))
+	assert.Equal(t, breakerClosed, c.state)
+	assert.Equal(t, 2, d.count())
+	assert.Len(t, second.requests(), 1)
+}
+
+func TestConnectorPoolHalfOpenSingleProbe(t *testing.T) {
+	d := &testDialer{}
+	p, now := newTestPool(testPoolConfig, d)
+	c := resolvePooled(t, p, "a:1")
+	c.state = breakerOpen
+	c.openedAt = *now
+	c.retryAt = *now
+
+	assert.NoError(t, c.admitLocked(*now))
+	assert.Equal(t, breakerHalfOpen, c.state)
+	assert.Equal(t, ConnectorCircuitOpenErr, c.admitLocked(*now))
+
+	// 探测失败后重新熔断，保留最初的熔断时间
+	c.recordFailureLocked()
+	assert.Equal(t, breakerOpen, c.state)
+	assert.Equal(t, now.Add(testPoolConfig.OpenTimeout), c.retryAt)
+	assert.Equal(t, *now, c.openedAt)
+}
+
+func TestConnectorPoolRedial(t *testing.T) {
+	testCases := []struct {
+		name      string
+		client    ConnectorClient
+		dialErr   error
+		wantState breakerState
+		wantConn  bool
+	}{
+		{name: "healthy", client: &checkedPoolClient{}, wantState: breakerClosed, wantConn: true},
+		{name: "unhealthy", client: &checkedPoolClient{healthErr: errors.New("not serving")}, wantState: breakerOpen},
+		{name: "no-health-check-half-open", client: &poolClient{}, wantState: breakerHalfOpen, wantConn: true},
+		{name: "dial-failed", dialErr: errors.New("refused"), wantState: breakerOpen},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d := &testDialer{err: tc.dialErr}
+			if tc.client != nil {
+				d.clients = []ConnectorClient{tc.client}
+			}
+			p, now := newTestPool(testPoolConfig, d)
+			c := resolvePooled(t, p, "a:1")
+			c.state = breakerOpen
+			c.openedAt = *now
+			c.retryAt = *now
+
+			p.checkAll(context.Background())
+			assert.Equal(t, tc.wantState, c.state)
+			assert.Equal(t, tc.wantConn, c.client != nil)
+			if tc.wantState == breakerOpen {
+				assert.Equal(t, now.Add(testPoolConfig.OpenTimeout), c.retryAt)
+			}
+			if checked, ok := tc.client.(*checkedPoolClient); ok && checked.healthErr != nil {
+				assert.True(t, checked.isClosed())
+			}
+		})
+	}
+}
+
+func TestConnectorPoolHealthCheckFailureOpensBreaker(t *testing.T) {
+	client := &checkedPoolClient{}
+	d := &testDialer{clients: []ConnectorClient{client}}
+	p, _ := newTestPool(testPoolConfig, d)
+	c := resolvePooled(t, p, "a:1")
+	assert.NoError(t, c.TransmitMessage(context.Background(), &TransmitMessageRequest{}))
+
+	client.healthErr = errors.New("not serving")
+	p.checkAll(context.Background())
+	p.checkAll(context.Background())
+	assert.Equal(t, breakerOpen, c.state)
+	assert.True(t, client.isClosed())
+}
+
+func TestConnectorPoolEvictIdle(t *testing.T) {
+	a := &poolClient{}
+	d := &testDialer{clients: []ConnectorClient{a, &poolClient{}, &poolClient{}}}
+	p, now := newTestPool(testPoolConfig, d)
+	ctx := context.Background()
+
+	old := resolvePooled(t, p, "a:1")
+	assert.NoError(t, old.TransmitMessage(ctx, &TransmitMessageRequest{}))
+	*now = now.Add(11 * time.Minute)
+	resolvePooled(t, p, "b:1")
+	assert.Equal(t, 1, p.Len())
+	assert.True(t, a.isClosed())
+
+	// 仍持有旧连接的请求改用连接池中的新连接
+	assert.NoError(t, old.TransmitMessage(ctx, &TransmitMessageRequest{}))
+	assert.Equal(t, 2, p.Len())
+	assert.NotSame(t, old, resolvePooled(t, p, "a:1"))
+
+	// 健康检查同样清理空闲连接
+	*now = now.Add(11 * time.Minute)
+	p.checkAll(ctx)
+	assert.Equal(t, 0, p.Len())
+}
+
+func TestConnectorPoolCloseOutsideLock(t *testing.T) {
+	testCases := []struct {
+		name  string
+		close func(p *ConnectorPool, c *pooledConnector)
+	}{
+		{name: "breaker-opened", close: func(p *ConnectorPool, c *pooledConnector) {
+			for i := 0; i < testPoolConfig.FailureThreshold; i++ {
+				c.TransmitMessage(context.Background(), &TransmitMessageRequest{})
+			}
+		}},
+		{name: "removed", close: func(p *ConnectorPool, c *pooledConnector) { p.Remove(c.addr) }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			client := &poolClient{fakeConnector: fakeConnector{err: errors.New("broken pipe")}}
+			d := &testDialer{clients: []ConnectorClient{client}}
+			p, _ := newTestPool(testPoolConfig, d)
+			c := resolvePooled(t, p, "a:1")
+			c.TransmitMessage(context.Background(), &TransmitMessageRequest{})
+			// Close 期间连接的锁没有被持有
+			client.onClose = func() {
+				if assert.True(t, c.mu.TryLock()) {
+					c.mu.Unlock()
+				}
+			}
+
+			tc.close(p, c)
+			assert.True(t, client.isClosed())
+			assert.Nil(t, c.client)
+		})
+	}
+}
@@ -0,0 +1,417 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+const (
+	MetricContentFlagged   = "router_content_flagged"
+	MetricContentMasked    = "router_content_masked"
+	MetricContentRejected  = "router_content_rejected"
+	MetricContentFilterErr = "router_content_filter_error"
+
+	// ContentFilterErrRule 过滤器出错并且 ContentFilterFailClosed 开启时上报的规则名
+	ContentFilterErrRule = "content_filter_error"
+
+	contentMaskRune = '*'
+)
+
+// ContentFilterAction 命中规则后的处理方式，数值越大越严格
+type ContentFilterAction int
+
+const (
+	ContentAllow ContentFilterAction = iota
+	// ContentFlag 只记录日志和监控，内容不变
+	ContentFlag
+	// ContentMask 命中的文字替换为 *
+	ContentMask
+	// ContentReject 拒绝下发
+	ContentReject
+)
+
+func (a ContentFilterAction) String() string {
+	switch a {
+	case ContentAllow:
+		return "allow"
+	case ContentFlag:
+		return "flag"
+	case ContentMask:
+		return "mask"
+	case ContentReject:
+		return "reject"
+	}
+	return fmt.Sprintf("ContentFilterAction(%d)", int(a))
+}
+
+func (a *ContentFilterAction) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return err
+	}
+	for _, v := range []ContentFilterAction{ContentAllow, ContentFlag, ContentMask, ContentReject} {
+		if v.String() == s {
+			*a = v
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown content filter action %q", s)
+}
+
+// ContentFilterResult Text 为处理后的文本，Rules 为命中的规则
+type ContentFilterResult struct {
+	Action ContentFilterAction
+	Text   string
+	Rules  []string
+}
+
+// ContentFilter 过滤一段用户可见的文本
+type ContentFilter interface {
+	FilterText(ctx context.Context, text string) (*ContentFilterResult, error)
+}
+
+// ContentFilterChain 依次执行过滤器，前一个过滤器处理后的文本交给下一个，命中 reject 时立即返回；
+// 过滤器出错时跳过该过滤器继续执行，返回其余过滤器的结果和第一个错误，由调用方决定放行还是拒绝
+type ContentFilterChain []ContentFilter
+
+func (c ContentFilterChain) FilterText(ctx context.Context, text string) (*ContentFilterResult, error) {
+	res := &ContentFilterResult{Text: text}
+	var firstErr error
+	for _, f := range c {
+		r, err := f.FilterText(ctx, res.Text)
+		if err != nil || r == nil {
+			if firstErr == nil {
+				firstErr = fmt.Errorf("content filter %T: %v", f, err)
+			}
+			continue
+		}
+		if r.Action > res.Action {
+			res.Action = r.Action
+		}
+		res.Text = r.Text
+		res.Rules = append(res.Rules, r.Rules...)
+		if res.Action == ContentReject {
+			break
+		}
+	}
+	return res, firstErr
+}
+
+// ContentFilterRule 关键词或正则规则，关键词匹配不区分大小写
+type ContentFilterRule struct {
+	Name    string
+	Pattern string
+	Regex   bool
+	Action  ContentFilterAction
+}
+
+type keywordMatch struct {
+	start, end int // rune 下标，[start, end)
+	rule       *ContentFilterRule
+}
+
+type regexRule struct {
+	re   *regexp.Regexp
+	rule *ContentFilterRule
+}
+
+// KeywordContentFilter 本地词典，关键词用 Aho-Corasick 自动机一次扫描匹配，正则逐条匹配
+type KeywordContentFilter struct {
+	ac      *ahoCorasick
+	regexes []regexRule
+}
+
+func NewKeywordContentFilter(rules []ContentFilterRule) (*KeywordContentFilter, error) {
+	f := &KeywordContentFilter{ac: newAhoCorasick()}
+	for i := range rules {
+		rule := &rules[i]
+		if len(rule.Pattern) == 0 {
+			return nil, fmt.Errorf("content filter rule %v has empty pattern", rule.Name)
+		}
+		if len(rule.Name) == 0 {
+			rule.Name = rule.Pattern
+		}
+		if rule.Regex {
+			re, err := regexp.Compile(rule.Pattern)
+			if err != nil {
+				return nil, fmt.Errorf("content filter rule %v: %v", rule.Name, err)
+			}
+			f.regexes = append(f.regexes, regexRule{re: re, rule: rule})
+			continue
+		}
+		f.ac.add(foldRunes(rule.Pattern), rule)
+	}
+	f.ac.build()
+	return f, nil
+}
+
+// LoadKeywordContentFilter 从 JSON 文件加载规则，文件内容为 ContentFilterRule 数组
+func LoadKeywordContentFilter(path string) (*KeywordContentFilter, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []ContentFilterRule
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("parse content filter rules %v: %v", path, err)
+	}
+	return NewKeywordContentFilter(rules)
+}
+
+func (f *KeywordContentFilter) FilterText(ctx context.Context, text string) (*ContentFilterResult, error) {
+	res := &ContentFilterResult{Text: text}
+	if len(text) == 0 {
+		return res, nil
+	}
+	runes := []rune(text)
+	matches := f.ac.match(foldRunes(text))
+	for _, r := range f.regexes {
+		for _, loc := range r.re.FindAllStringIndex(text, -1) {
+			matches = append(matches, keywordMatch{
+				start: len([]rune(text[:loc[0]])),
+				end:   len([]rune(text[:loc[1]])),
+				rule:  r.rule,
+			})
+		}
+	}
+	if len(matches) == 0 {
+		return res, nil
+	}
+	seen := make(map[string]bool)
+	masked := false
+	for _, m := range matches {
+		if !seen[m.rule.Name] {
+			seen[m.rule.Name] = true
+			res.Rules = append(res.Rules, m.rule.Name)
+		}
+		if m.rule.Action > res.Action {
+			res.Action = m.rule.Action
+		}
+		if m.rule.Action == ContentMask {
+			for i := m.start; i < m.end; i++ {
+				runes[i] = contentMaskRune
+			}
+			masked = true
+		}
+	}
+	if masked {
+		res.Text = string(runes)
+	}
+	return res, nil
+}
+
+// foldRunes 转成小写，不改变 rune 的个数，保证匹配位置可以映射回原文
+func foldRunes(s string) []rune {
+	runes := []rune(s)
+	for i, r := range runes {
+		runes[i] = unicode.ToLower(r)
+	}
+	return runes
+}
+
+// ============== Aho-Corasick ==============
+
+type acNode struct {
+	next   map[rune]int
+	fail   int
+	output []int // 以该节点结尾的关键词下标，包含 fail 链上的关键词
+}
+
+type ahoCorasick struct {
+	nodes    []acNode
+	patterns []int // 关键词的 rune 长度
+	rules    []*ContentFilterRule
+}
+
+func newAhoCorasick() *ahoCorasick {
+	return &ahoCorasick{nodes: []acNode{{next: make(map[rune]int)}}}
+}
+
+func (a *ahoCorasick) add(pattern []rune, rule *ContentFilterRule) {
+	cur := 0
+	for _, r := range pattern {
+		nxt, ok := a.nodes[cur].next[r]
+		if !ok {
+			a.nodes = append(a.nodes, acNode{next: make(map[rune]int)})
+			nxt = len(a.nodes) - 1
+			a.nodes[cur].next[r] = nxt
+		}
+		cur = nxt
+	}
+	a.nodes[cur].output = append(a.nodes[cur].output, len(a.patterns))
+	a.patterns = append(a.patterns, len(pattern))
+	a.rules = append(a.rules, rule)
+}
+
+// build 按 BFS 计算 fail 指针，并把 fail 节点的输出合并到当前节点
+func (a *ahoCorasick) build() {
+	queue := make([]int, 0, len(a.nodes))
+	for _, child := range a.nodes[0].next {
+		a.nodes[child].fail = 0
+		queue = append(queue, child)
+	}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for r, child := range a.nodes[cur].next {
+			f := a.nodes[cur].fail
+			for f > 0 {
+				if _, ok := a.nodes[f].next[r]; ok {
+					break
+				}
+				f = a.nodes[f].fail
+			}
+			if nxt, ok := a.nodes[f].next[r]; ok && nxt != child {
+				a.nodes[child].fail = nxt
+			} else {
+				a.nodes[child].fail = 0
+			}
+			a.nodes[child].output = append(a.nodes[child].output, a.nodes[a.nodes[child].fail].output...)
+			queue = append(queue, child)
+		}
+	}
+}
+
+func (a *ahoCorasick) match(text []rune) []keywordMatch {
+	var matches []keywordMatch
+	cur := 0
+	for i, r := range text {
+		for cur > 0 {
+			if _, ok := a.nodes[cur].next[r]; ok {
+				break
+			}
+			cur = a.nodes[cur].fail
+		}
+		if nxt, ok := a.nodes[cur].next[r]; ok {
+			cur = nxt
+		}
+		for _, p := range a.nodes[cur].output {
+			matches = append(matches, keywordMatch{start: i + 1 - a.patterns[p], end: i + 1, rule: a.rules[p]})
+		}
+	}
+	return matches
+}
+
+// ============== RouterServer ==============
+
+// filterPush 过滤本地化之后的推送文本，返回处理后的推送和最严格的处理方式
+func (s *RouterServer) filterPush(ctx context.Context, push PushContent) (PushContent, *ContentFilterResult) {
+	total := &ContentFilterResult{}
+	apply := func(text string) string {
+		if len(text) == 0 {
+			return text
+		}
+		r := s.filterText(ctx, text)
+		if r.Action > total.Action {
+			total.Action = r.Action
+		}
+		total.Rules = append(total.Rules, r.Rules...)
+		return r.Text
+	}
+	for _, i18n := range []*I18N{push.Title, push.Value, push.Ticker} {
+		if i18n != nil {
+			i18n.Value = apply(i18n.Value)
+		}
+	}
+	push.Message = apply(push.Message)
+	return push, total
+}
+
+// filterText 过滤器出错时默认放行，使用 ContentFilterChain 时其余过滤器的结果仍然生效；
+// 开启 ContentFilterFailClosed 后按 reject 处理
+func (s *RouterServer) filterText(ctx context.Context, text string) *ContentFilterResult {
+	r, err := s.ContentFilter.FilterText(ctx, text)
+	if err == nil && r != nil {
+		return r
+	}
+	if err == nil {
+		err = fmt.Errorf("content filter returned nil result")
+	}
+	Metrics.Counter(MetricContentFilterErr, 1)
+	Applog.Errorf("content filter err:%+v fail closed: %v", err, s.ContentFilterFailClosed)
+	if s.ContentFilterFailClosed {
+		return &ContentFilterResult{Action: ContentReject, Text: text, Rules: []string{ContentFilterErrRule}}
+	}
+	if r != nil {
+		return r
+	}
+	return &ContentFilterResult{Text: text}
+}
+
+// filterMsgData 解码 MsgData 后过滤其中所有的字符串字段，被 mask 时重新编码，数字保持原样；
+// 无法解码为 JSON 的 MsgData 不过滤
+func (s *RouterServer) filterMsgData(ctx context.Context, in *TransferMessageRequest) error {
+	if s.ContentFilter == nil || in.GetMsgData() == nil || len(in.MsgData.Value) == 0 {
+		return nil
+	}
+	var data interface{}
+	dec := json.NewDecoder(bytes.NewReader(in.MsgData.Value))
+	dec.UseNumber()
+	if err := dec.Decode(&data); err != nil {
+		return nil
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil
+	}
+	total := &ContentFilterResult{}
+	data = filterJSONStrings(data, func(text string) string {
+		r := s.filterText(ctx, text)
+		if r.Action > total.Action {
+			total.Action = r.Action
+		}
+		total.Rules = append(total.Rules, r.Rules...)
+		return r.Text
+	})
+	s.reportContentFilter(in, "", "msg_data", total)
+	switch total.Action {
+	case ContentReject:
+		return &InvalidRequestError{Reason: fmt.Sprintf("msg data rejected by content filter rules %v, msgId: %v", strings.Join(total.Rules, ","), in.MsgId)}
+	case ContentMask:
+		raw, err := json.Marshal(data)
+		if err != nil {
+			return err
+		}
+		in.MsgData = &Any{TypeUrl: in.MsgData.TypeUrl, Value: raw}
+	}
+	return nil
+}
+
+func filterJSONStrings(v interface{}, apply func(string) string) interface{} {
+	switch t := v.(type) {
+	case string:
+		if len(t) == 0 {
+			return t
+		}
+		return apply(t)
+	case map[string]interface{}:
+		for k, e := range t {
+			t[k] = filterJSONStrings(e, apply)
+		}
+	case []interface{}:
+		for i, e := range t {
+			t[i] = filterJSONStrings(e, apply)
+		}
+	}
+	return v
+}
+
+func (s *RouterServer) reportContentFilter(in *TransferMessageRequest, deviceID, field string, r *ContentFilterResult) {
+	switch r.Action {
+	case ContentFlag:
+		Metrics.Counter(MetricContentFlagged, 1)
+	case ContentMask:
+		Metrics.Counter(MetricContentMasked, 1)
+	case ContentReject:
+		Metrics.Counter(MetricContentRejected, 1)
+	default:
+		return
+	}
+	Applog.Warnf("content filter %v %v, rules: %v, msgId: %v, uid: %v, deviceID: %v", r.Action, field, strings.Join(r.Rules, ","), in.MsgId, in.ReceiverId, deviceID)
+}
@@ -0,0 +1,201 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestKeywordFilter(t *testing.T) *KeywordContentFilter {
+	t.Helper()
+	f, err := NewKeywordContentFilter([]ContentFilterRule{
+		{Name: "spam", Pattern: "Spam", Action: ContentMask},
+		{Name: "promo", Pattern: "promo", Action: ContentFlag},
+		{Name: "phone", Pattern: `\d{3}-\d{4}`, Regex: true, Action: ContentMask},
+		{Name: "banned", Pattern: "banned", Action: ContentReject},
+	})
+	assert.NoError(t, err)
+	return f
+}
+
+func TestKeywordContentFilter(t *testing.T) {
+	f := newTestKeywordFilter(t)
+	testCases := []struct {
+		name       string
+		text       string
+		wantAction ContentFilterAction
+		wantText   string
+		wantRules  []string
+	}{
+		{name: "clean", text: "hello", wantAction: ContentAllow, wantText: "hello"},
+		{name: "mask-case-insensitive", text: "no SPAM here", wantAction: ContentMask, wantText: "no **** here", wantRules: []string{"spam"}},
+		{name: "flag-keeps-text", text: "a promo", wantAction: ContentFlag, wantText: "a promo", wantRules: []string{"promo"}},
+		{name: "regex-mask", text: "call 555-1234", wantAction: ContentMask, wantText: "call ********", wantRules: []string{"phone"}},
+		{name: "strictest-wins", text: "banned spam", wantAction: ContentReject, wantText: "banned ****", wantRules: []string{"banned", "spam"}},
+		{name: "multibyte", text: "垃圾spam", wantAction: ContentMask, wantText: "垃圾****", wantRules: []string{"spam"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := f.FilterText(context.Background(), tc.text)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantAction, r.Action)
+			assert.Equal(t, tc.wantText, r.Text)
+			assert.ElementsMatch(t, tc.wantRules, r.Rules)
+		})
+	}
+}
+
+// errContentFilter 总是返回 err，res 为 nil 时模拟实现返回空结果
+type errContentFilter struct {
+	res *ContentFilterResult
+	err error
+}
+
+func (f errContentFilter) FilterText(ctx context.Context, text string) (*ContentFilterResult, error) {
+	return f.res, f.err
+}
+
+func TestContentFilterChain(t *testing.T) {
+	errDown := errors.New("down")
+	chain := ContentFilterChain{errContentFilter{err: errDown}, newTestKeywordFilter(t)}
+	r, err := chain.FilterText(context.Background(), "spam")
+	assert.ErrorContains(t, err, "down")
+	// 出错的过滤器被跳过，其余过滤器的结果仍然返回
+	assert.Equal(t, ContentMask, r.Action)
+	assert.Equal(t, "****", r.Text)
+
+	chain = ContentFilterChain{newTestKeywordFilter(t), errContentFilter{err: errDown}}
+	r, err = chain.FilterText(context.Background(), "banned")
+	assert.NoError(t, err, "reject stops the chain")
+	assert.Equal(t, ContentReject, r.Action)
+}
+
+func TestFilterTextErrors(t *testing.T) {
+	testCases := []struct {
+		name       string
+		filter     ContentFilter
+		failClosed bool
+		wantAction ContentFilterAction
+		wantText   string
+	}{
+		{name: "error-fail-open", filter: errContentFilter{err: errors.New("down")}, wantText: "spam"},
+		{name: "nil-result-fail-open", filter: errContentFilter{}, wantText: "spam"},
+		{name: "error-fail-closed", filter: errContentFilter{err: errors.New("down")}, failClosed: true, wantAction: ContentReject, wantText: "spam"},
+		{name: "nil-result-fail-closed", filter: errContentFilter{}, failClosed: true, wantAction: ContentReject, wantText: "spam"},
+		{name: "chain-partial-result-fail-open", filter: ContentFilterChain{errContentFilter{err: errors.New("down")}, newTestKeywordFilter(t)}, wantAction: ContentMask, wantText: "****"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.ContentFilter = tc.filter
+			ts.ContentFilterFailClosed = tc.failClosed
+			filterErr := Metrics.Value(MetricContentFilterErr)
+
+			r := ts.filterText(context.Background(), "spam")
+			assert.Equal(t, tc.wantAction, r.Action)
+			assert.Equal(t, tc.wantText, r.Text)
+			assert.Equal(t, filterErr+1, Metrics.Value(MetricContentFilterErr))
+		})
+	}
+}
+
+func TestFilterMsgData(t *testing.T) {
+	testCases := []struct {
+		name       string
+		data       string
+		filter     ContentFilter
+		failClosed bool
+		wantErr    bool
+		want       string
+	}{
+		{name: "clean-unchanged", data: `{"text":"hi","id":12345678901234567890}`, want: `{"text":"hi","id":12345678901234567890}`},
+		{name: "masked-keeps-numbers", data: `{"text":"spam","id":12345678901234567890,"ratio":1.50,"list":["spam",1e3]}`, want: `{"id":12345678901234567890,"list":["****",1e3],"ratio":1.50,"text":"****"}`},
+		{name: "rejected", data: `{"text":"banned"}`, wantErr: true},
+		{name: "not-json", data: `spam`, want: `spam`},
+		{name: "trailing-data-not-filtered", data: `{"text":"spam"} spam`, want: `{"text":"spam"} spam`},
+		{name: "filter-error-fail-closed", data: `{"text":"hi"}`, filter: errContentFilter{err: errors.New("down")}, failClosed: true, wantErr: true},
+		{name: "filter-error-fail-open", data: `{"text":"hi"}`, filter: errContentFilter{err: errors.New("down")}, want: `{"text":"hi"}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.ContentFilter = newTestKeywordFilter(t)
+			if tc.filter != nil {
+				ts.ContentFilter = tc.filter
+			}
+			ts.ContentFilterFailClosed = tc.failClosed
+			in := newTestRequest()
+			in.MsgData = &Any{TypeUrl: "type.googleapis.com/chat", Value: []byte(tc.data)}
+
+			err := ts.filterMsgData(context.Background(), in)
+			if tc.wantErr {
+				var invalid *InvalidRequestError
+				assert.ErrorAs(t, err, &invalid)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, string(in.MsgData.Value))
+			assert.Equal(t, "type.googleapis.com/chat", in.MsgData.TypeUrl)
+		})
+	}
+}
+
+func TestTransferContentFilter(t *testing.T) {
+	testCases := []struct {
+		name        string
+		title       string
+		wantSkipped bool
+		wantTitle   string
+	}{
+		{name: "clean", title: "hello", wantTitle: "hello"},
+		{name: "masked", title: "spam offer", wantTitle: "**** offer"},
+		{name: "rejected", title: "banned", wantSkipped: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			ts.ContentFilter = newTestKeywordFilter(t)
+			in := newTestRequest()
+			in.Push.Title = &I18N{Value: tc.title}
+
+			_, err := ts.TransferOnlineReliableMessage(context.Background(), in)
+			assert.NoError(t, err)
+			if tc.wantSkipped {
+				ev := ts.observer.next(t, EventDeviceSkipped)
+				assert.Equal(t, SkipReasonContentRejected, ev.Reason)
+				assert.Empty(t, conn.requests())
+				return
+			}
+			ts.observer.next(t, EventMsgDelivered)
+			if reqs := conn.requests(); assert.Len(t, reqs, 1) {
+				assert.Equal(t, tc.wantTitle, reqs[0].Push.Title.Value)
+			}
+		})
+	}
+}
+
+// panicConnector 在 TransmitMessage 中 panic，done 在 panic 展开时关闭
+type panicConnector struct {
+	done chan struct{}
+}
+
+func (c *panicConnector) TransmitMessage(ctx context.Context, req *TransmitMessageRequest) error {
+	defer close(c.done)
+	panic("connector bug")
+}
+
+func TestDispatchWithoutSchedulerRecoversPanic(t *testing.T) {
+	bad := &panicConnector{done: make(chan struct{})}
+	conn := &fakeConnector{}
+	ts := newTestServer(t, iosDevice("d1", conn))
+
+	assert.NoError(t, ts.dispatch(context.Background(), newTestRequest(), []*ConnectorClientWrapper{iosDevice("bad", bad)}))
+	<-bad.done
+	// 投递 goroutine 的 panic 被恢复，后续消息照常下发
+	assert.NoError(t, ts.dispatch(context.Background(), newTestRequest(), []*ConnectorClientWrapper{iosDevice("d1", conn)}))
+	ts.observer.next(t, EventMsgDelivered)
+	assert.Len(t, conn.requests(), 1)
+}
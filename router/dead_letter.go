@@ -0,0 +1,628 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gangcheng1030/ai_testing_and_refactoring/go_redis_test"
+)
+
+const (
+	DeadLetterStageStore   = "store"
+	DeadLetterStageDeliver = "deliver"
+
+	DeadLetterKey = "router_dead_letters"
+
+	MetricDeadLetter        = "router_dead_letter"
+	MetricDeadLetterFailed  = "router_dead_letter_failed"
+	MetricDeadLetterSkipped = "router_dead_letter_skipped"
+	MetricDeadLetterTrimmed = "router_dead_letter_trimmed"
+
+	// DefaultDeadLetterMaxLen 每个 sink（开启多租户时每个租户）默认最多保留的死信条数
+	DefaultDeadLetterMaxLen = 100000
+
+	// deadLetterReplayTimeout 重放投递阶段的死信时等待投递结果（包括重试）的最长时间
+	deadLetterReplayTimeout = 30 * time.Second
+)
+
+// DeadLetterDeviceOfflineErr 重放投递阶段的死信时目标设备不在线，死信保留到下次重放
+var DeadLetterDeviceOfflineErr = errors.New("dead letter target device is offline")
+
+// DeadLetter 一条无法投递或存储的消息，配置 RouterServer.Keyring 后写入 DeadLetterSink 的 Request
+// 只保留 AppName、ReceiverId 和 MsgId，完整的请求加密后保存在 SealedRequest 中
+type DeadLetter struct {
+	Id            string
+	Request       *TransferMessageRequest
+	SealedRequest string `json:",omitempty"`
+	DeviceID      string // 为空表示与设备无关，例如存储失败
+	Stage         string
+	Seq           int64 // 存储阶段的死信记录原来的序列号，重放时沿用
+	Error         string
+	Attempts      int
+	CreatedAt     int64
+}
+
+// DeadLetterSink 死信的持久化
+type DeadLetterSink interface {
+	Put(ctx context.Context, dl *DeadLetter) error
+	// List 按写入顺序返回死信，limit <= 0 表示全部
+	List(ctx context.Context, limit int) ([]*DeadLetter, error)
+	Remove(ctx context.Context, ids ...string) error
+}
+
+func newDeadLetter(in *TransferMessageRequest, deviceID, stage string, err error, attempts int, now time.Time) *DeadLetter {
+	return &DeadLetter{
+		Id:        fmt.Sprintf("%d_%s_%s", now.UnixNano(), in.GetMsgId(), deviceID),
+		Request:   in,
+		DeviceID:  deviceID,
+		Stage:     stage,
+		Error:     err.Error(),
+		Attempts:  attempts,
+		CreatedAt: now.UnixNano() / 1000000,
+	}
+}
+
+// putDeadLetter 记录死信，未配置 DeadLetters 时只打日志
+func (s *RouterServer) putDeadLetter(ctx context.Context, in *TransferMessageRequest, deviceID, stage string, err error, attempts int) {
+	s.saveDeadLetter(ctx, newDeadLetter(in, deviceID, stage, err, attempts, s.clock()))
+}
+
+// putDeliverDeadLetter 记录投递失败的消息，错误处理已经删除路由或者拉黑设备时重放也不会投递成功，只打日志
+func (s *RouterServer) putDeliverDeadLetter(ctx context.Context, in *TransferMessageRequest, deviceID string, err error, attempts int) {
+	policy := s.errorPolicy()
+	class := policy.Classify(err)
+	switch policy.ActionOf(class) {
+	case ErrActionDeleteRoute, ErrActionBlacklistDevice:
+		Metrics.Counter(MetricDeadLetterSkipped, 1)
+		Applog.Infof("skip dead letter, msgId: %v deviceID: %v class: %v err: %v", in.GetMsgId(), deviceID, class, err)
+		return
+	}
+	s.putDeadLetter(ctx, in, deviceID, DeadLetterStageDeliver, err, attempts)
+}
+
+// deadLetterMaxLen maxLen <= 0 时使用 DefaultDeadLetterMaxLen
+func deadLetterMaxLen(maxLen int) int {
+	if maxLen <= 0 {
+		return DefaultDeadLetterMaxLen
+	}
+	return maxLen
+}
+
+// saveDeadLetter 返回死信是否写入成功
+func (s *RouterServer) saveDeadLetter(ctx context.Context, dl *DeadLetter) bool {
+	if s.DeadLetters == nil {
+		return false
+	}
+	if deliverWaiterFrom(ctx) != nil {
+		// 重放失败时保留原来的死信，不再写入新的
+		return false
+	}
+	stored, err := s.sealDeadLetter(dl)
+	if err != nil {
+		Metrics.Counter(MetricDeadLetterFailed, 1)
+		return false
+	}
+	if perr := s.DeadLetters.Put(ctx, stored); perr != nil {
+		Metrics.Counter(MetricDeadLetterFailed, 1)
+		Applog.Errorf("put dead letter err:%+v msgId: %v deviceID: %v stage: %v", perr, dl.Request.GetMsgId(), dl.DeviceID, dl.Stage)
+		return false
+	}
+	Metrics.Counter(MetricDeadLetter, 1)
+	return true
+}
+
+// ReplayDeadLetters 重放 filter 选中的死信，确认成功后才从 DeadLetters 中删除：
+// 投递阶段的死信只重新投递到原来的设备，不重新生成序列号也不重新存储，设备不在线时保留；
+// 存储阶段的死信使用原来的序列号重新写入消息存储；
+// 其它阶段（例如定时消息）的消息还没有被接收，重新提交给 TransferOnlineReliableMessage
+func (s *RouterServer) ReplayDeadLetters(ctx context.Context, filter func(*DeadLetter) bool) (int, error) {
+	if s.DeadLetters == nil {
+		return 0, NewStatusError(CodeUnavailable, "dead letter sink is not configured")
+	}
+	dls, err := s.DeadLetters.List(ctx, 0)
+	if err != nil {
+		return 0, err
+	}
+	replayed := 0
+	for _, dl := range dls {
+		if dl.Request == nil || (filter != nil && !filter(dl)) {
+			continue
+		}
+		if dl, err = s.openDeadLetter(dl); err != nil {
+			Applog.Errorf("decrypt dead letter err:%+v id: %v msgId: %v", err, dl.Id, dl.Request.GetMsgId())
+			continue
+		}
+		if err := s.replayDeadLetter(ctx, dl); err != nil {
+			Applog.Errorf("replay dead letter err:%+v id: %v msgId: %v stage: %v", err, dl.Id, dl.Request.GetMsgId(), dl.Stage)
+			continue
+		}
+		if err := s.DeadLetters.Remove(ctx, dl.Id); err != nil {
+			return replayed, err
+		}
+		replayed++
+	}
+	return replayed, nil
+}
+
+func (s *RouterServer) replayDeadLetter(ctx context.Context, dl *DeadLetter) error {
+	in := dl.Request
+	switch dl.Stage {
+	case DeadLetterStageDeliver:
+		return s.replayDelivery(ctx, in, dl.DeviceID)
+	case DeadLetterStageStore:
+		if dl.Seq == 0 {
+			return &InvalidRequestError{Reason: fmt.Sprintf("dead letter %v has no seq", dl.Id)}
+		}
+		userIdInt, err := strconv.Atoi(in.ReceiverId)
+		if err != nil {
+			return &InvalidRequestError{Reason: "receiver id is not valid"}
+		}
+		return s.storeReliableMsg(ctx, in, s.appIndex(in.AppName), userIdInt, dl.Seq)
+	}
+	_, err := s.TransferOnlineReliableMessage(internalCallContext(ctx), in)
+	return err
+}
+
+// replayDelivery 同步等待每个设备的投递结果，全部成功才返回 nil
+func (s *RouterServer) replayDelivery(ctx context.Context, in *TransferMessageRequest, deviceID string) error {
+	wrappers := s.router.PickConnectors(ctx, in.AppName, in.ReceiverId, deviceID, in.GetFilters())
+	if len(deviceID) == 0 {
+		wrappers = filterDeviceIdPushTargets(in, indexDeviceIdPushes(in), wrappers)
+	}
+	if len(wrappers) == 0 {
+		return DeadLetterDeviceOfflineErr
+	}
+	waiter := &deliverWaiter{results: make(chan error, len(wrappers))}
+	s.deliver(withDeliverWaiter(ctx, waiter), in, wrappers)
+	timeout := time.NewTimer(deadLetterReplayTimeout)
+	defer timeout.Stop()
+	for range wrappers {
+		select {
+		case err := <-waiter.results:
+			if err != nil {
+				return err
+			}
+		case <-timeout.C:
+			return fmt.Errorf("wait dead letter delivery timeout, msgId: %v", in.MsgId)
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+// deliverWaiter 接收 deliver 中每个设备的最终结果，设备被跳过时收到跳过原因
+type deliverWaiter struct {
+	results chan error
+}
+
+type deliverWaiterKey struct{}
+
+func withDeliverWaiter(ctx context.Context, w *deliverWaiter) context.Context {
+	return context.WithValue(ctx, deliverWaiterKey{}, w)
+}
+
+func deliverWaiterFrom(ctx context.Context) *deliverWaiter {
+	w, _ := ctx.Value(deliverWaiterKey{}).(*deliverWaiter)
+	return w
+}
+
+// done 每个设备只会调用一次，results 的容量等于设备数，不会阻塞投递
+func (w *deliverWaiter) done(err error) {
+	if w != nil {
+		w.results <- err
+	}
+}
+
+// ============== 本地文件 ==============
+
+// FileDeadLetterSink 以 JSON Lines 格式把死信追加写入本地文件，第一次访问时整个文件加载到内存并按 id 建立索引；
+// Remove 追加删除记录，已删除的行多于剩余的死信时重写文件。只适用于单实例部署
+type FileDeadLetterSink struct {
+	path string
+	// MaxLen 最多保留的死信条数，超过时删除最早的死信，<= 0 时使用 DefaultDeadLetterMaxLen
+	MaxLen int
+
+	mu      sync.Mutex
+	loaded  bool
+	order   []string // 按写入顺序排列的 id，可能包含已删除的 id，重写文件时清理
+	byID    map[string]*DeadLetter
+	garbage int // 文件中已删除的死信和删除记录的行数
+}
+
+// fileDeadLetterRecord 文件中的一行，RemovedId 不为空时表示删除该死信
+type fileDeadLetterRecord struct {
+	*DeadLetter
+	RemovedId string `json:",omitempty"`
+}
+
+func NewFileDeadLetterSink(path string) *FileDeadLetterSink {
+	return &FileDeadLetterSink{path: path}
+}
+
+func (f *FileDeadLetterSink) Put(ctx context.Context, dl *DeadLetter) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.load(); err != nil {
+		return err
+	}
+	records := []*fileDeadLetterRecord{{DeadLetter: dl}}
+	count := len(f.byID)
+	if _, ok := f.byID[dl.Id]; !ok {
+		count++
+	}
+	for _, id := range f.order {
+		if count <= deadLetterMaxLen(f.MaxLen) {
+			break
+		}
+		if _, ok := f.byID[id]; ok && id != dl.Id {
+			records = append(records, &fileDeadLetterRecord{RemovedId: id})
+			count--
+		}
+	}
+	if err := f.append(records...); err != nil {
+		return err
+	}
+	f.add(dl)
+	if trimmed := records[1:]; len(trimmed) > 0 {
+		for _, rec := range trimmed {
+			f.remove(rec.RemovedId)
+		}
+		Metrics.Counter(MetricDeadLetterTrimmed, int64(len(trimmed)))
+		Applog.Warnf("dead letters exceed max len %v, trimmed %v oldest, file: %v", deadLetterMaxLen(f.MaxLen), len(trimmed), f.path)
+		if f.garbage > len(f.byID) {
+			return f.compact()
+		}
+	}
+	return nil
+}
+
+func (f *FileDeadLetterSink) List(ctx context.Context, limit int) ([]*DeadLetter, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.load(); err != nil {
+		return nil, err
+	}
+	var dls []*DeadLetter
+	for _, id := range f.order {
+		if limit > 0 && len(dls) >= limit {
+			break
+		}
+		if dl, ok := f.byID[id]; ok {
+			dls = append(dls, dl)
+		}
+	}
+	return dls, nil
+}
+
+func (f *FileDeadLetterSink) Remove(ctx context.Context, ids ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.load(); err != nil {
+		return err
+	}
+	var records []*fileDeadLetterRecord
+	for _, id := range ids {
+		if _, ok := f.byID[id]; ok {
+			records = append(records, &fileDeadLetterRecord{RemovedId: id})
+		}
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	if err := f.append(records...); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		f.remove(rec.RemovedId)
+	}
+	if f.garbage > len(f.byID) {
+		return f.compact()
+	}
+	return nil
+}
+
+func (f *FileDeadLetterSink) add(dl *DeadLetter) {
+	if _, ok := f.byID[dl.Id]; ok {
+		f.garbage++
+	} else {
+		f.order = append(f.order, dl.Id)
+	}
+	f.byID[dl.Id] = dl
+}
+
+func (f *FileDeadLetterSink) remove(id string) {
+	if _, ok := f.byID[id]; ok {
+		delete(f.byID, id)
+		f.garbage++
+	}
+	// 删除记录本身也是一行
+	f.garbage++
+}
+
+func (f *FileDeadLetterSink) load() error {
+	if f.loaded {
+		return nil
+	}
+	f.order, f.byID, f.garbage = nil, make(map[string]*DeadLetter), 0
+	file, err := os.Open(f.path)
+	if os.IsNotExist(err) {
+		f.loaded = true
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		rec := &fileDeadLetterRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), rec); err != nil {
+			Applog.Errorf("unmarshal dead letter err:%+v file: %v", err, f.path)
+			f.garbage++
+			continue
+		}
+		switch {
+		case len(rec.RemovedId) > 0:
+			f.remove(rec.RemovedId)
+		case rec.DeadLetter != nil && len(rec.Id) > 0:
+			f.add(rec.DeadLetter)
+		default:
+			f.garbage++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	f.loaded = true
+	return nil
+}
+
+func (f *FileDeadLetterSink) append(records ...*fileDeadLetterRecord) error {
+	var buf []byte
+	for _, rec := range records {
+		raw, err := json.Marshal(rec)
+		if err != nil {
+			return err
+		}
+		buf = append(append(buf, raw...), '\n')
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(buf); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// compact 只保留未删除的死信重写文件
+func (f *FileDeadLetterSink) compact() error {
+	tmp := f.path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(file)
+	order := make([]string, 0, len(f.byID))
+	for _, id := range f.order {
+		dl, ok := f.byID[id]
+		if !ok {
+			continue
+		}
+		raw, err := json.Marshal(&fileDeadLetterRecord{DeadLetter: dl})
+		if err != nil {
+			file.Close()
+			return err
+		}
+		w.Write(raw)
+		w.WriteByte('\n')
+		order = append(order, id)
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, f.path); err != nil {
+		return err
+	}
+	f.order, f.garbage = order, 0
+	return nil
+}
+
+// ============== Redis ==============
+
+// DeadLetterRedisClient Redis 死信需要的操作
+type DeadLetterRedisClient interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+var _ DeadLetterRedisClient = (*go_redis_test.RedisClient)(nil)
+
+// putDeadLetterScript 死信内容写入 hash，写入时间写入有序集合，超过 ARGV[4] 条时删除最早的死信，返回删除的条数
+const putDeadLetterScript = `
+redis.call('HSET', KEYS[2], ARGV[1], ARGV[3])
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+local excess = redis.call('ZCARD', KEYS[1]) - tonumber(ARGV[4])
+if excess <= 0 then
+	return 0
+end
+local ids = redis.call('ZRANGE', KEYS[1], 0, excess - 1)
+for _, id in ipairs(ids) do
+	redis.call('HDEL', KEYS[2], id)
+end
+redis.call('ZREMRANGEBYRANK', KEYS[1], 0, excess - 1)
+return excess
+`
+
+// listDeadLettersScript 按写入时间返回前 ARGV[1]+1 条死信的内容，ARGV[1] 为 -1 时返回全部
+const listDeadLettersScript = `
+local ids = redis.call('ZRANGE', KEYS[1], 0, ARGV[1])
+local res = {}
+for _, id in ipairs(ids) do
+	local v = redis.call('HGET', KEYS[2], id)
+	if v then
+		table.insert(res, v)
+	end
+end
+return res
+`
+
+// removeDeadLettersScript 按 id 删除死信，返回删除的条数
+const removeDeadLettersScript = `
+local n = 0
+for i = 1, #ARGV do
+	n = n + redis.call('ZREM', KEYS[1], ARGV[i])
+	redis.call('HDEL', KEYS[2], ARGV[i])
+end
+return n
+`
+
+// RedisDeadLetterSink 用有序集合按写入时间索引死信 id，死信内容保存在 hash 中；
+// 配置 Tenants 后每个租户的死信写入加了 KeyPrefix 的有序集合和 hash
+type RedisDeadLetterSink struct {
+	client DeadLetterRedisClient
+	key    string // 有序集合的 key，hash 的 key 为 key + "_data"
+	// MaxLen 每个有序集合最多保留的死信条数，超过时删除最早的死信，<= 0 时使用 DefaultDeadLetterMaxLen
+	MaxLen int
+	// Tenants 可选，需要和 RouterServer.Tenants 保持一致
+	Tenants *TenantRegistry
+}
+
+func NewRedisDeadLetterSink(client DeadLetterRedisClient, key string) *RedisDeadLetterSink {
+	if len(key) == 0 {
+		key = DeadLetterKey
+	}
+	return &RedisDeadLetterSink{client: client, key: key}
+}
+
+func (r *RedisDeadLetterSink) Put(ctx context.Context, dl *DeadLetter) error {
+	raw, err := json.Marshal(dl)
+	if err != nil {
+		return err
+	}
+	keys := r.keysOf(r.Tenants.KeyPrefix(dl.Request.AppName))
+	v, err := r.client.Eval(ctx, putDeadLetterScript, keys, dl.Id, dl.CreatedAt, string(raw), deadLetterMaxLen(r.MaxLen))
+	if err != nil {
+		return err
+	}
+	if trimmed, _ := toInt64(v); trimmed > 0 {
+		Metrics.Counter(MetricDeadLetterTrimmed, trimmed)
+		Applog.Warnf("dead letters exceed max len %v, trimmed %v oldest, key: %v", deadLetterMaxLen(r.MaxLen), trimmed, keys[0])
+	}
+	return nil
+}
+
+// keysOf 租户前缀对应的有序集合和 hash
+func (r *RedisDeadLetterSink) keysOf(prefix string) []string {
+	return []string{prefix + r.key, prefix + r.key + "_data"}
+}
+
+// prefixes 未开启多租户的死信和各个租户的死信所用的 key 前缀
+func (r *RedisDeadLetterSink) prefixes() []string {
+	return append([]string{""}, r.Tenants.KeyPrefixes()...)
+}
+
+// List 每个有序集合各取 limit 条，按写入时间合并后返回前 limit 条
+func (r *RedisDeadLetterSink) List(ctx context.Context, limit int) ([]*DeadLetter, error) {
+	stop := int64(-1)
+	if limit > 0 {
+		stop = int64(limit - 1)
+	}
+	var dls []*DeadLetter
+	for _, prefix := range r.prefixes() {
+		keys := r.keysOf(prefix)
+		v, err := r.client.Eval(ctx, listDeadLettersScript, keys, stop)
+		if err != nil {
+			return nil, err
+		}
+		vals, _ := v.([]interface{})
+		for _, val := range vals {
+			raw, _ := val.(string)
+			dl := &DeadLetter{}
+			if err := json.Unmarshal([]byte(raw), dl); err != nil {
+				Applog.Errorf("unmarshal dead letter err:%+v key: %v", err, keys[1])
+				continue
+			}
+			dls = append(dls, dl)
+		}
+	}
+	sort.SliceStable(dls, func(i, j int) bool { return dls[i].CreatedAt < dls[j].CreatedAt })
+	if limit > 0 && len(dls) > limit {
+		dls = dls[:limit]
+	}
+	return dls, nil
+}
+
+// Remove 死信 id 不带租户信息，依次在每个租户的有序集合中删除，全部删除后提前结束
+func (r *RedisDeadLetterSink) Remove(ctx context.Context, ids ...string) error {
+	if len(ids) == 0 {
+		return nil
+	}
+	args := make([]interface{}, 0, len(ids))
+	for _, id := range ids {
+		args = append(args, id)
+	}
+	removed := int64(0)
+	for _, prefix := range r.prefixes() {
+		v, err := r.client.Eval(ctx, removeDeadLettersScript, r.keysOf(prefix), args...)
+		if err != nil {
+			return err
+		}
+		n, err := toInt64(v)
+		if err != nil {
+			return err
+		}
+		if removed += n; removed >= int64(len(ids)) {
+			return nil
+		}
+	}
+	return nil
+}
+
+// sealDeadLetter 未配置 Keyring 时原样返回，否则返回加密后的副本，加密失败时不写入明文
+func (s *RouterServer) sealDeadLetter(dl *DeadLetter) (*DeadLetter, error) {
+	if s.Keyring == nil || dl.Request == nil {
+		return dl, nil
+	}
+	sealed, err := s.sealRequest(dl.Request)
+	if err != nil {
+		return nil, err
+	}
+	stored := *dl
+	stored.Request = &TransferMessageRequest{AppName: dl.Request.AppName, ReceiverId: dl.Request.ReceiverId, MsgId: dl.Request.MsgId}
+	stored.SealedRequest = sealed
+	return &stored, nil
+}
+
+// openDeadLetter 还原 sealDeadLetter 加密的请求，失败时返回原来的死信和错误
+func (s *RouterServer) openDeadLetter(dl *DeadLetter) (*DeadLetter, error) {
+	if len(dl.SealedRequest) == 0 || dl.Request == nil {
+		return dl, nil
+	}
+	in, err := s.decodeStoredMsg(msgBindingOf(dl.Request), dl.SealedRequest)
+	if err != nil {
+		return dl, err
+	}
+	opened := *dl
+	opened.Request = in
+	opened.SealedRequest = ""
+	return &opened, nil
+}
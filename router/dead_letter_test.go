@@ -0,0 +1,324 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeadLetterSinks(t *testing.T) {
+	testCases := []struct {
+		name string
+		sink func(t *testing.T) DeadLetterSink
+	}{
+		{name: "file", sink: func(t *testing.T) DeadLetterSink {
+			return NewFileDeadLetterSink(filepath.Join(t.TempDir(), "dead_letters.jsonl"))
+		}},
+		{name: "redis", sink: func(t *testing.T) DeadLetterSink {
+			_, client := newTestRedis(t)
+			return NewRedisDeadLetterSink(client, "")
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := tc.sink(t)
+			ctx := context.Background()
+			dls, err := sink.List(ctx, 0)
+			assert.NoError(t, err)
+			assert.Empty(t, dls)
+
+			for i, id := range []string{"m1", "m2", "m3"} {
+				in := newTestRequest()
+				in.MsgId = id
+				dl := newDeadLetter(in, "d1", DeadLetterStageDeliver, errors.New("boom"), i, testNow.Add(time.Duration(i)))
+				assert.NoError(t, sink.Put(ctx, dl))
+			}
+			dls, err = sink.List(ctx, 2)
+			assert.NoError(t, err)
+			if assert.Len(t, dls, 2) {
+				assert.Equal(t, "m1", dls[0].Request.MsgId)
+				assert.Equal(t, "boom", dls[0].Error)
+			}
+
+			assert.NoError(t, sink.Remove(ctx, dls[0].Id, "missing"))
+			dls, err = sink.List(ctx, 0)
+			assert.NoError(t, err)
+			if assert.Len(t, dls, 2) {
+				assert.Equal(t, "m2", dls[0].Request.MsgId)
+			}
+		})
+	}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	testCases := []struct {
+		name         string
+		stage        string
+		deviceID     string
+		seq          int64
+		connErr      error
+		filter       func(*DeadLetter) bool
+		wantReplayed int
+		wantSent     int
+		wantStored   []int64 // 重放后写入消息存储的序列号
+		wantAccepted bool    // 是否重新经过 TransferOnlineReliableMessage
+	}{
+		{name: "deliver-to-original-device", stage: DeadLetterStageDeliver, deviceID: "d1", wantReplayed: 1, wantSent: 1},
+		{name: "deliver-all-devices", stage: DeadLetterStageDeliver, wantReplayed: 1, wantSent: 1},
+		{name: "deliver-device-offline-kept", stage: DeadLetterStageDeliver, deviceID: "d2"},
+		{name: "deliver-failed-kept", stage: DeadLetterStageDeliver, deviceID: "d1", connErr: errors.New("boom"), wantSent: 1},
+		{name: "store-with-original-seq", stage: DeadLetterStageStore, seq: 17000000000001, wantReplayed: 1, wantStored: []int64{17000000000001}},
+		{name: "store-without-seq-kept", stage: DeadLetterStageStore},
+		{name: "delayed-resubmitted", stage: DeadLetterStageDelayed, wantReplayed: 1, wantSent: 1, wantAccepted: true},
+		{name: "filtered-out", stage: DeadLetterStageDeliver, deviceID: "d1", filter: func(*DeadLetter) bool { return false }},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConnector{err: tc.connErr}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			ts.DeadLetters = NewFileDeadLetterSink(filepath.Join(t.TempDir(), "dead_letters.jsonl"))
+			ctx := context.Background()
+			dl := newDeadLetter(newTestRequest(), tc.deviceID, tc.stage, errors.New("boom"), 1, testNow)
+			dl.Seq = tc.seq
+			assert.NoError(t, ts.DeadLetters.Put(ctx, dl))
+
+			n, err := ts.ReplayDeadLetters(ctx, tc.filter)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantReplayed, n)
+			if tc.wantAccepted {
+				ts.observer.next(t, EventMsgAccepted)
+				ts.observer.next(t, EventMsgDelivered)
+			}
+			assert.Len(t, conn.requests(), tc.wantSent)
+			var seqs []int64
+			for _, m := range ts.db.stored() {
+				seqs = append(seqs, m.seq)
+			}
+			assert.Equal(t, tc.wantStored, seqs)
+			for _, ev := range ts.observer.drain() {
+				assert.NotEqual(t, EventMsgAccepted, ev.Type, "no new seq for replayed msg")
+			}
+
+			// 只有确认成功的死信被删除，失败时不会写入新的死信
+			dls, err := ts.DeadLetters.List(ctx, 0)
+			assert.NoError(t, err)
+			if tc.wantReplayed == 1 {
+				assert.Empty(t, dls)
+			} else if assert.Len(t, dls, 1) {
+				assert.Equal(t, dl.Id, dls[0].Id)
+			}
+		})
+	}
+}
+
+func TestReplayDeadLettersWaitsForRetry(t *testing.T) {
+	ds, clock := newTestScheduler(t, 1, nil)
+	conn := &fakeConnector{errs: []error{NoConnectionErr}}
+	ts := newTestServer(t, iosDevice("d1", conn))
+	ts.Scheduler = ds
+	ts.DeadLetters = NewFileDeadLetterSink(filepath.Join(t.TempDir(), "dead_letters.jsonl"))
+	ctx := context.Background()
+	assert.NoError(t, ts.DeadLetters.Put(ctx, newDeadLetter(newTestRequest(), "d1", DeadLetterStageDeliver, NoConnectionErr, 3, testNow)))
+
+	done := make(chan int)
+	go func() {
+		n, _ := ts.ReplayDeadLetters(ctx, nil)
+		done <- n
+	}()
+	// 第一次失败后进入退避，重放等待重试的结果
+	clock.next(t).fire()
+	assert.Equal(t, 1, <-done)
+	assert.Len(t, conn.requests(), 2)
+}
+
+func TestAsyncStoreFailureRecordsSeq(t *testing.T) {
+	withServiceConfig(t, func(c *config) {
+		c.Service.IsStoreReliableMsg = true
+		c.Service.AsyncStoreReliableMsg = true
+	})
+	ts := newTestServer(t, iosDevice("d1", &fakeConnector{}))
+	ts.db.err = errors.New("db down")
+	ts.DeadLetters = NewFileDeadLetterSink(filepath.Join(t.TempDir(), "dead_letters.jsonl"))
+
+	_, err := ts.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+	assert.NoError(t, err)
+	var dls []*DeadLetter
+	assert.Eventually(t, func() bool {
+		dls, _ = ts.DeadLetters.List(context.Background(), 0)
+		return len(dls) == 1
+	}, 2*time.Second, 10*time.Millisecond)
+	assert.Equal(t, DeadLetterStageStore, dls[0].Stage)
+	assert.NotZero(t, dls[0].Seq)
+}
+
+func TestRedisDeadLetterSinkTenants(t *testing.T) {
+	mr, client := newTestRedis(t)
+	sink := NewRedisDeadLetterSink(client, "")
+	sink.Tenants = newTestTenants(t)
+	ctx := context.Background()
+	for i, app := range []string{"live", "im", "other", "im"} {
+		in := newTestRequest()
+		in.AppName = app
+		in.MsgId = fmt.Sprintf("m%d", i)
+		assert.NoError(t, sink.Put(ctx, newDeadLetter(in, "d1", DeadLetterStageDeliver, errors.New("boom"), 0, testNow.Add(time.Duration(i)*time.Millisecond))))
+	}
+	testCases := []struct {
+		key  string
+		want int
+	}{
+		{key: "{im}" + DeadLetterKey, want: 2},
+		{key: "{live}" + DeadLetterKey, want: 1},
+		{key: DeadLetterKey, want: 1},
+	}
+	for _, tc := range testCases {
+		ids, err := mr.ZMembers(tc.key)
+		assert.NoError(t, err)
+		assert.Len(t, ids, tc.want, tc.key)
+		fields, err := mr.HKeys(tc.key + "_data")
+		assert.NoError(t, err)
+		assert.ElementsMatch(t, ids, fields, tc.key)
+	}
+
+	// 各个租户的死信按写入时间合并
+	dls, err := sink.List(ctx, 3)
+	assert.NoError(t, err)
+	var ids []string
+	for _, dl := range dls {
+		ids = append(ids, dl.Request.MsgId)
+	}
+	assert.Equal(t, []string{"m0", "m1", "m2"}, ids)
+
+	assert.NoError(t, sink.Remove(ctx, dls[0].Id, dls[1].Id))
+	assert.False(t, mr.Exists("{live}"+DeadLetterKey))
+	assert.False(t, mr.Exists("{live}"+DeadLetterKey+"_data"))
+	members, _ := mr.ZMembers("{im}" + DeadLetterKey)
+	assert.Len(t, members, 1)
+}
+
+func TestFileDeadLetterSinkReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead_letters.jsonl")
+	sink := NewFileDeadLetterSink(path)
+	ctx := context.Background()
+	var ids []string
+	for i := 0; i < 4; i++ {
+		in := newTestRequest()
+		in.MsgId = fmt.Sprintf("m%d", i)
+		dl := newDeadLetter(in, "d1", DeadLetterStageDeliver, errors.New("boom"), 0, testNow.Add(time.Duration(i)))
+		assert.NoError(t, sink.Put(ctx, dl))
+		ids = append(ids, dl.Id)
+	}
+
+	testCases := []struct {
+		name      string
+		remove    []string
+		wantMsgs  []string
+		wantLines int
+	}{
+		// 删除只追加一行删除记录
+		{name: "append-removal", remove: []string{ids[1]}, wantMsgs: []string{"m0", "m2", "m3"}, wantLines: 5},
+		{name: "missing-id-ignored", remove: []string{"missing"}, wantMsgs: []string{"m0", "m2", "m3"}, wantLines: 5},
+		// 已删除的行多于剩余的死信时重写文件
+		{name: "compacted", remove: []string{ids[0], ids[3]}, wantMsgs: []string{"m2"}, wantLines: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.NoError(t, sink.Remove(ctx, tc.remove...))
+			// 新的实例从文件恢复出相同的死信
+			for _, s := range []*FileDeadLetterSink{sink, NewFileDeadLetterSink(path)} {
+				dls, err := s.List(ctx, 0)
+				assert.NoError(t, err)
+				var msgs []string
+				for _, dl := range dls {
+					msgs = append(msgs, dl.Request.MsgId)
+				}
+				assert.Equal(t, tc.wantMsgs, msgs)
+			}
+			data, err := os.ReadFile(path)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.wantLines, strings.Count(string(data), "\n"))
+		})
+	}
+}
+
+func TestDeliverDeadLetterSkipsNonReplayable(t *testing.T) {
+	testCases := []struct {
+		name     string
+		connErr  error
+		wantDead bool
+	}{
+		{name: "unknown-error-kept", connErr: errors.New("boom"), wantDead: true},
+		// 路由已经删除或者设备被拉黑，重放不会投递成功
+		{name: "stale-device-skipped", connErr: errors.New("user not exist")},
+		{name: "connector-gone-skipped", connErr: ConnectorUnreachableErr},
+		{name: "auth-revoked-skipped", connErr: errors.New("auth revoked")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t, iosDevice("d1", &fakeConnector{err: tc.connErr}))
+			ts.DeadLetters = NewFileDeadLetterSink(filepath.Join(t.TempDir(), "dead_letters.jsonl"))
+			skipped := Metrics.Value(MetricDeadLetterSkipped)
+			ctx := context.Background()
+
+			_, err := ts.TransferOnlineReliableMessage(ctx, newTestRequest())
+			assert.NoError(t, err)
+			ts.observer.next(t, EventDeliverFailed)
+			dls, err := ts.DeadLetters.List(ctx, 0)
+			assert.NoError(t, err)
+			if tc.wantDead {
+				assert.Len(t, dls, 1)
+				assert.Equal(t, skipped, Metrics.Value(MetricDeadLetterSkipped))
+				return
+			}
+			assert.Empty(t, dls)
+			assert.Equal(t, skipped+1, Metrics.Value(MetricDeadLetterSkipped))
+		})
+	}
+}
+
+func TestDeadLetterSinksMaxLen(t *testing.T) {
+	testCases := []struct {
+		name string
+		sink func(t *testing.T) DeadLetterSink
+	}{
+		{name: "file", sink: func(t *testing.T) DeadLetterSink {
+			sink := NewFileDeadLetterSink(filepath.Join(t.TempDir(), "dead_letters.jsonl"))
+			sink.MaxLen = 2
+			return sink
+		}},
+		{name: "redis", sink: func(t *testing.T) DeadLetterSink {
+			_, client := newTestRedis(t)
+			sink := NewRedisDeadLetterSink(client, "")
+			sink.MaxLen = 2
+			return sink
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := tc.sink(t)
+			ctx := context.Background()
+			trimmed := Metrics.Value(MetricDeadLetterTrimmed)
+			for i := 0; i < 4; i++ {
+				in := newTestRequest()
+				in.MsgId = fmt.Sprintf("m%d", i)
+				dl := newDeadLetter(in, "d1", DeadLetterStageDeliver, errors.New("boom"), 0, testNow.Add(time.Duration(i)*time.Millisecond))
+				assert.NoError(t, sink.Put(ctx, dl))
+			}
+			// 超过上限时删除最早的死信
+			dls, err := sink.List(ctx, 0)
+			assert.NoError(t, err)
+			var msgs []string
+			for _, dl := range dls {
+				msgs = append(msgs, dl.Request.MsgId)
+			}
+			assert.Equal(t, []string{"m2", "m3"}, msgs)
+			assert.Equal(t, trimmed+2, Metrics.Value(MetricDeadLetterTrimmed))
+		})
+	}
+}
@@ -0,0 +1,511 @@
+package router
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gangcheng1030/ai_testing_and_refactoring/go_redis_test"
+)
+
+const (
+	MetricDelayedMsgScheduled = "router_delayed_msg_scheduled"
+	MetricDelayedMsgFired     = "router_delayed_msg_fired"
+	MetricDelayedMsgCancelled = "router_delayed_msg_cancelled"
+	MetricDelayedMsgFailed    = "router_delayed_msg_failed"
+
+	DeadLetterStageDelayed = "delayed"
+
+	// 开启多租户时加上租户的 KeyPrefix，同一个租户的有序集合和 hash 使用同一个 {...} hash tag
+	DelayedMsgZSetKey = "router_delayed_msgs"
+	DelayedMsgHashKey = "router_delayed_msgs_data"
+)
+
+var DelayedMsgExistsErr = errors.New("delayed msg with the same msgId already exists")
+
+// DelayedMsgStore 持久化待定时下发的消息，id 由 delayedMsgKey 生成，按租户和 app 隔离
+type DelayedMsgStore interface {
+	// Add id 已存在时返回 DelayedMsgExistsErr
+	Add(ctx context.Context, id string, deliverAt int64, data string) error
+	// Due 返回 deliverAt 不晚于 now 的 id，按 deliverAt 从小到大排列
+	Due(ctx context.Context, now int64, limit int) ([]string, error)
+	// Claim deliverAt 不晚于 now 时把 deliverAt 推迟到 leaseUntil 并返回消息内容，
+	// 租约到期前其他实例取不到这条消息；消息不存在或未到期时 ok 为 false
+	Claim(ctx context.Context, id string, now, leaseUntil int64) (data string, ok bool, err error)
+	// Remove 删除并返回消息内容，消息不存在时 ok 为 false
+	Remove(ctx context.Context, id string) (data string, ok bool, err error)
+}
+
+// delayedMsgKey 定时消息在 store 中的 id，MsgId 只在同一个 app 内唯一，开启多租户时加上租户的 KeyPrefix
+func delayedMsgKey(prefix, appName, msgId string) string {
+	return prefix + appName + RedisInterval + msgId
+}
+
+// DelayedDeliveryConfig 定时下发的配置
+type DelayedDeliveryConfig struct {
+	PollInterval time.Duration
+	BatchSize    int
+	// MaxDelay 允许的最大延迟，0 表示不限制
+	MaxDelay time.Duration
+	// ClaimTimeout 取走消息后的租约，下发成功之前实例退出时，租约到期后由其他实例重新下发
+	ClaimTimeout time.Duration
+}
+
+var DefaultDelayedDeliveryConfig = DelayedDeliveryConfig{
+	PollInterval: time.Second,
+	BatchSize:    100,
+	MaxDelay:     30 * 24 * time.Hour,
+	ClaimTimeout: time.Minute,
+}
+
+// DelayedDelivery 把 DeliverAt 在未来的消息写入 DelayedMsgStore，
+// 到期后通过 TransferOnlineReliableMessage 走正常的投递流程；
+// 多个实例共享同一个 store 时，Claim 保证租约内每条消息只被一个实例取走，
+// 下发成功或者写入死信之后才删除，否则租约到期后重新下发
+type DelayedDelivery struct {
+	cfg    DelayedDeliveryConfig
+	store  DelayedMsgStore
+	server *RouterServer
+
+	stopOnce sync.Once
+	stopCh   chan struct{}
+	done     chan struct{}
+}
+
+// NewDelayedDelivery 创建后需要赋值给 RouterServer.Delayed，并调用 Start 开始轮询
+func NewDelayedDelivery(server *RouterServer, cfg DelayedDeliveryConfig, store DelayedMsgStore) *DelayedDelivery {
+	if cfg.PollInterval <= 0 {
+		cfg.PollInterval = DefaultDelayedDeliveryConfig.PollInterval
+	}
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultDelayedDeliveryConfig.BatchSize
+	}
+	if cfg.ClaimTimeout <= 0 {
+		cfg.ClaimTimeout = DefaultDelayedDeliveryConfig.ClaimTimeout
+	}
+	return &DelayedDelivery{
+		cfg:    cfg,
+		store:  store,
+		server: server,
+		stopCh: make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// Start 开始轮询到期的消息，重启后会继续下发 store 中已到期的消息
+func (d *DelayedDelivery) Start() {
+	go d.run()
+}
+
+func (d *DelayedDelivery) Stop() {
+	d.stopOnce.Do(func() {
+		close(d.stopCh)
+	})
+	<-d.done
+}
+
+func (d *DelayedDelivery) schedule(ctx context.Context, in *TransferMessageRequest, now int64) error {
+	if d.cfg.MaxDelay > 0 && in.DeliverAt-now > d.cfg.MaxDelay.Milliseconds() {
+		return &InvalidRequestError{Reason: fmt.Sprintf("deliverAt exceeds max delay %v, msgId: %v", d.cfg.MaxDelay, in.MsgId)}
+	}
+	data, err := d.server.marshalSealedMsg(in)
+	if err != nil {
+		return err
+	}
+	if err := d.store.Add(ctx, delayedMsgKey(d.server.Tenants.KeyPrefix(in.AppName), in.AppName, in.MsgId), in.DeliverAt, data); err != nil {
+		if errors.Is(err, DelayedMsgExistsErr) {
+			return &InvalidRequestError{Reason: fmt.Sprintf("delayed msg %v already scheduled", in.MsgId)}
+		}
+		return err
+	}
+	Metrics.Counter(MetricDelayedMsgScheduled, 1)
+	return nil
+}
+
+// Cancel 取消 app 下尚未下发的消息，消息不存在或已经下发时返回 false
+func (d *DelayedDelivery) Cancel(ctx context.Context, appName, msgId string) (bool, error) {
+	_, ok, err := d.store.Remove(ctx, delayedMsgKey(d.server.Tenants.KeyPrefix(appName), appName, msgId))
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		Metrics.Counter(MetricDelayedMsgCancelled, 1)
+		Applog.Infof("delayed msg cancelled, app: %v, msgId: %v", appName, msgId)
+	}
+	return ok, nil
+}
+
+func (d *DelayedDelivery) run() {
+	defer close(d.done)
+	ticker := time.NewTicker(d.cfg.PollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-d.stopCh:
+			return
+		case <-ticker.C:
+			d.poll()
+		}
+	}
+}
+
+// poll 一次取一批到期的消息，取满一批时继续取下一批
+func (d *DelayedDelivery) poll() {
+	ctx := context.Background()
+	for {
+		now := d.server.nowMs()
+		ids, err := d.store.Due(ctx, now, d.cfg.BatchSize)
+		if err != nil {
+			Applog.Errorf("list due delayed msgs err:%+v", err)
+			return
+		}
+		for _, id := range ids {
+			d.fire(ctx, id, now)
+		}
+		if len(ids) < d.cfg.BatchSize {
+			return
+		}
+		select {
+		case <-d.stopCh:
+			return
+		default:
+		}
+	}
+}
+
+// fire 取得租约后下发，下发成功或者写入死信之后才删除，失败时等租约到期后重试
+func (d *DelayedDelivery) fire(ctx context.Context, id string, now int64) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 1<<15)
+			n := runtime.Stack(buf, false)
+			err := fmt.Errorf("%v, STACK: %s", r, buf[0:n])
+			Applog.Errorf("fire delayed msg panic :%+v", err)
+		}
+	}()
+	data, ok, err := d.store.Claim(ctx, id, now, now+d.cfg.ClaimTimeout.Milliseconds())
+	if err != nil {
+		Applog.Errorf("claim delayed msg err:%+v id: %v", err, id)
+		return
+	}
+	if !ok {
+		return
+	}
+	in, err := d.server.unmarshalSealedMsg(data)
+	if err != nil {
+		// 无法解码的消息重试也不会成功，直接删除
+		Metrics.Counter(MetricDelayedMsgFailed, 1)
+		Applog.Errorf("decode delayed msg err:%+v id: %v", err, id)
+		d.remove(ctx, id)
+		return
+	}
+	in.DeliverAt = 0
+	ctx = internalCallContext(Tracing.PropagateContextWithServiceContext(ctx))
+	if _, err := d.server.TransferOnlineReliableMessage(ctx, in); err != nil {
+		Metrics.Counter(MetricDelayedMsgFailed, 1)
+		Applog.Errorf("fire delayed msg err:%+v msgId: %v", err, in.MsgId)
+		if d.server.saveDeadLetter(ctx, newDeadLetter(in, "", DeadLetterStageDelayed, err, 1, d.server.clock())) {
+			d.remove(ctx, id)
+		}
+		return
+	}
+	Metrics.Counter(MetricDelayedMsgFired, 1)
+	d.remove(ctx, id)
+}
+
+// remove 删除失败时租约到期后会重新下发一次
+func (d *DelayedDelivery) remove(ctx context.Context, id string) {
+	if _, _, err := d.store.Remove(ctx, id); err != nil {
+		Applog.Errorf("remove delayed msg err:%+v id: %v", err, id)
+	}
+}
+
+// CancelDelayedMessage 按 AppName 和 MsgId 取消定时消息
+func (s *RouterServer) CancelDelayedMessage(ctx context.Context, appName, msgId string) (bool, error) {
+	if s.Delayed == nil {
+		return false, NewStatusError(CodeUnavailable, "delayed delivery is not configured")
+	}
+	return s.Delayed.Cancel(ctx, appName, msgId)
+}
+
+// ============== Redis ==============
+
+// DelayedMsgRedisClient Redis 定时消息需要的操作
+type DelayedMsgRedisClient interface {
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+var _ DelayedMsgRedisClient = (*go_redis_test.RedisClient)(nil)
+
+// addDelayedMsgScript 消息内容写入 hash，下发时间写入有序集合
+const addDelayedMsgScript = `
+if redis.call('HSETNX', KEYS[2], ARGV[1], ARGV[3]) == 0 then
+	return 0
+end
+redis.call('ZADD', KEYS[1], ARGV[2], ARGV[1])
+return 1
+`
+
+// dueDelayedMsgScript 返回到期的 id 和下发时间，KEYS: 有序集合；ARGV: now, limit
+const dueDelayedMsgScript = `
+return redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'WITHSCORES', 'LIMIT', 0, ARGV[2])
+`
+
+// claimDelayedMsgScript 到期的消息把下发时间推迟到租约结束，返回消息内容，未到期或不存在时返回空字符串
+const claimDelayedMsgScript = `
+local score = redis.call('ZSCORE', KEYS[1], ARGV[1])
+if not score or tonumber(score) > tonumber(ARGV[2]) then
+	return ''
+end
+local v = redis.call('HGET', KEYS[2], ARGV[1])
+if not v then
+	redis.call('ZREM', KEYS[1], ARGV[1])
+	return ''
+end
+redis.call('ZADD', KEYS[1], ARGV[3], ARGV[1])
+return v
+`
+
+// removeDelayedMsgScript 只有从有序集合中删除成功的实例才能拿到消息内容，不存在时返回空字符串
+const removeDelayedMsgScript = `
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 0 then
+	return ''
+end
+local v = redis.call('HGET', KEYS[2], ARGV[1])
+redis.call('HDEL', KEYS[2], ARGV[1])
+return v or ''
+`
+
+// RedisDelayedMsgStore 用有序集合按下发时间索引 MsgId，消息内容保存在 hash 中；
+// 配置 Tenants 后每个租户的消息写入加了 KeyPrefix 的有序集合和 hash
+type RedisDelayedMsgStore struct {
+	client DelayedMsgRedisClient
+	// Tenants 可选，需要和 RouterServer.Tenants 保持一致
+	Tenants *TenantRegistry
+}
+
+func NewRedisDelayedMsgStore(client DelayedMsgRedisClient) *RedisDelayedMsgStore {
+	return &RedisDelayedMsgStore{client: client}
+}
+
+// keys id 所在的有序集合和 hash，id 由 delayedMsgKey 生成，以所属租户的 KeyPrefix 开头
+func (r *RedisDelayedMsgStore) keys(id string) []string {
+	prefix := r.Tenants.keyPrefixOf(id)
+	return []string{prefix + DelayedMsgZSetKey, prefix + DelayedMsgHashKey}
+}
+
+// zsetKeys 未开启多租户的消息和各个租户的消息所在的有序集合
+func (r *RedisDelayedMsgStore) zsetKeys() []string {
+	keys := []string{DelayedMsgZSetKey}
+	for _, prefix := range r.Tenants.KeyPrefixes() {
+		keys = append(keys, prefix+DelayedMsgZSetKey)
+	}
+	return keys
+}
+
+func (r *RedisDelayedMsgStore) Add(ctx context.Context, id string, deliverAt int64, data string) error {
+	v, err := r.client.Eval(ctx, addDelayedMsgScript, r.keys(id), id, deliverAt, data)
+	if err != nil {
+		return err
+	}
+	n, err := toInt64(v)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return DelayedMsgExistsErr
+	}
+	return nil
+}
+
+// Due 每个有序集合各取 limit 条，按下发时间合并后返回前 limit 条，一个租户积压时不影响其它租户按时下发
+func (r *RedisDelayedMsgStore) Due(ctx context.Context, now int64, limit int) ([]string, error) {
+	type dueMsg struct {
+		id        string
+		deliverAt float64
+	}
+	var due []dueMsg
+	for _, key := range r.zsetKeys() {
+		v, err := r.client.Eval(ctx, dueDelayedMsgScript, []string{key}, now, limit)
+		if err != nil {
+			return nil, err
+		}
+		vals, _ := v.([]interface{})
+		for i := 0; i+1 < len(vals); i += 2 {
+			id, _ := vals[i].(string)
+			score, _ := vals[i+1].(string)
+			deliverAt, err := strconv.ParseFloat(score, 64)
+			if err != nil {
+				return nil, fmt.Errorf("parse delayed msg score %v: %v", score, err)
+			}
+			due = append(due, dueMsg{id: id, deliverAt: deliverAt})
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool { return due[i].deliverAt < due[j].deliverAt })
+	if limit > 0 && len(due) > limit {
+		due = due[:limit]
+	}
+	ids := make([]string, 0, len(due))
+	for _, m := range due {
+		ids = append(ids, m.id)
+	}
+	return ids, nil
+}
+
+func (r *RedisDelayedMsgStore) Claim(ctx context.Context, id string, now, leaseUntil int64) (string, bool, error) {
+	v, err := r.client.Eval(ctx, claimDelayedMsgScript, r.keys(id), id, now, leaseUntil)
+	if err != nil {
+		return "", false, err
+	}
+	data, _ := v.(string)
+	return data, len(data) > 0, nil
+}
+
+func (r *RedisDelayedMsgStore) Remove(ctx context.Context, id string) (string, bool, error) {
+	v, err := r.client.Eval(ctx, removeDelayedMsgScript, r.keys(id), id)
+	if err != nil {
+		return "", false, err
+	}
+	data, _ := v.(string)
+	return data, len(data) > 0, nil
+}
+
+// ============== 本地文件 ==============
+
+// FileDelayedMsgStore 每条消息一个文件，文件名为 <deliverAt>_<hex(id)>，
+// 只适用于单实例部署
+type FileDelayedMsgStore struct {
+	dir string
+
+	mu    sync.Mutex
+	index map[string]string // id -> 文件名
+}
+
+func NewFileDelayedMsgStore(dir string) (*FileDelayedMsgStore, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	f := &FileDelayedMsgStore{dir: dir, index: make(map[string]string)}
+	for _, e := range entries {
+		if _, id, ok := parseDelayedMsgFileName(e.Name()); ok {
+			f.index[id] = e.Name()
+		}
+	}
+	return f, nil
+}
+
+func delayedMsgFileName(id string, deliverAt int64) string {
+	// 定长的时间戳保证字典序即下发时间顺序
+	return fmt.Sprintf("%020d_%s", deliverAt, hex.EncodeToString([]byte(id)))
+}
+
+func parseDelayedMsgFileName(name string) (int64, string, bool) {
+	parts := strings.SplitN(name, "_", 2)
+	if len(parts) != 2 {
+		return 0, "", false
+	}
+	deliverAt, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, "", false
+	}
+	id, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return 0, "", false
+	}
+	return deliverAt, string(id), true
+}
+
+func (f *FileDelayedMsgStore) Add(ctx context.Context, id string, deliverAt int64, data string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if _, ok := f.index[id]; ok {
+		return DelayedMsgExistsErr
+	}
+	name := delayedMsgFileName(id, deliverAt)
+	tmp := filepath.Join(f.dir, "."+name+".tmp")
+	if err := os.WriteFile(tmp, []byte(data), 0644); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, filepath.Join(f.dir, name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	f.index[id] = name
+	return nil
+}
+
+func (f *FileDelayedMsgStore) Due(ctx context.Context, now int64, limit int) ([]string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	names := make([]string, 0)
+	for _, name := range f.index {
+		if deliverAt, _, ok := parseDelayedMsgFileName(name); ok && deliverAt <= now {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if limit > 0 && len(names) > limit {
+		names = names[:limit]
+	}
+	ids := make([]string, 0, len(names))
+	for _, name := range names {
+		_, id, _ := parseDelayedMsgFileName(name)
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// Claim 把文件重命名为租约结束的时间
+func (f *FileDelayedMsgStore) Claim(ctx context.Context, id string, now, leaseUntil int64) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name, ok := f.index[id]
+	if !ok {
+		return "", false, nil
+	}
+	if deliverAt, _, _ := parseDelayedMsgFileName(name); deliverAt > now {
+		return "", false, nil
+	}
+	data, err := os.ReadFile(filepath.Join(f.dir, name))
+	if err != nil {
+		return "", false, err
+	}
+	leased := delayedMsgFileName(id, leaseUntil)
+	if err := os.Rename(filepath.Join(f.dir, name), filepath.Join(f.dir, leased)); err != nil {
+		return "", false, err
+	}
+	f.index[id] = leased
+	return string(data), true, nil
+}
+
+func (f *FileDelayedMsgStore) Remove(ctx context.Context, id string) (string, bool, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	name, ok := f.index[id]
+	if !ok {
+		return "", false, nil
+	}
+	path := filepath.Join(f.dir, name)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, err
+	}
+	if err := os.Remove(path); err != nil {
+		return "", false, err
+	}
+	delete(f.index, id)
+	return string(data), true, nil
+}
@@ -0,0 +1,273 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDelayedMsgStores(t *testing.T) {
+	testCases := []struct {
+		name  string
+		store func(t *testing.T) DelayedMsgStore
+	}{
+		{name: "file", store: func(t *testing.T) DelayedMsgStore {
+			f, err := NewFileDelayedMsgStore(t.TempDir())
+			assert.NoError(t, err)
+			return f
+		}},
+		{name: "redis", store: func(t *testing.T) DelayedMsgStore {
+			_, client := newTestRedis(t)
+			return NewRedisDelayedMsgStore(client)
+		}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := tc.store(t)
+			ctx := context.Background()
+			assert.NoError(t, store.Add(ctx, "im:m2", 200, "d2"))
+			assert.NoError(t, store.Add(ctx, "im:m1", 100, "d1"))
+			assert.NoError(t, store.Add(ctx, "live:m1", 300, "d3"))
+			assert.Equal(t, DelayedMsgExistsErr, store.Add(ctx, "im:m1", 100, "d1"))
+
+			ids, err := store.Due(ctx, 200, 10)
+			assert.NoError(t, err)
+			assert.Equal(t, []string{"im:m1", "im:m2"}, ids)
+
+			_, ok, err := store.Claim(ctx, "live:m1", 200, 1200)
+			assert.NoError(t, err)
+			assert.False(t, ok, "not due yet")
+
+			data, ok, err := store.Claim(ctx, "im:m1", 200, 1200)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, "d1", data)
+			// 租约期间其他实例取不到
+			_, ok, _ = store.Claim(ctx, "im:m1", 200, 1200)
+			assert.False(t, ok)
+			ids, _ = store.Due(ctx, 300, 10)
+			assert.Equal(t, []string{"im:m2", "live:m1"}, ids)
+			// 租约到期后重新可见
+			ids, _ = store.Due(ctx, 1200, 10)
+			assert.Equal(t, []string{"im:m2", "live:m1", "im:m1"}, ids)
+
+			data, ok, err = store.Remove(ctx, "im:m1")
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.Equal(t, "d1", data)
+			_, ok, _ = store.Remove(ctx, "im:m1")
+			assert.False(t, ok)
+			_, ok, _ = store.Claim(ctx, "im:m1", 2000, 3000)
+			assert.False(t, ok)
+		})
+	}
+}
+
+func TestRedisDelayedMsgStoreTenants(t *testing.T) {
+	mr, client := newTestRedis(t)
+	store := NewRedisDelayedMsgStore(client)
+	store.Tenants = newTestTenants(t)
+	ctx := context.Background()
+	im, live, global := delayedMsgKey("{im}", "im", "m1"), delayedMsgKey("{live}", "live", "m1"), delayedMsgKey("", "im", "m0")
+	assert.NoError(t, store.Add(ctx, im, 200, "d1"))
+	assert.NoError(t, store.Add(ctx, live, 100, "d2"))
+	assert.NoError(t, store.Add(ctx, global, 300, "d0"))
+
+	// 每个租户的有序集合和 hash 使用该租户的 hash tag
+	zset, _ := mr.ZMembers("{im}" + DelayedMsgZSetKey)
+	assert.Equal(t, []string{im}, zset)
+	assert.True(t, mr.Exists("{live}"+DelayedMsgHashKey))
+	zset, _ = mr.ZMembers(DelayedMsgZSetKey)
+	assert.Equal(t, []string{global}, zset)
+
+	// 开启多租户之前写入的消息仍然会被取出
+	ids, err := store.Due(ctx, 300, 10)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{live, im, global}, ids)
+	ids, _ = store.Due(ctx, 300, 2)
+	assert.Equal(t, []string{live, im}, ids)
+
+	data, ok, err := store.Claim(ctx, im, 300, 1300)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "d1", data)
+	data, ok, err = store.Remove(ctx, live)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "d2", data)
+	assert.False(t, mr.Exists("{live}"+DelayedMsgZSetKey))
+}
+
+// newTestDelayed 返回使用本地文件 store 的 DelayedDelivery，不启动轮询
+func newTestDelayed(t *testing.T, ts *testServer) (*DelayedDelivery, *FileDelayedMsgStore) {
+	t.Helper()
+	store, err := NewFileDelayedMsgStore(t.TempDir())
+	assert.NoError(t, err)
+	d := NewDelayedDelivery(ts.RouterServer, DelayedDeliveryConfig{MaxDelay: time.Hour, ClaimTimeout: time.Minute}, store)
+	ts.Delayed = d
+	return d, store
+}
+
+func TestTransferDelayedValidation(t *testing.T) {
+	nowMs := testNowMs()
+	testCases := []struct {
+		name       string
+		deliverAt  int64
+		expireAt   int64
+		noDelayed  bool
+		duplicate  bool
+		wantErr    bool
+		wantQueued bool
+	}{
+		{name: "scheduled", deliverAt: nowMs + 1000, wantQueued: true},
+		{name: "scheduled-before-expire", deliverAt: nowMs + 1000, expireAt: nowMs + 2000, wantQueued: true},
+		{name: "deliver-at-expire-at", deliverAt: nowMs + 1000, expireAt: nowMs + 1000, wantErr: true},
+		{name: "deliver-after-expire", deliverAt: nowMs + 2000, expireAt: nowMs + 1000, wantErr: true},
+		{name: "exceeds-max-delay", deliverAt: nowMs + time.Hour.Milliseconds() + 1, wantErr: true},
+		{name: "not-configured", deliverAt: nowMs + 1000, noDelayed: true, wantErr: true},
+		{name: "duplicate", deliverAt: nowMs + 1000, duplicate: true, wantErr: true},
+		{name: "past-deliver-at-sent-now", deliverAt: nowMs},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			_, store := newTestDelayed(t, ts)
+			if tc.noDelayed {
+				ts.Delayed = nil
+			}
+			in := newTestRequest()
+			in.DeliverAt = tc.deliverAt
+			in.ExpireAt = tc.expireAt
+			if tc.duplicate {
+				_, err := ts.TransferOnlineReliableMessage(context.Background(), in)
+				assert.NoError(t, err)
+			}
+
+			_, err := ts.TransferOnlineReliableMessage(context.Background(), in)
+			if tc.wantErr {
+				var invalid *InvalidRequestError
+				assert.ErrorAs(t, err, &invalid)
+				return
+			}
+			assert.NoError(t, err)
+			ids, _ := store.Due(context.Background(), tc.deliverAt, 10)
+			if tc.wantQueued {
+				assert.Equal(t, []string{delayedMsgKey("", "im", "m1")}, ids)
+				assert.Empty(t, conn.requests())
+				return
+			}
+			ts.observer.next(t, EventMsgDelivered)
+			assert.Len(t, conn.requests(), 1)
+		})
+	}
+}
+
+func TestDelayedDeliveryFire(t *testing.T) {
+	testCases := []struct {
+		name        string
+		dbErr       error
+		deadLetters bool
+		wantSent    int
+		wantRemoved bool
+		wantDLQ     bool
+	}{
+		{name: "delivered-then-removed", wantSent: 1, wantRemoved: true},
+		{name: "failed-moved-to-dead-letters", dbErr: errors.New("db down"), deadLetters: true, wantRemoved: true, wantDLQ: true},
+		{name: "failed-kept-until-lease-expires", dbErr: errors.New("db down")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withServiceConfig(t, func(c *config) { c.Service.IsStoreReliableMsg = true })
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			d, store := newTestDelayed(t, ts)
+			if tc.deadLetters {
+				ts.DeadLetters = NewFileDeadLetterSink(filepath.Join(t.TempDir(), "dead_letters.jsonl"))
+			}
+			ctx := context.Background()
+			in := newTestRequest()
+			in.DeliverAt = testNowMs() + 1000
+			_, err := ts.TransferOnlineReliableMessage(ctx, in)
+			assert.NoError(t, err)
+
+			now := testNow.Add(time.Second)
+			ts.now = func() time.Time { return now }
+			ts.db.err = tc.dbErr
+			d.poll()
+			if tc.wantSent > 0 {
+				ts.observer.next(t, EventMsgDelivered)
+			}
+			assert.Len(t, conn.requests(), tc.wantSent)
+
+			nowMs := now.UnixNano() / 1000000
+			leaseEnd := nowMs + time.Minute.Milliseconds()
+			ids, _ := store.Due(ctx, leaseEnd, 10)
+			if tc.wantRemoved {
+				assert.Empty(t, ids)
+			} else {
+				// 租约期间不会重复下发，租约到期后重试
+				due, _ := store.Due(ctx, nowMs, 10)
+				assert.Empty(t, due)
+				assert.Equal(t, []string{delayedMsgKey("", "im", "m1")}, ids)
+			}
+			if tc.deadLetters {
+				dls, err := ts.DeadLetters.List(ctx, 0)
+				assert.NoError(t, err)
+				if assert.Len(t, dls, 1) {
+					assert.Equal(t, DeadLetterStageDelayed, dls[0].Stage)
+				}
+			}
+		})
+	}
+}
+
+func TestCancelDelayedMessageByApp(t *testing.T) {
+	ts := newTestServer(t)
+	_, store := newTestDelayed(t, ts)
+	ctx := context.Background()
+	for _, app := range []string{"im", "live"} {
+		in := newTestRequest()
+		in.AppName = app
+		in.DeliverAt = testNowMs() + 1000
+		_, err := ts.TransferOnlineReliableMessage(ctx, in)
+		assert.NoError(t, err)
+	}
+	a := newTestAdminAPI(t, ts.RouterServer)
+
+	w := adminDo(a, testAdminToken, AdminDelayedCancelPath, &AdminDelayedCancelRequest{MsgId: "m1"})
+	assert.Equal(t, http.StatusBadRequest, w.Code)
+
+	w = adminDo(a, testAdminToken, AdminDelayedCancelPath, &AdminDelayedCancelRequest{AppName: "live", MsgId: "m1"})
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.JSONEq(t, `{"Cancelled":true}`, w.Body.String())
+	// 同一个 MsgId 的其它 app 的消息不受影响
+	ids, _ := store.Due(ctx, testNowMs()+1000, 10)
+	assert.Equal(t, []string{delayedMsgKey("", "im", "m1")}, ids)
+
+	ok, err := ts.CancelDelayedMessage(ctx, "live", "m1")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestDelayedDeliveryTenantPrefix(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Tenants = newTestTenants(t)
+	_, store := newTestDelayed(t, ts)
+	ctx := context.Background()
+	in := newTestRequest()
+	in.DeliverAt = testNowMs() + 1000
+	_, err := ts.TransferOnlineReliableMessage(ctx, in)
+	assert.NoError(t, err)
+
+	ids, _ := store.Due(ctx, in.DeliverAt, 10)
+	assert.Equal(t, []string{"{im}im" + RedisInterval + "m1"}, ids)
+	ok, err := ts.CancelDelayedMessage(ctx, "im", "m1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+}
@@ -0,0 +1,234 @@
+package router
+
+import (
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+)
+
+var DeliveryQueueFullErr = errors.New("delivery queue is full")
+var DeliverySchedulerStoppedErr = errors.New("delivery scheduler is stopped")
+
+// MsgPriority 消息投递优先级
+type MsgPriority int32
+
+const (
+	MSG_PRIORITY_NORMAL   MsgPriority = 0
+	MSG_PRIORITY_REALTIME MsgPriority = 1
+	MSG_PRIORITY_BULK     MsgPriority = 2
+)
+
+// DeliveryLaneConfig 单个优先级队列的配置
+type DeliveryLaneConfig struct {
+	Priority  MsgPriority
+	QueueSize int // 队列容量，满了以后 Submit 返回 DeliveryQueueFullErr
+	Weight    int // 加权轮询的权重
+}
+
+// DefaultDeliveryLanes 默认队列：实时聊天 > 普通 > 营销批量
+var DefaultDeliveryLanes = []DeliveryLaneConfig{
+	{Priority: MSG_PRIORITY_REALTIME, QueueSize: 10000, Weight: 8},
+	{Priority: MSG_PRIORITY_NORMAL, QueueSize: 10000, Weight: 3},
+	{Priority: MSG_PRIORITY_BULK, QueueSize: 5000, Weight: 1},
+}
+
+type deliveryLane struct {
+	DeliveryLaneConfig
+	tasks         []func()
+	currentWeight int
+}
+
+// delayedTask SubmitAfter 登记的等待入队的任务
+type delayedTask struct {
+	timer   deliveryTimer
+	dropped func(error)
+}
+
+// deliveryTimer time.Timer 的 Stop，测试中替换为手动触发的定时器
+type deliveryTimer interface {
+	Stop() bool
+}
+
+// DeliveryScheduler 按优先级分队列投递消息，固定数量的 worker 用平滑加权轮询从各队列取任务，
+// 队列满时拒绝新任务，避免批量营销消息挤占聊天消息
+type DeliveryScheduler struct {
+	mu      sync.Mutex
+	lanes   []*deliveryLane
+	byPrio  map[MsgPriority]*deliveryLane
+	notify  chan struct{}
+	stopped bool
+	wg      sync.WaitGroup
+	delayed map[*delayedTask]struct{}
+	// afterFunc 默认为 time.AfterFunc
+	afterFunc func(d time.Duration, f func()) deliveryTimer
+}
+
+// NewDeliveryScheduler 创建并启动调度器，lanes 为空时使用 DefaultDeliveryLanes，
+// 未配置的优先级投递到 MSG_PRIORITY_NORMAL 队列
+func NewDeliveryScheduler(workers int, lanes []DeliveryLaneConfig) (*DeliveryScheduler, error) {
+	if workers <= 0 {
+		return nil, fmt.Errorf("invalid delivery workers: %d", workers)
+	}
+	if len(lanes) == 0 {
+		lanes = DefaultDeliveryLanes
+	}
+	ds := &DeliveryScheduler{
+		byPrio:    make(map[MsgPriority]*deliveryLane),
+		delayed:   make(map[*delayedTask]struct{}),
+		afterFunc: func(d time.Duration, f func()) deliveryTimer { return time.AfterFunc(d, f) },
+	}
+	capacity := 0
+	for _, c := range lanes {
+		if c.QueueSize <= 0 || c.Weight <= 0 {
+			return nil, fmt.Errorf("invalid delivery lane config: %+v", c)
+		}
+		if _, ok := ds.byPrio[c.Priority]; ok {
+			return nil, fmt.Errorf("duplicate delivery lane priority: %d", c.Priority)
+		}
+		l := &deliveryLane{DeliveryLaneConfig: c}
+		ds.lanes = append(ds.lanes, l)
+		ds.byPrio[c.Priority] = l
+		capacity += c.QueueSize
+	}
+	if _, ok := ds.byPrio[MSG_PRIORITY_NORMAL]; !ok {
+		return nil, errors.New("delivery lane for MSG_PRIORITY_NORMAL is required")
+	}
+	ds.notify = make(chan struct{}, capacity)
+	for i := 0; i < workers; i++ {
+		ds.wg.Add(1)
+		go ds.work()
+	}
+	return ds, nil
+}
+
+// Submit 把投递任务放入对应优先级的队列
+func (ds *DeliveryScheduler) Submit(priority MsgPriority, task func()) error {
+	ds.mu.Lock()
+	if ds.stopped {
+		ds.mu.Unlock()
+		return DeliverySchedulerStoppedErr
+	}
+	l, ok := ds.byPrio[priority]
+	if !ok {
+		l = ds.byPrio[MSG_PRIORITY_NORMAL]
+	}
+	if len(l.tasks) >= l.QueueSize {
+		ds.mu.Unlock()
+		return DeliveryQueueFullErr
+	}
+	l.tasks = append(l.tasks, task)
+	// notify 容量等于所有队列容量之和，持锁发送不会阻塞，也不会与 Stop 中的 close 竞争
+	ds.notify <- struct{}{}
+	ds.mu.Unlock()
+	return nil
+}
+
+// SubmitAfter delay 之后把任务放入对应优先级的队列，等待期间不占用 worker，用于重试退避；
+// 到期时入队失败或者调度器已经停止时调用 dropped
+func (ds *DeliveryScheduler) SubmitAfter(priority MsgPriority, delay time.Duration, task func(), dropped func(error)) error {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if ds.stopped {
+		return DeliverySchedulerStoppedErr
+	}
+	dt := &delayedTask{dropped: dropped}
+	// 回调需要先拿到锁，持锁登记之后才可能执行
+	dt.timer = ds.afterFunc(delay, func() {
+		ds.mu.Lock()
+		_, ok := ds.delayed[dt]
+		delete(ds.delayed, dt)
+		ds.mu.Unlock()
+		if !ok {
+			return
+		}
+		if err := ds.Submit(priority, task); err != nil && dropped != nil {
+			dropped(err)
+		}
+	})
+	ds.delayed[dt] = struct{}{}
+	return nil
+}
+
+// QueueLen 返回指定优先级队列当前积压的任务数
+func (ds *DeliveryScheduler) QueueLen(priority MsgPriority) int {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	if l, ok := ds.byPrio[priority]; ok {
+		return len(l.tasks)
+	}
+	return 0
+}
+
+// Stop 停止接收新任务，等待已入队的任务执行完毕，还在等待入队的延迟任务以 DeliverySchedulerStoppedErr 丢弃
+func (ds *DeliveryScheduler) Stop() {
+	ds.mu.Lock()
+	if ds.stopped {
+		ds.mu.Unlock()
+		return
+	}
+	ds.stopped = true
+	var dropped []*delayedTask
+	for dt := range ds.delayed {
+		if dt.timer.Stop() {
+			dropped = append(dropped, dt)
+		}
+	}
+	ds.delayed = make(map[*delayedTask]struct{})
+	ds.mu.Unlock()
+	for _, dt := range dropped {
+		if dt.dropped != nil {
+			dt.dropped(DeliverySchedulerStoppedErr)
+		}
+	}
+	close(ds.notify)
+	ds.wg.Wait()
+}
+
+func (ds *DeliveryScheduler) work() {
+	defer ds.wg.Done()
+	for range ds.notify {
+		if task := ds.next(); task != nil {
+			runDeliveryTask(task)
+		}
+	}
+}
+
+// next 平滑加权轮询：只在非空队列中选择，当前权重最大的队列出队
+func (ds *DeliveryScheduler) next() func() {
+	ds.mu.Lock()
+	defer ds.mu.Unlock()
+	var best *deliveryLane
+	total := 0
+	for _, l := range ds.lanes {
+		if len(l.tasks) == 0 {
+			continue
+		}
+		l.currentWeight += l.Weight
+		total += l.Weight
+		if best == nil || l.currentWeight > best.currentWeight {
+			best = l
+		}
+	}
+	if best == nil {
+		return nil
+	}
+	best.currentWeight -= total
+	task := best.tasks[0]
+	best.tasks[0] = nil
+	best.tasks = best.tasks[1:]
+	return task
+}
+
+func runDeliveryTask(task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 1<<15)
+			n := runtime.Stack(buf, false)
+			err := fmt.Errorf("%v, STACK: %s", r, buf[0:n])
+			Applog.Errorf("delivery task panic :%+v", err)
+		}
+	}()
+	task()
+}
@@ -0,0 +1,273 @@
+package router
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// manualTimer 由测试手动触发的定时器
+type manualTimer struct {
+	delay time.Duration
+	f     func()
+
+	mu      sync.Mutex
+	stopped bool
+}
+
+func (m *manualTimer) Stop() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.stopped {
+		return false
+	}
+	m.stopped = true
+	return true
+}
+
+// fire 模拟定时器到期，已经 Stop 的定时器不执行
+func (m *manualTimer) fire() {
+	m.mu.Lock()
+	if m.stopped {
+		m.mu.Unlock()
+		return
+	}
+	m.stopped = true
+	m.mu.Unlock()
+	m.f()
+}
+
+// manualClock 替换 DeliveryScheduler.afterFunc，登记的定时器按顺序写入 timers
+type manualClock struct {
+	timers chan *manualTimer
+}
+
+func newManualClock() *manualClock {
+	return &manualClock{timers: make(chan *manualTimer, 64)}
+}
+
+func (c *manualClock) afterFunc(d time.Duration, f func()) deliveryTimer {
+	t := &manualTimer{delay: d, f: f}
+	c.timers <- t
+	return t
+}
+
+func (c *manualClock) next(t *testing.T) *manualTimer {
+	t.Helper()
+	select {
+	case tm := <-c.timers:
+		return tm
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for timer")
+		return nil
+	}
+}
+
+func newTestScheduler(t *testing.T, workers int, lanes []DeliveryLaneConfig) (*DeliveryScheduler, *manualClock) {
+	t.Helper()
+	ds, err := NewDeliveryScheduler(workers, lanes)
+	assert.NoError(t, err)
+	clock := newManualClock()
+	ds.afterFunc = clock.afterFunc
+	t.Cleanup(ds.Stop)
+	return ds, clock
+}
+
+func TestNewDeliverySchedulerValidation(t *testing.T) {
+	testCases := []struct {
+		name    string
+		workers int
+		lanes   []DeliveryLaneConfig
+		wantErr bool
+	}{
+		{name: "defaults", workers: 1},
+		{name: "no-workers", workers: 0, wantErr: true},
+		{name: "bad-queue-size", workers: 1, lanes: []DeliveryLaneConfig{{Priority: MSG_PRIORITY_NORMAL, QueueSize: 0, Weight: 1}}, wantErr: true},
+		{name: "bad-weight", workers: 1, lanes: []DeliveryLaneConfig{{Priority: MSG_PRIORITY_NORMAL, QueueSize: 1, Weight: 0}}, wantErr: true},
+		{name: "duplicate", workers: 1, lanes: []DeliveryLaneConfig{
+			{Priority: MSG_PRIORITY_NORMAL, QueueSize: 1, Weight: 1},
+			{Priority: MSG_PRIORITY_NORMAL, QueueSize: 1, Weight: 1},
+		}, wantErr: true},
+		{name: "normal-required", workers: 1, lanes: []DeliveryLaneConfig{{Priority: MSG_PRIORITY_BULK, QueueSize: 1, Weight: 1}}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ds, err := NewDeliveryScheduler(tc.workers, tc.lanes)
+			assert.Equal(t, tc.wantErr, err != nil)
+			if ds != nil {
+				ds.Stop()
+			}
+		})
+	}
+}
+
+// blockWorker 占住唯一的 worker，返回释放函数
+func blockWorker(t *testing.T, ds *DeliveryScheduler) func() {
+	t.Helper()
+	started := make(chan struct{})
+	release := make(chan struct{})
+	assert.NoError(t, ds.Submit(MSG_PRIORITY_NORMAL, func() {
+		close(started)
+		<-release
+	}))
+	<-started
+	return func() { close(release) }
+}
+
+func TestDeliverySchedulerWeightedOrder(t *testing.T) {
+	ds, _ := newTestScheduler(t, 1, []DeliveryLaneConfig{
+		{Priority: MSG_PRIORITY_REALTIME, QueueSize: 10, Weight: 2},
+		{Priority: MSG_PRIORITY_NORMAL, QueueSize: 10, Weight: 1},
+	})
+	release := blockWorker(t, ds)
+
+	var mu sync.Mutex
+	var order []string
+	var wg sync.WaitGroup
+	record := func(name string) func() {
+		wg.Add(1)
+		return func() {
+			mu.Lock()
+			order = append(order, name)
+			mu.Unlock()
+			wg.Done()
+		}
+	}
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, ds.Submit(MSG_PRIORITY_NORMAL, record("n")))
+		assert.NoError(t, ds.Submit(MSG_PRIORITY_REALTIME, record("r")))
+	}
+	assert.Equal(t, 3, ds.QueueLen(MSG_PRIORITY_REALTIME))
+	release()
+	wg.Wait()
+	assert.Equal(t, []string{"r", "n", "r", "r", "n", "n"}, order)
+}
+
+func TestDeliverySchedulerSubmit(t *testing.T) {
+	ds, _ := newTestScheduler(t, 1, []DeliveryLaneConfig{{Priority: MSG_PRIORITY_NORMAL, QueueSize: 1, Weight: 1}})
+	release := blockWorker(t, ds)
+
+	done := make(chan struct{})
+	// 未配置的优先级进入 NORMAL 队列
+	assert.NoError(t, ds.Submit(MSG_PRIORITY_BULK, func() { close(done) }))
+	assert.Equal(t, 1, ds.QueueLen(MSG_PRIORITY_NORMAL))
+	assert.Equal(t, 0, ds.QueueLen(MSG_PRIORITY_BULK))
+	assert.Equal(t, DeliveryQueueFullErr, ds.Submit(MSG_PRIORITY_NORMAL, func() {}))
+	release()
+	<-done
+
+	// panic 不影响后续任务
+	panicked := make(chan struct{})
+	assert.NoError(t, ds.Submit(MSG_PRIORITY_NORMAL, func() {
+		defer close(panicked)
+		panic("boom")
+	}))
+	<-panicked
+	after := make(chan struct{})
+	assert.NoError(t, ds.Submit(MSG_PRIORITY_NORMAL, func() { close(after) }))
+	<-after
+
+	ds.Stop()
+	assert.Equal(t, DeliverySchedulerStoppedErr, ds.Submit(MSG_PRIORITY_NORMAL, func() {}))
+}
+
+func TestDeliverySchedulerSubmitAfter(t *testing.T) {
+	t.Run("runs-after-timer-without-holding-worker", func(t *testing.T) {
+		ds, clock := newTestScheduler(t, 1, nil)
+		ran := make(chan struct{})
+		assert.NoError(t, ds.SubmitAfter(MSG_PRIORITY_REALTIME, time.Second, func() { close(ran) }, nil))
+		timer := clock.next(t)
+		assert.Equal(t, time.Second, timer.delay)
+
+		// 等待期间 worker 仍然可以执行其它任务
+		other := make(chan struct{})
+		assert.NoError(t, ds.Submit(MSG_PRIORITY_NORMAL, func() { close(other) }))
+		<-other
+
+		timer.fire()
+		<-ran
+	})
+	t.Run("queue-full-when-fired", func(t *testing.T) {
+		ds, clock := newTestScheduler(t, 1, []DeliveryLaneConfig{{Priority: MSG_PRIORITY_NORMAL, QueueSize: 1, Weight: 1}})
+		release := blockWorker(t, ds)
+		defer release()
+		var dropped error
+		assert.NoError(t, ds.SubmitAfter(MSG_PRIORITY_NORMAL, time.Second, func() {}, func(err error) { dropped = err }))
+		assert.NoError(t, ds.Submit(MSG_PRIORITY_NORMAL, func() {}))
+		clock.next(t).fire()
+		assert.Equal(t, DeliveryQueueFullErr, dropped)
+	})
+	t.Run("stop-drops-pending", func(t *testing.T) {
+		ds, clock := newTestScheduler(t, 1, nil)
+		var dropped error
+		ran := false
+		assert.NoError(t, ds.SubmitAfter(MSG_PRIORITY_NORMAL, time.Second, func() { ran = true }, func(err error) { dropped = err }))
+		timer := clock.next(t)
+		ds.Stop()
+		assert.Equal(t, DeliverySchedulerStoppedErr, dropped)
+		timer.fire()
+		assert.False(t, ran)
+		assert.Equal(t, DeliverySchedulerStoppedErr, ds.SubmitAfter(MSG_PRIORITY_NORMAL, 0, func() {}, nil))
+	})
+}
+
+func TestDeliverRetryRequeuesInsteadOfSleeping(t *testing.T) {
+	ds, clock := newTestScheduler(t, 1, nil)
+	conn := &fakeConnector{errs: []error{NoConnectionErr}}
+	ts := newTestServer(t, iosDevice("d1", conn))
+	ts.Scheduler = ds
+	ts.ErrorPolicy = &ErrorPolicy{
+		Actions:      map[ErrorClass]ErrorAction{ErrClassTransient: ErrActionRetry},
+		MaxRetries:   2,
+		RetryBackoff: time.Minute,
+	}
+	retries := Metrics.Value(MetricDeliverRetry)
+
+	_, err := ts.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+	assert.NoError(t, err)
+	timer := clock.next(t)
+	assert.Equal(t, time.Minute, timer.delay)
+	assert.Equal(t, retries+1, Metrics.Value(MetricDeliverRetry))
+
+	// 退避期间唯一的 worker 空闲
+	idle := make(chan struct{})
+	assert.NoError(t, ds.Submit(MSG_PRIORITY_REALTIME, func() { close(idle) }))
+	<-idle
+
+	timer.fire()
+	ev := ts.observer.next(t, EventMsgDelivered)
+	assert.Equal(t, 2, ev.Attempts)
+	assert.Len(t, conn.requests(), 2)
+}
+
+func TestDeliverRetryDroppedOnStop(t *testing.T) {
+	ds, clock := newTestScheduler(t, 1, nil)
+	conn := &fakeConnector{err: NoConnectionErr}
+	ts := newTestServer(t, iosDevice("d1", conn))
+	ts.Scheduler = ds
+
+	_, err := ts.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+	assert.NoError(t, err)
+	clock.next(t)
+	ds.Stop()
+	ev := ts.observer.next(t, EventDeliverFailed)
+	assert.Equal(t, 1, ev.Attempts)
+	assert.Equal(t, NoConnectionErr.Error(), ev.Err)
+}
+
+func TestDeliverRetryWithoutScheduler(t *testing.T) {
+	conn := &fakeConnector{err: NoConnectionErr}
+	ts := newTestServer(t, iosDevice("d1", conn))
+	ts.ErrorPolicy = &ErrorPolicy{
+		Actions:    map[ErrorClass]ErrorAction{ErrClassTransient: ErrActionRetry},
+		MaxRetries: 2,
+	}
+	_, err := ts.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+	assert.NoError(t, err)
+	ev := ts.observer.next(t, EventDeliverFailed)
+	assert.Equal(t, 3, ev.Attempts)
+	assert.Len(t, conn.requests(), 3)
+}
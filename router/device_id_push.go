@@ -0,0 +1,78 @@
+package router
+
+import "fmt"
+
+const MetricDeviceIdPushUnknownDevice = "router_device_id_push_unknown_device"
+
+// deviceIdPushIndex 设备 ID 到定向推送内容的索引
+type deviceIdPushIndex map[string]*PushContent
+
+// validateDeviceIdPushes 同一个设备只能出现在一个 DeviceIdPush 中
+func validateDeviceIdPushes(in *TransferMessageRequest) error {
+	seen := make(map[string]int)
+	for i, deviceIdPush := range in.GetDeviceIdPushes() {
+		if deviceIdPush == nil || len(deviceIdPush.GetDeviceIds()) == 0 {
+			return &InvalidRequestError{Reason: fmt.Sprintf("device id push %d has no device ids, msgId: %v", i, in.GetMsgId())}
+		}
+		for _, deviceId := range deviceIdPush.GetDeviceIds() {
+			if len(deviceId) == 0 {
+				return &InvalidRequestError{Reason: fmt.Sprintf("device id push %d has empty device id, msgId: %v", i, in.GetMsgId())}
+			}
+			if j, ok := seen[deviceId]; ok {
+				return &InvalidRequestError{Reason: fmt.Sprintf("device %v appears in device id push %d and %d, msgId: %v", deviceId, j, i, in.GetMsgId())}
+			}
+			seen[deviceId] = i
+		}
+	}
+	if in.DeviceIdPushesOnly && len(seen) == 0 {
+		return &InvalidRequestError{Reason: fmt.Sprintf("device id pushes only but no device listed, msgId: %v", in.GetMsgId())}
+	}
+	return nil
+}
+
+func indexDeviceIdPushes(in *TransferMessageRequest) deviceIdPushIndex {
+	if len(in.GetDeviceIdPushes()) == 0 {
+		return nil
+	}
+	idx := make(deviceIdPushIndex)
+	for _, deviceIdPush := range in.GetDeviceIdPushes() {
+		for _, deviceId := range deviceIdPush.GetDeviceIds() {
+			idx[deviceId] = deviceIdPush.GetPush()
+		}
+	}
+	return idx
+}
+
+// pushFor 返回设备对应的推送内容，未单独指定的设备使用通用的 Push
+func (idx deviceIdPushIndex) pushFor(req *TransferMessageRequest, deviceId string) *PushContent {
+	if push, ok := idx[deviceId]; ok {
+		return push
+	}
+	return req.GetPush()
+}
+
+// filterDeviceIdPushTargets 记录请求中指定但当前不在线的设备；
+// DeviceIdPushesOnly 为 true 时只保留请求中指定的设备
+func filterDeviceIdPushTargets(in *TransferMessageRequest, idx deviceIdPushIndex, wrappers []*ConnectorClientWrapper) []*ConnectorClientWrapper {
+	if len(idx) == 0 {
+		return wrappers
+	}
+	online := make(map[string]bool, len(wrappers))
+	targets := wrappers
+	if in.DeviceIdPushesOnly {
+		targets = make([]*ConnectorClientWrapper, 0, len(idx))
+	}
+	for _, w := range wrappers {
+		online[w.DeviceID] = true
+		if _, ok := idx[w.DeviceID]; ok && in.DeviceIdPushesOnly {
+			targets = append(targets, w)
+		}
+	}
+	for deviceId := range idx {
+		if !online[deviceId] {
+			Metrics.Counter(MetricDeviceIdPushUnknownDevice, 1)
+			Applog.Warnf("device %v in device id pushes is not online, uid: %v, msgId: %v", deviceId, in.ReceiverId, in.GetMsgId())
+		}
+	}
+	return targets
+}
@@ -0,0 +1,135 @@
+package router
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateDeviceIdPushes(t *testing.T) {
+	push := &PushContent{Title: &I18N{Value: "d"}}
+	testCases := []struct {
+		name    string
+		pushes  []*DeviceIdPush
+		only    bool
+		wantErr bool
+	}{
+		{name: "none"},
+		{name: "valid", pushes: []*DeviceIdPush{{DeviceIds: []string{"d1", "d2"}, Push: push}, {DeviceIds: []string{"d3"}}}},
+		{name: "nil-entry", pushes: []*DeviceIdPush{nil}, wantErr: true},
+		{name: "no-device-ids", pushes: []*DeviceIdPush{{Push: push}}, wantErr: true},
+		{name: "empty-device-id", pushes: []*DeviceIdPush{{DeviceIds: []string{""}}}, wantErr: true},
+		{name: "duplicate-in-entry", pushes: []*DeviceIdPush{{DeviceIds: []string{"d1", "d1"}}}, wantErr: true},
+		{name: "duplicate-across-entries", pushes: []*DeviceIdPush{{DeviceIds: []string{"d1"}}, {DeviceIds: []string{"d1"}}}, wantErr: true},
+		{name: "only-without-devices", only: true, wantErr: true},
+		{name: "only-with-devices", pushes: []*DeviceIdPush{{DeviceIds: []string{"d1"}}}, only: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			in := newTestRequest()
+			in.DeviceIdPushes = tc.pushes
+			in.DeviceIdPushesOnly = tc.only
+			err := validateDeviceIdPushes(in)
+			if !tc.wantErr {
+				assert.NoError(t, err)
+				return
+			}
+			var invalid *InvalidRequestError
+			assert.ErrorAs(t, err, &invalid)
+		})
+	}
+}
+
+func TestDeviceIdPushIndex(t *testing.T) {
+	in := newTestRequest()
+	d1Push := &PushContent{Title: &I18N{Value: "d1"}}
+	in.DeviceIdPushes = []*DeviceIdPush{{DeviceIds: []string{"d1"}, Push: d1Push}, {DeviceIds: []string{"d2"}}}
+	idx := indexDeviceIdPushes(in)
+
+	testCases := []struct {
+		name     string
+		deviceID string
+		want     *PushContent
+	}{
+		{name: "listed", deviceID: "d1", want: d1Push},
+		{name: "listed-without-push", deviceID: "d2"},
+		{name: "not-listed-falls-back", deviceID: "d3", want: in.Push},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, idx.pushFor(in, tc.deviceID))
+		})
+	}
+	assert.Nil(t, indexDeviceIdPushes(newTestRequest()))
+}
+
+func TestFilterDeviceIdPushTargets(t *testing.T) {
+	wrappers := []*ConnectorClientWrapper{iosDevice("d1", nil), iosDevice("d2", nil)}
+	testCases := []struct {
+		name        string
+		pushes      []*DeviceIdPush
+		only        bool
+		wantDevices []string
+		wantUnknown int64
+	}{
+		{name: "no-device-id-pushes", wantDevices: []string{"d1", "d2"}},
+		{name: "fallback-keeps-all", pushes: []*DeviceIdPush{{DeviceIds: []string{"d1"}}}, wantDevices: []string{"d1", "d2"}},
+		{name: "only-listed", pushes: []*DeviceIdPush{{DeviceIds: []string{"d2"}}}, only: true, wantDevices: []string{"d2"}},
+		{name: "unknown-device-counted", pushes: []*DeviceIdPush{{DeviceIds: []string{"d1", "d9"}}}, only: true, wantDevices: []string{"d1"}, wantUnknown: 1},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			in := newTestRequest()
+			in.DeviceIdPushes = tc.pushes
+			in.DeviceIdPushesOnly = tc.only
+			unknown := Metrics.Value(MetricDeviceIdPushUnknownDevice)
+
+			var devices []string
+			for _, w := range filterDeviceIdPushTargets(in, indexDeviceIdPushes(in), wrappers) {
+				devices = append(devices, w.DeviceID)
+			}
+			assert.Equal(t, tc.wantDevices, devices)
+			assert.Equal(t, unknown+tc.wantUnknown, Metrics.Value(MetricDeviceIdPushUnknownDevice))
+		})
+	}
+}
+
+func TestTransferWithDeviceIdPushes(t *testing.T) {
+	testCases := []struct {
+		name      string
+		only      bool
+		wantD2Msg bool
+	}{
+		{name: "fallback-to-generic-push", wantD2Msg: true},
+		{name: "only-listed-devices", only: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			d1, d2 := &fakeConnector{}, &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", d1), iosDevice("d2", d2))
+			in := newTestRequest()
+			in.DeviceIdPushes = []*DeviceIdPush{{DeviceIds: []string{"d1"}, Push: &PushContent{Title: &I18N{Value: "only d1"}}}}
+			in.DeviceIdPushesOnly = tc.only
+
+			rpl, err := ts.TransferOnlineReliableMessage(context.Background(), in)
+			assert.NoError(t, err)
+			assert.True(t, rpl.IsUserOnline)
+			ts.observer.next(t, EventMsgDelivered)
+			if tc.wantD2Msg {
+				ts.observer.next(t, EventMsgDelivered)
+			}
+
+			if reqs := d1.requests(); assert.Len(t, reqs, 1) {
+				assert.Equal(t, "only d1", reqs[0].Push.Title.Value)
+			}
+			if !tc.wantD2Msg {
+				assert.Empty(t, d2.requests())
+				return
+			}
+			if reqs := d2.requests(); assert.Len(t, reqs, 1) {
+				assert.Equal(t, "title", reqs[0].Push.Title.Value)
+			}
+		})
+	}
+}
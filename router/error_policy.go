@@ -0,0 +1,159 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+)
+
+// ErrorClass connector 返回错误的分类
+type ErrorClass int
+
+const (
+	ErrClassUnknown ErrorClass = iota
+	ErrClassTransient
+	ErrClassStaleDevice
+	ErrClassConnectorGone
+	ErrClassAuthRevoked
+	// ErrClassCircuitOpen connector 熔断中，默认不重试也不删除路由
+	ErrClassCircuitOpen
+)
+
+func (c ErrorClass) String() string {
+	switch c {
+	case ErrClassTransient:
+		return "transient"
+	case ErrClassStaleDevice:
+		return "stale_device"
+	case ErrClassConnectorGone:
+		return "connector_gone"
+	case ErrClassAuthRevoked:
+		return "auth_revoked"
+	case ErrClassCircuitOpen:
+		return "circuit_open"
+	}
+	return "unknown"
+}
+
+// ErrorAction 对某类错误的处理方式
+type ErrorAction int
+
+const (
+	ErrActionIgnore ErrorAction = iota
+	ErrActionRetry
+	ErrActionDeleteRoute
+	ErrActionBlacklistDevice
+)
+
+const (
+	MetricRouteDeleted      = "router_route_deleted"
+	MetricRouteDeleteMiss   = "router_route_delete_miss"
+	MetricRouteDeleteFailed = "router_route_delete_failed"
+	MetricDeliverRetry      = "router_deliver_retry"
+	MetricDeviceBlacklisted = "router_device_blacklisted"
+)
+
+// connector error check mock
+func IsErrAuthRevoked(err error) bool {
+	return err != nil && err.Error() == "auth revoked"
+}
+
+// ErrorClassifier 返回 ErrClassUnknown 表示无法识别，交给下一个分类器
+type ErrorClassifier func(err error) ErrorClass
+
+// DefaultErrorClassifier 识别 connector 和连接池返回的已知错误
+func DefaultErrorClassifier(err error) ErrorClass {
+	switch {
+	case IsErrUserNotExist(err):
+		return ErrClassStaleDevice
+	case IsErrAuthRevoked(err):
+		return ErrClassAuthRevoked
+	case errors.Is(err, ConnectorUnreachableErr):
+		return ErrClassConnectorGone
+	case errors.Is(err, ConnectorCircuitOpenErr):
+		return ErrClassCircuitOpen
+	case errors.Is(err, NoConnectionErr), errors.Is(err, context.DeadlineExceeded):
+		return ErrClassTransient
+	}
+	return ErrClassUnknown
+}
+
+// ErrorPolicy 决定 connector 错误的处理方式：重试、删除路由或者拉黑设备
+type ErrorPolicy struct {
+	// Classifiers 按顺序执行，都无法识别时使用 DefaultErrorClassifier
+	Classifiers  []ErrorClassifier
+	Actions      map[ErrorClass]ErrorAction
+	MaxRetries   int
+	RetryBackoff time.Duration // 第 n 次重试前等待 n*RetryBackoff
+	BlacklistTTL time.Duration
+}
+
+var DefaultErrorPolicy = &ErrorPolicy{
+	Actions: map[ErrorClass]ErrorAction{
+		ErrClassTransient:     ErrActionRetry,
+		ErrClassStaleDevice:   ErrActionDeleteRoute,
+		ErrClassConnectorGone: ErrActionDeleteRoute,
+		ErrClassAuthRevoked:   ErrActionBlacklistDevice,
+	},
+	MaxRetries:   2,
+	RetryBackoff: 200 * time.Millisecond,
+	BlacklistTTL: 10 * time.Minute,
+}
+
+func (p *ErrorPolicy) Classify(err error) ErrorClass {
+	for _, c := range p.Classifiers {
+		if class := c(err); class != ErrClassUnknown {
+			return class
+		}
+	}
+	return DefaultErrorClassifier(err)
+}
+
+func (p *ErrorPolicy) ActionOf(class ErrorClass) ErrorAction {
+	return p.Actions[class]
+}
+
+func (p *ErrorPolicy) backoff(attempt int) time.Duration {
+	return time.Duration(attempt) * p.RetryBackoff
+}
+
+// DeviceBlacklist 本地设备黑名单，拉黑期间不再向该设备下发消息
+type DeviceBlacklist struct {
+	mu      sync.Mutex
+	devices map[string]time.Time // key -> 解除拉黑的时间
+	now     func() time.Time
+}
+
+func NewDeviceBlacklist() *DeviceBlacklist {
+	return &DeviceBlacklist{devices: make(map[string]time.Time), now: time.Now}
+}
+
+func deviceBlacklistKey(appID, userId, deviceID string) string {
+	return appID + RedisInterval + userId + RedisInterval + deviceID
+}
+
+func (b *DeviceBlacklist) Add(appID, userId, deviceID string, ttl time.Duration) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	now := b.now()
+	for k, until := range b.devices {
+		if !until.After(now) {
+			delete(b.devices, k)
+		}
+	}
+	b.devices[deviceBlacklistKey(appID, userId, deviceID)] = now.Add(ttl)
+}
+
+func (b *DeviceBlacklist) Contains(appID, userId, deviceID string) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	until, ok := b.devices[deviceBlacklistKey(appID, userId, deviceID)]
+	return ok && until.After(b.now())
+}
+
+func (b *DeviceBlacklist) Remove(appID, userId, deviceID string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	delete(b.devices, deviceBlacklistKey(appID, userId, deviceID))
+}
@@ -0,0 +1,213 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// cadCall 记录一次 HCAD/HCADSR 调用
+type cadCall struct {
+	op       string
+	userId   string
+	deviceID string
+	addr     string
+}
+
+// cadStore 记录删除路由的调用，deleted 为 HCAD/HCADSR 的返回值
+type cadStore struct {
+	DefaultRouterRedisClient
+	deleted int64
+	err     error
+
+	mu    sync.Mutex
+	calls []cadCall
+}
+
+func (s *cadStore) record(op, userId, deviceID, addr string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.calls = append(s.calls, cadCall{op: op, userId: userId, deviceID: deviceID, addr: addr})
+	return s.deleted, s.err
+}
+
+func (s *cadStore) HCAD(ctx context.Context, appID, userId, deviceID, source, addr string) (int64, error) {
+	return s.record("HCAD", userId, deviceID, addr)
+}
+
+func (s *cadStore) HCADSR(ctx context.Context, appID, userId, deviceID, source, addr string) (int64, error) {
+	return s.record("HCADSR", userId, deviceID, addr)
+}
+
+func (s *cadStore) recorded() []cadCall {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]cadCall(nil), s.calls...)
+}
+
+func TestDefaultErrorClassifier(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want ErrorClass
+	}{
+		{name: "user-not-exist", err: errors.New("user not exist"), want: ErrClassStaleDevice},
+		{name: "auth-revoked", err: errors.New("auth revoked"), want: ErrClassAuthRevoked},
+		{name: "unreachable", err: ConnectorUnreachableErr, want: ErrClassConnectorGone},
+		{name: "circuit-open", err: ConnectorCircuitOpenErr, want: ErrClassCircuitOpen},
+		{name: "no-connection", err: NoConnectionErr, want: ErrClassTransient},
+		{name: "dial-failed", err: fmt.Errorf("%w: refused", NoConnectionErr), want: ErrClassTransient},
+		{name: "deadline", err: context.DeadlineExceeded, want: ErrClassTransient},
+		{name: "unknown", err: errors.New("boom"), want: ErrClassUnknown},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, DefaultErrorClassifier(tc.err))
+			assert.NotEmpty(t, tc.want.String())
+		})
+	}
+}
+
+func TestErrorPolicyClassify(t *testing.T) {
+	custom := errors.New("custom")
+	p := &ErrorPolicy{Classifiers: []ErrorClassifier{
+		func(err error) ErrorClass { return ErrClassUnknown },
+		func(err error) ErrorClass {
+			if err == custom || err == NoConnectionErr {
+				return ErrClassStaleDevice
+			}
+			return ErrClassUnknown
+		},
+	}}
+	// 自定义分类器优先于默认分类器
+	assert.Equal(t, ErrClassStaleDevice, p.Classify(custom))
+	assert.Equal(t, ErrClassStaleDevice, p.Classify(NoConnectionErr))
+	assert.Equal(t, ErrClassConnectorGone, p.Classify(ConnectorUnreachableErr))
+
+	assert.Equal(t, ErrActionRetry, DefaultErrorPolicy.ActionOf(ErrClassTransient))
+	assert.Equal(t, ErrActionIgnore, DefaultErrorPolicy.ActionOf(ErrClassCircuitOpen))
+	assert.Equal(t, ErrActionIgnore, DefaultErrorPolicy.ActionOf(ErrClassUnknown))
+	assert.Equal(t, 3*DefaultErrorPolicy.RetryBackoff, DefaultErrorPolicy.backoff(3))
+}
+
+func TestHandleError(t *testing.T) {
+	const addr = "10.0.0.1:80"
+	testCases := []struct {
+		name        string
+		err         error
+		userId      string
+		attempt     int
+		wantRetry   bool
+		wantCalls   []cadCall
+		blacklisted bool
+	}{
+		{name: "transient-retry", err: NoConnectionErr, userId: "42", attempt: 1, wantRetry: true},
+		{name: "transient-give-up", err: NoConnectionErr, userId: "42", attempt: 3},
+		{name: "circuit-open-no-retry", err: ConnectorCircuitOpenErr, userId: "42", attempt: 1},
+		{
+			name: "stale-device-deletes-unconditionally", err: errors.New("user not exist"), userId: "42", attempt: 1,
+			wantCalls: []cadCall{{op: "HCADSR", userId: "42", deviceID: "d1"}},
+		},
+		{
+			name: "unreachable-deletes-route-on-addr", err: ConnectorUnreachableErr, userId: "42", attempt: 1,
+			wantCalls: []cadCall{{op: "HCADSR", userId: "42", deviceID: "d1", addr: addr}},
+		},
+		{
+			name: "anonymous-primary-route-only", err: ConnectorUnreachableErr, userId: AnonymousUserIDStr, attempt: 1,
+			wantCalls: []cadCall{{op: "HCAD", userId: AnonymousUserIDStr, deviceID: "d1", addr: addr}},
+		},
+		{name: "auth-revoked-blacklists", err: errors.New("auth revoked"), userId: "42", attempt: 1, blacklisted: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			store := &cadStore{deleted: 1}
+			ts.Store = store
+			ts.Blacklist = NewDeviceBlacklist()
+			blacklisted := Metrics.Value(MetricDeviceBlacklisted)
+
+			retry := ts.handleError(context.Background(), tc.err, "im", tc.userId, "d1", "ios", addr, tc.attempt)
+			assert.Equal(t, tc.wantRetry, retry)
+			assert.Equal(t, tc.wantCalls, store.recorded())
+			assert.Equal(t, tc.blacklisted, ts.Blacklist.Contains("im", tc.userId, "d1"))
+			if tc.blacklisted {
+				assert.Equal(t, blacklisted+1, Metrics.Value(MetricDeviceBlacklisted))
+			}
+		})
+	}
+}
+
+func TestHandleErrorDeleteRouteResult(t *testing.T) {
+	testCases := []struct {
+		name       string
+		deleted    int64
+		err        error
+		wantMetric string
+		wantEvent  bool
+	}{
+		{name: "deleted", deleted: 1, wantMetric: MetricRouteDeleted, wantEvent: true},
+		{name: "route-changed", deleted: 0, wantMetric: MetricRouteDeleteMiss},
+		{name: "redis-error", err: errTestRedisDown, wantMetric: MetricRouteDeleteFailed},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			ts.Store = &cadStore{deleted: tc.deleted, err: tc.err}
+			before := Metrics.Value(tc.wantMetric)
+
+			ts.handleError(context.Background(), ConnectorUnreachableErr, "im", "42", "d1", "ios", "10.0.0.1:80", 1)
+			assert.Equal(t, before+1, Metrics.Value(tc.wantMetric))
+			var deleted []*RouterEvent
+			for _, ev := range ts.observer.drain() {
+				if ev.Type == EventRouteDeleted {
+					deleted = append(deleted, ev)
+				}
+			}
+			if !tc.wantEvent {
+				assert.Empty(t, deleted)
+				return
+			}
+			if assert.Len(t, deleted, 1) {
+				assert.Equal(t, ErrClassConnectorGone.String(), deleted[0].Reason)
+			}
+		})
+	}
+}
+
+func TestDeliverCircuitOpenNotRetried(t *testing.T) {
+	conn := &fakeConnector{err: ConnectorCircuitOpenErr}
+	ts := newTestServer(t, iosDevice("d1", conn))
+	retries := Metrics.Value(MetricDeliverRetry)
+
+	_, err := ts.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+	assert.NoError(t, err)
+	ev := ts.observer.next(t, EventDeliverFailed)
+	assert.Equal(t, 1, ev.Attempts)
+	assert.Len(t, conn.requests(), 1)
+	assert.Equal(t, retries, Metrics.Value(MetricDeliverRetry))
+}
+
+func TestDeviceBlacklist(t *testing.T) {
+	b := NewDeviceBlacklist()
+	now := testNow
+	b.now = func() time.Time { return now }
+
+	b.Add("im", "42", "d1", time.Minute)
+	b.Add("im", "42", "d2", time.Hour)
+	assert.True(t, b.Contains("im", "42", "d1"))
+	assert.False(t, b.Contains("im", "43", "d1"))
+
+	// 过期后不再拉黑，下一次 Add 时清理
+	now = now.Add(2 * time.Minute)
+	assert.False(t, b.Contains("im", "42", "d1"))
+	b.Add("im", "42", "d3", time.Minute)
+	assert.Len(t, b.devices, 2)
+
+	b.Remove("im", "42", "d2")
+	assert.False(t, b.Contains("im", "42", "d2"))
+}
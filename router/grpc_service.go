@@ -0,0 +1,189 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/gangcheng1030/ai_testing_and_refactoring/router/routerpb"
+)
+
+// ============== 状态码和 Metadata（取值与 grpc/codes、grpc/metadata 一致，业务代码不直接依赖 grpc） ==============
+
+// Code 模拟 grpc/codes
+type Code uint32
+
+const (
+	CodeOK                Code = 0
+	CodeInvalidArgument   Code = 3
+	CodePermissionDenied  Code = 7
+	CodeResourceExhausted Code = 8
+	CodeUnimplemented     Code = 12
+	CodeInternal          Code = 13
+	CodeUnavailable       Code = 14
+	CodeUnauthenticated   Code = 16
+)
+
+func (c Code) String() string {
+	switch c {
+	case CodeOK:
+		return "OK"
+	case CodeInvalidArgument:
+		return "InvalidArgument"
+	case CodePermissionDenied:
+		return "PermissionDenied"
+	case CodeResourceExhausted:
+		return "ResourceExhausted"
+	case CodeUnimplemented:
+		return "Unimplemented"
+	case CodeInternal:
+		return "Internal"
+	case CodeUnavailable:
+		return "Unavailable"
+	case CodeUnauthenticated:
+		return "Unauthenticated"
+	}
+	return fmt.Sprintf("Code(%d)", uint32(c))
+}
+
+// StatusError 模拟 grpc/status
+type StatusError struct {
+	Code    Code
+	Message string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("rpc error: code = %v desc = %v", e.Code, e.Message)
+}
+
+func NewStatusError(code Code, format string, args ...interface{}) error {
+	return &StatusError{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// StatusCode 返回错误对应的状态码，RouterServer 的错误按类型映射
+func StatusCode(err error) Code {
+	if err == nil {
+		return CodeOK
+	}
+	var se *StatusError
+	switch {
+	case errors.As(err, &se):
+		return se.Code
+	case IsErrInvalidRequest(err):
+		return CodeInvalidArgument
+	case IsErrRateLimited(err):
+		return CodeResourceExhausted
+	case errors.Is(err, DeliveryQueueFullErr), errors.Is(err, DeliverySchedulerStoppedErr):
+		return CodeUnavailable
+	}
+	return CodeInternal
+}
+
+// toStatusError 把 RouterServer 返回的错误转换成带状态码的错误
+func toStatusError(err error) error {
+	if err == nil {
+		return nil
+	}
+	var se *StatusError
+	if errors.As(err, &se) {
+		return se
+	}
+	return &StatusError{Code: StatusCode(err), Message: err.Error()}
+}
+
+// Metadata 模拟 grpc/metadata，key 统一为小写
+type Metadata map[string][]string
+
+func (md Metadata) Get(key string) string {
+	if v := md[strings.ToLower(key)]; len(v) > 0 {
+		return v[0]
+	}
+	return ""
+}
+
+type incomingMetadataKey struct{}
+type outgoingMetadataKey struct{}
+
+func NewIncomingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, incomingMetadataKey{}, md)
+}
+
+func MetadataFromIncomingContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(incomingMetadataKey{}).(Metadata)
+	return md, ok
+}
+
+func NewOutgoingContext(ctx context.Context, md Metadata) context.Context {
+	return context.WithValue(ctx, outgoingMetadataKey{}, md)
+}
+
+func MetadataFromOutgoingContext(ctx context.Context) (Metadata, bool) {
+	md, ok := ctx.Value(outgoingMetadataKey{}).(Metadata)
+	return md, ok
+}
+
+const RouterService_TransferOnlineReliableMessage_FullMethodName = routerpb.RouterService_TransferOnlineReliableMessage_FullMethodName
+
+// RouterServiceServer 对应 router.proto 中的 RouterService
+type RouterServiceServer interface {
+	TransferOnlineReliableMessage(ctx context.Context, in *TransferMessageRequest) (*TransferPushMessageReply, error)
+}
+
+// RouterServiceClient 对应 router.proto 中的 RouterService 客户端
+type RouterServiceClient interface {
+	TransferOnlineReliableMessage(ctx context.Context, in *TransferMessageRequest) (*TransferPushMessageReply, error)
+}
+
+var _ RouterServiceServer = (*RouterServer)(nil)
+
+// ============== 拦截器（注册到 grpc.Server，见 NewGRPCServer） ==============
+
+// LoggingInterceptor 记录每次调用的方法、耗时和错误
+func LoggingInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		start := time.Now()
+		rpl, err := handler(ctx, req)
+		if err != nil {
+			Applog.Errorf("grpc call %v failed, cost: %v, err: %v", info.FullMethod, time.Since(start), err)
+		} else {
+			Applog.Debugf("grpc call %v, cost: %v", info.FullMethod, time.Since(start))
+		}
+		return rpl, err
+	}
+}
+
+// MetricsInterceptor 按方法和状态码计数
+func MetricsInterceptor() grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		rpl, err := handler(ctx, req)
+		Metrics.Counter(fmt.Sprintf("router_grpc_calls{method=%q,code=%q}", info.FullMethod, status.Code(err)), 1)
+		return rpl, err
+	}
+}
+
+// AuthFunc 校验调用方身份，可以返回携带调用方信息的新 ctx，ctx 中的 grpc metadata 已转换成 Metadata
+type AuthFunc func(ctx context.Context, fullMethod string) (context.Context, error)
+
+// AuthInterceptor 在调用业务逻辑之前执行 authFunc，StatusError 按原状态码返回，其它错误返回 Unauthenticated
+func AuthInterceptor(authFunc AuthFunc) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		newCtx, err := authFunc(incomingMetadataContext(ctx), info.FullMethod)
+		if err != nil {
+			var se *StatusError
+			if errors.As(err, &se) {
+				return nil, toGRPCStatus(se)
+			}
+			if _, ok := status.FromError(err); ok {
+				return nil, err
+			}
+			return nil, status.Errorf(codes.Unauthenticated, "%v", err)
+		}
+		return handler(newCtx, req)
+	}
+}
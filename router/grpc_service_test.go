@@ -0,0 +1,189 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/gangcheng1030/ai_testing_and_refactoring/router/routerpb"
+)
+
+// newBufconnClient 在 bufconn 上启动 gs，返回客户端连接
+func newBufconnClient(t *testing.T, gs *grpc.Server) *grpc.ClientConn {
+	t.Helper()
+	lis := bufconn.Listen(1 << 20)
+	go gs.Serve(lis)
+	t.Cleanup(gs.Stop)
+
+	cc, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(ctx context.Context, _ string) (net.Conn, error) { return lis.DialContext(ctx) }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()))
+	assert.NoError(t, err)
+	t.Cleanup(func() { cc.Close() })
+	return cc
+}
+
+func TestGRPCServiceOverBufconn(t *testing.T) {
+	errDenied := errors.New("denied")
+	testCases := []struct {
+		name     string
+		modify   func(in *TransferMessageRequest)
+		auth     AuthFunc
+		wantCode Code
+	}{
+		{name: "delivered", wantCode: CodeOK},
+		{name: "invalid-request", modify: func(in *TransferMessageRequest) { in.ReceiverId = "" }, wantCode: CodeInvalidArgument},
+		{
+			name: "metadata-reaches-auth",
+			auth: func(ctx context.Context, fullMethod string) (context.Context, error) {
+				md, _ := MetadataFromIncomingContext(ctx)
+				if md.Get(APIKeyHeader) != "secret" || fullMethod != RouterService_TransferOnlineReliableMessage_FullMethodName {
+					return nil, errDenied
+				}
+				return ctx, nil
+			},
+			wantCode: CodeOK,
+		},
+		{
+			name:     "auth-rejected",
+			auth:     func(ctx context.Context, fullMethod string) (context.Context, error) { return nil, errDenied },
+			wantCode: CodeUnauthenticated,
+		},
+		{
+			name: "status-error-kept",
+			auth: func(ctx context.Context, fullMethod string) (context.Context, error) {
+				return nil, NewStatusError(CodePermissionDenied, "app not allowed")
+			},
+			wantCode: CodePermissionDenied,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			var interceptors []grpc.UnaryServerInterceptor
+			if tc.auth != nil {
+				interceptors = append(interceptors, AuthInterceptor(tc.auth))
+			}
+			client := NewGRPCRouterClient(newBufconnClient(t, NewGRPCServer(ts.RouterServer, interceptors...)))
+
+			in := newTestRequest()
+			in.MsgData = &Any{TypeUrl: "type.googleapis.com/chat", Value: []byte(`{"text":"hi"}`)}
+			if tc.modify != nil {
+				tc.modify(in)
+			}
+			ctx := NewOutgoingContext(context.Background(), Metadata{APIKeyHeader: {"secret"}})
+			rpl, err := client.TransferOnlineReliableMessage(ctx, in)
+			assert.Equal(t, tc.wantCode, StatusCode(err))
+			if tc.wantCode != CodeOK {
+				assert.Nil(t, rpl)
+				return
+			}
+			assert.True(t, rpl.IsUserOnline)
+			assert.Equal(t, []*DeviceIdentifier{{Identifer: "d1", IsOnline: true}}, rpl.DeviceIdentifiers)
+			ts.observer.next(t, EventMsgDelivered)
+			if reqs := conn.requests(); assert.Len(t, reqs, 1) {
+				assert.Equal(t, in.MsgData, reqs[0].MsgData)
+				assert.Equal(t, "title", reqs[0].Push.Title.Value)
+			}
+		})
+	}
+}
+
+func TestGRPCServiceUnknownMethod(t *testing.T) {
+	ts := newTestServer(t)
+	cc := newBufconnClient(t, NewGRPCServer(ts.RouterServer))
+
+	err := cc.Invoke(context.Background(), "/proto_router.RouterService/Unknown", &routerpb.TransferMessageRequest{}, &routerpb.TransferPushMessageReply{})
+	assert.Equal(t, codes.Unimplemented, status.Code(err))
+}
+
+func TestGRPCServerInterceptors(t *testing.T) {
+	okMetric := fmt.Sprintf("router_grpc_calls{method=%q,code=%q}", RouterService_TransferOnlineReliableMessage_FullMethodName, codes.OK)
+	deniedMetric := fmt.Sprintf("router_grpc_calls{method=%q,code=%q}", RouterService_TransferOnlineReliableMessage_FullMethodName, codes.Unauthenticated)
+	ok, denied := Metrics.Value(okMetric), Metrics.Value(deniedMetric)
+	ts := newTestServer(t, iosDevice("d1", &fakeConnector{}))
+	// metrics 在最外层，鉴权失败的调用也会被计数
+	auth := AuthInterceptor(func(ctx context.Context, fullMethod string) (context.Context, error) {
+		md, _ := MetadataFromIncomingContext(ctx)
+		if md.Get(APIKeyHeader) != "secret" {
+			return nil, errors.New("denied")
+		}
+		return ctx, nil
+	})
+	client := NewGRPCRouterClient(newBufconnClient(t, NewGRPCServer(ts.RouterServer, MetricsInterceptor(), LoggingInterceptor(), auth)))
+
+	_, err := client.TransferOnlineReliableMessage(NewOutgoingContext(context.Background(), Metadata{APIKeyHeader: {"secret"}}), newTestRequest())
+	assert.NoError(t, err)
+	ts.observer.next(t, EventMsgDelivered)
+	_, err = client.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+	assert.Equal(t, CodeUnauthenticated, StatusCode(err))
+
+	assert.Equal(t, ok+1, Metrics.Value(okMetric))
+	assert.Equal(t, denied+1, Metrics.Value(deniedMetric))
+}
+
+func TestRequestPBConversion(t *testing.T) {
+	in := &TransferMessageRequest{
+		ReceiverId:  "42",
+		MsgId:       "m1",
+		MsgType:     1,
+		MsgData:     &Any{TypeUrl: "t", Value: []byte("v")},
+		Push:        &PushContent{Title: &I18N{Value: "k", Locales: map[string]string{"en": "hi"}, Params: []string{"a"}, IsCatalogKey: true}, Message: "m", CreateTime: 1, Silent: true},
+		MsgTypeName: "chat",
+		AppName:     "im",
+		DeviceIdPushes: []*DeviceIdPush{
+			{DeviceIds: []string{"d1"}, Push: &PushContent{Ticker: &I18N{Value: "t"}}},
+		},
+		DeviceIdPushesOnly: true,
+		DeviceIdentifer:    "d1",
+		Filters:            map[string]string{"source": "ios"},
+		LimitVersion:       &LimitVersion{MinAndroidVersion: "1", MaxAndroidVersion: "2", MinIosVersion: "3", MaxIosVersion: "4", MinUIVersion: "5"},
+		ForceLangs:         []string{"en"},
+		ExpireAt:           10,
+		TTLSeconds:         20,
+		Priority:           MSG_PRIORITY_BULK,
+		CollapseKey:        "c",
+		DeliverAt:          30,
+	}
+	assert.Equal(t, in, requestFromPB(requestToPB(in)))
+	assert.Nil(t, requestFromPB(nil))
+
+	rpl := &TransferPushMessageReply{IsUserOnline: true, DeviceIdentifiers: []*DeviceIdentifier{{Identifer: "d1", IsOnline: true}}}
+	assert.Equal(t, rpl, replyFromPB(replyToPB(rpl)))
+	assert.Equal(t, &TransferPushMessageReply{}, replyFromPB(replyToPB(nil)))
+}
+
+// ctxErrConnector 等到 release 关闭后才投递，把投递时 ctx 的状态写入 errs
+type ctxErrConnector struct {
+	release chan struct{}
+	errs    chan error
+}
+
+func (c *ctxErrConnector) TransmitMessage(ctx context.Context, req *TransmitMessageRequest) error {
+	<-c.release
+	c.errs <- ctx.Err()
+	return ctx.Err()
+}
+
+func TestGRPCServiceAsyncDeliveryOutlivesRPC(t *testing.T) {
+	conn := &ctxErrConnector{release: make(chan struct{}), errs: make(chan error, 1)}
+	ts := newTestServer(t, iosDevice("d1", conn))
+	client := NewGRPCRouterClient(newBufconnClient(t, NewGRPCServer(ts.RouterServer)))
+
+	_, err := client.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+	assert.NoError(t, err)
+	// RPC 已经返回，服务端的 ctx 已被 gRPC 取消
+	close(conn.release)
+	assert.NoError(t, <-conn.errs)
+	ts.observer.next(t, EventMsgDelivered)
+}
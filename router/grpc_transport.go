@@ -0,0 +1,253 @@
+package router
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+	protobuf "google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/anypb"
+
+	"github.com/gangcheng1030/ai_testing_and_refactoring/router/routerpb"
+)
+
+// ============== RouterService gRPC 传输层（routerpb 由 proto/router.proto 生成） ==============
+
+// grpcRouterService 把 routerpb 的请求转换成 router 的类型交给 RouterServiceServer，拦截器由 grpc.Server 执行
+type grpcRouterService struct {
+	routerpb.UnimplementedRouterServiceServer
+	impl RouterServiceServer
+}
+
+// RegisterRouterService 把 RouterServiceServer 注册到 grpc.Server，未注册的方法由 gRPC 返回 Unimplemented
+func RegisterRouterService(s grpc.ServiceRegistrar, impl RouterServiceServer) {
+	routerpb.RegisterRouterServiceServer(s, &grpcRouterService{impl: impl})
+}
+
+// NewGRPCServer 创建注册了 RouterService 的 grpc.Server，拦截器按顺序串联，第一个拦截器在最外层；
+// 需要 TLS 等其它 ServerOption 时自行创建 grpc.Server 后调用 RegisterRouterService
+func NewGRPCServer(impl RouterServiceServer, interceptors ...grpc.UnaryServerInterceptor) *grpc.Server {
+	s := grpc.NewServer(grpc.ChainUnaryInterceptor(interceptors...))
+	RegisterRouterService(s, impl)
+	return s
+}
+
+func (s *grpcRouterService) TransferOnlineReliableMessage(ctx context.Context, in *routerpb.TransferMessageRequest) (*routerpb.TransferPushMessageReply, error) {
+	ctx = incomingMetadataContext(ctx)
+	if md, _ := MetadataFromIncomingContext(ctx); len(md.Get(CallerIDHeader)) > 0 {
+		// 签名覆盖收到的 proto，requestFromPB 会丢失空 map 和 nil 等差异
+		body, err := marshalRequestPB(in)
+		if err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "encode request for signature: %v", err)
+		}
+		ctx = withSignedBody(ctx, body)
+	}
+	// RPC 返回后 gRPC 会取消 ctx，异步投递和重试不能跟着取消，与 HTTP 网关一致
+	rpl, err := s.impl.TransferOnlineReliableMessage(context.WithoutCancel(ctx), requestFromPB(in))
+	if err != nil {
+		return nil, toGRPCStatus(err)
+	}
+	return replyToPB(rpl), nil
+}
+
+// incomingMetadataContext 把 grpc 的 incoming metadata 转换成 Metadata 供鉴权和租户识别使用，
+// grpc 的 metadata key 已经是小写；拦截器已经转换过时直接返回
+func incomingMetadataContext(ctx context.Context) context.Context {
+	if _, ok := MetadataFromIncomingContext(ctx); ok {
+		return ctx
+	}
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		return NewIncomingContext(ctx, Metadata(md))
+	}
+	return ctx
+}
+
+// marshalRequestPB 签名使用的规范编码，deterministic 编码下 map 按 key 排序，空 map 和空列表与未设置一致
+func marshalRequestPB(in *routerpb.TransferMessageRequest) ([]byte, error) {
+	return protobuf.MarshalOptions{Deterministic: true}.Marshal(in)
+}
+
+// toGRPCStatus 把 StatusError 转换成 grpc status，Code 的取值与 grpc/codes 一致
+func toGRPCStatus(err error) error {
+	se := toStatusError(err).(*StatusError)
+	return status.Error(codes.Code(se.Code), se.Message)
+}
+
+// fromGRPCStatus 把 grpc status 还原成 StatusError，调用方可以继续使用 StatusCode 判断错误类型
+func fromGRPCStatus(err error) error {
+	st, ok := status.FromError(err)
+	if !ok {
+		return err
+	}
+	return &StatusError{Code: Code(st.Code()), Message: st.Message()}
+}
+
+// grpcRouterClient 通过 grpc.ClientConn 调用 RouterService，outgoing Metadata 转换成 grpc metadata
+type grpcRouterClient struct {
+	client routerpb.RouterServiceClient
+}
+
+func NewGRPCRouterClient(cc grpc.ClientConnInterface) RouterServiceClient {
+	return &grpcRouterClient{client: routerpb.NewRouterServiceClient(cc)}
+}
+
+func (c *grpcRouterClient) TransferOnlineReliableMessage(ctx context.Context, in *TransferMessageRequest) (*TransferPushMessageReply, error) {
+	if md, ok := MetadataFromOutgoingContext(ctx); ok {
+		ctx = metadata.NewOutgoingContext(ctx, metadata.MD(md))
+	}
+	rpl, err := c.client.TransferOnlineReliableMessage(ctx, requestToPB(in))
+	if err != nil {
+		return nil, fromGRPCStatus(err)
+	}
+	return replyFromPB(rpl), nil
+}
+
+// ============== routerpb 与 router 类型之间的转换 ==============
+
+func requestFromPB(in *routerpb.TransferMessageRequest) *TransferMessageRequest {
+	if in == nil {
+		return nil
+	}
+	out := &TransferMessageRequest{
+		ReceiverId:         in.GetReceiverId(),
+		MsgId:              in.GetMsgId(),
+		MsgType:            in.GetMsgType(),
+		Push:               pushFromPB(in.GetPush()),
+		DeviceIdPushesOnly: in.GetDeviceIdPushesOnly(),
+		MsgTypeName:        in.GetMsgTypeName(),
+		AppName:            in.GetAppName(),
+		DeviceIdentifer:    in.GetDeviceIdentifer(),
+		Filters:            in.GetFilters(),
+		ForceLangs:         in.GetForceLangs(),
+		ExpireAt:           in.GetExpireAt(),
+		TTLSeconds:         in.GetTtlSeconds(),
+		Priority:           MsgPriority(in.GetPriority()),
+		CollapseKey:        in.GetCollapseKey(),
+		DeliverAt:          in.GetDeliverAt(),
+	}
+	if d := in.GetMsgData(); d != nil {
+		out.MsgData = &Any{TypeUrl: d.GetTypeUrl(), Value: d.GetValue()}
+	}
+	for _, p := range in.GetDeviceIdPushes() {
+		out.DeviceIdPushes = append(out.DeviceIdPushes, &DeviceIdPush{DeviceIds: p.GetDeviceIds(), Push: pushFromPB(p.GetPush())})
+	}
+	if lv := in.GetLimitVersion(); lv != nil {
+		out.LimitVersion = &LimitVersion{
+			MinAndroidVersion: lv.GetMinAndroidVersion(),
+			MaxAndroidVersion: lv.GetMaxAndroidVersion(),
+			MinIosVersion:     lv.GetMinIosVersion(),
+			MaxIosVersion:     lv.GetMaxIosVersion(),
+			MinUIVersion:      lv.GetMinUiVersion(),
+		}
+	}
+	return out
+}
+
+func requestToPB(in *TransferMessageRequest) *routerpb.TransferMessageRequest {
+	if in == nil {
+		return nil
+	}
+	out := &routerpb.TransferMessageRequest{
+		ReceiverId:         in.ReceiverId,
+		MsgId:              in.MsgId,
+		MsgType:            in.MsgType,
+		Push:               pushToPB(in.Push),
+		DeviceIdPushesOnly: in.DeviceIdPushesOnly,
+		MsgTypeName:        in.MsgTypeName,
+		AppName:            in.AppName,
+		DeviceIdentifer:    in.DeviceIdentifer,
+		Filters:            in.Filters,
+		ForceLangs:         in.ForceLangs,
+		ExpireAt:           in.ExpireAt,
+		TtlSeconds:         in.TTLSeconds,
+		Priority:           routerpb.MsgPriority(in.Priority),
+		CollapseKey:        in.CollapseKey,
+		DeliverAt:          in.DeliverAt,
+	}
+	if in.MsgData != nil {
+		out.MsgData = &anypb.Any{TypeUrl: in.MsgData.TypeUrl, Value: in.MsgData.Value}
+	}
+	for _, p := range in.DeviceIdPushes {
+		if p == nil {
+			continue
+		}
+		out.DeviceIdPushes = append(out.DeviceIdPushes, &routerpb.DeviceIdPush{DeviceIds: p.DeviceIds, Push: pushToPB(p.Push)})
+	}
+	if lv := in.LimitVersion; lv != nil {
+		out.LimitVersion = &routerpb.LimitVersion{
+			MinAndroidVersion: lv.MinAndroidVersion,
+			MaxAndroidVersion: lv.MaxAndroidVersion,
+			MinIosVersion:     lv.MinIosVersion,
+			MaxIosVersion:     lv.MaxIosVersion,
+			MinUiVersion:      lv.MinUIVersion,
+		}
+	}
+	return out
+}
+
+func pushFromPB(p *routerpb.PushContent) *PushContent {
+	if p == nil {
+		return nil
+	}
+	return &PushContent{
+		Title:      i18nFromPB(p.GetTitle()),
+		Value:      i18nFromPB(p.GetValue()),
+		Ticker:     i18nFromPB(p.GetTicker()),
+		Message:    p.GetMessage(),
+		CreateTime: p.GetCreateTime(),
+		Silent:     p.GetSilent(),
+	}
+}
+
+func pushToPB(p *PushContent) *routerpb.PushContent {
+	if p == nil {
+		return nil
+	}
+	return &routerpb.PushContent{
+		Title:      i18nToPB(p.Title),
+		Value:      i18nToPB(p.Value),
+		Ticker:     i18nToPB(p.Ticker),
+		Message:    p.Message,
+		CreateTime: p.CreateTime,
+		Silent:     p.Silent,
+	}
+}
+
+func i18nFromPB(i *routerpb.I18N) *I18N {
+	if i == nil {
+		return nil
+	}
+	return &I18N{Value: i.GetValue(), Locales: i.GetLocales(), Params: i.GetParams(), IsCatalogKey: i.GetIsCatalogKey()}
+}
+
+func i18nToPB(i *I18N) *routerpb.I18N {
+	if i == nil {
+		return nil
+	}
+	return &routerpb.I18N{Value: i.Value, Locales: i.Locales, Params: i.Params, IsCatalogKey: i.IsCatalogKey}
+}
+
+func replyToPB(rpl *TransferPushMessageReply) *routerpb.TransferPushMessageReply {
+	out := &routerpb.TransferPushMessageReply{}
+	if rpl == nil {
+		return out
+	}
+	out.IsUserOnline = rpl.IsUserOnline
+	for _, d := range rpl.DeviceIdentifiers {
+		if d == nil {
+			continue
+		}
+		out.DeviceIdentifiers = append(out.DeviceIdentifiers, &routerpb.DeviceIdentifier{Identifer: d.Identifer, IsOnline: d.IsOnline})
+	}
+	return out
+}
+
+func replyFromPB(rpl *routerpb.TransferPushMessageReply) *TransferPushMessageReply {
+	out := &TransferPushMessageReply{IsUserOnline: rpl.GetIsUserOnline()}
+	for _, d := range rpl.GetDeviceIdentifiers() {
+		out.DeviceIdentifiers = append(out.DeviceIdentifiers, &DeviceIdentifier{Identifer: d.GetIdentifer(), IsOnline: d.GetIsOnline()})
+	}
+	return out
+}
@@ -0,0 +1,302 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	HTTPTransferPath      = "/v1/messages/transfer"
+	HTTPTransferBatchPath = "/v1/messages/transfer/batch"
+
+	maxHTTPBodyBytes    = 1 << 20
+	MaxHTTPBatchSize    = 100
+	httpContentTypeJSON = "application/json"
+)
+
+// HTTPErrorBody 错误响应
+type HTTPErrorBody struct {
+	Code    string
+	Message string
+}
+
+// HTTPBatchTransferRequest 批量发送请求，请求头中的 API key 对所有消息生效；
+// 签名只覆盖单条消息，请求头中的签名不用于批量请求，需要在 Signatures 中逐条签名
+type HTTPBatchTransferRequest struct {
+	Messages []*TransferMessageRequest
+	// Signatures 可选，不为空时与 Messages 一一对应，内容为 SignTransferBody 对单条消息 JSON 的签名信息
+	Signatures []Metadata `json:",omitempty"`
+}
+
+// httpBatchTransferBody 解码批量请求时保留每条消息的原始 JSON，用于校验逐条签名
+type httpBatchTransferBody struct {
+	Messages   []json.RawMessage
+	Signatures []Metadata
+}
+
+// HTTPBatchTransferResult 批量发送中单条消息的结果
+type HTTPBatchTransferResult struct {
+	MsgId  string
+	Status int
+	Reply  *TransferPushMessageReply `json:",omitempty"`
+	Error  *HTTPErrorBody            `json:",omitempty"`
+}
+
+type HTTPBatchTransferReply struct {
+	Results []*HTTPBatchTransferResult
+}
+
+// HTTPGateway 给不能使用 gRPC 的调用方提供 HTTP/JSON 接口，
+// JSON 格式与 proto.Marshal 的序列化格式一致，请求头作为 incoming metadata 传给服务端
+type HTTPGateway struct {
+	server RouterServiceServer
+	mux    *http.ServeMux
+}
+
+func NewHTTPGateway(server RouterServiceServer) *HTTPGateway {
+	g := &HTTPGateway{
+		server: server,
+		mux:    http.NewServeMux(),
+	}
+	g.mux.HandleFunc(HTTPTransferPath, g.handleTransfer)
+	g.mux.HandleFunc(HTTPTransferBatchPath, g.handleBatchTransfer)
+	return g
+}
+
+func (g *HTTPGateway) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	g.mux.ServeHTTP(w, r)
+}
+
+func (g *HTTPGateway) handleTransfer(w http.ResponseWriter, r *http.Request) {
+	if !checkJSONPost(w, r) {
+		return
+	}
+	body, err := readHTTPBody(r)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	in := &TransferMessageRequest{}
+	if err := decodeJSON(body, in); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if err := validateTransferRequest(in); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	rpl, err := g.server.TransferOnlineReliableMessage(withSignedBody(httpIncomingContext(r), body), in)
+	if err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	if rpl == nil {
+		rpl = &TransferPushMessageReply{}
+	}
+	writeJSON(w, http.StatusOK, rpl)
+}
+
+func (g *HTTPGateway) handleBatchTransfer(w http.ResponseWriter, r *http.Request) {
+	if !checkJSONPost(w, r) {
+		return
+	}
+	batch := &httpBatchTransferBody{}
+	if err := decodeJSONBody(r, batch); err != nil {
+		writeHTTPError(w, err)
+		return
+	}
+	msgs := make([]*TransferMessageRequest, len(batch.Messages))
+	for i, raw := range batch.Messages {
+		msgs[i] = &TransferMessageRequest{}
+		if err := decodeJSON(raw, msgs[i]); err != nil {
+			writeHTTPError(w, err)
+			return
+		}
+	}
+	if len(batch.Messages) == 0 || len(batch.Messages) > MaxHTTPBatchSize {
+		writeHTTPError(w, &InvalidRequestError{Reason: fmt.Sprintf("batch size must be between 1 and %d", MaxHTTPBatchSize)})
+		return
+	}
+	if len(batch.Signatures) > 0 && len(batch.Signatures) != len(batch.Messages) {
+		writeHTTPError(w, &InvalidRequestError{Reason: "signatures must match messages one to one"})
+		return
+	}
+	reply := &HTTPBatchTransferReply{Results: make([]*HTTPBatchTransferResult, 0, len(batch.Messages))}
+	for i, in := range msgs {
+		var sig Metadata
+		if len(batch.Signatures) > 0 {
+			sig = batch.Signatures[i]
+		}
+		// 签名只覆盖该消息在请求体中的原始 JSON
+		ctx := withSignedBody(httpBatchIncomingContext(r, sig), batch.Messages[i])
+		result := &HTTPBatchTransferResult{Status: http.StatusOK}
+		result.MsgId = in.GetMsgId()
+		err := validateTransferRequest(in)
+		if err == nil {
+			result.Reply, err = g.server.TransferOnlineReliableMessage(ctx, in)
+		}
+		if err != nil {
+			result.Status = httpStatusOf(err)
+			result.Error = httpErrorBody(err)
+			result.Reply = nil
+		} else if result.Reply == nil {
+			result.Reply = &TransferPushMessageReply{}
+		}
+		reply.Results = append(reply.Results, result)
+	}
+	writeJSON(w, http.StatusOK, reply)
+}
+
+// validateTransferRequest 在进入 RouterServer 之前做参数校验
+func validateTransferRequest(in *TransferMessageRequest) error {
+	switch {
+	case len(in.ReceiverId) == 0:
+		return &InvalidRequestError{Reason: "receiver id is empty"}
+	case len(in.MsgId) == 0:
+		return &InvalidRequestError{Reason: "msg id is empty"}
+	case len(in.AppName) == 0:
+		return &InvalidRequestError{Reason: "app name is empty"}
+	case !Get().AppExist(in.AppName):
+		return &InvalidRequestError{Reason: fmt.Sprintf("app %v not exist", in.AppName)}
+	case in.TTLSeconds < 0 || in.ExpireAt < 0:
+		return &InvalidRequestError{Reason: "invalid expire time"}
+	case in.Priority < MSG_PRIORITY_NORMAL || in.Priority > MSG_PRIORITY_BULK:
+		return &InvalidRequestError{Reason: fmt.Sprintf("invalid priority %d", in.Priority)}
+	}
+	if _, err := strconv.Atoi(in.ReceiverId); err != nil {
+		return &InvalidRequestError{Reason: "receiver id is not valid"}
+	}
+	return nil
+}
+
+func checkJSONPost(w http.ResponseWriter, r *http.Request) bool {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		writeJSON(w, http.StatusMethodNotAllowed, &HTTPErrorBody{Code: "MethodNotAllowed", Message: "only POST is allowed"})
+		return false
+	}
+	if ct := r.Header.Get("Content-Type"); ct != "" && !strings.HasPrefix(ct, httpContentTypeJSON) {
+		writeJSON(w, http.StatusUnsupportedMediaType, &HTTPErrorBody{Code: "UnsupportedMediaType", Message: "content type must be " + httpContentTypeJSON})
+		return false
+	}
+	return true
+}
+
+func decodeJSONBody(r *http.Request, v interface{}) error {
+	body, err := readHTTPBody(r)
+	if err != nil {
+		return err
+	}
+	return decodeJSON(body, v)
+}
+
+// httpBodyTooLargeError 请求体超过 maxHTTPBodyBytes，HTTP 状态码为 413，其它场景按 InvalidRequestError 处理
+type httpBodyTooLargeError struct {
+	limit int64
+}
+
+func (e *httpBodyTooLargeError) Error() string {
+	return fmt.Sprintf("request body exceeds %d bytes", e.limit)
+}
+
+func (e *httpBodyTooLargeError) Unwrap() error {
+	return &InvalidRequestError{Reason: e.Error()}
+}
+
+// readHTTPBody 读取完整的请求体，签名校验需要解码前的原始字节
+func readHTTPBody(r *http.Request) ([]byte, error) {
+	body, err := io.ReadAll(http.MaxBytesReader(nil, r.Body, maxHTTPBodyBytes))
+	if err != nil {
+		var mbe *http.MaxBytesError
+		if errors.As(err, &mbe) {
+			return nil, &httpBodyTooLargeError{limit: mbe.Limit}
+		}
+		return nil, &InvalidRequestError{Reason: fmt.Sprintf("read body: %v", err)}
+	}
+	return body, nil
+}
+
+func decodeJSON(data []byte, v interface{}) error {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return &InvalidRequestError{Reason: fmt.Sprintf("invalid json body: %v", err)}
+	}
+	return nil
+}
+
+// httpIncomingContext 把请求头转换成 incoming metadata，gRPC 和 HTTP 共用同一套鉴权逻辑；
+// 消息在请求返回后仍会异步下发，所以不继承请求 ctx 的取消信号
+func httpIncomingContext(r *http.Request) context.Context {
+	md := make(Metadata, len(r.Header))
+	for k, v := range r.Header {
+		md[strings.ToLower(k)] = v
+	}
+	return NewIncomingContext(context.WithoutCancel(r.Context()), md)
+}
+
+// callerSignatureHeaders 签名相关的 metadata，批量请求中只能逐条设置
+var callerSignatureHeaders = []string{CallerIDHeader, CallerTimestampHeader, CallerNonceHeader, CallerSignatureHeader}
+
+// httpBatchIncomingContext 批量请求中单条消息的 incoming metadata，
+// 去掉请求头中的签名，换成该消息自己的签名
+func httpBatchIncomingContext(r *http.Request, sig Metadata) context.Context {
+	md := make(Metadata, len(r.Header)+len(callerSignatureHeaders))
+	for k, v := range r.Header {
+		md[strings.ToLower(k)] = v
+	}
+	for _, k := range callerSignatureHeaders {
+		delete(md, k)
+		if v := sig.Get(k); len(v) > 0 {
+			md[k] = []string{v}
+		}
+	}
+	return NewIncomingContext(context.WithoutCancel(r.Context()), md)
+}
+
+// httpStatusOf 按错误类型返回 HTTP 状态码
+func httpStatusOf(err error) int {
+	var tooLarge *httpBodyTooLargeError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge
+	}
+	switch StatusCode(err) {
+	case CodeOK:
+		return http.StatusOK
+	case CodeInvalidArgument:
+		return http.StatusBadRequest
+	case CodeUnauthenticated:
+		return http.StatusUnauthorized
+	case CodePermissionDenied:
+		return http.StatusForbidden
+	case CodeResourceExhausted:
+		return http.StatusTooManyRequests
+	case CodeUnavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func httpErrorBody(err error) *HTTPErrorBody {
+	se := toStatusError(err).(*StatusError)
+	return &HTTPErrorBody{Code: se.Code.String(), Message: se.Message}
+}
+
+func writeHTTPError(w http.ResponseWriter, err error) {
+	writeJSON(w, httpStatusOf(err), httpErrorBody(err))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", httpContentTypeJSON)
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		Applog.Errorf("write http response err:%+v", err)
+	}
+}
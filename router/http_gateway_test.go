@@ -0,0 +1,195 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// gatewayDo 以 JSON POST 调用 HTTP 网关，header 中的内容放入请求头
+func gatewayDo(g *HTTPGateway, path string, header Metadata, body interface{}) *httptest.ResponseRecorder {
+	raw, _ := json.Marshal(body)
+	return gatewayDoRaw(g, path, header, raw)
+}
+
+// gatewayDoRaw 和 gatewayDo 相同，请求体原样发送
+func gatewayDoRaw(g *HTTPGateway, path string, header Metadata, raw []byte) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(raw))
+	r.Header.Set("Content-Type", httpContentTypeJSON)
+	for k, v := range header {
+		for _, vv := range v {
+			r.Header.Add(k, vv)
+		}
+	}
+	w := httptest.NewRecorder()
+	g.ServeHTTP(w, r)
+	return w
+}
+
+func TestHTTPBatchTransferAuth(t *testing.T) {
+	sign := func(t *testing.T, nonce string, in *TransferMessageRequest) Metadata {
+		// 批量请求中单条消息的 JSON 与 json.Marshal 的结果一致
+		body, err := json.Marshal(in)
+		assert.NoError(t, err)
+		return signTransferBody(testCallerSecret, "signer", testNowMs(), nonce, body)
+	}
+	testCases := []struct {
+		name       string
+		build      func(t *testing.T, msgs []*TransferMessageRequest) (Metadata, *HTTPBatchTransferRequest)
+		wantStatus int
+		want       []int
+	}{
+		{name: "api-key-header-for-all", wantStatus: http.StatusOK, want: []int{http.StatusOK, http.StatusOK},
+			build: func(t *testing.T, msgs []*TransferMessageRequest) (Metadata, *HTTPBatchTransferRequest) {
+				return Metadata{APIKeyHeader: {"caller-key"}}, &HTTPBatchTransferRequest{Messages: msgs}
+			}},
+		{name: "signed-per-message", wantStatus: http.StatusOK, want: []int{http.StatusOK, http.StatusOK},
+			build: func(t *testing.T, msgs []*TransferMessageRequest) (Metadata, *HTTPBatchTransferRequest) {
+				return nil, &HTTPBatchTransferRequest{Messages: msgs, Signatures: []Metadata{sign(t, "n1", msgs[0]), sign(t, "n2", msgs[1])}}
+			}},
+		{name: "one-signature-invalid", wantStatus: http.StatusOK, want: []int{http.StatusOK, http.StatusUnauthorized},
+			build: func(t *testing.T, msgs []*TransferMessageRequest) (Metadata, *HTTPBatchTransferRequest) {
+				return nil, &HTTPBatchTransferRequest{Messages: msgs, Signatures: []Metadata{sign(t, "n1", msgs[0]), sign(t, "n2", msgs[0])}}
+			}},
+		{name: "header-signature-not-used-for-batch", wantStatus: http.StatusOK, want: []int{http.StatusUnauthorized, http.StatusUnauthorized},
+			build: func(t *testing.T, msgs []*TransferMessageRequest) (Metadata, *HTTPBatchTransferRequest) {
+				return sign(t, "n1", msgs[0]), &HTTPBatchTransferRequest{Messages: msgs}
+			}},
+		{name: "signatures-count-mismatch", wantStatus: http.StatusBadRequest,
+			build: func(t *testing.T, msgs []*TransferMessageRequest) (Metadata, *HTTPBatchTransferRequest) {
+				return nil, &HTTPBatchTransferRequest{Messages: msgs, Signatures: []Metadata{sign(t, "n1", msgs[0])}}
+			}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t, iosDevice("d1", &fakeConnector{}))
+			ts.Auth = newTestCallerAuth(t)
+			var msgs []*TransferMessageRequest
+			for _, id := range []string{"m1", "m2"} {
+				in := newTestRequest()
+				in.MsgId = id
+				msgs = append(msgs, in)
+			}
+			header, batch := tc.build(t, msgs)
+
+			w := gatewayDo(NewHTTPGateway(ts.RouterServer), HTTPTransferBatchPath, header, batch)
+			assert.Equal(t, tc.wantStatus, w.Code)
+			if tc.wantStatus != http.StatusOK {
+				return
+			}
+			var rpl HTTPBatchTransferReply
+			assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rpl))
+			var got []int
+			for _, r := range rpl.Results {
+				got = append(got, r.Status)
+			}
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestHTTPTransfer(t *testing.T) {
+	valid := func(t *testing.T) []byte {
+		raw, err := json.Marshal(newTestRequest())
+		assert.NoError(t, err)
+		return raw
+	}
+	testCases := []struct {
+		name       string
+		body       func(t *testing.T) []byte
+		header     func(t *testing.T, body []byte) Metadata
+		setup      func(t *testing.T, ts *testServer)
+		wantStatus int
+		wantCode   string
+		wantSent   int
+	}{
+		{name: "ok", body: valid, wantStatus: http.StatusOK, wantSent: 1},
+		{name: "invalid-json", wantStatus: http.StatusBadRequest, wantCode: CodeInvalidArgument.String(),
+			body: func(t *testing.T) []byte { return []byte("{") }},
+		{name: "unknown-field", wantStatus: http.StatusBadRequest, wantCode: CodeInvalidArgument.String(),
+			body: func(t *testing.T) []byte { return []byte(`{"Unknown":1}`) }},
+		{name: "missing-receiver", wantStatus: http.StatusBadRequest, wantCode: CodeInvalidArgument.String(),
+			body: func(t *testing.T) []byte { return []byte(`{"MsgId":"m1","AppName":"im"}`) }},
+		{name: "body-too-large", wantStatus: http.StatusRequestEntityTooLarge, wantCode: CodeInvalidArgument.String(),
+			body: func(t *testing.T) []byte { return bytes.Repeat([]byte(" "), maxHTTPBodyBytes+1) }},
+		{name: "unauthenticated", body: valid, wantStatus: http.StatusUnauthorized, wantCode: CodeUnauthenticated.String(),
+			setup: func(t *testing.T, ts *testServer) { ts.Auth = newTestCallerAuth(t) }},
+		{name: "signed-body", body: valid, wantStatus: http.StatusOK, wantSent: 1,
+			header: func(t *testing.T, body []byte) Metadata {
+				return signTransferBody(testCallerSecret, "signer", testNowMs(), "n1", body)
+			},
+			setup: func(t *testing.T, ts *testServer) { ts.Auth = newTestCallerAuth(t) }},
+		{name: "rate-limited", body: valid, wantStatus: http.StatusTooManyRequests, wantCode: CodeResourceExhausted.String(),
+			setup: func(t *testing.T, ts *testServer) {
+				ts.RateLimiter = NewRateLimiter(RateLimitConfig{PerReceiver: RateLimitRule{Rate: 1, Burst: 1}}, &DefaultRouterRedisClient{})
+				ts.RateLimiter.now = func() time.Time { return testNow }
+				assert.NoError(t, ts.RateLimiter.Allow(context.Background(), "im", "42"))
+			}},
+		{name: "nonce-store-unavailable", body: valid, wantStatus: http.StatusServiceUnavailable, wantCode: CodeUnavailable.String(),
+			header: func(t *testing.T, body []byte) Metadata {
+				return signTransferBody(testCallerSecret, "signer", testNowMs(), "n1", body)
+			},
+			setup: func(t *testing.T, ts *testServer) {
+				ts.Auth = newTestCallerAuth(t)
+				ts.Auth.Store = NewRedisStore(errRedisCommander{})
+			}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			if tc.setup != nil {
+				tc.setup(t, ts)
+			}
+			body := tc.body(t)
+			var header Metadata
+			if tc.header != nil {
+				header = tc.header(t, body)
+			}
+
+			w := gatewayDoRaw(NewHTTPGateway(ts.RouterServer), HTTPTransferPath, header, body)
+			assert.Equal(t, tc.wantStatus, w.Code, w.Body.String())
+			if tc.wantStatus == http.StatusOK {
+				var rpl TransferPushMessageReply
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &rpl))
+				assert.True(t, rpl.IsUserOnline)
+				ts.observer.next(t, EventMsgDelivered)
+			} else {
+				var eb HTTPErrorBody
+				assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &eb))
+				assert.Equal(t, tc.wantCode, eb.Code)
+			}
+			assert.Len(t, conn.requests(), tc.wantSent)
+		})
+	}
+}
+
+func TestHTTPStatusOf(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		want int
+	}{
+		{name: "ok", want: http.StatusOK},
+		{name: "invalid-request", err: &InvalidRequestError{Reason: "bad"}, want: http.StatusBadRequest},
+		{name: "body-too-large", err: &httpBodyTooLargeError{limit: 1}, want: http.StatusRequestEntityTooLarge},
+		{name: "unauthenticated", err: NewStatusError(CodeUnauthenticated, "no"), want: http.StatusUnauthorized},
+		{name: "permission-denied", err: NewStatusError(CodePermissionDenied, "no"), want: http.StatusForbidden},
+		{name: "rate-limited", err: &RateLimitedError{Scope: RateLimitScopeApp, Key: "im"}, want: http.StatusTooManyRequests},
+		{name: "queue-full", err: DeliveryQueueFullErr, want: http.StatusServiceUnavailable},
+		{name: "scheduler-stopped", err: DeliverySchedulerStoppedErr, want: http.StatusServiceUnavailable},
+		{name: "internal", err: errors.New("boom"), want: http.StatusInternalServerError},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, httpStatusOf(tc.err))
+		})
+	}
+}
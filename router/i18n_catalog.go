@@ -0,0 +1,222 @@
+package router
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// I18NCatalog 翻译目录，根据 key 和 locale 查找本地化模板
+type I18NCatalog interface {
+	Lookup(key, locale string) (string, bool)
+}
+
+// lookupCatalog 先按设备 locale 查找，找不到时回退到 DefaultLocale
+func lookupCatalog(catalog I18NCatalog, key, locale string) (string, bool) {
+	if catalog == nil || len(key) == 0 {
+		return "", false
+	}
+	if s, ok := catalog.Lookup(key, locale); ok {
+		return s, true
+	}
+	if locale != DefaultLocale {
+		return catalog.Lookup(key, DefaultLocale)
+	}
+	return "", false
+}
+
+// FileI18NCatalog 从本地目录加载的翻译目录
+// 目录下每个文件对应一个 locale，文件名即 locale，例如 en-US.json、zh-CN.po
+// json 文件格式为 {"key": "template"}，po 文件使用 msgid/msgstr，
+// 带 msgctxt 的条目按 gettext 约定以 msgctxt + "\x04" + msgid 为 key
+type FileI18NCatalog struct {
+	dir string
+
+	mu      sync.RWMutex
+	entries map[string]map[string]string // locale -> key -> template
+}
+
+// NewFileI18NCatalog 创建并加载翻译目录
+func NewFileI18NCatalog(dir string) (*FileI18NCatalog, error) {
+	c := &FileI18NCatalog{dir: dir}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *FileI18NCatalog) Lookup(key, locale string) (string, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	s, ok := c.entries[locale][key]
+	return s, ok
+}
+
+// Reload 重新加载目录下的所有翻译文件，只有目录无法读取时返回错误；
+// 单个文件解析失败时记录日志并跳过，该 locale 保留上一次加载的数据
+func (c *FileI18NCatalog) Reload() error {
+	files, err := os.ReadDir(c.dir)
+	if err != nil {
+		return err
+	}
+	entries := make(map[string]map[string]string)
+	failed := make(map[string]bool)
+	for _, f := range files {
+		if f.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(f.Name())
+		locale := strings.TrimSuffix(f.Name(), ext)
+		path := filepath.Join(c.dir, f.Name())
+		var m map[string]string
+		switch ext {
+		case ".json":
+			m, err = loadJSONCatalog(path)
+		case ".po":
+			m, err = loadPOCatalog(path)
+		default:
+			continue
+		}
+		if err != nil {
+			Applog.Errorf("load i18n catalog %v err:%+v", path, err)
+			failed[locale] = true
+			continue
+		}
+		if entries[locale] == nil {
+			entries[locale] = make(map[string]string, len(m))
+		}
+		for k, v := range m {
+			entries[locale][k] = v
+		}
+	}
+	c.mu.Lock()
+	for locale := range failed {
+		if old, ok := c.entries[locale]; ok {
+			entries[locale] = old
+		}
+	}
+	c.entries = entries
+	c.mu.Unlock()
+	return nil
+}
+
+// StartAutoReload 按固定间隔重新加载翻译文件，ctx 取消后退出
+func (c *FileI18NCatalog) StartAutoReload(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := c.Reload(); err != nil {
+					Applog.Errorf("reload i18n catalog err:%+v", err)
+				}
+			}
+		}
+	}()
+}
+
+func loadJSONCatalog(path string) (map[string]string, error) {
+	raw, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string)
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// poContextSeparator gettext 中 msgctxt 和 msgid 之间的分隔符
+const poContextSeparator = "\x04"
+
+// loadPOCatalog 解析 gettext po 文件，支持 msgctxt、多行续接字符串，
+// 复数条目（msgid_plural）只取 msgstr[0] 作为 msgid 的翻译
+func loadPOCatalog(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	m := make(map[string]string)
+	var msgctxt, msgid, msgidPlural, msgstr, ignored string
+	var cur *string
+	inContext := false
+	flush := func() {
+		if len(msgid) > 0 && len(msgstr) > 0 {
+			key := msgid
+			if inContext {
+				key = msgctxt + poContextSeparator + msgid
+			}
+			m[key] = msgstr
+		}
+		msgctxt, msgid, msgidPlural, msgstr, ignored, cur = "", "", "", "", "", nil
+		inContext = false
+	}
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case len(line) == 0 || strings.HasPrefix(line, "#"):
+			continue
+		case strings.HasPrefix(line, "msgctxt "):
+			flush()
+			inContext = true
+			cur = &msgctxt
+			line = strings.TrimPrefix(line, "msgctxt ")
+		case strings.HasPrefix(line, "msgid_plural "):
+			cur = &msgidPlural
+			line = strings.TrimPrefix(line, "msgid_plural ")
+		case strings.HasPrefix(line, "msgid "):
+			// msgid 紧跟在 msgctxt 之后时属于同一个条目
+			if cur != &msgctxt {
+				flush()
+			}
+			cur = &msgid
+			line = strings.TrimPrefix(line, "msgid ")
+		case strings.HasPrefix(line, "msgstr[0] "):
+			cur = &msgstr
+			line = strings.TrimPrefix(line, "msgstr[0] ")
+		case strings.HasPrefix(line, "msgstr["):
+			end := strings.Index(line, "] ")
+			if end < 0 {
+				return nil, fmt.Errorf("line %d: unsupported syntax", lineNo)
+			}
+			cur = &ignored
+			line = line[end+2:]
+		case strings.HasPrefix(line, "msgstr "):
+			cur = &msgstr
+			line = strings.TrimPrefix(line, "msgstr ")
+		case strings.HasPrefix(line, `"`):
+			if cur == nil {
+				return nil, fmt.Errorf("line %d: unexpected string", lineNo)
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unsupported syntax", lineNo)
+		}
+		s, err := strconv.Unquote(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", lineNo, err)
+		}
+		*cur += s
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	flush()
+	return m, nil
+}
@@ -0,0 +1,163 @@
+package router
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCatalogFile(t *testing.T, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	return path
+}
+
+func TestLoadPOCatalog(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		want    map[string]string
+		wantErr bool
+	}{
+		{
+			name: "plain-and-multiline",
+			content: `# header
+msgid ""
+msgstr "Content-Type: text/plain; charset=UTF-8\n"
+
+msgid "greeting"
+msgstr "Hello "
+"%s"
+`,
+			want: map[string]string{"greeting": "Hello %s"},
+		},
+		{
+			name: "msgctxt-does-not-clobber-plain-msgid",
+			content: `msgid "open"
+msgstr "Open"
+
+msgctxt "menu"
+msgid "open"
+msgstr "Open…"
+`,
+			want: map[string]string{"open": "Open", "menu" + poContextSeparator + "open": "Open…"},
+		},
+		{
+			name: "plural-uses-first-form",
+			content: `msgid "one_msg"
+msgid_plural "n_msgs"
+msgstr[0] "%d message"
+msgstr[1] "%d messages"
+
+msgid "next"
+msgstr "Next"
+`,
+			want: map[string]string{"one_msg": "%d message", "next": "Next"},
+		},
+		{
+			name: "obsolete-entries-skipped",
+			content: `#~ msgid "old"
+#~ msgstr "Old"
+msgid "new"
+msgstr "New"
+`,
+			want: map[string]string{"new": "New"},
+		},
+		{
+			name: "untranslated-skipped",
+			content: `msgid "todo"
+msgstr ""
+`,
+			want: map[string]string{},
+		},
+		{
+			name:    "dangling-string",
+			content: `"orphan"`,
+			wantErr: true,
+		},
+		{
+			name:    "unknown-keyword",
+			content: `msgfoo "x"`,
+			wantErr: true,
+		},
+		{
+			name:    "bad-quote",
+			content: `msgid "unterminated`,
+			wantErr: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeCatalogFile(t, t.TempDir(), "en-US.po", tc.content)
+			got, err := loadPOCatalog(path)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
+
+func TestFileI18NCatalogReload(t *testing.T) {
+	dir := t.TempDir()
+	writeCatalogFile(t, dir, "en-US.json", `{"hello": "Hello"}`)
+	writeCatalogFile(t, dir, "zh-CN.po", "msgid \"hello\"\nmsgstr \"你好\"\n")
+	writeCatalogFile(t, dir, "fr-FR.json", `{bad json`)
+	writeCatalogFile(t, dir, "README.md", "ignored")
+
+	c, err := NewFileI18NCatalog(dir)
+	assert.NoError(t, err)
+	s, ok := c.Lookup("hello", "zh-CN")
+	assert.True(t, ok)
+	assert.Equal(t, "你好", s)
+	_, ok = c.Lookup("hello", "fr-FR")
+	assert.False(t, ok)
+
+	// 单个文件损坏时其它 locale 正常更新，损坏的 locale 保留旧数据
+	writeCatalogFile(t, dir, "en-US.json", `{"hello": "Hi"}`)
+	writeCatalogFile(t, dir, "zh-CN.po", `"broken`)
+	assert.NoError(t, c.Reload())
+	s, _ = c.Lookup("hello", "en-US")
+	assert.Equal(t, "Hi", s)
+	s, ok = c.Lookup("hello", "zh-CN")
+	assert.True(t, ok)
+	assert.Equal(t, "你好", s)
+
+	_, err = NewFileI18NCatalog(filepath.Join(dir, "missing"))
+	assert.Error(t, err)
+}
+
+func TestLookupCatalog(t *testing.T) {
+	dir := t.TempDir()
+	writeCatalogFile(t, dir, DefaultLocale+".json", `{"hello": "Hello", "bye": "Bye"}`)
+	writeCatalogFile(t, dir, "zh-CN.json", `{"hello": "你好"}`)
+	c, err := NewFileI18NCatalog(dir)
+	assert.NoError(t, err)
+
+	testCases := []struct {
+		name    string
+		catalog I18NCatalog
+		key     string
+		locale  string
+		want    string
+		wantOK  bool
+	}{
+		{name: "device-locale", catalog: c, key: "hello", locale: "zh-CN", want: "你好", wantOK: true},
+		{name: "fallback-default", catalog: c, key: "bye", locale: "zh-CN", want: "Bye", wantOK: true},
+		{name: "missing-key", catalog: c, key: "nope", locale: DefaultLocale},
+		{name: "empty-key", catalog: c, key: "", locale: "zh-CN"},
+		{name: "nil-catalog", catalog: nil, key: "hello", locale: "zh-CN"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, ok := lookupCatalog(tc.catalog, tc.key, tc.locale)
+			assert.Equal(t, tc.wantOK, ok)
+			assert.Equal(t, tc.want, got)
+		})
+	}
+}
@@ -0,0 +1,320 @@
+package router
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+/*
+	加密后的存储格式（InsertMsg 写入的 msgData）：
+		enc1:<keyID>:<base64(wrappedDEK)>:<base64(payload)>
+	每条消息随机生成一个 32 字节的数据密钥（DEK），用 DEK 加密 base64 编码的原始内容，
+	再用 app 当前的 keyring 密钥加密 DEK；wrappedDEK 和 payload 都是 nonce + AES-GCM 密文，
+	AAD 为 keyID、app、接收者和 MsgId，密文不能被挪到其它用户或者其它消息的记录中使用。
+	没有前缀的 msgData 是未加密的旧数据，按原样返回。
+	定时消息和死信使用同样的格式加密，见 sealedMsg 和 DeadLetter.SealedRequest
+*/
+
+const (
+	encryptedMsgPrefix = "enc1:"
+	msgDataKeySize     = 32
+
+	MetricMsgEncrypted     = "router_msg_encrypted"
+	MetricMsgEncryptFailed = "router_msg_encrypt_failed"
+	MetricMsgDecryptFailed = "router_msg_decrypt_failed"
+)
+
+var MsgKeyNotFoundErr = errors.New("msg encryption key not found")
+
+// MsgBinding 加密内容所属的消息，作为 AAD 的一部分，解密时必须与加密时一致
+type MsgBinding struct {
+	App        string
+	ReceiverId string
+	MsgId      string
+}
+
+func msgBindingOf(in *TransferMessageRequest) MsgBinding {
+	return MsgBinding{App: in.AppName, ReceiverId: in.ReceiverId, MsgId: in.MsgId}
+}
+
+// storedMsgBinding MsgDB 中的记录按数字形式的 userId 绑定，管理接口查询时传入的 user 可能带前导零
+func storedMsgBinding(appName string, userId int, msgId string) MsgBinding {
+	return MsgBinding{App: appName, ReceiverId: strconv.Itoa(userId), MsgId: msgId}
+}
+
+func (b MsgBinding) aad(keyID string) []byte {
+	return []byte(strings.Join([]string{keyID, b.App, b.ReceiverId, b.MsgId}, "\n"))
+}
+
+// MsgKey keyring 文件中的一个密钥，Key 为 base64 编码的 32 字节 AES-256 密钥；
+// 同一个 app 只能有一个 Active 的密钥，用于加密新消息，其余密钥只用于解密旧消息
+type MsgKey struct {
+	ID     string
+	App    string
+	Key    string
+	Active bool
+}
+
+type msgKeyringFile struct {
+	Keys []*MsgKey
+}
+
+// MsgKeyring 本地 keyring，轮换密钥时添加新密钥并标记为 Active，旧密钥保留到不再有数据使用为止
+type MsgKeyring struct {
+	mu     sync.RWMutex
+	keys   map[string]cipher.AEAD // keyID -> AEAD
+	active map[string]string      // app -> keyID
+}
+
+func NewMsgKeyring(keys []*MsgKey) (*MsgKeyring, error) {
+	k := &MsgKeyring{}
+	if err := k.Replace(keys); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// LoadMsgKeyring 从 JSON 文件加载密钥，格式为 {"Keys": [MsgKey...]}
+func LoadMsgKeyring(path string) (*MsgKeyring, error) {
+	keys, err := readMsgKeyringFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewMsgKeyring(keys)
+}
+
+// Reload 重新读取 keyring 文件，文件不合法时保留原有密钥
+func (k *MsgKeyring) Reload(path string) error {
+	keys, err := readMsgKeyringFile(path)
+	if err != nil {
+		return err
+	}
+	return k.Replace(keys)
+}
+
+func readMsgKeyringFile(path string) ([]*MsgKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f msgKeyringFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("parse msg keyring %v: %v", path, err)
+	}
+	return f.Keys, nil
+}
+
+// Replace keyID 全局唯一，且不能包含 ":"
+func (k *MsgKeyring) Replace(keys []*MsgKey) error {
+	aeads := make(map[string]cipher.AEAD, len(keys))
+	active := make(map[string]string)
+	for _, key := range keys {
+		if key == nil || len(key.ID) == 0 || strings.Contains(key.ID, ":") {
+			return fmt.Errorf("invalid msg key id")
+		}
+		if _, ok := aeads[key.ID]; ok {
+			return fmt.Errorf("duplicate msg key %v", key.ID)
+		}
+		raw, err := base64.StdEncoding.DecodeString(key.Key)
+		if err != nil || len(raw) != msgDataKeySize {
+			return fmt.Errorf("msg key %v must be %d bytes base64", key.ID, msgDataKeySize)
+		}
+		aead, err := newGCM(raw)
+		if err != nil {
+			return fmt.Errorf("msg key %v: %v", key.ID, err)
+		}
+		aeads[key.ID] = aead
+		if key.Active {
+			if len(key.App) == 0 {
+				return fmt.Errorf("active msg key %v has no app", key.ID)
+			}
+			if other, ok := active[key.App]; ok {
+				return fmt.Errorf("app %v has more than one active key: %v, %v", key.App, other, key.ID)
+			}
+			active[key.App] = key.ID
+		}
+	}
+	k.mu.Lock()
+	k.keys = aeads
+	k.active = active
+	k.mu.Unlock()
+	return nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Seal 用 b.App 当前的密钥加密 msgData，app 没有 Active 密钥时返回 MsgKeyNotFoundErr，不会写入明文
+func (k *MsgKeyring) Seal(b MsgBinding, msgData string) (string, error) {
+	k.mu.RLock()
+	keyID, ok := k.active[b.App]
+	kek := k.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w: no active key for app %v", MsgKeyNotFoundErr, b.App)
+	}
+	dek := make([]byte, msgDataKeySize)
+	if _, err := io.ReadFull(rand.Reader, dek); err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	aad := b.aad(keyID)
+	wrapped, err := gcmSeal(kek, dek, aad)
+	if err != nil {
+		return "", err
+	}
+	payload, err := gcmSeal(aead, []byte(msgData), aad)
+	if err != nil {
+		return "", err
+	}
+	Metrics.Counter(MetricMsgEncrypted, 1)
+	return encryptedMsgPrefix + keyID + ":" + base64.StdEncoding.EncodeToString(wrapped) + ":" + base64.StdEncoding.EncodeToString(payload), nil
+}
+
+// Open 还原 Seal 之前的 msgData，b 与加密时不一致时解密失败，未加密的 msgData 原样返回
+func (k *MsgKeyring) Open(b MsgBinding, msgData string) (string, error) {
+	if !strings.HasPrefix(msgData, encryptedMsgPrefix) {
+		return msgData, nil
+	}
+	parts := strings.Split(strings.TrimPrefix(msgData, encryptedMsgPrefix), ":")
+	if len(parts) != 3 {
+		return "", fmt.Errorf("malformed encrypted msg")
+	}
+	keyID := parts[0]
+	k.mu.RLock()
+	kek, ok := k.keys[keyID]
+	k.mu.RUnlock()
+	if !ok {
+		return "", fmt.Errorf("%w: %v", MsgKeyNotFoundErr, keyID)
+	}
+	wrapped, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return "", err
+	}
+	payload, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", err
+	}
+	aad := b.aad(keyID)
+	dek, err := gcmOpen(kek, wrapped, aad)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newGCM(dek)
+	if err != nil {
+		return "", err
+	}
+	plain, err := gcmOpen(aead, payload, aad)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// gcmSeal 返回 nonce + 密文
+func gcmSeal(aead cipher.AEAD, plain, aad []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(plain)+aead.Overhead())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, aad), nil
+}
+
+func gcmOpen(aead cipher.AEAD, data, aad []byte) ([]byte, error) {
+	if len(data) < aead.NonceSize() {
+		return nil, fmt.Errorf("encrypted data too short")
+	}
+	return aead.Open(nil, data[:aead.NonceSize()], data[aead.NonceSize():], aad)
+}
+
+// sealStoredMsg 未配置 Keyring 时不加密，配置后加密失败的消息不会以明文写入
+func (s *RouterServer) sealStoredMsg(b MsgBinding, msgData string) (string, error) {
+	if s.Keyring == nil {
+		return msgData, nil
+	}
+	sealed, err := s.Keyring.Seal(b, msgData)
+	if err != nil {
+		Metrics.Counter(MetricMsgEncryptFailed, 1)
+		Applog.Errorf("encrypt msg err:%+v app: %v msgId: %v", err, b.App, b.MsgId)
+		return "", err
+	}
+	return sealed, nil
+}
+
+// OpenStoredMsg 同步和重放时把 MsgDB 中的内容还原成 InsertMsg 之前的 base64 编码，
+// b 为记录所属的 app、用户和 MsgId，未加密的旧数据原样返回
+func (s *RouterServer) OpenStoredMsg(b MsgBinding, msgData string) (string, error) {
+	if !strings.HasPrefix(msgData, encryptedMsgPrefix) {
+		return msgData, nil
+	}
+	if s.Keyring == nil {
+		return "", fmt.Errorf("%w: keyring is not configured", MsgKeyNotFoundErr)
+	}
+	plain, err := s.Keyring.Open(b, msgData)
+	if err != nil {
+		Metrics.Counter(MetricMsgDecryptFailed, 1)
+		return "", err
+	}
+	return plain, nil
+}
+
+// sealedMsg 定时消息的存储格式，路由字段明文保存并参与 AAD，Data 为 sealStoredMsg 的结果
+type sealedMsg struct {
+	AppName    string
+	ReceiverId string
+	MsgId      string
+	Data       string
+}
+
+// sealRequest 编码请求后按 msgBindingOf(in) 加密，用于定时消息和死信
+func (s *RouterServer) sealRequest(in *TransferMessageRequest) (string, error) {
+	raw, err := proto.Marshal(in)
+	if err != nil {
+		return "", err
+	}
+	return s.sealStoredMsg(msgBindingOf(in), base64.StdEncoding.EncodeToString(raw))
+}
+
+// marshalSealedMsg 返回定时消息 store 中保存的内容
+func (s *RouterServer) marshalSealedMsg(in *TransferMessageRequest) (string, error) {
+	data, err := s.sealRequest(in)
+	if err != nil {
+		return "", err
+	}
+	b := msgBindingOf(in)
+	raw, err := json.Marshal(&sealedMsg{AppName: b.App, ReceiverId: b.ReceiverId, MsgId: b.MsgId, Data: data})
+	if err != nil {
+		return "", err
+	}
+	return string(raw), nil
+}
+
+// unmarshalSealedMsg 还原 marshalSealedMsg 的结果，兼容只做了 base64 编码的旧数据
+func (s *RouterServer) unmarshalSealedMsg(data string) (*TransferMessageRequest, error) {
+	if !strings.HasPrefix(data, "{") {
+		return s.decodeStoredMsg(MsgBinding{}, data)
+	}
+	var m sealedMsg
+	if err := json.Unmarshal([]byte(data), &m); err != nil {
+		return nil, err
+	}
+	return s.decodeStoredMsg(MsgBinding{App: m.AppName, ReceiverId: m.ReceiverId, MsgId: m.MsgId}, m.Data)
+}
@@ -0,0 +1,208 @@
+package router
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testMsgKey(id, app string, active bool, b byte) *MsgKey {
+	return &MsgKey{ID: id, App: app, Key: base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string(rune(b)), msgDataKeySize))), Active: active}
+}
+
+func newTestKeyring(t *testing.T, keys ...*MsgKey) *MsgKeyring {
+	t.Helper()
+	if len(keys) == 0 {
+		keys = []*MsgKey{testMsgKey("k1", "im", true, 'a')}
+	}
+	k, err := NewMsgKeyring(keys)
+	assert.NoError(t, err)
+	return k
+}
+
+func TestMsgKeyringBinding(t *testing.T) {
+	k := newTestKeyring(t)
+	b := MsgBinding{App: "im", ReceiverId: "42", MsgId: "m1"}
+	sealed, err := k.Seal(b, "cGxhaW4=")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, encryptedMsgPrefix+"k1:"))
+
+	testCases := []struct {
+		name    string
+		binding MsgBinding
+		wantErr bool
+	}{
+		{name: "same-binding", binding: b},
+		{name: "other-receiver", binding: MsgBinding{App: "im", ReceiverId: "43", MsgId: "m1"}, wantErr: true},
+		{name: "other-msg", binding: MsgBinding{App: "im", ReceiverId: "42", MsgId: "m2"}, wantErr: true},
+		{name: "other-app", binding: MsgBinding{App: "live", ReceiverId: "42", MsgId: "m1"}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			plain, err := k.Open(tc.binding, sealed)
+			if tc.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "cGxhaW4=", plain)
+		})
+	}
+}
+
+func TestMsgKeyringRotationAndMissingKey(t *testing.T) {
+	k := newTestKeyring(t)
+	b := MsgBinding{App: "im", ReceiverId: "42", MsgId: "m1"}
+	old, err := k.Seal(b, "data")
+	assert.NoError(t, err)
+
+	// 轮换后新消息使用新密钥，旧消息仍然可以用旧密钥解密
+	assert.NoError(t, k.Replace([]*MsgKey{testMsgKey("k1", "im", false, 'a'), testMsgKey("k2", "im", true, 'b')}))
+	sealed, err := k.Seal(b, "data")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(sealed, encryptedMsgPrefix+"k2:"))
+	plain, err := k.Open(b, old)
+	assert.NoError(t, err)
+	assert.Equal(t, "data", plain)
+
+	_, err = k.Seal(MsgBinding{App: "live", ReceiverId: "42", MsgId: "m1"}, "data")
+	assert.ErrorIs(t, err, MsgKeyNotFoundErr)
+}
+
+func TestSealStoredMsgFailsClosed(t *testing.T) {
+	ts := newTestServer(t)
+	ts.Keyring = newTestKeyring(t)
+	failed := Metrics.Value(MetricMsgEncryptFailed)
+
+	sealed, err := ts.sealStoredMsg(MsgBinding{App: "live", ReceiverId: "42", MsgId: "m1"}, "data")
+	assert.ErrorIs(t, err, MsgKeyNotFoundErr)
+	assert.Empty(t, sealed)
+	assert.Equal(t, failed+1, Metrics.Value(MetricMsgEncryptFailed))
+}
+
+func TestTransferStoresEncryptedMsg(t *testing.T) {
+	withServiceConfig(t, func(c *config) { c.Service.IsStoreReliableMsg = true })
+	ts := newTestServer(t, iosDevice("d1", &fakeConnector{}))
+	ts.Keyring = newTestKeyring(t)
+	_, err := ts.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+	assert.NoError(t, err)
+	ts.observer.next(t, EventMsgDelivered)
+
+	stored := ts.db.stored()
+	if !assert.Len(t, stored, 1) {
+		return
+	}
+	assert.True(t, strings.HasPrefix(stored[0].msgData, encryptedMsgPrefix))
+	assert.Equal(t, "title", decodeStored(t, ts.RouterServer, "im", stored[0]).Push.Title.Value)
+	// 密文不能用于其它用户的记录
+	_, err = ts.OpenStoredMsg(storedMsgBinding("im", 43, "m1"), stored[0].msgData)
+	assert.Error(t, err)
+
+	w := adminDo(newTestAdminAPI(t, ts.RouterServer), testAdminToken, AdminMessagesPath+"?app=im&user=042", nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var msgs []*TransferMessageRequest
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &msgs))
+	if assert.Len(t, msgs, 1) {
+		assert.Equal(t, "title", msgs[0].Push.Title.Value)
+	}
+}
+
+func TestDelayedMsgEncrypted(t *testing.T) {
+	testCases := []struct {
+		name    string
+		keys    []*MsgKey
+		wantErr bool
+	}{
+		{name: "sealed", keys: []*MsgKey{testMsgKey("k1", "im", true, 'a')}},
+		{name: "no-active-key-rejected", keys: []*MsgKey{testMsgKey("k1", "live", true, 'a')}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			ts.Keyring = newTestKeyring(t, tc.keys...)
+			d, store := newTestDelayed(t, ts)
+			ctx := context.Background()
+			in := newTestRequest()
+			in.DeliverAt = testNowMs() + 1000
+
+			_, err := ts.TransferOnlineReliableMessage(ctx, in)
+			if tc.wantErr {
+				assert.ErrorIs(t, err, MsgKeyNotFoundErr)
+				ids, _ := store.Due(ctx, in.DeliverAt, 10)
+				assert.Empty(t, ids)
+				return
+			}
+			assert.NoError(t, err)
+			files, err := os.ReadDir(store.dir)
+			assert.NoError(t, err)
+			assert.NotEmpty(t, files)
+			for _, f := range files {
+				raw, err := os.ReadFile(filepath.Join(store.dir, f.Name()))
+				assert.NoError(t, err)
+				assert.NotContains(t, string(raw), "title")
+			}
+
+			now := testNow.Add(time.Second)
+			ts.now = func() time.Time { return now }
+			d.poll()
+			ts.observer.next(t, EventMsgDelivered)
+			if reqs := conn.requests(); assert.Len(t, reqs, 1) {
+				assert.Equal(t, "title", reqs[0].Push.Title.Value)
+			}
+		})
+	}
+}
+
+func TestDeadLetterEncrypted(t *testing.T) {
+	conn := &fakeConnector{}
+	ts := newTestServer(t, iosDevice("d1", conn))
+	ts.Keyring = newTestKeyring(t)
+	path := filepath.Join(t.TempDir(), "dead_letters.jsonl")
+	ts.DeadLetters = NewFileDeadLetterSink(path)
+	ctx := context.Background()
+
+	ts.putDeadLetter(ctx, newTestRequest(), "d1", DeadLetterStageDeliver, errors.New("boom"), 1)
+	raw, err := os.ReadFile(path)
+	assert.NoError(t, err)
+	assert.NotContains(t, string(raw), "title")
+	dls, err := ts.DeadLetters.List(ctx, 0)
+	assert.NoError(t, err)
+	if assert.Len(t, dls, 1) {
+		assert.Equal(t, "m1", dls[0].Request.MsgId)
+		assert.Nil(t, dls[0].Request.Push)
+		assert.NotEmpty(t, dls[0].SealedRequest)
+	}
+
+	w := adminDo(newTestAdminAPI(t, ts.RouterServer), testAdminToken, AdminDeadLettersPath, nil)
+	assert.Equal(t, http.StatusOK, w.Code)
+	var listed []*DeadLetter
+	assert.NoError(t, json.Unmarshal(w.Body.Bytes(), &listed))
+	if assert.Len(t, listed, 1) {
+		assert.Equal(t, "title", listed[0].Request.Push.Title.Value)
+		assert.Empty(t, listed[0].SealedRequest)
+	}
+
+	n, err := ts.ReplayDeadLetters(ctx, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	if reqs := conn.requests(); assert.Len(t, reqs, 1) {
+		assert.Equal(t, "title", reqs[0].Push.Title.Value)
+	}
+
+	// 没有可用密钥时不写入明文
+	ts.Keyring = newTestKeyring(t, testMsgKey("k1", "live", true, 'a'))
+	ts.putDeadLetter(ctx, newTestRequest(), "d1", DeadLetterStageDeliver, errors.New("boom"), 1)
+	dls, err = ts.DeadLetters.List(ctx, 0)
+	assert.NoError(t, err)
+	assert.Empty(t, dls)
+}
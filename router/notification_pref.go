@@ -0,0 +1,153 @@
+package router
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const (
+	MetricPushMutedByPref    = "router_push_muted_by_pref"
+	MetricPushSilencedByPref = "router_push_silenced_by_pref"
+)
+
+// NotificationPrefAction 用户对某类消息的通知设置
+type NotificationPrefAction int
+
+const (
+	// NotifyDefault 未设置，正常推送
+	NotifyDefault NotificationPrefAction = iota
+	// NotifySilent 只同步数据，推送降级为静默推送
+	NotifySilent
+	// NotifyMute 不下发到设备
+	NotifyMute
+)
+
+// NotificationPrefs 用户的通知设置，key 为 MsgTypeName；
+// Devices 按设备覆盖用户级别的设置
+type NotificationPrefs struct {
+	MsgTypes map[string]NotificationPrefAction
+	Devices  map[string]map[string]NotificationPrefAction
+}
+
+// actionFor 设备级别的设置优先于用户级别的设置
+func (p *NotificationPrefs) actionFor(deviceId, msgTypeName string) NotificationPrefAction {
+	if p == nil {
+		return NotifyDefault
+	}
+	if action, ok := p.Devices[deviceId][msgTypeName]; ok {
+		return action
+	}
+	return p.MsgTypes[msgTypeName]
+}
+
+// NotificationPrefProvider 读取用户的通知设置，用户未设置时返回 nil, nil
+type NotificationPrefProvider interface {
+	GetNotificationPrefs(ctx context.Context, appName, userId string) (*NotificationPrefs, error)
+}
+
+// MemoryNotificationPrefProvider 进程内的通知设置
+type MemoryNotificationPrefProvider struct {
+	mu    sync.RWMutex
+	prefs map[string]*NotificationPrefs
+}
+
+func NewMemoryNotificationPrefProvider() *MemoryNotificationPrefProvider {
+	return &MemoryNotificationPrefProvider{prefs: make(map[string]*NotificationPrefs)}
+}
+
+func (m *MemoryNotificationPrefProvider) GetNotificationPrefs(ctx context.Context, appName, userId string) (*NotificationPrefs, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.prefs[appName+RedisInterval+userId], nil
+}
+
+func (m *MemoryNotificationPrefProvider) SetNotificationPrefs(appName, userId string, prefs *NotificationPrefs) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.prefs[appName+RedisInterval+userId] = prefs
+}
+
+type cachedNotificationPrefs struct {
+	prefs    *NotificationPrefs
+	expireAt time.Time
+}
+
+// CachedNotificationPrefProvider 缓存下游的通知设置，未设置的用户也会被缓存；
+// 读取失败时不缓存，直接返回错误
+type CachedNotificationPrefProvider struct {
+	next       NotificationPrefProvider
+	ttl        time.Duration
+	maxEntries int
+	// now 缓存过期使用的时钟，默认为 time.Now
+	now func() time.Time
+
+	mu      sync.Mutex
+	entries map[string]*cachedNotificationPrefs
+}
+
+func NewCachedNotificationPrefProvider(next NotificationPrefProvider, ttl time.Duration, maxEntries int) *CachedNotificationPrefProvider {
+	return &CachedNotificationPrefProvider{
+		next:       next,
+		ttl:        ttl,
+		maxEntries: maxEntries,
+		now:        time.Now,
+		entries:    make(map[string]*cachedNotificationPrefs),
+	}
+}
+
+func (c *CachedNotificationPrefProvider) GetNotificationPrefs(ctx context.Context, appName, userId string) (*NotificationPrefs, error) {
+	key := appName + RedisInterval + userId
+	now := c.now()
+	c.mu.Lock()
+	if e, ok := c.entries[key]; ok && now.Before(e.expireAt) {
+		c.mu.Unlock()
+		return e.prefs, nil
+	}
+	c.mu.Unlock()
+
+	prefs, err := c.next.GetNotificationPrefs(ctx, appName, userId)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.maxEntries > 0 && len(c.entries) >= c.maxEntries {
+		c.evictLocked(now)
+	}
+	c.entries[key] = &cachedNotificationPrefs{prefs: prefs, expireAt: now.Add(c.ttl)}
+	return prefs, nil
+}
+
+// Invalidate 用户修改设置后调用，下次读取时回源
+func (c *CachedNotificationPrefProvider) Invalidate(appName, userId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	delete(c.entries, appName+RedisInterval+userId)
+}
+
+// evictLocked 先清理过期的缓存，仍然超出上限时清空
+func (c *CachedNotificationPrefProvider) evictLocked(now time.Time) {
+	for k, e := range c.entries {
+		if !now.Before(e.expireAt) {
+			delete(c.entries, k)
+		}
+	}
+	if len(c.entries) >= c.maxEntries {
+		c.entries = make(map[string]*cachedNotificationPrefs)
+	}
+}
+
+// notificationPrefs 读取失败时按未设置处理，不影响投递
+func (s *RouterServer) notificationPrefs(ctx context.Context, in *TransferMessageRequest) *NotificationPrefs {
+	if s.NotificationPrefs == nil || len(in.MsgTypeName) == 0 {
+		return nil
+	}
+	prefs, err := s.NotificationPrefs.GetNotificationPrefs(ctx, in.AppName, in.ReceiverId)
+	if err != nil {
+		Applog.Errorf("get notification prefs err:%+v app: %v uid: %v", err, in.AppName, in.ReceiverId)
+		return nil
+	}
+	return prefs
+}
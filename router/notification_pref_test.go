@@ -0,0 +1,151 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNotificationPrefsActionFor(t *testing.T) {
+	prefs := &NotificationPrefs{
+		MsgTypes: map[string]NotificationPrefAction{"promo": NotifyMute, "chat": NotifySilent},
+		Devices:  map[string]map[string]NotificationPrefAction{"d1": {"promo": NotifyDefault}},
+	}
+	testCases := []struct {
+		name        string
+		prefs       *NotificationPrefs
+		deviceID    string
+		msgTypeName string
+		want        NotificationPrefAction
+	}{
+		{name: "nil-prefs", deviceID: "d2", msgTypeName: "promo", want: NotifyDefault},
+		{name: "user-level", prefs: prefs, deviceID: "d2", msgTypeName: "promo", want: NotifyMute},
+		{name: "device-overrides-user", prefs: prefs, deviceID: "d1", msgTypeName: "promo", want: NotifyDefault},
+		{name: "device-falls-back-to-user", prefs: prefs, deviceID: "d1", msgTypeName: "chat", want: NotifySilent},
+		{name: "unset-type", prefs: prefs, deviceID: "d2", msgTypeName: "order", want: NotifyDefault},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.prefs.actionFor(tc.deviceID, tc.msgTypeName))
+		})
+	}
+}
+
+// countingPrefProvider 记录回源次数，err 非空时读取失败
+type countingPrefProvider struct {
+	*MemoryNotificationPrefProvider
+	err error
+
+	mu    sync.Mutex
+	calls int
+}
+
+func (p *countingPrefProvider) GetNotificationPrefs(ctx context.Context, appName, userId string) (*NotificationPrefs, error) {
+	p.mu.Lock()
+	p.calls++
+	p.mu.Unlock()
+	if p.err != nil {
+		return nil, p.err
+	}
+	return p.MemoryNotificationPrefProvider.GetNotificationPrefs(ctx, appName, userId)
+}
+
+func TestCachedNotificationPrefProvider(t *testing.T) {
+	ctx := context.Background()
+	testCases := []struct {
+		name      string
+		err       error
+		advance   time.Duration
+		invalid   bool
+		otherUser bool
+		wantCalls int
+	}{
+		{name: "cached", wantCalls: 1},
+		{name: "expired", advance: time.Minute, wantCalls: 2},
+		{name: "invalidated", invalid: true, wantCalls: 2},
+		{name: "errors-not-cached", err: errors.New("down"), wantCalls: 2},
+		{name: "evicted-when-full", otherUser: true, wantCalls: 2},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			next := &countingPrefProvider{MemoryNotificationPrefProvider: NewMemoryNotificationPrefProvider(), err: tc.err}
+			next.SetNotificationPrefs("im", "42", &NotificationPrefs{MsgTypes: map[string]NotificationPrefAction{"promo": NotifyMute}})
+			c := NewCachedNotificationPrefProvider(next, time.Minute, 1)
+			now := testNow
+			c.now = func() time.Time { return now }
+
+			_, err := c.GetNotificationPrefs(ctx, "im", "42")
+			assert.Equal(t, tc.err, err)
+			now = now.Add(tc.advance)
+			if tc.invalid {
+				c.Invalidate("im", "42")
+			}
+			if tc.otherUser {
+				// 未设置的用户也会被缓存，超出上限时淘汰
+				prefs, err := c.GetNotificationPrefs(ctx, "im", "43")
+				assert.NoError(t, err)
+				assert.Nil(t, prefs)
+				tc.wantCalls++
+			}
+			prefs, err := c.GetNotificationPrefs(ctx, "im", "42")
+			assert.Equal(t, tc.err, err)
+			if tc.err == nil {
+				assert.Equal(t, NotifyMute, prefs.actionFor("d1", "promo"))
+			}
+			assert.Equal(t, tc.wantCalls, next.calls)
+		})
+	}
+}
+
+func TestTransferWithNotificationPrefs(t *testing.T) {
+	testCases := []struct {
+		name        string
+		msgTypeName string
+		prefs       *NotificationPrefs
+		providerErr error
+		wantSkipped bool
+		wantSilent  bool
+	}{
+		{name: "no-prefs", msgTypeName: "promo"},
+		{name: "muted", msgTypeName: "promo", prefs: &NotificationPrefs{MsgTypes: map[string]NotificationPrefAction{"promo": NotifyMute}}, wantSkipped: true},
+		{name: "silenced", msgTypeName: "promo", prefs: &NotificationPrefs{MsgTypes: map[string]NotificationPrefAction{"promo": NotifySilent}}, wantSilent: true},
+		{name: "device-unmuted", msgTypeName: "promo", prefs: &NotificationPrefs{
+			MsgTypes: map[string]NotificationPrefAction{"promo": NotifyMute},
+			Devices:  map[string]map[string]NotificationPrefAction{"d1": {"promo": NotifyDefault}},
+		}},
+		{name: "no-msg-type-name", prefs: &NotificationPrefs{MsgTypes: map[string]NotificationPrefAction{"": NotifyMute}}},
+		{name: "provider-error-delivers", msgTypeName: "promo", providerErr: errors.New("down")},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			provider := &countingPrefProvider{MemoryNotificationPrefProvider: NewMemoryNotificationPrefProvider(), err: tc.providerErr}
+			provider.SetNotificationPrefs("im", "42", tc.prefs)
+			ts.NotificationPrefs = provider
+			in := newTestRequest()
+			in.MsgTypeName = tc.msgTypeName
+			muted := Metrics.Value(MetricPushMutedByPref)
+
+			_, err := ts.TransferOnlineReliableMessage(context.Background(), in)
+			assert.NoError(t, err)
+			if tc.wantSkipped {
+				ev := ts.observer.next(t, EventDeviceSkipped)
+				assert.Equal(t, SkipReasonMuted, ev.Reason)
+				assert.Equal(t, "d1", ev.DeviceID)
+				assert.Empty(t, conn.requests())
+				assert.Equal(t, muted+1, Metrics.Value(MetricPushMutedByPref))
+				return
+			}
+			ts.observer.next(t, EventMsgDelivered)
+			if reqs := conn.requests(); assert.Len(t, reqs, 1) {
+				assert.Equal(t, tc.wantSilent, reqs[0].Push.Silent)
+				assert.Equal(t, "title", reqs[0].Push.Title.Value)
+			}
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+	"sync"
+)
+
+// RouterEventType 消息生命周期中的事件类型
+type RouterEventType int
+
+const (
+	// EventMsgAccepted 消息已经生成序列号并且存储成功（异步存储时为交给存储之后），下发之前发出
+	EventMsgAccepted RouterEventType = iota + 1
+	EventDeviceSkipped
+	EventMsgDelivered
+	EventDeliverFailed
+	EventRouteDeleted
+)
+
+func (t RouterEventType) String() string {
+	switch t {
+	case EventMsgAccepted:
+		return "accepted"
+	case EventDeviceSkipped:
+		return "device_skipped"
+	case EventMsgDelivered:
+		return "delivered"
+	case EventDeliverFailed:
+		return "failed"
+	case EventRouteDeleted:
+		return "route_deleted"
+	}
+	return fmt.Sprintf("RouterEventType(%d)", int(t))
+}
+
+// 设备被跳过的原因
+const (
+	SkipReasonLimitVersion = "limit_version"
+	SkipReasonForceLang    = "force_lang"
+	SkipReasonExpired      = "expired"
+	SkipReasonBlacklisted  = "blacklisted"
+	SkipReasonPayloadLimit = "payload_limit"
+	SkipReasonMuted        = "muted"
+	SkipReasonCollapsed    = "collapsed"
+	// SkipReasonContentRejected 推送文本被内容过滤拒绝
+	SkipReasonContentRejected = "content_rejected"
+)
+
+const MetricObserverEventDropped = "router_observer_event_dropped"
+
+// RouterEvent 消息生命周期事件，事件发出后不应再被修改
+type RouterEvent struct {
+	Type     RouterEventType
+	AppName  string
+	UserId   string
+	MsgId    string
+	DeviceID string
+	Source   string
+	Reason   string // 设备被跳过的原因或者路由被删除的错误分类
+	Err      string
+	Attempts int
+	Time     int64 // 毫秒时间戳
+}
+
+// RouterObserver 订阅 RouterServer 的消息生命周期事件，
+// OnRouterEvent 在投递流程中同步调用，耗时的观察者应使用 AsyncObserverDispatcher 包装
+type RouterObserver interface {
+	OnRouterEvent(ctx context.Context, ev *RouterEvent)
+}
+
+// RegisterObserver 注册观察者，需要在开始处理请求之前调用
+func (s *RouterServer) RegisterObserver(o RouterObserver) {
+	s.observers = append(s.observers, o)
+}
+
+func (s *RouterServer) emit(ctx context.Context, ev *RouterEvent) {
+	if len(s.observers) == 0 {
+		return
+	}
+	ev.Time = s.nowMs()
+	for _, o := range s.observers {
+		notifyObserver(ctx, o, ev)
+	}
+}
+
+func (s *RouterServer) emitAccepted(ctx context.Context, in *TransferMessageRequest) {
+	s.emit(ctx, &RouterEvent{Type: EventMsgAccepted, AppName: in.AppName, UserId: in.ReceiverId, MsgId: in.MsgId})
+}
+
+func (s *RouterServer) emitDeviceSkipped(ctx context.Context, in *TransferMessageRequest, wrapper *ConnectorClientWrapper, reason string) {
+	deliverWaiterFrom(ctx).done(fmt.Errorf("device %v skipped: %v", wrapper.DeviceID, reason))
+	s.emit(ctx, &RouterEvent{
+		Type:     EventDeviceSkipped,
+		AppName:  in.AppName,
+		UserId:   in.ReceiverId,
+		MsgId:    in.GetMsgId(),
+		DeviceID: wrapper.DeviceID,
+		Source:   wrapper.Source,
+		Reason:   reason,
+	})
+}
+
+func (s *RouterServer) emitDeliverResult(ctx context.Context, in *TransferMessageRequest, wrapper *ConnectorClientWrapper, err error, attempts int) {
+	ev := &RouterEvent{
+		Type:     EventMsgDelivered,
+		AppName:  in.AppName,
+		UserId:   in.ReceiverId,
+		MsgId:    in.GetMsgId(),
+		DeviceID: wrapper.DeviceID,
+		Source:   wrapper.Source,
+		Attempts: attempts,
+	}
+	if err != nil {
+		ev.Type = EventDeliverFailed
+		ev.Err = err.Error()
+	}
+	deliverWaiterFrom(ctx).done(err)
+	s.emit(ctx, ev)
+}
+
+// notifyObserver 观察者 panic 不影响消息投递
+func notifyObserver(ctx context.Context, o RouterObserver, ev *RouterEvent) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 1<<15)
+			n := runtime.Stack(buf, false)
+			err := fmt.Errorf("%v, STACK: %s", r, buf[0:n])
+			Applog.Errorf("router observer panic :%+v", err)
+		}
+	}()
+	o.OnRouterEvent(ctx, ev)
+}
+
+type observedEvent struct {
+	ctx context.Context
+	ev  *RouterEvent
+}
+
+// AsyncObserverDispatcher 把事件放入缓冲队列，由单独的 goroutine 分发给下游观察者，
+// 队列满时丢弃事件并计数，保证慢观察者不会阻塞投递
+type AsyncObserverDispatcher struct {
+	observers []RouterObserver
+	events    chan observedEvent
+
+	mu     sync.RWMutex
+	closed bool
+	done   chan struct{}
+}
+
+func NewAsyncObserverDispatcher(bufferSize int, observers ...RouterObserver) *AsyncObserverDispatcher {
+	d := &AsyncObserverDispatcher{
+		observers: observers,
+		events:    make(chan observedEvent, bufferSize),
+		done:      make(chan struct{}),
+	}
+	go d.run()
+	return d
+}
+
+func (d *AsyncObserverDispatcher) OnRouterEvent(ctx context.Context, ev *RouterEvent) {
+	d.mu.RLock()
+	defer d.mu.RUnlock()
+	if d.closed {
+		return
+	}
+	select {
+	case d.events <- observedEvent{ctx: ctx, ev: ev}:
+	default:
+		Metrics.Counter(MetricObserverEventDropped, 1)
+	}
+}
+
+// Stop 不再接收新事件，等待已缓冲的事件分发完毕
+func (d *AsyncObserverDispatcher) Stop() {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return
+	}
+	d.closed = true
+	close(d.events)
+	d.mu.Unlock()
+	<-d.done
+}
+
+func (d *AsyncObserverDispatcher) run() {
+	defer close(d.done)
+	for e := range d.events {
+		for _, o := range d.observers {
+			notifyObserver(e.ctx, o, e.ev)
+		}
+	}
+}
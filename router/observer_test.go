@@ -0,0 +1,206 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// panicObserver 每次收到事件都 panic
+type panicObserver struct{}
+
+func (panicObserver) OnRouterEvent(ctx context.Context, ev *RouterEvent) {
+	panic("observer panic")
+}
+
+// blockingObserver 收到事件后阻塞直到 release 关闭
+type blockingObserver struct {
+	received chan *RouterEvent
+	release  chan struct{}
+}
+
+func (o *blockingObserver) OnRouterEvent(ctx context.Context, ev *RouterEvent) {
+	o.received <- ev
+	<-o.release
+}
+
+func TestRouterEventTypeString(t *testing.T) {
+	testCases := []struct {
+		typ  RouterEventType
+		want string
+	}{
+		{typ: EventMsgAccepted, want: "accepted"},
+		{typ: EventDeviceSkipped, want: "device_skipped"},
+		{typ: EventMsgDelivered, want: "delivered"},
+		{typ: EventDeliverFailed, want: "failed"},
+		{typ: EventRouteDeleted, want: "route_deleted"},
+		{typ: RouterEventType(99), want: "RouterEventType(99)"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.want, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.typ.String())
+		})
+	}
+}
+
+func TestRouterEventsLifecycle(t *testing.T) {
+	testCases := []struct {
+		name       string
+		conn       *fakeConnector
+		modify     func(in *TransferMessageRequest)
+		wantEvents []RouterEventType
+		wantReason string
+		wantErr    string
+	}{
+		{
+			name:       "delivered",
+			conn:       &fakeConnector{},
+			wantEvents: []RouterEventType{EventMsgAccepted, EventMsgDelivered},
+		},
+		{
+			name:       "failed",
+			conn:       &fakeConnector{err: errors.New("boom")},
+			wantEvents: []RouterEventType{EventMsgAccepted, EventDeliverFailed},
+			wantErr:    "boom",
+		},
+		{
+			name:       "skipped",
+			conn:       &fakeConnector{},
+			modify:     func(in *TransferMessageRequest) { in.ForceLangs = []string{"fr"} },
+			wantEvents: []RouterEventType{EventMsgAccepted, EventDeviceSkipped},
+			wantReason: SkipReasonForceLang,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t, iosDevice("d1", tc.conn))
+			// 观察者 panic 不影响其它观察者和投递
+			ts.RegisterObserver(panicObserver{})
+			in := newTestRequest()
+			if tc.modify != nil {
+				tc.modify(in)
+			}
+			_, err := ts.TransferOnlineReliableMessage(context.Background(), in)
+			assert.NoError(t, err)
+
+			var last *RouterEvent
+			for _, typ := range tc.wantEvents {
+				last = ts.observer.next(t, typ)
+				assert.Equal(t, "im", last.AppName)
+				assert.Equal(t, "42", last.UserId)
+				assert.Equal(t, "m1", last.MsgId)
+				assert.Equal(t, testNowMs(), last.Time)
+			}
+			assert.Equal(t, "d1", last.DeviceID)
+			assert.Equal(t, tc.wantReason, last.Reason)
+			assert.Equal(t, tc.wantErr, last.Err)
+		})
+	}
+}
+
+// storedOnAcceptObserver 记录收到 EventMsgAccepted 时消息存储中的消息数
+type storedOnAcceptObserver struct {
+	db     *memMsgDB
+	stored []int
+}
+
+func (o *storedOnAcceptObserver) OnRouterEvent(ctx context.Context, ev *RouterEvent) {
+	if ev.Type == EventMsgAccepted {
+		o.stored = append(o.stored, len(o.db.stored()))
+	}
+}
+
+func TestMsgAcceptedAfterPersist(t *testing.T) {
+	testCases := []struct {
+		name       string
+		store      bool
+		deferred   bool
+		dbErr      error
+		wantErr    bool
+		wantStored []int // 每次收到 EventMsgAccepted 时已经存储的消息数
+	}{
+		{name: "stored-before-accepted", store: true, wantStored: []int{1}},
+		{name: "storage-disabled", wantStored: []int{0}},
+		{name: "store-failed-not-accepted", store: true, dbErr: errors.New("db down"), wantErr: true},
+		{name: "deferred-stored-before-accepted", store: true, deferred: true, wantStored: []int{1}},
+		{name: "deferred-store-failed-not-accepted", store: true, deferred: true, dbErr: errors.New("db down"), wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withServiceConfig(t, func(c *config) { c.Service.IsStoreReliableMsg = tc.store })
+			ts := newTestServer(t, iosDevice("d1", &fakeConnector{}))
+			ts.db.err = tc.dbErr
+			o := &storedOnAcceptObserver{db: ts.db}
+			ts.RegisterObserver(o)
+			if tc.deferred {
+				ts.RateLimiter = NewRateLimiter(RateLimitConfig{PerReceiver: RateLimitRule{Rate: 1, Burst: 1}, DeferToStorage: true}, &DefaultRouterRedisClient{})
+				ts.RateLimiter.now = func() time.Time { return testNow }
+				assert.NoError(t, ts.RateLimiter.Allow(context.Background(), "im", "42"))
+			}
+
+			_, err := ts.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+			if tc.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+			assert.Equal(t, tc.wantStored, o.stored)
+		})
+	}
+}
+
+func TestAsyncObserverDispatcher(t *testing.T) {
+	t.Run("dispatch-in-order", func(t *testing.T) {
+		o := newRecordingObserver()
+		d := NewAsyncObserverDispatcher(8, panicObserver{}, o)
+		for i := 0; i < 3; i++ {
+			d.OnRouterEvent(context.Background(), &RouterEvent{Type: EventMsgAccepted, Attempts: i})
+		}
+		d.Stop()
+		evs := o.drain()
+		if assert.Len(t, evs, 3) {
+			for i, ev := range evs {
+				assert.Equal(t, i, ev.Attempts)
+			}
+		}
+		// Stop 之后的事件被忽略，重复 Stop 不会 panic
+		d.OnRouterEvent(context.Background(), &RouterEvent{Type: EventMsgAccepted})
+		d.Stop()
+		assert.Empty(t, o.drain())
+	})
+	t.Run("drop-when-full", func(t *testing.T) {
+		o := &blockingObserver{received: make(chan *RouterEvent, 4), release: make(chan struct{})}
+		d := NewAsyncObserverDispatcher(1, o)
+		dropped := Metrics.Value(MetricObserverEventDropped)
+
+		d.OnRouterEvent(context.Background(), &RouterEvent{Type: EventMsgAccepted})
+		<-o.received
+		// 第一个事件阻塞在观察者中，缓冲区只能再放一个
+		d.OnRouterEvent(context.Background(), &RouterEvent{Type: EventMsgDelivered})
+		d.OnRouterEvent(context.Background(), &RouterEvent{Type: EventDeliverFailed})
+		assert.Equal(t, dropped+1, Metrics.Value(MetricObserverEventDropped))
+
+		close(o.release)
+		d.Stop()
+		assert.Equal(t, EventMsgDelivered, (<-o.received).Type)
+	})
+	t.Run("concurrent-stop", func(t *testing.T) {
+		d := NewAsyncObserverDispatcher(16, newRecordingObserver())
+		var wg sync.WaitGroup
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				for j := 0; j < 50; j++ {
+					d.OnRouterEvent(context.Background(), &RouterEvent{Type: EventMsgAccepted})
+				}
+			}()
+		}
+		d.Stop()
+		wg.Wait()
+	})
+}
@@ -0,0 +1,116 @@
+package router
+
+import (
+	"errors"
+	"unicode"
+	"unicode/utf8"
+)
+
+var PayloadTooLargeErr = errors.New("msg data exceeds payload limit")
+
+// PushPayloadLimit 单个平台的推送负载预算，字节数按 UTF-8 编码计算，0 表示不限制
+type PushPayloadLimit struct {
+	MaxTitleBytes   int
+	MaxValueBytes   int
+	MaxTickerBytes  int
+	MaxMsgDataBytes int
+	// RejectOversizeMsgData 为 true 时 MsgData 超限的消息不下发到该平台
+	RejectOversizeMsgData bool
+	// Ellipsis 截断后追加的后缀，计入字节预算
+	Ellipsis string
+}
+
+// PushPayloadLimits 按 UserAgent.Source 区分的推送负载预算
+type PushPayloadLimits map[ClientSourceEnum]*PushPayloadLimit
+
+func (l PushPayloadLimits) limitOf(wrapper *ConnectorClientWrapper) *PushPayloadLimit {
+	if len(l) == 0 || wrapper.UA == nil {
+		return nil
+	}
+	return l[wrapper.UA.Source]
+}
+
+// applyPushLimit 按平台预算截断已本地化的 title、value、ticker
+func applyPushLimit(push PushContent, limit *PushPayloadLimit) PushContent {
+	if limit == nil {
+		return push
+	}
+	push.Title = truncateI18n(push.Title, limit.MaxTitleBytes, limit.Ellipsis)
+	push.Value = truncateI18n(push.Value, limit.MaxValueBytes, limit.Ellipsis)
+	push.Ticker = truncateI18n(push.Ticker, limit.MaxTickerBytes, limit.Ellipsis)
+	return push
+}
+
+// checkMsgDataLimit 检查 MsgData 是否超出平台预算
+func checkMsgDataLimit(msgData *Any, limit *PushPayloadLimit) error {
+	if limit == nil || limit.MaxMsgDataBytes <= 0 || !limit.RejectOversizeMsgData || msgData == nil {
+		return nil
+	}
+	if len(msgData.Value) > limit.MaxMsgDataBytes {
+		return PayloadTooLargeErr
+	}
+	return nil
+}
+
+func truncateI18n(i18n *I18N, maxBytes int, ellipsis string) *I18N {
+	if i18n == nil || maxBytes <= 0 || len(i18n.Value) <= maxBytes {
+		return i18n
+	}
+	truncated := *i18n
+	if len(ellipsis) >= maxBytes {
+		truncated.Value = truncateUTF8(i18n.Value, maxBytes)
+	} else {
+		truncated.Value = truncateUTF8(i18n.Value, maxBytes-len(ellipsis)) + ellipsis
+	}
+	return &truncated
+}
+
+// truncateUTF8 把 s 截断到不超过 maxBytes 字节，不会切断多字节字符，
+// 也不会把组合字符、间距组合字符（例如天城文元音符号）、变体选择符、零宽连接符与其前面的字符拆开，
+// 组成国旗的区域指示符按两个一组保留
+func truncateUTF8(s string, maxBytes int) string {
+	if len(s) <= maxBytes {
+		return s
+	}
+	cut := 0
+	riCount := 0 // 到当前字符为止连续的区域指示符个数
+	for i := 0; i < len(s); {
+		// 非法字节解码为 RuneError，按实际占用的 1 个字节计算
+		r, size := utf8.DecodeRuneInString(s[i:])
+		next := i + size
+		if next > maxBytes {
+			break
+		}
+		i = next
+		if isRegionalIndicator(r) {
+			riCount++
+		} else {
+			riCount = 0
+		}
+		// 只在字素簇边界处记录可截断位置
+		if next < len(s) {
+			nr, _ := utf8.DecodeRuneInString(s[next:])
+			if isGraphemeExtend(nr) || r == zeroWidthJoiner || (riCount%2 == 1 && isRegionalIndicator(nr)) {
+				continue
+			}
+		}
+		cut = next
+	}
+	return s[:cut]
+}
+
+const zeroWidthJoiner = '\u200d'
+
+func isGraphemeExtend(r rune) bool {
+	return r == zeroWidthJoiner ||
+		unicode.Is(unicode.Mn, r) ||
+		unicode.Is(unicode.Me, r) ||
+		unicode.Is(unicode.Mc, r) ||
+		(r >= 0xFE00 && r <= 0xFE0F) || // variation selectors
+		(r >= 0x1F3FB && r <= 0x1F3FF) // emoji skin tone modifiers
+}
+
+// isRegionalIndicator 两个区域指示符组成一个国旗
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1F1E6 && r <= 0x1F1FF
+}
@@ -0,0 +1,105 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateUTF8(t *testing.T) {
+	testCases := []struct {
+		name     string
+		in       string
+		maxBytes int
+		want     string
+	}{
+		{name: "fits", in: "hello", maxBytes: 5, want: "hello"},
+		{name: "ascii", in: "hello", maxBytes: 3, want: "hel"},
+		{name: "no-split-multibyte", in: "你好世界", maxBytes: 7, want: "你好"},
+		{name: "combining-mark-kept-with-base", in: "aéb", maxBytes: 2, want: "a"},
+		{name: "zwj-sequence-kept-whole", in: "x👨‍👩y", maxBytes: 8, want: "x"},
+		{name: "skin-tone-kept-with-emoji", in: "a👍🏽b", maxBytes: 5, want: "a"},
+		{name: "spacing-mark-kept-with-base", in: "aकिb", maxBytes: 4, want: "a"},
+		{name: "spacing-mark-fits", in: "aकिb", maxBytes: 7, want: "aकि"},
+		{name: "flag-kept-whole", in: "a🇨🇳b", maxBytes: 5, want: "a"},
+		{name: "flags-split-between-pairs", in: "🇨🇳🇺🇸", maxBytes: 12, want: "🇨🇳"},
+		{name: "odd-regional-indicators", in: "🇨🇳🇺x", maxBytes: 12, want: "🇨🇳🇺"},
+		{name: "invalid-bytes-count-one-byte", in: "ab\xff\xfecd", maxBytes: 4, want: "ab\xff\xfe"},
+		{name: "invalid-byte-before-multibyte", in: "\xff你", maxBytes: 3, want: "\xff"},
+		{name: "zero-budget", in: "abc", maxBytes: 0, want: ""},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got := truncateUTF8(tc.in, tc.maxBytes)
+			assert.Equal(t, tc.want, got)
+			if len(tc.in) > tc.maxBytes {
+				assert.LessOrEqual(t, len(got), tc.maxBytes)
+			}
+		})
+	}
+}
+
+func TestTruncateI18n(t *testing.T) {
+	testCases := []struct {
+		name     string
+		in       *I18N
+		maxBytes int
+		ellipsis string
+		want     *I18N
+	}{
+		{name: "nil", in: nil, maxBytes: 3, want: nil},
+		{name: "unlimited", in: &I18N{Value: "hello"}, maxBytes: 0, want: &I18N{Value: "hello"}},
+		{name: "with-ellipsis", in: &I18N{Value: "hello world", Params: []string{"p"}}, maxBytes: 8, ellipsis: "...", want: &I18N{Value: "hello...", Params: []string{"p"}}},
+		{name: "ellipsis-too-long", in: &I18N{Value: "hello"}, maxBytes: 2, ellipsis: "...", want: &I18N{Value: "he"}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, truncateI18n(tc.in, tc.maxBytes, tc.ellipsis))
+		})
+	}
+
+	// 不修改调用方的 I18N
+	in := &I18N{Value: "hello world"}
+	truncateI18n(in, 5, "")
+	assert.Equal(t, "hello world", in.Value)
+}
+
+func TestApplyPushLimit(t *testing.T) {
+	push := PushContent{Title: &I18N{Value: "title"}, Value: &I18N{Value: "value"}, Ticker: &I18N{Value: "ticker"}}
+	assert.Equal(t, push, applyPushLimit(push, nil))
+
+	got := applyPushLimit(push, &PushPayloadLimit{MaxTitleBytes: 2, MaxValueBytes: 3, MaxTickerBytes: 4})
+	assert.Equal(t, "ti", got.Title.Value)
+	assert.Equal(t, "val", got.Value.Value)
+	assert.Equal(t, "tick", got.Ticker.Value)
+}
+
+func TestCheckMsgDataLimit(t *testing.T) {
+	data := &Any{Value: []byte("0123456789")}
+	testCases := []struct {
+		name    string
+		data    *Any
+		limit   *PushPayloadLimit
+		wantErr error
+	}{
+		{name: "no-limit", data: data, limit: nil},
+		{name: "not-rejecting", data: data, limit: &PushPayloadLimit{MaxMsgDataBytes: 5}},
+		{name: "within", data: data, limit: &PushPayloadLimit{MaxMsgDataBytes: 10, RejectOversizeMsgData: true}},
+		{name: "oversize", data: data, limit: &PushPayloadLimit{MaxMsgDataBytes: 9, RejectOversizeMsgData: true}, wantErr: PayloadTooLargeErr},
+		{name: "nil-data", data: nil, limit: &PushPayloadLimit{MaxMsgDataBytes: 1, RejectOversizeMsgData: true}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.wantErr, checkMsgDataLimit(tc.data, tc.limit))
+		})
+	}
+}
+
+func TestPushPayloadLimitsLimitOf(t *testing.T) {
+	ios := &PushPayloadLimit{MaxTitleBytes: 10}
+	limits := PushPayloadLimits{CLIENT_SOURCE_IOS: ios}
+	assert.Equal(t, ios, limits.limitOf(&ConnectorClientWrapper{UA: &UserAgent{Source: CLIENT_SOURCE_IOS}}))
+	assert.Nil(t, limits.limitOf(&ConnectorClientWrapper{UA: &UserAgent{Source: CLIENT_SOURCE_ANDROID}}))
+	assert.Nil(t, limits.limitOf(&ConnectorClientWrapper{}))
+	assert.Nil(t, PushPayloadLimits(nil).limitOf(&ConnectorClientWrapper{UA: &UserAgent{Source: CLIENT_SOURCE_IOS}}))
+}
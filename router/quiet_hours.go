@@ -0,0 +1,193 @@
+package router
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+const MetricPushSilencedByDND = "router_push_silenced_by_dnd"
+
+// DNDMode 免打扰生效时对推送内容的处理方式
+type DNDMode int
+
+const (
+	// DNDModeSilent 保留推送内容，标记为静默推送，客户端不弹通知
+	DNDModeSilent DNDMode = iota
+	// DNDModeStrip 去掉标题、正文和 ticker，只保留静默推送
+	DNDModeStrip
+)
+
+// QuietWindow 一天中的免打扰时间段，单位为从 0 点开始的分钟数，[Start, End)；
+// End 小于 Start 表示跨过午夜，Weekdays 为空表示每天生效，按窗口开始的那一天判断
+type QuietWindow struct {
+	StartMinute int
+	EndMinute   int
+	Weekdays    []time.Weekday
+}
+
+// DNDPolicy 用户的免打扰设置
+type DNDPolicy struct {
+	Enabled  bool
+	TimeZone string // IANA 时区名，例如 Asia/Shanghai，为空时使用 UTC
+	Windows  []QuietWindow
+	Mode     DNDMode
+
+	// loc ResolveTimeZone 解析出的时区
+	loc *time.Location
+}
+
+// dndLocations 时区名到 *time.Location 的缓存，未调用 ResolveTimeZone 的设置也不会每条消息都解析时区
+var dndLocations sync.Map
+
+func loadDNDLocation(name string) (*time.Location, error) {
+	if l, ok := dndLocations.Load(name); ok {
+		return l.(*time.Location), nil
+	}
+	l, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+	dndLocations.Store(name, l)
+	return l, nil
+}
+
+// ResolveTimeZone 解析并缓存 TimeZone，DNDPreferenceStore 在加载设置时调用
+func (p *DNDPolicy) ResolveTimeZone() error {
+	if len(p.TimeZone) == 0 {
+		p.loc = time.UTC
+		return nil
+	}
+	l, err := loadDNDLocation(p.TimeZone)
+	if err != nil {
+		return err
+	}
+	p.loc = l
+	return nil
+}
+
+func (p *DNDPolicy) location() (*time.Location, error) {
+	if p.loc != nil {
+		return p.loc, nil
+	}
+	if len(p.TimeZone) == 0 {
+		return time.UTC, nil
+	}
+	return loadDNDLocation(p.TimeZone)
+}
+
+// Active 判断 now 是否处于免打扰时间段
+func (p *DNDPolicy) Active(now time.Time) bool {
+	if p == nil || !p.Enabled || len(p.Windows) == 0 {
+		return false
+	}
+	loc, err := p.location()
+	if err != nil {
+		Applog.Warnf("invalid dnd time zone %v, err: %v", p.TimeZone, err)
+		return false
+	}
+	local := now.In(loc)
+	minute := local.Hour()*60 + local.Minute()
+	for _, w := range p.Windows {
+		if w.contains(minute, local.Weekday()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (w QuietWindow) contains(minute int, weekday time.Weekday) bool {
+	if w.StartMinute == w.EndMinute {
+		return false
+	}
+	if w.StartMinute < w.EndMinute {
+		return minute >= w.StartMinute && minute < w.EndMinute && w.onDay(weekday)
+	}
+	// 跨午夜：午夜之前属于当天的窗口，午夜之后属于前一天的窗口
+	if minute >= w.StartMinute {
+		return w.onDay(weekday)
+	}
+	if minute < w.EndMinute {
+		return w.onDay((weekday + 6) % 7)
+	}
+	return false
+}
+
+func (w QuietWindow) onDay(weekday time.Weekday) bool {
+	if len(w.Weekdays) == 0 {
+		return true
+	}
+	for _, d := range w.Weekdays {
+		if d == weekday {
+			return true
+		}
+	}
+	return false
+}
+
+// DNDPreferenceStore 读取用户的免打扰设置，用户未设置时返回 nil, nil；
+// 返回的设置应当已经调用过 ResolveTimeZone
+type DNDPreferenceStore interface {
+	GetDNDPolicy(ctx context.Context, appName, userId string) (*DNDPolicy, error)
+}
+
+// MemoryDNDPreferenceStore 进程内的免打扰设置
+type MemoryDNDPreferenceStore struct {
+	mu       sync.RWMutex
+	policies map[string]*DNDPolicy
+}
+
+func NewMemoryDNDPreferenceStore() *MemoryDNDPreferenceStore {
+	return &MemoryDNDPreferenceStore{policies: make(map[string]*DNDPolicy)}
+}
+
+func (m *MemoryDNDPreferenceStore) GetDNDPolicy(ctx context.Context, appName, userId string) (*DNDPolicy, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.policies[appName+RedisInterval+userId], nil
+}
+
+// SetDNDPolicy 保存时解析时区，时区无效时返回错误
+func (m *MemoryDNDPreferenceStore) SetDNDPolicy(appName, userId string, policy *DNDPolicy) error {
+	if policy != nil {
+		if err := policy.ResolveTimeZone(); err != nil {
+			return err
+		}
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.policies[appName+RedisInterval+userId] = policy
+	return nil
+}
+
+// activeDNDPolicy 返回当前生效的免打扰设置，读取失败时按未开启处理
+func (s *RouterServer) activeDNDPolicy(ctx context.Context, in *TransferMessageRequest) *DNDPolicy {
+	if s.DNDStore == nil {
+		return nil
+	}
+	policy, err := s.DNDStore.GetDNDPolicy(ctx, in.AppName, in.ReceiverId)
+	if err != nil {
+		Applog.Errorf("get dnd policy err:%+v app: %v uid: %v", err, in.AppName, in.ReceiverId)
+		return nil
+	}
+	if !policy.Active(s.clock()) {
+		return nil
+	}
+	return policy
+}
+
+// applyDND 免打扰期间把推送降级为静默推送，MsgData 不受影响
+func applyDND(push PushContent, policy *DNDPolicy) PushContent {
+	if policy == nil {
+		return push
+	}
+	Metrics.Counter(MetricPushSilencedByDND, 1)
+	if policy.Mode == DNDModeStrip {
+		return PushContent{
+			Silent:     true,
+			CreateTime: push.CreateTime,
+		}
+	}
+	push.Silent = true
+	return push
+}
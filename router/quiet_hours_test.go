@@ -0,0 +1,105 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testNow 是 UTC 周二 22:13，Asia/Shanghai 周三 06:13
+func TestDNDPolicyActive(t *testing.T) {
+	testCases := []struct {
+		name   string
+		policy *DNDPolicy
+		want   bool
+	}{
+		{name: "nil", want: false},
+		{name: "disabled", policy: &DNDPolicy{Windows: []QuietWindow{{StartMinute: 0, EndMinute: 1440}}}},
+		{name: "utc-in-window", policy: &DNDPolicy{Enabled: true, Windows: []QuietWindow{{StartMinute: 22 * 60, EndMinute: 23 * 60}}}, want: true},
+		{name: "utc-end-exclusive", policy: &DNDPolicy{Enabled: true, Windows: []QuietWindow{{StartMinute: 21 * 60, EndMinute: 22*60 + 13}}}},
+		{name: "time-zone", policy: &DNDPolicy{Enabled: true, TimeZone: "Asia/Shanghai", Windows: []QuietWindow{{StartMinute: 6 * 60, EndMinute: 7 * 60}}}, want: true},
+		{name: "cross-midnight-after", policy: &DNDPolicy{Enabled: true, TimeZone: "Asia/Shanghai", Windows: []QuietWindow{{StartMinute: 23 * 60, EndMinute: 7 * 60, Weekdays: []time.Weekday{time.Tuesday}}}}, want: true},
+		{name: "cross-midnight-wrong-day", policy: &DNDPolicy{Enabled: true, TimeZone: "Asia/Shanghai", Windows: []QuietWindow{{StartMinute: 23 * 60, EndMinute: 7 * 60, Weekdays: []time.Weekday{time.Wednesday}}}}},
+		{name: "cross-midnight-before", policy: &DNDPolicy{Enabled: true, Windows: []QuietWindow{{StartMinute: 22 * 60, EndMinute: 60, Weekdays: []time.Weekday{time.Tuesday}}}}, want: true},
+		{name: "empty-window", policy: &DNDPolicy{Enabled: true, Windows: []QuietWindow{{StartMinute: 60, EndMinute: 60}}}},
+		{name: "invalid-time-zone", policy: &DNDPolicy{Enabled: true, TimeZone: "Mars/Base", Windows: []QuietWindow{{StartMinute: 0, EndMinute: 1439}}}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, tc.policy.Active(testNow))
+		})
+	}
+}
+
+func TestDNDPolicyResolveTimeZone(t *testing.T) {
+	testCases := []struct {
+		name     string
+		timeZone string
+		wantLoc  string
+		wantErr  bool
+	}{
+		{name: "default-utc", wantLoc: "UTC"},
+		{name: "iana", timeZone: "Asia/Shanghai", wantLoc: "Asia/Shanghai"},
+		{name: "invalid", timeZone: "Mars/Base", wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			store := NewMemoryDNDPreferenceStore()
+			policy := &DNDPolicy{Enabled: true, TimeZone: tc.timeZone}
+			err := store.SetDNDPolicy("im", "42", policy)
+			got, _ := store.GetDNDPolicy(context.Background(), "im", "42")
+			if tc.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, got)
+				return
+			}
+			assert.NoError(t, err)
+			// 加载时解析一次，之后每条消息直接使用缓存的时区
+			if assert.NotNil(t, got.loc) {
+				assert.Equal(t, tc.wantLoc, got.loc.String())
+			}
+		})
+	}
+	l1, err := loadDNDLocation("Asia/Shanghai")
+	assert.NoError(t, err)
+	l2, _ := loadDNDLocation("Asia/Shanghai")
+	assert.Same(t, l1, l2)
+}
+
+func TestTransferWithDND(t *testing.T) {
+	window := []QuietWindow{{StartMinute: 6 * 60, EndMinute: 7 * 60}}
+	testCases := []struct {
+		name       string
+		policy     *DNDPolicy
+		wantSilent bool
+		wantTitle  bool
+	}{
+		{name: "no-policy", wantTitle: true},
+		{name: "inactive", policy: &DNDPolicy{Enabled: true, Windows: window}, wantTitle: true},
+		{name: "silent", policy: &DNDPolicy{Enabled: true, TimeZone: "Asia/Shanghai", Windows: window}, wantSilent: true, wantTitle: true},
+		{name: "strip", policy: &DNDPolicy{Enabled: true, TimeZone: "Asia/Shanghai", Windows: window, Mode: DNDModeStrip}, wantSilent: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			store := NewMemoryDNDPreferenceStore()
+			assert.NoError(t, store.SetDNDPolicy("im", "42", tc.policy))
+			ts.DNDStore = store
+			in := newTestRequest()
+			in.MsgData = &Any{TypeUrl: "t", Value: []byte(`{}`)}
+
+			_, err := ts.TransferOnlineReliableMessage(context.Background(), in)
+			assert.NoError(t, err)
+			ts.observer.next(t, EventMsgDelivered)
+			if reqs := conn.requests(); assert.Len(t, reqs, 1) {
+				assert.Equal(t, tc.wantSilent, reqs[0].Push.Silent)
+				assert.Equal(t, tc.wantTitle, reqs[0].Push.Title != nil)
+				assert.Equal(t, testNowMs(), reqs[0].Push.CreateTime)
+				assert.Equal(t, in.MsgData, reqs[0].MsgData)
+			}
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package router
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	RateLimitScopeApp      = "app"
+	RateLimitScopeReceiver = "receiver"
+
+	RateLimitKeyPre = "rate_limit_"
+
+	MetricMsgRateLimited = "router_msg_rate_limited"
+	MetricMsgDeferred    = "router_msg_deferred"
+)
+
+// RateLimitedError 请求被限流时返回的错误
+type RateLimitedError struct {
+	Scope string
+	Key   string
+}
+
+func (e *RateLimitedError) Error() string {
+	return fmt.Sprintf("rate limited, scope: %v, key: %v", e.Scope, e.Key)
+}
+
+// IsErrRateLimited 判断 err 是否为限流错误
+func IsErrRateLimited(err error) bool {
+	var rle *RateLimitedError
+	return errors.As(err, &rle)
+}
+
+// RateLimitRule 令牌桶参数，Rate 为每秒补充的令牌数，Rate 为 0 表示不限制
+type RateLimitRule struct {
+	Rate  float64
+	Burst int
+}
+
+type RateLimitConfig struct {
+	PerApp      RateLimitRule
+	PerReceiver RateLimitRule
+	// DeferToStorage 为 true 且开启了消息存储时，被限流的消息只存储不下发，由客户端同步拉取
+	DeferToStorage bool
+}
+
+// RateLimiter 按 app 和接收者限流，store 实现 TokenTaker 时令牌桶存放在 Redis 中以保证多实例一致，
+// 未实现或者 Redis 出错时使用本地令牌桶
+type RateLimiter struct {
+	cfg   RateLimitConfig
+	store RouterRedisClient
+
+	mu    sync.Mutex
+	local map[string]*localTokenBucket
+	// now 本地令牌桶使用的时钟，默认为 time.Now
+	now func() time.Time
+}
+
+func NewRateLimiter(cfg RateLimitConfig, store RouterRedisClient) *RateLimiter {
+	return &RateLimiter{
+		cfg:   cfg,
+		store: store,
+		local: make(map[string]*localTokenBucket),
+		now:   time.Now,
+	}
+}
+
+// Allow 同时检查 app 和接收者两个维度，任一维度超限返回 *RateLimitedError 且两个维度都不消耗令牌
+func (l *RateLimiter) Allow(ctx context.Context, appName, receiverID string) error {
+	return l.allow(ctx, "", appName, receiverID, l.cfg)
+}
+
+// AllowTenant 使用租户自己的限流规则和 key 前缀，租户未配置规则时使用默认规则，
+// tenant 为 nil 时等同于 Allow
+func (l *RateLimiter) AllowTenant(ctx context.Context, tenant *TenantConfig, appName, receiverID string) error {
+	if tenant == nil {
+		return l.Allow(ctx, appName, receiverID)
+	}
+	return l.allow(ctx, tenant.KeyPrefix, appName, receiverID, l.tenantConfig(tenant))
+}
+
+// rateLimitBucket 一个维度的令牌桶，id 为 RateLimitedError.Key
+type rateLimitBucket struct {
+	scope string
+	id    string
+	rule  RateLimitRule
+}
+
+func (l *RateLimiter) allow(ctx context.Context, prefix, appName, receiverID string, cfg RateLimitConfig) error {
+	var scopes []rateLimitBucket
+	var buckets []TokenBucket
+	for _, b := range []rateLimitBucket{
+		{scope: RateLimitScopeApp, id: appName, rule: cfg.PerApp},
+		{scope: RateLimitScopeReceiver, id: appName + RedisInterval + receiverID, rule: cfg.PerReceiver},
+	} {
+		if b.rule.Rate <= 0 {
+			continue
+		}
+		scopes = append(scopes, b)
+		buckets = append(buckets, TokenBucket{Key: prefix + RateLimitKeyPre + b.scope + RedisInterval + b.id, Rate: b.rule.Rate, Burst: b.rule.Burst})
+	}
+	if len(buckets) == 0 {
+		return nil
+	}
+	rejected := l.takeTokens(ctx, buckets)
+	if rejected < 0 {
+		return nil
+	}
+	Metrics.Counter(MetricMsgRateLimited, 1)
+	return &RateLimitedError{Scope: scopes[rejected].scope, Key: scopes[rejected].id}
+}
+
+// takeTokens 返回第一个没有令牌的桶的下标，全部放行时返回 -1
+func (l *RateLimiter) takeTokens(ctx context.Context, buckets []TokenBucket) int {
+	if taker, ok := l.store.(TokenTaker); ok {
+		rejected, err := taker.TakeTokens(ctx, buckets)
+		if err == nil {
+			return rejected
+		}
+		Applog.Warnf("redis take token err:%+v key: %v, fallback to local limiter", err, buckets[0].Key)
+	}
+	return l.takeLocal(buckets, l.now())
+}
+
+func (l *RateLimiter) tenantConfig(tenant *TenantConfig) RateLimitConfig {
+	if tenant != nil && tenant.RateLimit != nil {
+		return *tenant.RateLimit
+	}
+	return l.cfg
+}
+
+func (l *RateLimiter) DeferToStorage() bool {
+	return l.cfg.DeferToStorage
+}
+
+// DeferToStorageFor 租户配置了限流规则时使用租户的 DeferToStorage
+func (l *RateLimiter) DeferToStorageFor(tenant *TenantConfig) bool {
+	return l.tenantConfig(tenant).DeferToStorage
+}
+
+// localBucketSweepSize 本地令牌桶数量超过该值时清理已经回满的桶
+const localBucketSweepSize = 10000
+
+// takeLocal 和 TakeTokens 的语义一致，所有桶都有令牌时才各取一个
+func (l *RateLimiter) takeLocal(buckets []TokenBucket, now time.Time) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if len(l.local) > localBucketSweepSize {
+		for k, b := range l.local {
+			if b.full(now) {
+				delete(l.local, k)
+			}
+		}
+	}
+	local := make([]*localTokenBucket, len(buckets))
+	for i, tb := range buckets {
+		rule := RateLimitRule{Rate: tb.Rate, Burst: tb.Burst}
+		b, ok := l.local[tb.Key]
+		if !ok {
+			b = &localTokenBucket{tokens: float64(burstOf(rule)), last: now}
+			l.local[tb.Key] = b
+		}
+		b.rule = rule
+		b.refill(now)
+		if b.tokens < 1 {
+			return i
+		}
+		local[i] = b
+	}
+	for _, b := range local {
+		b.tokens--
+	}
+	return -1
+}
+
+type localTokenBucket struct {
+	tokens float64
+	last   time.Time
+	rule   RateLimitRule // 最近一次使用的规则，清理时用来判断是否已经回满
+}
+
+func (b *localTokenBucket) refill(now time.Time) {
+	elapsed := now.Sub(b.last).Seconds()
+	if elapsed > 0 {
+		b.tokens += elapsed * b.rule.Rate
+		if max := float64(burstOf(b.rule)); b.tokens > max {
+			b.tokens = max
+		}
+		b.last = now
+	}
+}
+
+func (b *localTokenBucket) full(now time.Time) bool {
+	b.refill(now)
+	return b.tokens >= float64(burstOf(b.rule))
+}
+
+func burstOf(rule RateLimitRule) int {
+	if rule.Burst > 0 {
+		return rule.Burst
+	}
+	return 1
+}
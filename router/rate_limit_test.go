@@ -0,0 +1,167 @@
+package router
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimiterAllow(t *testing.T) {
+	testCases := []struct {
+		name      string
+		cfg       RateLimitConfig
+		calls     []string // 依次请求的 receiver
+		wantScope []string // 每次请求被限流的维度，空字符串表示放行
+	}{
+		{
+			name:      "unlimited",
+			cfg:       RateLimitConfig{},
+			calls:     []string{"1", "1", "1"},
+			wantScope: []string{"", "", ""},
+		},
+		{
+			name:      "per-receiver",
+			cfg:       RateLimitConfig{PerReceiver: RateLimitRule{Rate: 1, Burst: 1}},
+			calls:     []string{"1", "1", "2"},
+			wantScope: []string{"", RateLimitScopeReceiver, ""},
+		},
+		{
+			name:      "per-app-before-receiver",
+			cfg:       RateLimitConfig{PerApp: RateLimitRule{Rate: 1, Burst: 2}, PerReceiver: RateLimitRule{Rate: 1, Burst: 5}},
+			calls:     []string{"1", "2", "3"},
+			wantScope: []string{"", "", RateLimitScopeApp},
+		},
+		{
+			// 被接收者维度拒绝的请求不消耗 app 维度的令牌
+			name:      "receiver-rejection-keeps-app-token",
+			cfg:       RateLimitConfig{PerApp: RateLimitRule{Rate: 1, Burst: 2}, PerReceiver: RateLimitRule{Rate: 1, Burst: 1}},
+			calls:     []string{"1", "1", "2", "3"},
+			wantScope: []string{"", RateLimitScopeReceiver, "", RateLimitScopeApp},
+		},
+	}
+	stores := []struct {
+		name  string
+		store func(t *testing.T) RouterRedisClient
+	}{
+		{name: "redis", store: func(t *testing.T) RouterRedisClient {
+			_, store := newTestRedisStore(t, testNow)
+			return store
+		}},
+		{name: "local", store: func(t *testing.T) RouterRedisClient { return &DefaultRouterRedisClient{} }},
+	}
+	for _, st := range stores {
+		for _, tc := range testCases {
+			t.Run(st.name+"/"+tc.name, func(t *testing.T) {
+				l := NewRateLimiter(tc.cfg, st.store(t))
+				l.now = func() time.Time { return testNow }
+				for i, receiver := range tc.calls {
+					err := l.Allow(context.Background(), "im", receiver)
+					if tc.wantScope[i] == "" {
+						assert.NoError(t, err, "call %d", i)
+						continue
+					}
+					assert.True(t, IsErrRateLimited(err), "call %d", i)
+					if rle, ok := err.(*RateLimitedError); ok {
+						assert.Equal(t, tc.wantScope[i], rle.Scope)
+					}
+				}
+			})
+		}
+	}
+}
+
+func TestRateLimiterLocalFallback(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{PerReceiver: RateLimitRule{Rate: 2, Burst: 1}}, NewRedisStore(errRedisCommander{}))
+	now := testNow
+	l.now = func() time.Time { return now }
+	ctx := context.Background()
+
+	limited := Metrics.Value(MetricMsgRateLimited)
+	assert.NoError(t, l.Allow(ctx, "im", "1"))
+	assert.True(t, IsErrRateLimited(l.Allow(ctx, "im", "1")))
+	assert.Equal(t, limited+1, Metrics.Value(MetricMsgRateLimited))
+
+	now = now.Add(250 * time.Millisecond)
+	assert.True(t, IsErrRateLimited(l.Allow(ctx, "im", "1")))
+	now = now.Add(250 * time.Millisecond)
+	assert.NoError(t, l.Allow(ctx, "im", "1"))
+}
+
+func TestRateLimiterLocalSweep(t *testing.T) {
+	l := NewRateLimiter(RateLimitConfig{}, NewRedisStore(errRedisCommander{}))
+	rule := RateLimitRule{Rate: 1, Burst: 1}
+	for i := 0; i <= localBucketSweepSize; i++ {
+		l.local[string(rune(i))] = &localTokenBucket{tokens: 1, last: testNow}
+	}
+	assert.Equal(t, -1, l.takeLocal([]TokenBucket{{Key: "new", Rate: rule.Rate, Burst: rule.Burst}}, testNow))
+	assert.Len(t, l.local, 1)
+}
+
+func TestRateLimiterTenant(t *testing.T) {
+	mr, store := newTestRedisStore(t, testNow)
+	l := NewRateLimiter(RateLimitConfig{PerReceiver: RateLimitRule{Rate: 1, Burst: 1}}, store)
+	ctx := context.Background()
+	own := &TenantConfig{AppName: "im", KeyPrefix: "{im}", RateLimit: &RateLimitConfig{DeferToStorage: true}}
+	shared := &TenantConfig{AppName: "im", KeyPrefix: "{shared}"}
+
+	// 租户自己的规则不限流
+	for i := 0; i < 3; i++ {
+		assert.NoError(t, l.AllowTenant(ctx, own, "im", "1"))
+	}
+	assert.True(t, l.DeferToStorageFor(own))
+
+	// 使用默认规则时 key 带上租户前缀，和未开启多租户的桶互不影响
+	assert.NoError(t, l.AllowTenant(ctx, shared, "im", "1"))
+	assert.True(t, mr.Exists("{shared}"+RateLimitKeyPre+RateLimitScopeReceiver+"_im_1"))
+	assert.True(t, IsErrRateLimited(l.AllowTenant(ctx, shared, "im", "1")))
+	assert.NoError(t, l.AllowTenant(ctx, nil, "im", "1"))
+	assert.False(t, l.DeferToStorageFor(shared))
+	assert.False(t, l.DeferToStorage())
+}
+
+func TestTransferRateLimited(t *testing.T) {
+	testCases := []struct {
+		name     string
+		defer2db bool
+		store    bool
+		wantErr  bool
+	}{
+		{name: "rejected", wantErr: true},
+		{name: "defer-without-storage-rejected", defer2db: true, wantErr: true},
+		{name: "deferred-to-storage", defer2db: true, store: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withServiceConfig(t, func(c *config) { c.Service.IsStoreReliableMsg = tc.store })
+			_, store := newTestRedisStore(t, testNow)
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			ts.RateLimiter = NewRateLimiter(RateLimitConfig{
+				PerReceiver:    RateLimitRule{Rate: 1, Burst: 1},
+				DeferToStorage: tc.defer2db,
+			}, store)
+			ctx := context.Background()
+			_, err := ts.TransferOnlineReliableMessage(ctx, newTestRequest())
+			assert.NoError(t, err)
+			ts.observer.next(t, EventMsgDelivered)
+
+			in := newTestRequest()
+			in.MsgId = "m2"
+			rpl, err := ts.TransferOnlineReliableMessage(ctx, in)
+			if tc.wantErr {
+				assert.True(t, IsErrRateLimited(err))
+				assert.Nil(t, rpl)
+				return
+			}
+			assert.NoError(t, err)
+			assert.False(t, rpl.IsUserOnline)
+			stored := ts.db.stored()
+			if assert.Len(t, stored, 2) {
+				assert.Equal(t, "m2", stored[1].msgID)
+			}
+			assert.Len(t, conn.requests(), 1)
+		})
+	}
+}
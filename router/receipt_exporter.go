@@ -0,0 +1,565 @@
+package router
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	ReceiptStatusDelivered = "delivered"
+	ReceiptStatusFailed    = "failed"
+	ReceiptStatusSkipped   = "skipped"
+
+	MetricReceiptExported     = "router_receipt_exported"
+	MetricReceiptExportFailed = "router_receipt_export_failed"
+	MetricReceiptDropped      = "router_receipt_dropped"
+)
+
+// DeliveryReceipt 单个设备的投递回执
+type DeliveryReceipt struct {
+	MsgId    string `json:"msg_id"`
+	AppName  string `json:"app_name"`
+	UserId   string `json:"user_id"`
+	DeviceID string `json:"device_id"`
+	Source   string `json:"source,omitempty"`
+	Status   string `json:"status"`
+	Reason   string `json:"reason,omitempty"`
+	Error    string `json:"error,omitempty"`
+	Attempts int    `json:"attempts,omitempty"`
+	Time     int64  `json:"time"`
+}
+
+// ReceiptSink 回执的输出目标，data 为换行分隔的 JSON
+type ReceiptSink interface {
+	Write(ctx context.Context, data []byte) error
+}
+
+type ReceiptExporterConfig struct {
+	BatchSize     int
+	FlushInterval time.Duration
+	// MaxRetries 导出失败后按退避时间重试的次数，用完后等到下一个 FlushInterval 再导出
+	MaxRetries   int
+	RetryBackoff time.Duration // 第 n 次重试前等待 2^(n-1)*RetryBackoff
+	// MaxPending 未成功导出的回执上限，超出后丢弃新回执，0 表示不限制
+	MaxPending int
+	// SpoolDir 不为空时回执先追加写入该目录下的预写日志，sink 确认后才删除，
+	// 进程重启后继续导出未确认的回执
+	SpoolDir string
+}
+
+var DefaultReceiptExporterConfig = ReceiptExporterConfig{
+	BatchSize:     500,
+	FlushInterval: time.Second,
+	MaxRetries:    3,
+	RetryBackoff:  200 * time.Millisecond,
+	MaxPending:    100000,
+}
+
+// ReceiptExporter 实现 RouterObserver，把每个设备的投递结果攒批后导出；
+// 导出失败的回执保留在队列头部，下次继续重试。配置 SpoolDir 后回执在 sink 确认之前保存在预写日志中，
+// 进程退出或者 Stop 时导出失败的回执在重启后继续导出，保证至少一次（可能重复，sink 按 MsgId 和 DeviceID 去重）；
+// 只有队列超过 MaxPending 时丢弃新回执，丢弃时打日志并计数。未配置 SpoolDir 时回执只保存在内存中
+type ReceiptExporter struct {
+	cfg   ReceiptExporterConfig
+	sink  ReceiptSink
+	spool *receiptSpool
+
+	mu      sync.Mutex
+	pending []*pendingReceipt
+	dropped int // 本轮队列满之后丢弃的回执数，队列恢复后打日志并清零
+
+	// failures 连续导出失败的次数，只在 run 中访问
+	failures int
+	// after 重试退避使用的定时器，默认为 time.After
+	after func(d time.Duration) <-chan time.Time
+
+	flushCh chan struct{}
+	stopCh  chan struct{}
+	done    chan struct{}
+}
+
+// pendingReceipt 待导出的回执，seg 为所在的预写日志分段，未写入预写日志时为 -1
+type pendingReceipt struct {
+	r   *DeliveryReceipt
+	seg int64
+}
+
+// NewReceiptExporter 配置了 SpoolDir 时先加载上次未确认的回执
+func NewReceiptExporter(cfg ReceiptExporterConfig, sink ReceiptSink) (*ReceiptExporter, error) {
+	if cfg.BatchSize <= 0 {
+		cfg.BatchSize = DefaultReceiptExporterConfig.BatchSize
+	}
+	if cfg.FlushInterval <= 0 {
+		cfg.FlushInterval = DefaultReceiptExporterConfig.FlushInterval
+	}
+	var spool *receiptSpool
+	var pending []*pendingReceipt
+	if len(cfg.SpoolDir) > 0 {
+		var err error
+		if spool, pending, err = openReceiptSpool(cfg.SpoolDir); err != nil {
+			return nil, err
+		}
+	}
+	e := &ReceiptExporter{
+		spool:   spool,
+		pending: pending,
+		cfg:     cfg,
+		sink:    sink,
+		after:   time.After,
+		flushCh: make(chan struct{}, 1),
+		stopCh:  make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go e.run()
+	return e, nil
+}
+
+func (e *ReceiptExporter) OnRouterEvent(ctx context.Context, ev *RouterEvent) {
+	r := &DeliveryReceipt{
+		MsgId:    ev.MsgId,
+		AppName:  ev.AppName,
+		UserId:   ev.UserId,
+		DeviceID: ev.DeviceID,
+		Source:   ev.Source,
+		Reason:   ev.Reason,
+		Error:    ev.Err,
+		Attempts: ev.Attempts,
+		Time:     ev.Time,
+	}
+	switch ev.Type {
+	case EventMsgDelivered:
+		r.Status = ReceiptStatusDelivered
+	case EventDeliverFailed:
+		r.Status = ReceiptStatusFailed
+	case EventDeviceSkipped:
+		r.Status = ReceiptStatusSkipped
+	default:
+		return
+	}
+	e.mu.Lock()
+	if e.cfg.MaxPending > 0 && len(e.pending) >= e.cfg.MaxPending {
+		e.dropped++
+		first := e.dropped == 1
+		e.mu.Unlock()
+		Metrics.Counter(MetricReceiptDropped, 1)
+		if first {
+			Applog.Errorf("receipt exporter pending queue is full, max pending: %v, dropping new receipts", e.cfg.MaxPending)
+		}
+		return
+	}
+	if e.dropped > 0 {
+		Applog.Warnf("receipt exporter pending queue recovered, dropped receipts: %v", e.dropped)
+		e.dropped = 0
+	}
+	p := &pendingReceipt{r: r, seg: -1}
+	if e.spool != nil {
+		seg, err := e.spool.append(r)
+		if err != nil {
+			// 写入失败时仍然导出，只是重启后无法恢复
+			Applog.Errorf("append delivery receipt to spool err:%+v msgId: %v", err, r.MsgId)
+		} else {
+			p.seg = seg
+		}
+	}
+	e.pending = append(e.pending, p)
+	full := len(e.pending) >= e.cfg.BatchSize
+	e.mu.Unlock()
+	if full {
+		select {
+		case e.flushCh <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Stop 停止定时导出并尝试导出剩余的回执，导出失败的回执保留在预写日志中，未配置 SpoolDir 时被丢弃
+func (e *ReceiptExporter) Stop() {
+	close(e.stopCh)
+	<-e.done
+}
+
+func (e *ReceiptExporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(e.cfg.FlushInterval)
+	defer ticker.Stop()
+	// retry 不为空时处于退避中，退避结束之前不响应定时和攒满触发的导出
+	var retry <-chan time.Time
+	for {
+		select {
+		case <-e.stopCh:
+			if err := e.flush(); err != nil {
+				e.dropPending(err)
+			}
+			if e.spool != nil {
+				e.mu.Lock()
+				e.spool.close()
+				e.mu.Unlock()
+			}
+			return
+		case <-ticker.C:
+			if retry == nil {
+				retry = e.flushOrBackoff()
+			}
+		case <-e.flushCh:
+			if retry == nil {
+				retry = e.flushOrBackoff()
+			}
+		case <-retry:
+			retry = e.flushOrBackoff()
+		}
+	}
+}
+
+// flushOrBackoff 导出失败时返回下一次重试的定时器，重试次数用完后等待下一次定时导出
+func (e *ReceiptExporter) flushOrBackoff() <-chan time.Time {
+	err := e.flush()
+	if err == nil {
+		e.failures = 0
+		return nil
+	}
+	e.failures++
+	if e.failures > e.cfg.MaxRetries {
+		Applog.Errorf("export delivery receipts failed %v times, retry after flush interval, err: %v", e.failures, err)
+		e.failures = 0
+		return nil
+	}
+	return e.after(e.cfg.RetryBackoff << uint(e.failures-1))
+}
+
+// flush 按批导出，任一批失败则停止本轮并返回错误；sink 确认之后才从队列和预写日志中删除
+func (e *ReceiptExporter) flush() error {
+	for {
+		e.mu.Lock()
+		n := len(e.pending)
+		if n > e.cfg.BatchSize {
+			n = e.cfg.BatchSize
+		}
+		batch := e.pending[:n:n]
+		if e.spool != nil && len(batch) > 0 {
+			// 封存当前分段，之后的回执写入新分段，分段中的回执全部确认后才能删除
+			if err := e.spool.seal(); err != nil {
+				Applog.Errorf("seal receipt spool err:%+v", err)
+			}
+		}
+		e.mu.Unlock()
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := e.export(batch); err != nil {
+			Metrics.Counter(MetricReceiptExportFailed, int64(len(batch)))
+			Applog.Errorf("export delivery receipts err:%+v count: %v", err, len(batch))
+			return err
+		}
+		Metrics.Counter(MetricReceiptExported, int64(len(batch)))
+		e.mu.Lock()
+		e.pending = e.pending[len(batch):]
+		if e.spool != nil {
+			if err := e.spool.ack(batch); err != nil {
+				// 分段没有删除，重启后会重复导出
+				Applog.Errorf("remove acked receipt spool err:%+v", err)
+			}
+		}
+		e.mu.Unlock()
+	}
+}
+
+// dropPending Stop 时最后一次导出失败，写入了预写日志的回执在重启后继续导出，其余的回执无法保留
+func (e *ReceiptExporter) dropPending(err error) {
+	e.mu.Lock()
+	n, kept := 0, 0
+	for _, p := range e.pending {
+		if p.seg >= 0 {
+			kept++
+		} else {
+			n++
+		}
+	}
+	e.pending = nil
+	e.mu.Unlock()
+	if kept > 0 {
+		Applog.Warnf("keep delivery receipts in spool on stop, count: %v, err: %v", kept, err)
+	}
+	if n > 0 {
+		Metrics.Counter(MetricReceiptDropped, int64(n))
+		Applog.Errorf("drop delivery receipts on stop, count: %v, err: %v", n, err)
+	}
+}
+
+func (e *ReceiptExporter) export(batch []*pendingReceipt) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, p := range batch {
+		if err := enc.Encode(p.r); err != nil {
+			return err
+		}
+	}
+	return e.sink.Write(context.Background(), buf.Bytes())
+}
+
+// ============== 预写日志 ==============
+
+// receiptSpool 回执的预写日志，每个分段一个文件 <seq>.ndjson，回执追加写入当前分段；
+// 导出前封存当前分段，封存的分段中回执全部被 sink 确认后删除文件。调用方负责加锁
+type receiptSpool struct {
+	dir       string
+	cur       *os.File // 当前分段，第一次写入时创建
+	curSeq    int64
+	remaining map[int64]int // 分段 -> 未确认的回执数
+}
+
+func receiptSpoolFileName(seq int64) string {
+	// 定长的序号保证字典序即写入顺序
+	return fmt.Sprintf("%020d.ndjson", seq)
+}
+
+// openReceiptSpool 按写入顺序加载所有分段中的回执，进程退出时最后一行可能不完整，解析失败的行被跳过
+func openReceiptSpool(dir string) (*receiptSpool, []*pendingReceipt, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, nil, err
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, nil, err
+	}
+	s := &receiptSpool{dir: dir, remaining: make(map[int64]int)}
+	var pending []*pendingReceipt
+	for _, e := range entries {
+		var seq int64
+		if _, err := fmt.Sscanf(e.Name(), "%d.ndjson", &seq); err != nil || e.Name() != receiptSpoolFileName(seq) {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, line := range bytes.Split(data, []byte("\n")) {
+			if len(bytes.TrimSpace(line)) == 0 {
+				continue
+			}
+			r := &DeliveryReceipt{}
+			if err := json.Unmarshal(line, r); err != nil {
+				Applog.Errorf("unmarshal spooled receipt err:%+v file: %v", err, e.Name())
+				continue
+			}
+			pending = append(pending, &pendingReceipt{r: r, seg: seq})
+			s.remaining[seq]++
+		}
+		if s.remaining[seq] == 0 {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+		if seq >= s.curSeq {
+			s.curSeq = seq + 1
+		}
+	}
+	return s, pending, nil
+}
+
+func (s *receiptSpool) append(r *DeliveryReceipt) (int64, error) {
+	raw, err := json.Marshal(r)
+	if err != nil {
+		return -1, err
+	}
+	if s.cur == nil {
+		f, err := os.OpenFile(filepath.Join(s.dir, receiptSpoolFileName(s.curSeq)), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return -1, err
+		}
+		s.cur = f
+	}
+	if _, err := s.cur.Write(append(raw, '\n')); err != nil {
+		return -1, err
+	}
+	s.remaining[s.curSeq]++
+	return s.curSeq, nil
+}
+
+// seal 把当前分段刷到磁盘并关闭，之后的回执写入新分段
+func (s *receiptSpool) seal() error {
+	if s.cur == nil {
+		return nil
+	}
+	err := s.cur.Sync()
+	if cerr := s.cur.Close(); err == nil {
+		err = cerr
+	}
+	s.cur = nil
+	s.curSeq++
+	return err
+}
+
+// ack sink 确认之后调用，删除回执全部确认的封存分段
+func (s *receiptSpool) ack(batch []*pendingReceipt) error {
+	var firstErr error
+	for _, p := range batch {
+		if p.seg < 0 {
+			continue
+		}
+		s.remaining[p.seg]--
+		if s.remaining[p.seg] > 0 || p.seg == s.curSeq {
+			continue
+		}
+		delete(s.remaining, p.seg)
+		if err := os.Remove(filepath.Join(s.dir, receiptSpoolFileName(p.seg))); err != nil && !os.IsNotExist(err) && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+func (s *receiptSpool) close() error {
+	if s.cur == nil {
+		return nil
+	}
+	err := s.cur.Close()
+	s.cur = nil
+	s.curSeq++
+	return err
+}
+
+// ============== HTTP Webhook ==============
+
+// WebhookReceiptSink 以 application/x-ndjson 格式 POST 到 webhook
+type WebhookReceiptSink struct {
+	url     string
+	headers map[string]string
+	client  *http.Client
+}
+
+func NewWebhookReceiptSink(url string, headers map[string]string, timeout time.Duration) *WebhookReceiptSink {
+	return &WebhookReceiptSink{
+		url:     url,
+		headers: headers,
+		client:  &http.Client{Timeout: timeout},
+	}
+}
+
+func (w *WebhookReceiptSink) Write(ctx context.Context, data []byte) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.url, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	for k, v := range w.headers {
+		req.Header.Set(k, v)
+	}
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %v returned status %d", w.url, resp.StatusCode)
+	}
+	return nil
+}
+
+// ============== 本地滚动文件 ==============
+
+// RotatingFileReceiptSink 写入 dir/<prefix>.ndjson，超过 maxBytes 后重命名为带时间戳的文件，
+// 最多保留 maxFiles 个历史文件
+type RotatingFileReceiptSink struct {
+	dir      string
+	prefix   string
+	maxBytes int64
+	maxFiles int
+	// now 历史文件名使用的时钟，默认为 time.Now
+	now func() time.Time
+
+	mu sync.Mutex
+}
+
+func NewRotatingFileReceiptSink(dir, prefix string, maxBytes int64, maxFiles int) (*RotatingFileReceiptSink, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	return &RotatingFileReceiptSink{
+		dir:      dir,
+		prefix:   prefix,
+		maxBytes: maxBytes,
+		maxFiles: maxFiles,
+		now:      time.Now,
+	}, nil
+}
+
+func (f *RotatingFileReceiptSink) currentPath() string {
+	return filepath.Join(f.dir, f.prefix+".ndjson")
+}
+
+func (f *RotatingFileReceiptSink) Write(ctx context.Context, data []byte) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := f.rotateIfNeeded(int64(len(data))); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.currentPath(), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+func (f *RotatingFileReceiptSink) rotateIfNeeded(incoming int64) error {
+	if f.maxBytes <= 0 {
+		return nil
+	}
+	info, err := os.Stat(f.currentPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.Size() == 0 || info.Size()+incoming <= f.maxBytes {
+		return nil
+	}
+	rotated := filepath.Join(f.dir, fmt.Sprintf("%s.%s.ndjson", f.prefix, f.now().Format("20060102150405.000000")))
+	if err := os.Rename(f.currentPath(), rotated); err != nil {
+		return err
+	}
+	return f.removeOldFiles()
+}
+
+func (f *RotatingFileReceiptSink) removeOldFiles() error {
+	if f.maxFiles <= 0 {
+		return nil
+	}
+	entries, err := os.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	var rotated []string
+	for _, e := range entries {
+		name := e.Name()
+		if strings.HasPrefix(name, f.prefix+".") && name != f.prefix+".ndjson" && strings.HasSuffix(name, ".ndjson") {
+			rotated = append(rotated, name)
+		}
+	}
+	// 文件名中的时间戳保证字典序即时间顺序
+	sort.Strings(rotated)
+	for len(rotated) > f.maxFiles {
+		if err := os.Remove(filepath.Join(f.dir, rotated[0])); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
@@ -0,0 +1,346 @@
+package router
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// memReceiptSink 记录写入的数据，errs 依次作为每次 Write 的返回值
+type memReceiptSink struct {
+	mu     sync.Mutex
+	errs   []error
+	writes [][]byte
+	calls  chan struct{}
+}
+
+func newMemReceiptSink(errs ...error) *memReceiptSink {
+	return &memReceiptSink{errs: errs, calls: make(chan struct{}, 64)}
+}
+
+func (s *memReceiptSink) Write(ctx context.Context, data []byte) error {
+	s.mu.Lock()
+	defer func() {
+		s.mu.Unlock()
+		s.calls <- struct{}{}
+	}()
+	if len(s.errs) > 0 {
+		err := s.errs[0]
+		s.errs = s.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	s.writes = append(s.writes, append([]byte(nil), data...))
+	return nil
+}
+
+func (s *memReceiptSink) receipts(t *testing.T) []*DeliveryReceipt {
+	t.Helper()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var res []*DeliveryReceipt
+	for _, data := range s.writes {
+		scanner := bufio.NewScanner(bytes.NewReader(data))
+		for scanner.Scan() {
+			r := &DeliveryReceipt{}
+			assert.NoError(t, json.Unmarshal(scanner.Bytes(), r))
+			res = append(res, r)
+		}
+	}
+	return res
+}
+
+func (s *memReceiptSink) waitCall(t *testing.T) {
+	t.Helper()
+	select {
+	case <-s.calls:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timeout waiting for sink write")
+	}
+}
+
+// manualAfter 替换 ReceiptExporter.after，记录退避时间，由测试触发
+type manualAfter struct {
+	delays chan time.Duration
+	fire   chan time.Time
+}
+
+func newManualAfter() *manualAfter {
+	return &manualAfter{delays: make(chan time.Duration, 16), fire: make(chan time.Time)}
+}
+
+func (m *manualAfter) after(d time.Duration) <-chan time.Time {
+	m.delays <- d
+	return m.fire
+}
+
+func newTestReceiptExporter(t *testing.T, cfg ReceiptExporterConfig, sink ReceiptSink) (*ReceiptExporter, *manualAfter) {
+	t.Helper()
+	// FlushInterval 足够长，测试中只由攒满和退避触发导出
+	cfg.FlushInterval = time.Hour
+	e, err := NewReceiptExporter(cfg, sink)
+	assert.NoError(t, err)
+	m := newManualAfter()
+	e.after = m.after
+	return e, m
+}
+
+func deliveredEvent(msgID string) *RouterEvent {
+	return &RouterEvent{Type: EventMsgDelivered, AppName: "im", UserId: "42", MsgId: msgID, DeviceID: "d1", Attempts: 1, Time: testNowMs()}
+}
+
+func TestReceiptExporterStatus(t *testing.T) {
+	testCases := []struct {
+		name       string
+		ev         *RouterEvent
+		wantStatus string
+	}{
+		{name: "delivered", ev: &RouterEvent{Type: EventMsgDelivered}, wantStatus: ReceiptStatusDelivered},
+		{name: "failed", ev: &RouterEvent{Type: EventDeliverFailed, Err: "boom"}, wantStatus: ReceiptStatusFailed},
+		{name: "skipped", ev: &RouterEvent{Type: EventDeviceSkipped, Reason: SkipReasonMuted}, wantStatus: ReceiptStatusSkipped},
+		{name: "accepted-ignored", ev: &RouterEvent{Type: EventMsgAccepted}},
+		{name: "route-deleted-ignored", ev: &RouterEvent{Type: EventRouteDeleted}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := newMemReceiptSink()
+			e, _ := newTestReceiptExporter(t, ReceiptExporterConfig{BatchSize: 10}, sink)
+			e.OnRouterEvent(context.Background(), tc.ev)
+			e.Stop()
+			receipts := sink.receipts(t)
+			if tc.wantStatus == "" {
+				assert.Empty(t, receipts)
+				return
+			}
+			if assert.Len(t, receipts, 1) {
+				assert.Equal(t, tc.wantStatus, receipts[0].Status)
+				assert.Equal(t, tc.ev.Err, receipts[0].Error)
+				assert.Equal(t, tc.ev.Reason, receipts[0].Reason)
+			}
+		})
+	}
+}
+
+func TestReceiptExporterBatch(t *testing.T) {
+	sink := newMemReceiptSink()
+	e, _ := newTestReceiptExporter(t, ReceiptExporterConfig{BatchSize: 2}, sink)
+	exported := Metrics.Value(MetricReceiptExported)
+
+	e.OnRouterEvent(context.Background(), deliveredEvent("m1"))
+	e.OnRouterEvent(context.Background(), deliveredEvent("m2"))
+	sink.waitCall(t)
+	e.OnRouterEvent(context.Background(), deliveredEvent("m3"))
+	e.Stop()
+
+	sink.mu.Lock()
+	assert.Len(t, sink.writes, 2)
+	sink.mu.Unlock()
+	receipts := sink.receipts(t)
+	if assert.Len(t, receipts, 3) {
+		assert.Equal(t, "m1", receipts[0].MsgId)
+		assert.Equal(t, testNowMs(), receipts[0].Time)
+		assert.Equal(t, "m3", receipts[2].MsgId)
+	}
+	assert.Equal(t, exported+3, Metrics.Value(MetricReceiptExported))
+}
+
+func TestReceiptExporterRetryBackoff(t *testing.T) {
+	errDown := errors.New("sink down")
+	testCases := []struct {
+		name       string
+		errs       []error
+		wantDelays []time.Duration
+		wantKept   bool // 重试次数用完后回执保留在队列中
+	}{
+		{name: "recovered", errs: []error{errDown, errDown}, wantDelays: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}},
+		{name: "give-up-until-next-interval", errs: []error{errDown, errDown, errDown}, wantDelays: []time.Duration{100 * time.Millisecond, 200 * time.Millisecond}, wantKept: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := newMemReceiptSink(tc.errs...)
+			e, clock := newTestReceiptExporter(t, ReceiptExporterConfig{BatchSize: 1, MaxRetries: 2, RetryBackoff: 100 * time.Millisecond}, sink)
+
+			e.OnRouterEvent(context.Background(), deliveredEvent("m1"))
+			sink.waitCall(t)
+			for _, want := range tc.wantDelays {
+				// 退避期间 run 不阻塞在 sleep 中，由定时器触发下一次导出
+				assert.Equal(t, want, <-clock.delays)
+				clock.fire <- testNow
+				sink.waitCall(t)
+			}
+			if tc.wantKept {
+				// 攒满触发的导出重新开始计数
+				e.OnRouterEvent(context.Background(), deliveredEvent("m2"))
+				sink.waitCall(t)
+			}
+			e.Stop()
+			var ids []string
+			for _, r := range sink.receipts(t) {
+				ids = append(ids, r.MsgId)
+			}
+			if tc.wantKept {
+				assert.Equal(t, []string{"m1", "m2"}, ids)
+				return
+			}
+			assert.Equal(t, []string{"m1"}, ids)
+		})
+	}
+}
+
+func TestReceiptExporterDrops(t *testing.T) {
+	t.Run("max-pending", func(t *testing.T) {
+		sink := newMemReceiptSink()
+		e, clock := newTestReceiptExporter(t, ReceiptExporterConfig{BatchSize: 10, MaxPending: 2}, sink)
+		dropped := Metrics.Value(MetricReceiptDropped)
+		for _, id := range []string{"m1", "m2", "m3", "m4"} {
+			e.OnRouterEvent(context.Background(), deliveredEvent(id))
+		}
+		assert.Equal(t, dropped+2, Metrics.Value(MetricReceiptDropped))
+		e.mu.Lock()
+		assert.Len(t, e.pending, 2)
+		assert.Equal(t, 2, e.dropped)
+		e.mu.Unlock()
+		e.Stop()
+		assert.Empty(t, clock.delays)
+		assert.Len(t, sink.receipts(t), 2)
+	})
+	t.Run("stop-flush-failed", func(t *testing.T) {
+		sink := newMemReceiptSink(errors.New("sink down"))
+		e, _ := newTestReceiptExporter(t, ReceiptExporterConfig{BatchSize: 10}, sink)
+		dropped := Metrics.Value(MetricReceiptDropped)
+		e.OnRouterEvent(context.Background(), deliveredEvent("m1"))
+		e.OnRouterEvent(context.Background(), deliveredEvent("m2"))
+		e.Stop()
+		assert.Equal(t, dropped+2, Metrics.Value(MetricReceiptDropped))
+		assert.Empty(t, sink.receipts(t))
+		assert.Empty(t, e.pending)
+	})
+}
+
+func TestReceiptExporterSpoolRestart(t *testing.T) {
+	dir := t.TempDir()
+	cfg := ReceiptExporterConfig{BatchSize: 10, SpoolDir: dir}
+	dropped := Metrics.Value(MetricReceiptDropped)
+
+	// sink 不可用时 Stop，回执保留在预写日志中
+	down := newMemReceiptSink(errors.New("sink down"))
+	e, _ := newTestReceiptExporter(t, cfg, down)
+	e.OnRouterEvent(context.Background(), deliveredEvent("m1"))
+	e.OnRouterEvent(context.Background(), deliveredEvent("m2"))
+	e.Stop()
+	assert.Empty(t, down.receipts(t))
+	assert.Equal(t, dropped, Metrics.Value(MetricReceiptDropped))
+	files, err := os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Len(t, files, 1)
+
+	// 重启后先导出上次未确认的回执，sink 确认后删除预写日志
+	sink := newMemReceiptSink()
+	e, _ = newTestReceiptExporter(t, cfg, sink)
+	e.OnRouterEvent(context.Background(), deliveredEvent("m3"))
+	e.Stop()
+	var ids []string
+	for _, r := range sink.receipts(t) {
+		ids = append(ids, r.MsgId)
+	}
+	assert.Equal(t, []string{"m1", "m2", "m3"}, ids)
+	files, err = os.ReadDir(dir)
+	assert.NoError(t, err)
+	assert.Empty(t, files)
+}
+
+func TestReceiptExporterSpoolPartialAck(t *testing.T) {
+	dir := t.TempDir()
+	// 第二批和 Stop 时的导出失败，已确认的分段被删除，未确认的分段保留
+	errDown := errors.New("sink down")
+	sink := newMemReceiptSink(nil, errDown, errDown)
+	e, _ := newTestReceiptExporter(t, ReceiptExporterConfig{BatchSize: 1, SpoolDir: dir}, sink)
+	e.OnRouterEvent(context.Background(), deliveredEvent("m1"))
+	sink.waitCall(t)
+	e.OnRouterEvent(context.Background(), deliveredEvent("m2"))
+	sink.waitCall(t)
+	e.Stop()
+
+	e, _ = newTestReceiptExporter(t, ReceiptExporterConfig{BatchSize: 10, SpoolDir: dir}, newMemReceiptSink())
+	e.mu.Lock()
+	if assert.Len(t, e.pending, 1) {
+		assert.Equal(t, "m2", e.pending[0].r.MsgId)
+	}
+	e.mu.Unlock()
+	e.Stop()
+}
+
+// roundTripFunc 替换 http.Client 的 Transport，不访问网络
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+func TestWebhookReceiptSink(t *testing.T) {
+	testCases := []struct {
+		name    string
+		status  int
+		wantErr bool
+	}{
+		{name: "ok", status: http.StatusOK},
+		{name: "accepted", status: http.StatusAccepted},
+		{name: "server-error", status: http.StatusInternalServerError, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			sink := NewWebhookReceiptSink("http://receipts.example/hook", map[string]string{"Authorization": "Bearer t"}, time.Second)
+			var got *http.Request
+			var body []byte
+			sink.client.Transport = roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				got = r
+				body, _ = io.ReadAll(r.Body)
+				return &http.Response{StatusCode: tc.status, Body: io.NopCloser(bytes.NewReader(nil))}, nil
+			})
+			err := sink.Write(context.Background(), []byte("{}\n"))
+			assert.Equal(t, tc.wantErr, err != nil)
+			assert.Equal(t, http.MethodPost, got.Method)
+			assert.Equal(t, "application/x-ndjson", got.Header.Get("Content-Type"))
+			assert.Equal(t, "Bearer t", got.Header.Get("Authorization"))
+			assert.Equal(t, "{}\n", string(body))
+		})
+	}
+}
+
+func TestRotatingFileReceiptSink(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewRotatingFileReceiptSink(dir, "receipts", 8, 2)
+	assert.NoError(t, err)
+	now := testNow
+	sink.now = func() time.Time {
+		now = now.Add(time.Second)
+		return now
+	}
+	ctx := context.Background()
+	for _, line := range []string{"aaaaa\n", "bbbbb\n", "ccccc\n", "ddddd\n"} {
+		assert.NoError(t, sink.Write(ctx, []byte(line)))
+	}
+
+	current, err := os.ReadFile(filepath.Join(dir, "receipts.ndjson"))
+	assert.NoError(t, err)
+	assert.Equal(t, "ddddd\n", string(current))
+	rotated, err := filepath.Glob(filepath.Join(dir, "receipts.*.ndjson"))
+	assert.NoError(t, err)
+	// 最多保留 2 个历史文件，最早的 aaaaa 被删除
+	if assert.Len(t, rotated, 2) {
+		first, _ := os.ReadFile(rotated[0])
+		assert.Equal(t, "bbbbb\n", string(first))
+	}
+}
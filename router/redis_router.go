@@ -0,0 +1,244 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+/*
+	路由表 key 布局（HCAD/HCADSR 按同样的布局做比较删除）：
+		主路由: router_<appID>_<userId>          field: <deviceID>_<source>  value: RouteInfo json
+		二级路由: router_sr_<appID>_<deviceID>    field: <source>             value: RouteInfo json
+	二级路由只为登录用户维护，用于从设备反查当前登录的用户
+	开启多租户时所有 key 加上租户的 KeyPrefix
+*/
+
+const (
+	RouteKeyPre          = "router_"
+	RouteSRKeyPre        = "router_sr_"
+	AnonymousUserIDStr   = "0"
+	DefaultRouteTTL      = 5 * time.Minute
+	RouteFilterSource    = "source"
+	RouteFilterLocale    = "locale"
+	RouteFilterPlatform  = "platform"
+	RoutePlatformAndroid = "android"
+	RoutePlatformIOS     = "ios"
+)
+
+var RouteNotFoundErr = errors.New("route not found")
+var RouteUAMissingErr = errors.New("route user agent is empty")
+
+func RouteKey(appID, userId string) string {
+	return RouteKeyPre + appID + RedisInterval + userId
+}
+
+func RouteSRKey(appID, deviceID string) string {
+	return RouteSRKeyPre + appID + RedisInterval + deviceID
+}
+
+func RouteField(deviceID, source string) string {
+	return deviceID + RedisInterval + source
+}
+
+// RouteInfo 一条设备路由
+type RouteInfo struct {
+	UserID   string
+	DeviceID string
+	Source   string
+	Addr     string // connector 地址
+	Locale   string
+	UA       *UserAgent
+	Extra    map[string]string // 其它可用于 filters 匹配的属性
+	ExpireAt int64             // 毫秒时间戳，过期的路由视为设备离线
+}
+
+// RouteRedisClient 路由表需要的 Redis hash 操作
+type RouteRedisClient interface {
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	// SetRoute 原子地写入路由并刷新 key 的过期时间，srKey 为空时只写主路由
+	SetRoute(ctx context.Context, key, field, srKey, srField, value string, ttl time.Duration) error
+	// RefreshRoute 路由存在、未过期（ExpireAt 晚于 now 毫秒）并且仍指向 addr 时原子地延长 ttl，返回是否延长
+	RefreshRoute(ctx context.Context, key, field, srKey, srField, addr string, now int64, ttl time.Duration) (bool, error)
+	// PruneRoutes 删除 fields 中 ExpireAt 不晚于 now（毫秒）的路由，返回删除的数量
+	PruneRoutes(ctx context.Context, key string, now int64, fields ...string) (int64, error)
+}
+
+// ConnectorResolver 根据 connector 地址获取可用的 ConnectorClient
+type ConnectorResolver interface {
+	Resolve(addr string) (ConnectorClient, error)
+}
+
+// RedisRouter 基于 Redis 的路由表，替代 DefaultRouter
+type RedisRouter struct {
+	store    RouterRedisClient
+	hash     RouteRedisClient
+	resolver ConnectorResolver
+	ttl      time.Duration
+	// Tenants 可选，按租户给路由 key 加前缀，需要和 RedisStore.Tenants 保持一致
+	Tenants *TenantRegistry
+	now     func() time.Time
+}
+
+func NewRedisRouter(store RouterRedisClient, hash RouteRedisClient, resolver ConnectorResolver, ttl time.Duration) *RedisRouter {
+	if ttl <= 0 {
+		ttl = DefaultRouteTTL
+	}
+	return &RedisRouter{
+		store:    store,
+		hash:     hash,
+		resolver: resolver,
+		ttl:      ttl,
+		now:      time.Now,
+	}
+}
+
+func (r *RedisRouter) routeKey(appID, userId string) string {
+	return r.Tenants.KeyPrefix(appID) + RouteKey(appID, userId)
+}
+
+func (r *RedisRouter) routeSRKey(appID, deviceID string) string {
+	return r.Tenants.KeyPrefix(appID) + RouteSRKey(appID, deviceID)
+}
+
+// Register 注册或覆盖设备路由，并刷新整个路由 key 的过期时间，不修改调用方的 info；
+// 下发时按 UA 判断平台和版本，UA 为空时返回 RouteUAMissingErr
+func (r *RedisRouter) Register(ctx context.Context, appID string, info *RouteInfo) error {
+	if info == nil || info.UA == nil {
+		return RouteUAMissingErr
+	}
+	route := *info
+	route.ExpireAt = r.now().Add(r.ttl).UnixNano() / 1000000
+	raw, err := json.Marshal(&route)
+	if err != nil {
+		return err
+	}
+	srKey := ""
+	if route.UserID != AnonymousUserIDStr {
+		srKey = r.routeSRKey(appID, route.DeviceID)
+	}
+	return r.hash.SetRoute(ctx, r.routeKey(appID, route.UserID), RouteField(route.DeviceID, route.Source), srKey, route.Source, string(raw), r.ttl)
+}
+
+// Refresh 由 addr 对应的 connector 调用，原子地延长路由的存活时间；
+// 路由不存在、已过期或者已经迁移到其它 connector 时返回 RouteNotFoundErr，需要重新 Register
+func (r *RedisRouter) Refresh(ctx context.Context, appID, userId, deviceID, source, addr string) error {
+	srKey := ""
+	if userId != AnonymousUserIDStr {
+		srKey = r.routeSRKey(appID, deviceID)
+	}
+	now := r.now().UnixNano() / 1000000
+	ok, err := r.hash.RefreshRoute(ctx, r.routeKey(appID, userId), RouteField(deviceID, source), srKey, source, addr, now, r.ttl)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return RouteNotFoundErr
+	}
+	return nil
+}
+
+// Unregister 删除设备路由，addr 非空时只有路由仍指向该 connector 才删除
+func (r *RedisRouter) Unregister(ctx context.Context, appID, userId, deviceID, source, addr string) (int64, error) {
+	if userId == AnonymousUserIDStr {
+		return r.store.HCAD(ctx, appID, userId, deviceID, source, addr)
+	}
+	return r.store.HCADSR(ctx, appID, userId, deviceID, source, addr)
+}
+
+// ListRoutes 返回用户未过期的全部路由，并清理已过期的字段
+func (r *RedisRouter) ListRoutes(ctx context.Context, appID, userId string) ([]*RouteInfo, error) {
+	key := r.routeKey(appID, userId)
+	routes, err := r.hash.HGetAll(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	now := r.now().UnixNano() / 1000000
+	infos := make([]*RouteInfo, 0, len(routes))
+	var stale []string
+	for field, raw := range routes {
+		var info RouteInfo
+		if err := json.Unmarshal([]byte(raw), &info); err != nil {
+			Applog.Errorf("unmarshal route err:%+v key: %v field: %v", err, key, field)
+			continue
+		}
+		if info.ExpireAt > 0 && info.ExpireAt <= now {
+			stale = append(stale, field)
+			continue
+		}
+		infos = append(infos, &info)
+	}
+	if len(stale) > 0 {
+		// 清理失败不影响本次读取，下次读取时会再次清理
+		if _, err := r.hash.PruneRoutes(ctx, key, now, stale...); err != nil {
+			Applog.Warnf("prune expired routes err:%+v key: %v", err, key)
+		}
+	}
+	return infos, nil
+}
+
+func (r *RedisRouter) PickConnectors(ctx context.Context, appName, userID, deviceIdentifier string, filters map[string]string) []*ConnectorClientWrapper {
+	routes, err := r.ListRoutes(ctx, appName, userID)
+	if err != nil {
+		Applog.Errorf("list routes err:%+v app: %v uid: %v", err, appName, userID)
+		return nil
+	}
+	var wrappers []*ConnectorClientWrapper
+	for _, info := range routes {
+		if deviceIdentifier != "" && info.DeviceID != deviceIdentifier {
+			continue
+		}
+		if !matchRouteFilters(info, filters) {
+			continue
+		}
+		conn, err := r.resolver.Resolve(info.Addr)
+		if err != nil {
+			Applog.Errorf("resolve connector err:%+v addr: %v deviceID: %v", err, info.Addr, info.DeviceID)
+			continue
+		}
+		wrappers = append(wrappers, &ConnectorClientWrapper{
+			DeviceID:  info.DeviceID,
+			Locale:    info.Locale,
+			Source:    info.Source,
+			Addr:      info.Addr,
+			UA:        info.UA,
+			Connector: conn,
+		})
+	}
+	return wrappers
+}
+
+// matchRouteFilters 所有 filter 都匹配才返回 true，未知的 filter 按 Extra 属性匹配
+func matchRouteFilters(info *RouteInfo, filters map[string]string) bool {
+	for k, v := range filters {
+		var actual string
+		switch k {
+		case RouteFilterSource:
+			actual = info.Source
+		case RouteFilterLocale:
+			actual = info.Locale
+		case RouteFilterPlatform:
+			actual = routePlatform(info.UA)
+		default:
+			actual = info.Extra[k]
+		}
+		if actual != v {
+			return false
+		}
+	}
+	return true
+}
+
+func routePlatform(ua *UserAgent) string {
+	if ua == nil {
+		return ""
+	}
+	switch ua.Source {
+	case CLIENT_SOURCE_ANDROID:
+		return RoutePlatformAndroid
+	case CLIENT_SOURCE_IOS:
+		return RoutePlatformIOS
+	}
+	return ""
+}
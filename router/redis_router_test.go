@@ -0,0 +1,265 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeResolver 按地址返回 fakeConnector，未登记的地址返回错误
+type fakeResolver map[string]*fakeConnector
+
+func (r fakeResolver) Resolve(addr string) (ConnectorClient, error) {
+	c, ok := r[addr]
+	if !ok {
+		return nil, errors.New("unknown connector " + addr)
+	}
+	return c, nil
+}
+
+func newTestRedisRouter(t *testing.T, now time.Time, resolver ConnectorResolver) (*miniredis.Miniredis, *RedisRouter) {
+	t.Helper()
+	mr, store := newTestRedisStore(t, now)
+	r := NewRedisRouter(store, store, resolver, time.Minute)
+	r.now = func() time.Time { return now }
+	return mr, r
+}
+
+func decodeRoute(t *testing.T, raw string) *RouteInfo {
+	t.Helper()
+	var info RouteInfo
+	assert.NoError(t, json.Unmarshal([]byte(raw), &info))
+	return &info
+}
+
+var testRouteUA = &UserAgent{Source: CLIENT_SOURCE_IOS, AppVersion: "1.0.0"}
+
+func TestRedisRouterRegister(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	testCases := []struct {
+		name   string
+		userID string
+		wantSR bool
+	}{
+		{name: "login-user-writes-sr", userID: "42", wantSR: true},
+		{name: "anonymous-skips-sr", userID: AnonymousUserIDStr, wantSR: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr, r := newTestRedisRouter(t, now, fakeResolver{})
+			info := &RouteInfo{UserID: tc.userID, DeviceID: "d1", Source: "ios", Addr: "10.0.0.1:80", UA: testRouteUA}
+			assert.NoError(t, r.Register(context.Background(), "im", info))
+
+			// 调用方的 info 不被修改
+			assert.Equal(t, int64(0), info.ExpireAt)
+
+			key := RouteKey("im", tc.userID)
+			stored := decodeRoute(t, mr.HGet(key, RouteField("d1", "ios")))
+			assert.Equal(t, now.Add(time.Minute).UnixNano()/1000000, stored.ExpireAt)
+			assert.Equal(t, time.Minute, mr.TTL(key))
+
+			srKey := RouteSRKey("im", "d1")
+			assert.Equal(t, tc.wantSR, mr.Exists(srKey))
+			if tc.wantSR {
+				assert.Equal(t, time.Minute, mr.TTL(srKey))
+				assert.Equal(t, tc.userID, decodeRoute(t, mr.HGet(srKey, "ios")).UserID)
+			}
+		})
+	}
+}
+
+func TestRedisRouterRegisterError(t *testing.T) {
+	r := NewRedisRouter(NewRedisStore(errRedisCommander{}), NewRedisStore(errRedisCommander{}), fakeResolver{}, 0)
+	err := r.Register(context.Background(), "im", &RouteInfo{UserID: "42", DeviceID: "d1", Source: "ios", UA: testRouteUA})
+	assert.Equal(t, errTestRedisDown, err)
+	// 没有 UA 的路由在写入 Redis 之前被拒绝
+	assert.Equal(t, RouteUAMissingErr, r.Register(context.Background(), "im", &RouteInfo{UserID: "42", DeviceID: "d1", Source: "ios"}))
+	assert.Equal(t, DefaultRouteTTL, r.ttl)
+}
+
+func TestRedisRouterListRoutesPrunesExpired(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	nowMs := now.UnixNano() / 1000000
+	mr, r := newTestRedisRouter(t, now, fakeResolver{})
+	key := RouteKey("im", "42")
+	mr.HSet(key, RouteField("live", "ios"), routeJSON(t, &RouteInfo{UserID: "42", DeviceID: "live", ExpireAt: nowMs + 1}))
+	mr.HSet(key, RouteField("stale", "ios"), routeJSON(t, &RouteInfo{UserID: "42", DeviceID: "stale", ExpireAt: nowMs}))
+	mr.HSet(key, RouteField("forever", "ios"), routeJSON(t, &RouteInfo{UserID: "42", DeviceID: "forever"}))
+	mr.HSet(key, RouteField("broken", "ios"), "{")
+
+	routes, err := r.ListRoutes(context.Background(), "im", "42")
+	assert.NoError(t, err)
+	devices := make([]string, 0, len(routes))
+	for _, info := range routes {
+		devices = append(devices, info.DeviceID)
+	}
+	assert.ElementsMatch(t, []string{"live", "forever"}, devices)
+
+	fields, err := mr.HKeys(key)
+	assert.NoError(t, err)
+	assert.ElementsMatch(t, []string{RouteField("live", "ios"), RouteField("forever", "ios"), RouteField("broken", "ios")}, fields)
+}
+
+func TestRedisStorePruneRoutes(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expireAt int64
+		pruned   int64
+	}{
+		{name: "expired", expireAt: 1000, pruned: 1},
+		{name: "refreshed-after-read", expireAt: 3000, pruned: 0},
+		{name: "no-expire", expireAt: 0, pruned: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr, store := newTestRedisStore(t, time.Unix(1700000000, 0))
+			mr.HSet("routes", "f", routeJSON(t, &RouteInfo{DeviceID: "d1", ExpireAt: tc.expireAt}))
+			n, err := store.PruneRoutes(context.Background(), "routes", 2000, "f", "missing")
+			assert.NoError(t, err)
+			assert.Equal(t, tc.pruned, n)
+			assert.Equal(t, tc.pruned == 0, mr.HGet("routes", "f") != "")
+		})
+	}
+}
+
+func TestRedisRouterRefresh(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	testCases := []struct {
+		name     string
+		register bool
+		after    time.Duration
+		addr     string
+		srOwner  string // 不为空时二级路由在刷新前被该用户覆盖
+		wantErr  error
+	}{
+		{name: "extended", register: true, after: 30 * time.Second, addr: "10.0.0.1:80"},
+		{name: "not-found", addr: "10.0.0.1:80", wantErr: RouteNotFoundErr},
+		{name: "expired", register: true, after: time.Minute, addr: "10.0.0.1:80", wantErr: RouteNotFoundErr},
+		{name: "moved-to-other-connector", register: true, after: 30 * time.Second, addr: "10.0.0.2:80", wantErr: RouteNotFoundErr},
+		{name: "sr-owned-by-other-user", register: true, after: 30 * time.Second, addr: "10.0.0.1:80", srOwner: "43"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr, r := newTestRedisRouter(t, start, fakeResolver{})
+			ctx := context.Background()
+			key, srKey := RouteKey("im", "42"), RouteSRKey("im", "d1")
+			if tc.register {
+				assert.NoError(t, r.Register(ctx, "im", &RouteInfo{UserID: "42", DeviceID: "d1", Source: "ios", Addr: "10.0.0.1:80", UA: testRouteUA}))
+			}
+			if len(tc.srOwner) > 0 {
+				mr.HSet(srKey, "ios", routeJSON(t, &RouteInfo{UserID: tc.srOwner, DeviceID: "d1", Source: "ios", Addr: "10.0.0.1:80", UA: testRouteUA}))
+			}
+			before := mr.HGet(key, RouteField("d1", "ios"))
+			later := start.Add(tc.after)
+			r.now = func() time.Time { return later }
+
+			err := r.Refresh(ctx, "im", "42", "d1", "ios", tc.addr)
+			assert.Equal(t, tc.wantErr, err)
+			if tc.wantErr != nil {
+				// 失败时不修改路由
+				assert.Equal(t, before, mr.HGet(key, RouteField("d1", "ios")))
+				return
+			}
+			stored := decodeRoute(t, mr.HGet(key, RouteField("d1", "ios")))
+			assert.Equal(t, later.Add(time.Minute).UnixNano()/1000000, stored.ExpireAt)
+			assert.Equal(t, "10.0.0.1:80", stored.Addr)
+			assert.Equal(t, testRouteUA, stored.UA)
+			assert.Equal(t, time.Minute, mr.TTL(key))
+
+			sr := decodeRoute(t, mr.HGet(srKey, "ios"))
+			if len(tc.srOwner) > 0 {
+				assert.Equal(t, tc.srOwner, sr.UserID)
+				return
+			}
+			assert.Equal(t, stored.ExpireAt, sr.ExpireAt)
+			assert.Equal(t, time.Minute, mr.TTL(srKey))
+		})
+	}
+}
+
+func TestRedisRouterUnregister(t *testing.T) {
+	testCases := []struct {
+		name    string
+		userID  string
+		addr    string
+		deleted int64
+	}{
+		{name: "login-user", userID: "42", addr: "10.0.0.1:80", deleted: 2},
+		{name: "anonymous", userID: AnonymousUserIDStr, addr: "", deleted: 1},
+		{name: "moved-connector", userID: "42", addr: "10.0.0.2:80", deleted: 0},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, r := newTestRedisRouter(t, time.Unix(1700000000, 0), fakeResolver{})
+			ctx := context.Background()
+			assert.NoError(t, r.Register(ctx, "im", &RouteInfo{UserID: tc.userID, DeviceID: "d1", Source: "ios", Addr: "10.0.0.1:80", UA: testRouteUA}))
+			n, err := r.Unregister(ctx, "im", tc.userID, "d1", "ios", tc.addr)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.deleted, n)
+		})
+	}
+}
+
+func TestRedisRouterPickConnectors(t *testing.T) {
+	c1 := &fakeConnector{addr: "10.0.0.1:80"}
+	c2 := &fakeConnector{addr: "10.0.0.2:80"}
+	resolver := fakeResolver{c1.addr: c1, c2.addr: c2}
+	routes := []*RouteInfo{
+		{UserID: "42", DeviceID: "ios1", Source: "ios", Addr: c1.addr, Locale: "en", UA: &UserAgent{Source: CLIENT_SOURCE_IOS}},
+		{UserID: "42", DeviceID: "and1", Source: "android", Addr: c2.addr, Locale: "zh", UA: &UserAgent{Source: CLIENT_SOURCE_ANDROID}},
+		{UserID: "42", DeviceID: "gone", Source: "web", Addr: "10.0.0.9:80", UA: &UserAgent{}},
+	}
+	testCases := []struct {
+		name    string
+		device  string
+		filters map[string]string
+		want    []string
+	}{
+		{name: "all-resolvable", want: []string{"ios1", "and1"}},
+		{name: "by-device", device: "and1", want: []string{"and1"}},
+		{name: "by-platform", filters: map[string]string{RouteFilterPlatform: RoutePlatformIOS}, want: []string{"ios1"}},
+		{name: "by-locale", filters: map[string]string{RouteFilterLocale: "zh"}, want: []string{"and1"}},
+		{name: "no-match", filters: map[string]string{RouteFilterSource: "web", "region": "eu"}, want: []string{}},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, r := newTestRedisRouter(t, time.Unix(1700000000, 0), resolver)
+			ctx := context.Background()
+			for _, info := range routes {
+				assert.NoError(t, r.Register(ctx, "im", info))
+			}
+			got := []string{}
+			for _, w := range r.PickConnectors(ctx, "im", "42", tc.device, tc.filters) {
+				got = append(got, w.DeviceID)
+				assert.NotNil(t, w.Connector)
+			}
+			assert.ElementsMatch(t, tc.want, got)
+		})
+	}
+}
+
+func TestMatchRouteFilters(t *testing.T) {
+	info := &RouteInfo{Source: "ios", Locale: "en", UA: &UserAgent{Source: CLIENT_SOURCE_IOS}, Extra: map[string]string{"region": "eu"}}
+	testCases := []struct {
+		name    string
+		filters map[string]string
+		want    bool
+	}{
+		{name: "empty", filters: nil, want: true},
+		{name: "source", filters: map[string]string{RouteFilterSource: "ios"}, want: true},
+		{name: "extra", filters: map[string]string{"region": "eu"}, want: true},
+		{name: "extra-mismatch", filters: map[string]string{"region": "us"}, want: false},
+		{name: "platform-mismatch", filters: map[string]string{RouteFilterPlatform: RoutePlatformAndroid}, want: false},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, matchRouteFilters(info, tc.filters))
+		})
+	}
+	assert.Equal(t, "", routePlatform(nil))
+}
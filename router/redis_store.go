@@ -0,0 +1,299 @@
+package router
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/gangcheng1030/ai_testing_and_refactoring/go_redis_test"
+)
+
+// RedisCommander RedisStore 依赖的 Redis 命令，
+// *go_redis_test.RedisClient 实现了该接口，也可以替换成进程内的 Redis 替身
+type RedisCommander interface {
+	HSet(ctx context.Context, key, field string, value interface{}) error
+	HGetAll(ctx context.Context, key string) (map[string]string, error)
+	Expire(ctx context.Context, key string, expiration time.Duration) (bool, error)
+	Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error)
+}
+
+var _ RedisCommander = (*go_redis_test.RedisClient)(nil)
+
+var _ NonceRecorder = (*RedisStore)(nil)
+var _ TokenTaker = (*RedisStore)(nil)
+
+// genSequenceScript INCR 与 EXPIRE 在同一脚本中执行，避免 key 没有过期时间
+const genSequenceScript = `
+local v = redis.call('INCR', KEYS[1])
+if v == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[1])
+end
+return v
+`
+
+// compareAndDeleteLua 比较并删除 hash 字段：addr 非空时要求路由仍指向该 connector，
+// uid 非空时要求路由属于该用户
+const compareAndDeleteLua = `
+local function cad(key, field, addr, uid)
+	local v = redis.call('HGET', key, field)
+	if not v then
+		return 0
+	end
+	if addr ~= '' or uid ~= '' then
+		local ok, info = pcall(cjson.decode, v)
+		if not ok then
+			return 0
+		end
+		if addr ~= '' and info['Addr'] ~= addr then
+			return 0
+		end
+		if uid ~= '' and info['UserID'] ~= uid then
+			return 0
+		end
+	end
+	return redis.call('HDEL', key, field)
+end
+`
+
+// hcadScript KEYS: 主路由 key；ARGV: field, addr
+const hcadScript = compareAndDeleteLua + `
+return cad(KEYS[1], ARGV[1], ARGV[2], '')
+`
+
+// hcadsrScript KEYS: 主路由 key, 二级路由 key；ARGV: 主路由 field, 二级路由 field, addr, uid
+const hcadsrScript = compareAndDeleteLua + `
+return cad(KEYS[1], ARGV[1], ARGV[3], '') + cad(KEYS[2], ARGV[2], ARGV[3], ARGV[4])
+`
+
+// takeTokensScript 令牌桶，使用 Redis 服务器的时间，各实例的时钟偏差不影响补充速度；
+// 所有桶都有令牌时各取一个并返回 0，否则不取令牌，返回第一个没有令牌的桶的序号（从 1 开始）。
+// KEYS: 各个桶的 key；ARGV: 每个桶的 rate（每秒）, burst 依次排列
+const takeTokensScript = `
+redis.replicate_commands()
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local tokens = {}
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[2 * i - 1])
+	local burst = tonumber(ARGV[2 * i])
+	local b = redis.call('HMGET', key, 'tokens', 'ts')
+	local n = tonumber(b[1])
+	local ts = tonumber(b[2])
+	if n == nil or ts == nil then
+		n = burst
+		ts = now
+	end
+	n = math.min(burst, n + math.max(0, now - ts) / 1000 * rate)
+	if n < 1 then
+		return i
+	end
+	tokens[i] = n
+end
+for i, key in ipairs(KEYS) do
+	local rate = tonumber(ARGV[2 * i - 1])
+	local burst = tonumber(ARGV[2 * i])
+	redis.call('HSET', key, 'tokens', tostring(tokens[i] - 1), 'ts', tostring(now))
+	redis.call('PEXPIRE', key, math.ceil(burst / rate * 1000) + 1000)
+end
+return 0
+`
+
+// setNXScript KEYS: key；ARGV: ttl 毫秒。key 已存在时 SET 返回 false
+const setNXScript = `
+if redis.call('SET', KEYS[1], '1', 'NX', 'PX', ARGV[1]) then
+	return 1
+end
+return 0
+`
+
+// setRouteScript KEYS: 主路由 key, 二级路由 key（可选）；ARGV: 主路由 field, 二级路由 field, value, ttl 毫秒
+const setRouteScript = `
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[3])
+redis.call('PEXPIRE', KEYS[1], ARGV[4])
+if KEYS[2] then
+	redis.call('HSET', KEYS[2], ARGV[2], ARGV[3])
+	redis.call('PEXPIRE', KEYS[2], ARGV[4])
+end
+return 1
+`
+
+// refreshRouteScript KEYS: 主路由 key, 二级路由 key（可选）；ARGV: 主路由 field, 二级路由 field, addr, 当前毫秒时间戳, ttl 毫秒
+// 路由存在、未过期并且仍指向 addr 时延长 ExpireAt 和 key 的过期时间，返回 1，否则返回 0；
+// 二级路由只有仍属于同一个用户和 connector 时才一起延长
+const refreshRouteScript = `
+local v = redis.call('HGET', KEYS[1], ARGV[1])
+if not v then
+	return 0
+end
+local ok, info = pcall(cjson.decode, v)
+if not ok or info['Addr'] ~= ARGV[3] then
+	return 0
+end
+local now = tonumber(ARGV[4])
+local ttl = tonumber(ARGV[5])
+if type(info['ExpireAt']) == 'number' and info['ExpireAt'] > 0 and info['ExpireAt'] <= now then
+	return 0
+end
+info['ExpireAt'] = now + ttl
+local raw = cjson.encode(info)
+redis.call('HSET', KEYS[1], ARGV[1], raw)
+redis.call('PEXPIRE', KEYS[1], ttl)
+if KEYS[2] then
+	local sv = redis.call('HGET', KEYS[2], ARGV[2])
+	if sv then
+		local sok, sr = pcall(cjson.decode, sv)
+		if sok and sr['UserID'] == info['UserID'] and sr['Addr'] == ARGV[3] then
+			redis.call('HSET', KEYS[2], ARGV[2], raw)
+			redis.call('PEXPIRE', KEYS[2], ttl)
+		end
+	end
+end
+return 1
+`
+
+// pruneRoutesScript KEYS: 路由 key；ARGV: 当前毫秒时间戳, fields...
+// 只删除仍然过期的字段，避免删掉读取之后刚刚重新注册的路由
+const pruneRoutesScript = `
+local now = tonumber(ARGV[1])
+local n = 0
+for i = 2, #ARGV do
+	local v = redis.call('HGET', KEYS[1], ARGV[i])
+	if v then
+		local ok, info = pcall(cjson.decode, v)
+		if ok and type(info['ExpireAt']) == 'number' and info['ExpireAt'] > 0 and info['ExpireAt'] <= now then
+			n = n + redis.call('HDEL', KEYS[1], ARGV[i])
+		end
+	end
+end
+return n
+`
+
+// RedisStore 基于 RedisCommander 的 RouterRedisClient 和 RouteRedisClient 实现
+type RedisStore struct {
+	client RedisCommander
+	// Tenants 可选，按租户给路由 key 加前缀，需要和 RedisRouter.Tenants 保持一致
+	Tenants *TenantRegistry
+	now     func() time.Time
+}
+
+func (r *RedisStore) routeKey(appID, userId string) string {
+	return r.Tenants.KeyPrefix(appID) + RouteKey(appID, userId)
+}
+
+func NewRedisStore(client RedisCommander) *RedisStore {
+	return &RedisStore{client: client, now: time.Now}
+}
+
+func (r *RedisStore) GenSequenceID(ctx context.Context, key string, expireSeconds int) (int64, error) {
+	res, err := r.client.Eval(ctx, genSequenceScript, []string{key}, expireSeconds)
+	if err != nil {
+		return RedisFailCode, err
+	}
+	return toInt64(res)
+}
+
+func (r *RedisStore) HCAD(ctx context.Context, appID, userId, deviceID, source, addr string) (int64, error) {
+	res, err := r.client.Eval(ctx, hcadScript, []string{r.routeKey(appID, userId)}, RouteField(deviceID, source), addr)
+	if err != nil {
+		return RedisFailCode, err
+	}
+	return toInt64(res)
+}
+
+func (r *RedisStore) HCADSR(ctx context.Context, appID, userId, deviceID, source, addr string) (int64, error) {
+	keys := []string{r.routeKey(appID, userId), r.Tenants.KeyPrefix(appID) + RouteSRKey(appID, deviceID)}
+	res, err := r.client.Eval(ctx, hcadsrScript, keys, RouteField(deviceID, source), source, addr, userId)
+	if err != nil {
+		return RedisFailCode, err
+	}
+	return toInt64(res)
+}
+
+func (r *RedisStore) TakeTokens(ctx context.Context, buckets []TokenBucket) (int, error) {
+	if len(buckets) == 0 {
+		return -1, nil
+	}
+	keys := make([]string, 0, len(buckets))
+	args := make([]interface{}, 0, 2*len(buckets))
+	for _, b := range buckets {
+		burst := b.Burst
+		if burst <= 0 {
+			burst = 1
+		}
+		keys = append(keys, b.Key)
+		args = append(args, b.Rate, burst)
+	}
+	res, err := r.client.Eval(ctx, takeTokensScript, keys, args...)
+	if err != nil {
+		return -1, err
+	}
+	n, err := toInt64(res)
+	if err != nil {
+		return -1, err
+	}
+	return int(n) - 1, nil
+}
+
+func (r *RedisStore) SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error) {
+	res, err := r.client.Eval(ctx, setNXScript, []string{key}, ttl.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := toInt64(res)
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (r *RedisStore) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return r.client.HGetAll(ctx, key)
+}
+
+func (r *RedisStore) SetRoute(ctx context.Context, key, field, srKey, srField, value string, ttl time.Duration) error {
+	keys := []string{key}
+	if len(srKey) > 0 {
+		keys = append(keys, srKey)
+	}
+	_, err := r.client.Eval(ctx, setRouteScript, keys, field, srField, value, ttl.Milliseconds())
+	return err
+}
+
+func (r *RedisStore) RefreshRoute(ctx context.Context, key, field, srKey, srField, addr string, now int64, ttl time.Duration) (bool, error) {
+	keys := []string{key}
+	if len(srKey) > 0 {
+		keys = append(keys, srKey)
+	}
+	res, err := r.client.Eval(ctx, refreshRouteScript, keys, field, srField, addr, now, ttl.Milliseconds())
+	if err != nil {
+		return false, err
+	}
+	n, err := toInt64(res)
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+func (r *RedisStore) PruneRoutes(ctx context.Context, key string, now int64, fields ...string) (int64, error) {
+	args := make([]interface{}, 0, len(fields)+1)
+	args = append(args, now)
+	for _, f := range fields {
+		args = append(args, f)
+	}
+	res, err := r.client.Eval(ctx, pruneRoutesScript, []string{key}, args...)
+	if err != nil {
+		return RedisFailCode, err
+	}
+	return toInt64(res)
+}
+
+func toInt64(v interface{}) (int64, error) {
+	switch n := v.(type) {
+	case int64:
+		return n, nil
+	case int:
+		return int64(n), nil
+	}
+	return RedisFailCode, fmt.Errorf("unexpected redis script result %T: %v", v, v)
+}
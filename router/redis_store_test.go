@@ -0,0 +1,242 @@
+package router
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/stretchr/testify/assert"
+
+	"github.com/gangcheng1030/ai_testing_and_refactoring/go_redis_test"
+)
+
+// newTestRedis 启动进程内的 Redis 替身，Lua 脚本在替身中真实执行
+func newTestRedis(t *testing.T) (*miniredis.Miniredis, *go_redis_test.RedisClient) {
+	t.Helper()
+	mr := miniredis.RunT(t)
+	client := go_redis_test.NewRedisClient(go_redis_test.RedisConfig{Addr: mr.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return mr, client
+}
+
+// newTestRedisStore 返回使用固定时钟的 RedisStore
+func newTestRedisStore(t *testing.T, now time.Time) (*miniredis.Miniredis, *RedisStore) {
+	t.Helper()
+	mr, client := newTestRedis(t)
+	mr.SetTime(now)
+	store := NewRedisStore(client)
+	store.now = func() time.Time { return now }
+	return mr, store
+}
+
+var errTestRedisDown = errors.New("redis down")
+
+// errRedisCommander 所有命令都失败的 RedisCommander，用于测试 Redis 不可用时的退化逻辑
+type errRedisCommander struct{}
+
+func (errRedisCommander) HSet(ctx context.Context, key, field string, value interface{}) error {
+	return errTestRedisDown
+}
+
+func (errRedisCommander) HGetAll(ctx context.Context, key string) (map[string]string, error) {
+	return nil, errTestRedisDown
+}
+
+func (errRedisCommander) Expire(ctx context.Context, key string, expiration time.Duration) (bool, error) {
+	return false, errTestRedisDown
+}
+
+func (errRedisCommander) Eval(ctx context.Context, script string, keys []string, args ...interface{}) (interface{}, error) {
+	return nil, errTestRedisDown
+}
+
+func routeJSON(t *testing.T, info *RouteInfo) string {
+	t.Helper()
+	raw, err := json.Marshal(info)
+	assert.NoError(t, err)
+	return string(raw)
+}
+
+func TestRedisStoreGenSequenceID(t *testing.T) {
+	mr, store := newTestRedisStore(t, time.Unix(1700000000, 0))
+	ctx := context.Background()
+
+	for want := int64(1); want <= 3; want++ {
+		seq, err := store.GenSequenceID(ctx, "seq_key", 60)
+		assert.NoError(t, err)
+		assert.Equal(t, want, seq)
+	}
+	assert.Equal(t, 60*time.Second, mr.TTL("seq_key"))
+
+	mr.FastForward(61 * time.Second)
+	seq, err := store.GenSequenceID(ctx, "seq_key", 60)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), seq)
+}
+
+func TestRedisStoreHCAD(t *testing.T) {
+	testCases := []struct {
+		name    string
+		stored  *RouteInfo
+		addr    string
+		deleted int64
+	}{
+		{
+			name:    "no-addr-deletes",
+			stored:  &RouteInfo{UserID: "0", DeviceID: "d1", Source: "ios", Addr: "10.0.0.1:80"},
+			addr:    "",
+			deleted: 1,
+		},
+		{
+			name:    "same-addr-deletes",
+			stored:  &RouteInfo{UserID: "0", DeviceID: "d1", Source: "ios", Addr: "10.0.0.1:80"},
+			addr:    "10.0.0.1:80",
+			deleted: 1,
+		},
+		{
+			name:    "moved-to-other-connector-kept",
+			stored:  &RouteInfo{UserID: "0", DeviceID: "d1", Source: "ios", Addr: "10.0.0.2:80"},
+			addr:    "10.0.0.1:80",
+			deleted: 0,
+		},
+		{
+			name:    "missing-field",
+			stored:  nil,
+			addr:    "",
+			deleted: 0,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr, store := newTestRedisStore(t, time.Unix(1700000000, 0))
+			key := RouteKey("im", AnonymousUserIDStr)
+			field := RouteField("d1", "ios")
+			if tc.stored != nil {
+				mr.HSet(key, field, routeJSON(t, tc.stored))
+			}
+			n, err := store.HCAD(context.Background(), "im", AnonymousUserIDStr, "d1", "ios", tc.addr)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.deleted, n)
+			if tc.stored != nil {
+				assert.Equal(t, tc.deleted == 0, mr.HGet(key, field) != "")
+			}
+		})
+	}
+}
+
+func TestRedisStoreHCADSR(t *testing.T) {
+	testCases := []struct {
+		name      string
+		srUserID  string
+		addr      string
+		deleted   int64
+		srRemains bool
+	}{
+		{
+			name:      "deletes-both",
+			srUserID:  "42",
+			addr:      "10.0.0.1:80",
+			deleted:   2,
+			srRemains: false,
+		},
+		{
+			name:      "device-relogged-as-other-user",
+			srUserID:  "43",
+			addr:      "10.0.0.1:80",
+			deleted:   1,
+			srRemains: true,
+		},
+		{
+			name:      "connector-mismatch",
+			srUserID:  "42",
+			addr:      "10.0.0.9:80",
+			deleted:   0,
+			srRemains: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			mr, store := newTestRedisStore(t, time.Unix(1700000000, 0))
+			info := &RouteInfo{UserID: "42", DeviceID: "d1", Source: "ios", Addr: "10.0.0.1:80"}
+			sr := &RouteInfo{UserID: tc.srUserID, DeviceID: "d1", Source: "ios", Addr: "10.0.0.1:80"}
+			mr.HSet(RouteKey("im", "42"), RouteField("d1", "ios"), routeJSON(t, info))
+			mr.HSet(RouteSRKey("im", "d1"), "ios", routeJSON(t, sr))
+
+			n, err := store.HCADSR(context.Background(), "im", "42", "d1", "ios", tc.addr)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.deleted, n)
+			assert.Equal(t, tc.srRemains, mr.HGet(RouteSRKey("im", "d1"), "ios") != "")
+		})
+	}
+}
+
+func TestRedisStoreTakeTokens(t *testing.T) {
+	start := time.Unix(1700000000, 0)
+	mr, store := newTestRedisStore(t, start)
+	// 令牌桶只使用 Redis 服务器的时间
+	store.now = func() time.Time { return start.Add(time.Hour) }
+	ctx := context.Background()
+	shared := TokenBucket{Key: "shared", Rate: 2, Burst: 2}
+	single := TokenBucket{Key: "single", Rate: 2, Burst: 1}
+
+	steps := []struct {
+		name     string
+		at       time.Duration
+		buckets  []TokenBucket
+		rejected int
+	}{
+		{"both-buckets", 0, []TokenBucket{shared, single}, -1},
+		// 第二个桶没有令牌时第一个桶也不消耗令牌
+		{"second-rejected", 0, []TokenBucket{shared, single}, 1},
+		{"first-kept", 0, []TokenBucket{shared}, -1},
+		{"first-rejected", 0, []TokenBucket{shared, single}, 0},
+		{"half-token-refilled", 250 * time.Millisecond, []TokenBucket{shared}, 0},
+		{"one-token-refilled", 500 * time.Millisecond, []TokenBucket{shared}, -1},
+		{"empty-again", 500 * time.Millisecond, []TokenBucket{shared}, 0},
+	}
+	for _, step := range steps {
+		mr.SetTime(start.Add(step.at))
+		rejected, err := store.TakeTokens(ctx, step.buckets)
+		assert.NoError(t, err, step.name)
+		assert.Equal(t, step.rejected, rejected, step.name)
+	}
+	assert.True(t, mr.TTL("shared") > 0)
+}
+
+func TestRedisStoreEvalError(t *testing.T) {
+	store := NewRedisStore(errRedisCommander{})
+	ctx := context.Background()
+
+	_, err := store.TakeTokens(ctx, []TokenBucket{{Key: "bucket", Rate: 1, Burst: 1}})
+	assert.Equal(t, errTestRedisDown, err)
+	seq, err := store.GenSequenceID(ctx, "seq", 1)
+	assert.Equal(t, errTestRedisDown, err)
+	assert.Equal(t, int64(RedisFailCode), seq)
+	n, err := store.HCAD(ctx, "im", "0", "d1", "ios", "")
+	assert.Equal(t, errTestRedisDown, err)
+	assert.Equal(t, int64(RedisFailCode), n)
+}
+
+func TestToInt64(t *testing.T) {
+	testCases := []struct {
+		name    string
+		in      interface{}
+		want    int64
+		wantErr bool
+	}{
+		{"int64", int64(3), 3, false},
+		{"int", 4, 4, false},
+		{"string", "5", RedisFailCode, true},
+		{"nil", nil, RedisFailCode, true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := toInt64(tc.in)
+			assert.Equal(t, tc.want, got)
+			assert.Equal(t, tc.wantErr, err != nil)
+		})
+	}
+}
@@ -0,0 +1,4 @@
+// Package routerpb 由 proto/router.proto 生成，修改 proto 之后在本目录执行 go generate
+package routerpb
+
+//go:generate protoc -I ../proto --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative router.proto
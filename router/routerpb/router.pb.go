@@ -0,0 +1,967 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.34.1
+// 	protoc        (unknown)
+// source: router.proto
+
+package routerpb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	anypb "google.golang.org/protobuf/types/known/anypb"
+	reflect "reflect"
+	sync "sync"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+type MsgPriority int32
+
+const (
+	MsgPriority_MSG_PRIORITY_NORMAL   MsgPriority = 0
+	MsgPriority_MSG_PRIORITY_REALTIME MsgPriority = 1
+	MsgPriority_MSG_PRIORITY_BULK     MsgPriority = 2
+)
+
+// Enum value maps for MsgPriority.
+var (
+	MsgPriority_name = map[int32]string{
+		0: "MSG_PRIORITY_NORMAL",
+		1: "MSG_PRIORITY_REALTIME",
+		2: "MSG_PRIORITY_BULK",
+	}
+	MsgPriority_value = map[string]int32{
+		"MSG_PRIORITY_NORMAL":   0,
+		"MSG_PRIORITY_REALTIME": 1,
+		"MSG_PRIORITY_BULK":     2,
+	}
+)
+
+func (x MsgPriority) Enum() *MsgPriority {
+	p := new(MsgPriority)
+	*p = x
+	return p
+}
+
+func (x MsgPriority) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (MsgPriority) Descriptor() protoreflect.EnumDescriptor {
+	return file_router_proto_enumTypes[0].Descriptor()
+}
+
+func (MsgPriority) Type() protoreflect.EnumType {
+	return &file_router_proto_enumTypes[0]
+}
+
+func (x MsgPriority) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use MsgPriority.Descriptor instead.
+func (MsgPriority) EnumDescriptor() ([]byte, []int) {
+	return file_router_proto_rawDescGZIP(), []int{0}
+}
+
+type I18N struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Value   string            `protobuf:"bytes,1,opt,name=value,proto3" json:"value,omitempty"`
+	Locales map[string]string `protobuf:"bytes,2,rep,name=locales,proto3" json:"locales,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	Params  []string          `protobuf:"bytes,3,rep,name=params,proto3" json:"params,omitempty"`
+	// value 是翻译目录的 key，由 router 按设备 locale 解析
+	IsCatalogKey bool `protobuf:"varint,4,opt,name=is_catalog_key,json=isCatalogKey,proto3" json:"is_catalog_key,omitempty"`
+}
+
+func (x *I18N) Reset() {
+	*x = I18N{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_router_proto_msgTypes[0]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *I18N) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*I18N) ProtoMessage() {}
+
+func (x *I18N) ProtoReflect() protoreflect.Message {
+	mi := &file_router_proto_msgTypes[0]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use I18N.ProtoReflect.Descriptor instead.
+func (*I18N) Descriptor() ([]byte, []int) {
+	return file_router_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *I18N) GetValue() string {
+	if x != nil {
+		return x.Value
+	}
+	return ""
+}
+
+func (x *I18N) GetLocales() map[string]string {
+	if x != nil {
+		return x.Locales
+	}
+	return nil
+}
+
+func (x *I18N) GetParams() []string {
+	if x != nil {
+		return x.Params
+	}
+	return nil
+}
+
+func (x *I18N) GetIsCatalogKey() bool {
+	if x != nil {
+		return x.IsCatalogKey
+	}
+	return false
+}
+
+type PushContent struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Title      *I18N  `protobuf:"bytes,1,opt,name=title,proto3" json:"title,omitempty"`
+	Value      *I18N  `protobuf:"bytes,2,opt,name=value,proto3" json:"value,omitempty"`
+	Ticker     *I18N  `protobuf:"bytes,3,opt,name=ticker,proto3" json:"ticker,omitempty"`
+	Message    string `protobuf:"bytes,4,opt,name=message,proto3" json:"message,omitempty"`
+	CreateTime int64  `protobuf:"varint,5,opt,name=create_time,json=createTime,proto3" json:"create_time,omitempty"`
+	// 静默推送，客户端只同步数据不弹通知
+	Silent bool `protobuf:"varint,6,opt,name=silent,proto3" json:"silent,omitempty"`
+}
+
+func (x *PushContent) Reset() {
+	*x = PushContent{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_router_proto_msgTypes[1]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *PushContent) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*PushContent) ProtoMessage() {}
+
+func (x *PushContent) ProtoReflect() protoreflect.Message {
+	mi := &file_router_proto_msgTypes[1]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use PushContent.ProtoReflect.Descriptor instead.
+func (*PushContent) Descriptor() ([]byte, []int) {
+	return file_router_proto_rawDescGZIP(), []int{1}
+}
+
+func (x *PushContent) GetTitle() *I18N {
+	if x != nil {
+		return x.Title
+	}
+	return nil
+}
+
+func (x *PushContent) GetValue() *I18N {
+	if x != nil {
+		return x.Value
+	}
+	return nil
+}
+
+func (x *PushContent) GetTicker() *I18N {
+	if x != nil {
+		return x.Ticker
+	}
+	return nil
+}
+
+func (x *PushContent) GetMessage() string {
+	if x != nil {
+		return x.Message
+	}
+	return ""
+}
+
+func (x *PushContent) GetCreateTime() int64 {
+	if x != nil {
+		return x.CreateTime
+	}
+	return 0
+}
+
+func (x *PushContent) GetSilent() bool {
+	if x != nil {
+		return x.Silent
+	}
+	return false
+}
+
+type DeviceIdPush struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	DeviceIds []string     `protobuf:"bytes,1,rep,name=device_ids,json=deviceIds,proto3" json:"device_ids,omitempty"`
+	Push      *PushContent `protobuf:"bytes,2,opt,name=push,proto3" json:"push,omitempty"`
+}
+
+func (x *DeviceIdPush) Reset() {
+	*x = DeviceIdPush{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_router_proto_msgTypes[2]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DeviceIdPush) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeviceIdPush) ProtoMessage() {}
+
+func (x *DeviceIdPush) ProtoReflect() protoreflect.Message {
+	mi := &file_router_proto_msgTypes[2]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeviceIdPush.ProtoReflect.Descriptor instead.
+func (*DeviceIdPush) Descriptor() ([]byte, []int) {
+	return file_router_proto_rawDescGZIP(), []int{2}
+}
+
+func (x *DeviceIdPush) GetDeviceIds() []string {
+	if x != nil {
+		return x.DeviceIds
+	}
+	return nil
+}
+
+func (x *DeviceIdPush) GetPush() *PushContent {
+	if x != nil {
+		return x.Push
+	}
+	return nil
+}
+
+type LimitVersion struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	MinAndroidVersion string `protobuf:"bytes,1,opt,name=min_android_version,json=minAndroidVersion,proto3" json:"min_android_version,omitempty"`
+	MaxAndroidVersion string `protobuf:"bytes,2,opt,name=max_android_version,json=maxAndroidVersion,proto3" json:"max_android_version,omitempty"`
+	MinIosVersion     string `protobuf:"bytes,3,opt,name=min_ios_version,json=minIosVersion,proto3" json:"min_ios_version,omitempty"`
+	MaxIosVersion     string `protobuf:"bytes,4,opt,name=max_ios_version,json=maxIosVersion,proto3" json:"max_ios_version,omitempty"`
+	MinUiVersion      string `protobuf:"bytes,5,opt,name=min_ui_version,json=minUiVersion,proto3" json:"min_ui_version,omitempty"`
+}
+
+func (x *LimitVersion) Reset() {
+	*x = LimitVersion{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_router_proto_msgTypes[3]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *LimitVersion) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LimitVersion) ProtoMessage() {}
+
+func (x *LimitVersion) ProtoReflect() protoreflect.Message {
+	mi := &file_router_proto_msgTypes[3]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LimitVersion.ProtoReflect.Descriptor instead.
+func (*LimitVersion) Descriptor() ([]byte, []int) {
+	return file_router_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *LimitVersion) GetMinAndroidVersion() string {
+	if x != nil {
+		return x.MinAndroidVersion
+	}
+	return ""
+}
+
+func (x *LimitVersion) GetMaxAndroidVersion() string {
+	if x != nil {
+		return x.MaxAndroidVersion
+	}
+	return ""
+}
+
+func (x *LimitVersion) GetMinIosVersion() string {
+	if x != nil {
+		return x.MinIosVersion
+	}
+	return ""
+}
+
+func (x *LimitVersion) GetMaxIosVersion() string {
+	if x != nil {
+		return x.MaxIosVersion
+	}
+	return ""
+}
+
+func (x *LimitVersion) GetMinUiVersion() string {
+	if x != nil {
+		return x.MinUiVersion
+	}
+	return ""
+}
+
+type TransferMessageRequest struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	ReceiverId      string            `protobuf:"bytes,1,opt,name=receiver_id,json=receiverId,proto3" json:"receiver_id,omitempty"`
+	MsgId           string            `protobuf:"bytes,2,opt,name=msg_id,json=msgId,proto3" json:"msg_id,omitempty"`
+	MsgType         int32             `protobuf:"varint,3,opt,name=msg_type,json=msgType,proto3" json:"msg_type,omitempty"`
+	MsgData         *anypb.Any        `protobuf:"bytes,4,opt,name=msg_data,json=msgData,proto3" json:"msg_data,omitempty"`
+	Push            *PushContent      `protobuf:"bytes,5,opt,name=push,proto3" json:"push,omitempty"`
+	DeviceIdPushes  []*DeviceIdPush   `protobuf:"bytes,6,rep,name=device_id_pushes,json=deviceIdPushes,proto3" json:"device_id_pushes,omitempty"`
+	MsgTypeName     string            `protobuf:"bytes,7,opt,name=msg_type_name,json=msgTypeName,proto3" json:"msg_type_name,omitempty"`
+	AppName         string            `protobuf:"bytes,8,opt,name=app_name,json=appName,proto3" json:"app_name,omitempty"`
+	DeviceIdentifer string            `protobuf:"bytes,9,opt,name=device_identifer,json=deviceIdentifer,proto3" json:"device_identifer,omitempty"`
+	Filters         map[string]string `protobuf:"bytes,10,rep,name=filters,proto3" json:"filters,omitempty" protobuf_key:"bytes,1,opt,name=key,proto3" protobuf_val:"bytes,2,opt,name=value,proto3"`
+	LimitVersion    *LimitVersion     `protobuf:"bytes,11,opt,name=limit_version,json=limitVersion,proto3" json:"limit_version,omitempty"`
+	ForceLangs      []string          `protobuf:"bytes,12,rep,name=force_langs,json=forceLangs,proto3" json:"force_langs,omitempty"`
+	// 过期时间，毫秒时间戳，0 表示不过期
+	ExpireAt int64 `protobuf:"varint,13,opt,name=expire_at,json=expireAt,proto3" json:"expire_at,omitempty"`
+	// 相对过期时间，expire_at 为 0 时按接收时间换算
+	TtlSeconds int32       `protobuf:"varint,14,opt,name=ttl_seconds,json=ttlSeconds,proto3" json:"ttl_seconds,omitempty"`
+	Priority   MsgPriority `protobuf:"varint,15,opt,name=priority,proto3,enum=proto_router.MsgPriority" json:"priority,omitempty"`
+	// 只下发给 device_id_pushes 中列出的设备，不回退到通用 push
+	DeviceIdPushesOnly bool `protobuf:"varint,16,opt,name=device_id_pushes_only,json=deviceIdPushesOnly,proto3" json:"device_id_pushes_only,omitempty"`
+	// 同一个用户同一个 collapse_key 在合并窗口内只下发最新的一条推送
+	CollapseKey string `protobuf:"bytes,17,opt,name=collapse_key,json=collapseKey,proto3" json:"collapse_key,omitempty"`
+	// 定时下发时间，毫秒时间戳，不晚于当前时间时立即下发
+	DeliverAt int64 `protobuf:"varint,18,opt,name=deliver_at,json=deliverAt,proto3" json:"deliver_at,omitempty"`
+}
+
+func (x *TransferMessageRequest) Reset() {
+	*x = TransferMessageRequest{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_router_proto_msgTypes[4]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TransferMessageRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferMessageRequest) ProtoMessage() {}
+
+func (x *TransferMessageRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_router_proto_msgTypes[4]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferMessageRequest.ProtoReflect.Descriptor instead.
+func (*TransferMessageRequest) Descriptor() ([]byte, []int) {
+	return file_router_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *TransferMessageRequest) GetReceiverId() string {
+	if x != nil {
+		return x.ReceiverId
+	}
+	return ""
+}
+
+func (x *TransferMessageRequest) GetMsgId() string {
+	if x != nil {
+		return x.MsgId
+	}
+	return ""
+}
+
+func (x *TransferMessageRequest) GetMsgType() int32 {
+	if x != nil {
+		return x.MsgType
+	}
+	return 0
+}
+
+func (x *TransferMessageRequest) GetMsgData() *anypb.Any {
+	if x != nil {
+		return x.MsgData
+	}
+	return nil
+}
+
+func (x *TransferMessageRequest) GetPush() *PushContent {
+	if x != nil {
+		return x.Push
+	}
+	return nil
+}
+
+func (x *TransferMessageRequest) GetDeviceIdPushes() []*DeviceIdPush {
+	if x != nil {
+		return x.DeviceIdPushes
+	}
+	return nil
+}
+
+func (x *TransferMessageRequest) GetMsgTypeName() string {
+	if x != nil {
+		return x.MsgTypeName
+	}
+	return ""
+}
+
+func (x *TransferMessageRequest) GetAppName() string {
+	if x != nil {
+		return x.AppName
+	}
+	return ""
+}
+
+func (x *TransferMessageRequest) GetDeviceIdentifer() string {
+	if x != nil {
+		return x.DeviceIdentifer
+	}
+	return ""
+}
+
+func (x *TransferMessageRequest) GetFilters() map[string]string {
+	if x != nil {
+		return x.Filters
+	}
+	return nil
+}
+
+func (x *TransferMessageRequest) GetLimitVersion() *LimitVersion {
+	if x != nil {
+		return x.LimitVersion
+	}
+	return nil
+}
+
+func (x *TransferMessageRequest) GetForceLangs() []string {
+	if x != nil {
+		return x.ForceLangs
+	}
+	return nil
+}
+
+func (x *TransferMessageRequest) GetExpireAt() int64 {
+	if x != nil {
+		return x.ExpireAt
+	}
+	return 0
+}
+
+func (x *TransferMessageRequest) GetTtlSeconds() int32 {
+	if x != nil {
+		return x.TtlSeconds
+	}
+	return 0
+}
+
+func (x *TransferMessageRequest) GetPriority() MsgPriority {
+	if x != nil {
+		return x.Priority
+	}
+	return MsgPriority_MSG_PRIORITY_NORMAL
+}
+
+func (x *TransferMessageRequest) GetDeviceIdPushesOnly() bool {
+	if x != nil {
+		return x.DeviceIdPushesOnly
+	}
+	return false
+}
+
+func (x *TransferMessageRequest) GetCollapseKey() string {
+	if x != nil {
+		return x.CollapseKey
+	}
+	return ""
+}
+
+func (x *TransferMessageRequest) GetDeliverAt() int64 {
+	if x != nil {
+		return x.DeliverAt
+	}
+	return 0
+}
+
+type DeviceIdentifier struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	Identifer string `protobuf:"bytes,1,opt,name=identifer,proto3" json:"identifer,omitempty"`
+	IsOnline  bool   `protobuf:"varint,2,opt,name=is_online,json=isOnline,proto3" json:"is_online,omitempty"`
+}
+
+func (x *DeviceIdentifier) Reset() {
+	*x = DeviceIdentifier{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_router_proto_msgTypes[5]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *DeviceIdentifier) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*DeviceIdentifier) ProtoMessage() {}
+
+func (x *DeviceIdentifier) ProtoReflect() protoreflect.Message {
+	mi := &file_router_proto_msgTypes[5]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use DeviceIdentifier.ProtoReflect.Descriptor instead.
+func (*DeviceIdentifier) Descriptor() ([]byte, []int) {
+	return file_router_proto_rawDescGZIP(), []int{5}
+}
+
+func (x *DeviceIdentifier) GetIdentifer() string {
+	if x != nil {
+		return x.Identifer
+	}
+	return ""
+}
+
+func (x *DeviceIdentifier) GetIsOnline() bool {
+	if x != nil {
+		return x.IsOnline
+	}
+	return false
+}
+
+type TransferPushMessageReply struct {
+	state         protoimpl.MessageState
+	sizeCache     protoimpl.SizeCache
+	unknownFields protoimpl.UnknownFields
+
+	IsUserOnline      bool                `protobuf:"varint,1,opt,name=is_user_online,json=isUserOnline,proto3" json:"is_user_online,omitempty"`
+	DeviceIdentifiers []*DeviceIdentifier `protobuf:"bytes,2,rep,name=device_identifiers,json=deviceIdentifiers,proto3" json:"device_identifiers,omitempty"`
+}
+
+func (x *TransferPushMessageReply) Reset() {
+	*x = TransferPushMessageReply{}
+	if protoimpl.UnsafeEnabled {
+		mi := &file_router_proto_msgTypes[6]
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		ms.StoreMessageInfo(mi)
+	}
+}
+
+func (x *TransferPushMessageReply) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*TransferPushMessageReply) ProtoMessage() {}
+
+func (x *TransferPushMessageReply) ProtoReflect() protoreflect.Message {
+	mi := &file_router_proto_msgTypes[6]
+	if protoimpl.UnsafeEnabled && x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use TransferPushMessageReply.ProtoReflect.Descriptor instead.
+func (*TransferPushMessageReply) Descriptor() ([]byte, []int) {
+	return file_router_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *TransferPushMessageReply) GetIsUserOnline() bool {
+	if x != nil {
+		return x.IsUserOnline
+	}
+	return false
+}
+
+func (x *TransferPushMessageReply) GetDeviceIdentifiers() []*DeviceIdentifier {
+	if x != nil {
+		return x.DeviceIdentifiers
+	}
+	return nil
+}
+
+var File_router_proto protoreflect.FileDescriptor
+
+var file_router_proto_rawDesc = []byte{
+	0x0a, 0x0c, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x12, 0x0c,
+	0x70, 0x72, 0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x1a, 0x19, 0x67, 0x6f,
+	0x6f, 0x67, 0x6c, 0x65, 0x2f, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x62, 0x75, 0x66, 0x2f, 0x61, 0x6e,
+	0x79, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x22, 0xd1, 0x01, 0x0a, 0x04, 0x49, 0x31, 0x38, 0x4e,
+	0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12, 0x39, 0x0a, 0x07, 0x6c, 0x6f, 0x63, 0x61, 0x6c, 0x65,
+	0x73, 0x18, 0x02, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1f, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x5f,
+	0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x2e, 0x49, 0x31, 0x38, 0x4e, 0x2e, 0x4c, 0x6f, 0x63, 0x61,
+	0x6c, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x6c, 0x6f, 0x63, 0x61, 0x6c, 0x65,
+	0x73, 0x12, 0x16, 0x0a, 0x06, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x18, 0x03, 0x20, 0x03, 0x28,
+	0x09, 0x52, 0x06, 0x70, 0x61, 0x72, 0x61, 0x6d, 0x73, 0x12, 0x24, 0x0a, 0x0e, 0x69, 0x73, 0x5f,
+	0x63, 0x61, 0x74, 0x61, 0x6c, 0x6f, 0x67, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x04, 0x20, 0x01, 0x28,
+	0x08, 0x52, 0x0c, 0x69, 0x73, 0x43, 0x61, 0x74, 0x61, 0x6c, 0x6f, 0x67, 0x4b, 0x65, 0x79, 0x1a,
+	0x3a, 0x0a, 0x0c, 0x4c, 0x6f, 0x63, 0x61, 0x6c, 0x65, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12,
+	0x10, 0x0a, 0x03, 0x6b, 0x65, 0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65,
+	0x79, 0x12, 0x14, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0xe0, 0x01, 0x0a, 0x0b,
+	0x50, 0x75, 0x73, 0x68, 0x43, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x12, 0x28, 0x0a, 0x05, 0x74,
+	0x69, 0x74, 0x6c, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x2e, 0x49, 0x31, 0x38, 0x4e, 0x52, 0x05,
+	0x74, 0x69, 0x74, 0x6c, 0x65, 0x12, 0x28, 0x0a, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02,
+	0x20, 0x01, 0x28, 0x0b, 0x32, 0x12, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75,
+	0x74, 0x65, 0x72, 0x2e, 0x49, 0x31, 0x38, 0x4e, 0x52, 0x05, 0x76, 0x61, 0x6c, 0x75, 0x65, 0x12,
+	0x2a, 0x0a, 0x06, 0x74, 0x69, 0x63, 0x6b, 0x65, 0x72, 0x18, 0x03, 0x20, 0x01, 0x28, 0x0b, 0x32,
+	0x12, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x2e, 0x49,
+	0x31, 0x38, 0x4e, 0x52, 0x06, 0x74, 0x69, 0x63, 0x6b, 0x65, 0x72, 0x12, 0x18, 0x0a, 0x07, 0x6d,
+	0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x6d, 0x65,
+	0x73, 0x73, 0x61, 0x67, 0x65, 0x12, 0x1f, 0x0a, 0x0b, 0x63, 0x72, 0x65, 0x61, 0x74, 0x65, 0x5f,
+	0x74, 0x69, 0x6d, 0x65, 0x18, 0x05, 0x20, 0x01, 0x28, 0x03, 0x52, 0x0a, 0x63, 0x72, 0x65, 0x61,
+	0x74, 0x65, 0x54, 0x69, 0x6d, 0x65, 0x12, 0x16, 0x0a, 0x06, 0x73, 0x69, 0x6c, 0x65, 0x6e, 0x74,
+	0x18, 0x06, 0x20, 0x01, 0x28, 0x08, 0x52, 0x06, 0x73, 0x69, 0x6c, 0x65, 0x6e, 0x74, 0x22, 0x5c,
+	0x0a, 0x0c, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x50, 0x75, 0x73, 0x68, 0x12, 0x1d,
+	0x0a, 0x0a, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x5f, 0x69, 0x64, 0x73, 0x18, 0x01, 0x20, 0x03,
+	0x28, 0x09, 0x52, 0x09, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x73, 0x12, 0x2d, 0x0a,
+	0x04, 0x70, 0x75, 0x73, 0x68, 0x18, 0x02, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x19, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x2e, 0x50, 0x75, 0x73, 0x68, 0x43,
+	0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x52, 0x04, 0x70, 0x75, 0x73, 0x68, 0x22, 0xe4, 0x01, 0x0a,
+	0x0c, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x2e, 0x0a,
+	0x13, 0x6d, 0x69, 0x6e, 0x5f, 0x61, 0x6e, 0x64, 0x72, 0x6f, 0x69, 0x64, 0x5f, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x11, 0x6d, 0x69, 0x6e, 0x41,
+	0x6e, 0x64, 0x72, 0x6f, 0x69, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x2e, 0x0a,
+	0x13, 0x6d, 0x61, 0x78, 0x5f, 0x61, 0x6e, 0x64, 0x72, 0x6f, 0x69, 0x64, 0x5f, 0x76, 0x65, 0x72,
+	0x73, 0x69, 0x6f, 0x6e, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x11, 0x6d, 0x61, 0x78, 0x41,
+	0x6e, 0x64, 0x72, 0x6f, 0x69, 0x64, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x26, 0x0a,
+	0x0f, 0x6d, 0x69, 0x6e, 0x5f, 0x69, 0x6f, 0x73, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e,
+	0x18, 0x03, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d, 0x6d, 0x69, 0x6e, 0x49, 0x6f, 0x73, 0x56, 0x65,
+	0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x26, 0x0a, 0x0f, 0x6d, 0x61, 0x78, 0x5f, 0x69, 0x6f, 0x73,
+	0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18, 0x04, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0d,
+	0x6d, 0x61, 0x78, 0x49, 0x6f, 0x73, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12, 0x24, 0x0a,
+	0x0e, 0x6d, 0x69, 0x6e, 0x5f, 0x75, 0x69, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18,
+	0x05, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0c, 0x6d, 0x69, 0x6e, 0x55, 0x69, 0x56, 0x65, 0x72, 0x73,
+	0x69, 0x6f, 0x6e, 0x22, 0xd0, 0x06, 0x0a, 0x16, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72,
+	0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x12, 0x1f,
+	0x0a, 0x0b, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x72, 0x5f, 0x69, 0x64, 0x18, 0x01, 0x20,
+	0x01, 0x28, 0x09, 0x52, 0x0a, 0x72, 0x65, 0x63, 0x65, 0x69, 0x76, 0x65, 0x72, 0x49, 0x64, 0x12,
+	0x15, 0x0a, 0x06, 0x6d, 0x73, 0x67, 0x5f, 0x69, 0x64, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52,
+	0x05, 0x6d, 0x73, 0x67, 0x49, 0x64, 0x12, 0x19, 0x0a, 0x08, 0x6d, 0x73, 0x67, 0x5f, 0x74, 0x79,
+	0x70, 0x65, 0x18, 0x03, 0x20, 0x01, 0x28, 0x05, 0x52, 0x07, 0x6d, 0x73, 0x67, 0x54, 0x79, 0x70,
+	0x65, 0x12, 0x2f, 0x0a, 0x08, 0x6d, 0x73, 0x67, 0x5f, 0x64, 0x61, 0x74, 0x61, 0x18, 0x04, 0x20,
+	0x01, 0x28, 0x0b, 0x32, 0x14, 0x2e, 0x67, 0x6f, 0x6f, 0x67, 0x6c, 0x65, 0x2e, 0x70, 0x72, 0x6f,
+	0x74, 0x6f, 0x62, 0x75, 0x66, 0x2e, 0x41, 0x6e, 0x79, 0x52, 0x07, 0x6d, 0x73, 0x67, 0x44, 0x61,
+	0x74, 0x61, 0x12, 0x2d, 0x0a, 0x04, 0x70, 0x75, 0x73, 0x68, 0x18, 0x05, 0x20, 0x01, 0x28, 0x0b,
+	0x32, 0x19, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x2e,
+	0x50, 0x75, 0x73, 0x68, 0x43, 0x6f, 0x6e, 0x74, 0x65, 0x6e, 0x74, 0x52, 0x04, 0x70, 0x75, 0x73,
+	0x68, 0x12, 0x44, 0x0a, 0x10, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x5f, 0x69, 0x64, 0x5f, 0x70,
+	0x75, 0x73, 0x68, 0x65, 0x73, 0x18, 0x06, 0x20, 0x03, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x70, 0x72,
+	0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x2e, 0x44, 0x65, 0x76, 0x69, 0x63,
+	0x65, 0x49, 0x64, 0x50, 0x75, 0x73, 0x68, 0x52, 0x0e, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49,
+	0x64, 0x50, 0x75, 0x73, 0x68, 0x65, 0x73, 0x12, 0x22, 0x0a, 0x0d, 0x6d, 0x73, 0x67, 0x5f, 0x74,
+	0x79, 0x70, 0x65, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x07, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b,
+	0x6d, 0x73, 0x67, 0x54, 0x79, 0x70, 0x65, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x19, 0x0a, 0x08, 0x61,
+	0x70, 0x70, 0x5f, 0x6e, 0x61, 0x6d, 0x65, 0x18, 0x08, 0x20, 0x01, 0x28, 0x09, 0x52, 0x07, 0x61,
+	0x70, 0x70, 0x4e, 0x61, 0x6d, 0x65, 0x12, 0x29, 0x0a, 0x10, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65,
+	0x5f, 0x69, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x65, 0x72, 0x18, 0x09, 0x20, 0x01, 0x28, 0x09,
+	0x52, 0x0f, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x65,
+	0x72, 0x12, 0x4b, 0x0a, 0x07, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x73, 0x18, 0x0a, 0x20, 0x03,
+	0x28, 0x0b, 0x32, 0x31, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65,
+	0x72, 0x2e, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67,
+	0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x2e, 0x46, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x73,
+	0x45, 0x6e, 0x74, 0x72, 0x79, 0x52, 0x07, 0x66, 0x69, 0x6c, 0x74, 0x65, 0x72, 0x73, 0x12, 0x3f,
+	0x0a, 0x0d, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x5f, 0x76, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x18,
+	0x0b, 0x20, 0x01, 0x28, 0x0b, 0x32, 0x1a, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f,
+	0x75, 0x74, 0x65, 0x72, 0x2e, 0x4c, 0x69, 0x6d, 0x69, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f,
+	0x6e, 0x52, 0x0c, 0x6c, 0x69, 0x6d, 0x69, 0x74, 0x56, 0x65, 0x72, 0x73, 0x69, 0x6f, 0x6e, 0x12,
+	0x1f, 0x0a, 0x0b, 0x66, 0x6f, 0x72, 0x63, 0x65, 0x5f, 0x6c, 0x61, 0x6e, 0x67, 0x73, 0x18, 0x0c,
+	0x20, 0x03, 0x28, 0x09, 0x52, 0x0a, 0x66, 0x6f, 0x72, 0x63, 0x65, 0x4c, 0x61, 0x6e, 0x67, 0x73,
+	0x12, 0x1b, 0x0a, 0x09, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x5f, 0x61, 0x74, 0x18, 0x0d, 0x20,
+	0x01, 0x28, 0x03, 0x52, 0x08, 0x65, 0x78, 0x70, 0x69, 0x72, 0x65, 0x41, 0x74, 0x12, 0x1f, 0x0a,
+	0x0b, 0x74, 0x74, 0x6c, 0x5f, 0x73, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x18, 0x0e, 0x20, 0x01,
+	0x28, 0x05, 0x52, 0x0a, 0x74, 0x74, 0x6c, 0x53, 0x65, 0x63, 0x6f, 0x6e, 0x64, 0x73, 0x12, 0x35,
+	0x0a, 0x08, 0x70, 0x72, 0x69, 0x6f, 0x72, 0x69, 0x74, 0x79, 0x18, 0x0f, 0x20, 0x01, 0x28, 0x0e,
+	0x32, 0x19, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x2e,
+	0x4d, 0x73, 0x67, 0x50, 0x72, 0x69, 0x6f, 0x72, 0x69, 0x74, 0x79, 0x52, 0x08, 0x70, 0x72, 0x69,
+	0x6f, 0x72, 0x69, 0x74, 0x79, 0x12, 0x31, 0x0a, 0x15, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x5f,
+	0x69, 0x64, 0x5f, 0x70, 0x75, 0x73, 0x68, 0x65, 0x73, 0x5f, 0x6f, 0x6e, 0x6c, 0x79, 0x18, 0x10,
+	0x20, 0x01, 0x28, 0x08, 0x52, 0x12, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x50, 0x75,
+	0x73, 0x68, 0x65, 0x73, 0x4f, 0x6e, 0x6c, 0x79, 0x12, 0x21, 0x0a, 0x0c, 0x63, 0x6f, 0x6c, 0x6c,
+	0x61, 0x70, 0x73, 0x65, 0x5f, 0x6b, 0x65, 0x79, 0x18, 0x11, 0x20, 0x01, 0x28, 0x09, 0x52, 0x0b,
+	0x63, 0x6f, 0x6c, 0x6c, 0x61, 0x70, 0x73, 0x65, 0x4b, 0x65, 0x79, 0x12, 0x1d, 0x0a, 0x0a, 0x64,
+	0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x5f, 0x61, 0x74, 0x18, 0x12, 0x20, 0x01, 0x28, 0x03, 0x52,
+	0x09, 0x64, 0x65, 0x6c, 0x69, 0x76, 0x65, 0x72, 0x41, 0x74, 0x1a, 0x3a, 0x0a, 0x0c, 0x46, 0x69,
+	0x6c, 0x74, 0x65, 0x72, 0x73, 0x45, 0x6e, 0x74, 0x72, 0x79, 0x12, 0x10, 0x0a, 0x03, 0x6b, 0x65,
+	0x79, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x03, 0x6b, 0x65, 0x79, 0x12, 0x14, 0x0a, 0x05,
+	0x76, 0x61, 0x6c, 0x75, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x09, 0x52, 0x05, 0x76, 0x61, 0x6c,
+	0x75, 0x65, 0x3a, 0x02, 0x38, 0x01, 0x22, 0x4d, 0x0a, 0x10, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65,
+	0x49, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x12, 0x1c, 0x0a, 0x09, 0x69, 0x64,
+	0x65, 0x6e, 0x74, 0x69, 0x66, 0x65, 0x72, 0x18, 0x01, 0x20, 0x01, 0x28, 0x09, 0x52, 0x09, 0x69,
+	0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x65, 0x72, 0x12, 0x1b, 0x0a, 0x09, 0x69, 0x73, 0x5f, 0x6f,
+	0x6e, 0x6c, 0x69, 0x6e, 0x65, 0x18, 0x02, 0x20, 0x01, 0x28, 0x08, 0x52, 0x08, 0x69, 0x73, 0x4f,
+	0x6e, 0x6c, 0x69, 0x6e, 0x65, 0x22, 0x8f, 0x01, 0x0a, 0x18, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66,
+	0x65, 0x72, 0x50, 0x75, 0x73, 0x68, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x52, 0x65, 0x70,
+	0x6c, 0x79, 0x12, 0x24, 0x0a, 0x0e, 0x69, 0x73, 0x5f, 0x75, 0x73, 0x65, 0x72, 0x5f, 0x6f, 0x6e,
+	0x6c, 0x69, 0x6e, 0x65, 0x18, 0x01, 0x20, 0x01, 0x28, 0x08, 0x52, 0x0c, 0x69, 0x73, 0x55, 0x73,
+	0x65, 0x72, 0x4f, 0x6e, 0x6c, 0x69, 0x6e, 0x65, 0x12, 0x4d, 0x0a, 0x12, 0x64, 0x65, 0x76, 0x69,
+	0x63, 0x65, 0x5f, 0x69, 0x64, 0x65, 0x6e, 0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x18, 0x02,
+	0x20, 0x03, 0x28, 0x0b, 0x32, 0x1e, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75,
+	0x74, 0x65, 0x72, 0x2e, 0x44, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x65, 0x6e, 0x74, 0x69,
+	0x66, 0x69, 0x65, 0x72, 0x52, 0x11, 0x64, 0x65, 0x76, 0x69, 0x63, 0x65, 0x49, 0x64, 0x65, 0x6e,
+	0x74, 0x69, 0x66, 0x69, 0x65, 0x72, 0x73, 0x2a, 0x58, 0x0a, 0x0b, 0x4d, 0x73, 0x67, 0x50, 0x72,
+	0x69, 0x6f, 0x72, 0x69, 0x74, 0x79, 0x12, 0x17, 0x0a, 0x13, 0x4d, 0x53, 0x47, 0x5f, 0x50, 0x52,
+	0x49, 0x4f, 0x52, 0x49, 0x54, 0x59, 0x5f, 0x4e, 0x4f, 0x52, 0x4d, 0x41, 0x4c, 0x10, 0x00, 0x12,
+	0x19, 0x0a, 0x15, 0x4d, 0x53, 0x47, 0x5f, 0x50, 0x52, 0x49, 0x4f, 0x52, 0x49, 0x54, 0x59, 0x5f,
+	0x52, 0x45, 0x41, 0x4c, 0x54, 0x49, 0x4d, 0x45, 0x10, 0x01, 0x12, 0x15, 0x0a, 0x11, 0x4d, 0x53,
+	0x47, 0x5f, 0x50, 0x52, 0x49, 0x4f, 0x52, 0x49, 0x54, 0x59, 0x5f, 0x42, 0x55, 0x4c, 0x4b, 0x10,
+	0x02, 0x32, 0x7e, 0x0a, 0x0d, 0x52, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x53, 0x65, 0x72, 0x76, 0x69,
+	0x63, 0x65, 0x12, 0x6d, 0x0a, 0x1d, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x4f, 0x6e,
+	0x6c, 0x69, 0x6e, 0x65, 0x52, 0x65, 0x6c, 0x69, 0x61, 0x62, 0x6c, 0x65, 0x4d, 0x65, 0x73, 0x73,
+	0x61, 0x67, 0x65, 0x12, 0x24, 0x2e, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x5f, 0x72, 0x6f, 0x75, 0x74,
+	0x65, 0x72, 0x2e, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65, 0x72, 0x4d, 0x65, 0x73, 0x73, 0x61,
+	0x67, 0x65, 0x52, 0x65, 0x71, 0x75, 0x65, 0x73, 0x74, 0x1a, 0x26, 0x2e, 0x70, 0x72, 0x6f, 0x74,
+	0x6f, 0x5f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x2e, 0x54, 0x72, 0x61, 0x6e, 0x73, 0x66, 0x65,
+	0x72, 0x50, 0x75, 0x73, 0x68, 0x4d, 0x65, 0x73, 0x73, 0x61, 0x67, 0x65, 0x52, 0x65, 0x70, 0x6c,
+	0x79, 0x42, 0x4e, 0x5a, 0x4c, 0x67, 0x69, 0x74, 0x68, 0x75, 0x62, 0x2e, 0x63, 0x6f, 0x6d, 0x2f,
+	0x67, 0x61, 0x6e, 0x67, 0x63, 0x68, 0x65, 0x6e, 0x67, 0x31, 0x30, 0x33, 0x30, 0x2f, 0x61, 0x69,
+	0x5f, 0x74, 0x65, 0x73, 0x74, 0x69, 0x6e, 0x67, 0x5f, 0x61, 0x6e, 0x64, 0x5f, 0x72, 0x65, 0x66,
+	0x61, 0x63, 0x74, 0x6f, 0x72, 0x69, 0x6e, 0x67, 0x2f, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x2f,
+	0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x70, 0x62, 0x3b, 0x72, 0x6f, 0x75, 0x74, 0x65, 0x72, 0x70,
+	0x62, 0x62, 0x06, 0x70, 0x72, 0x6f, 0x74, 0x6f, 0x33,
+}
+
+var (
+	file_router_proto_rawDescOnce sync.Once
+	file_router_proto_rawDescData = file_router_proto_rawDesc
+)
+
+func file_router_proto_rawDescGZIP() []byte {
+	file_router_proto_rawDescOnce.Do(func() {
+		file_router_proto_rawDescData = protoimpl.X.CompressGZIP(file_router_proto_rawDescData)
+	})
+	return file_router_proto_rawDescData
+}
+
+var file_router_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_router_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
+var file_router_proto_goTypes = []interface{}{
+	(MsgPriority)(0),                 // 0: proto_router.MsgPriority
+	(*I18N)(nil),                     // 1: proto_router.I18N
+	(*PushContent)(nil),              // 2: proto_router.PushContent
+	(*DeviceIdPush)(nil),             // 3: proto_router.DeviceIdPush
+	(*LimitVersion)(nil),             // 4: proto_router.LimitVersion
+	(*TransferMessageRequest)(nil),   // 5: proto_router.TransferMessageRequest
+	(*DeviceIdentifier)(nil),         // 6: proto_router.DeviceIdentifier
+	(*TransferPushMessageReply)(nil), // 7: proto_router.TransferPushMessageReply
+	nil,                              // 8: proto_router.I18N.LocalesEntry
+	nil,                              // 9: proto_router.TransferMessageRequest.FiltersEntry
+	(*anypb.Any)(nil),                // 10: google.protobuf.Any
+}
+var file_router_proto_depIdxs = []int32{
+	8,  // 0: proto_router.I18N.locales:type_name -> proto_router.I18N.LocalesEntry
+	1,  // 1: proto_router.PushContent.title:type_name -> proto_router.I18N
+	1,  // 2: proto_router.PushContent.value:type_name -> proto_router.I18N
+	1,  // 3: proto_router.PushContent.ticker:type_name -> proto_router.I18N
+	2,  // 4: proto_router.DeviceIdPush.push:type_name -> proto_router.PushContent
+	10, // 5: proto_router.TransferMessageRequest.msg_data:type_name -> google.protobuf.Any
+	2,  // 6: proto_router.TransferMessageRequest.push:type_name -> proto_router.PushContent
+	3,  // 7: proto_router.TransferMessageRequest.device_id_pushes:type_name -> proto_router.DeviceIdPush
+	9,  // 8: proto_router.TransferMessageRequest.filters:type_name -> proto_router.TransferMessageRequest.FiltersEntry
+	4,  // 9: proto_router.TransferMessageRequest.limit_version:type_name -> proto_router.LimitVersion
+	0,  // 10: proto_router.TransferMessageRequest.priority:type_name -> proto_router.MsgPriority
+	6,  // 11: proto_router.TransferPushMessageReply.device_identifiers:type_name -> proto_router.DeviceIdentifier
+	5,  // 12: proto_router.RouterService.TransferOnlineReliableMessage:input_type -> proto_router.TransferMessageRequest
+	7,  // 13: proto_router.RouterService.TransferOnlineReliableMessage:output_type -> proto_router.TransferPushMessageReply
+	13, // [13:14] is the sub-list for method output_type
+	12, // [12:13] is the sub-list for method input_type
+	12, // [12:12] is the sub-list for extension type_name
+	12, // [12:12] is the sub-list for extension extendee
+	0,  // [0:12] is the sub-list for field type_name
+}
+
+func init() { file_router_proto_init() }
+func file_router_proto_init() {
+	if File_router_proto != nil {
+		return
+	}
+	if !protoimpl.UnsafeEnabled {
+		file_router_proto_msgTypes[0].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*I18N); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_router_proto_msgTypes[1].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*PushContent); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_router_proto_msgTypes[2].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DeviceIdPush); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_router_proto_msgTypes[3].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*LimitVersion); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_router_proto_msgTypes[4].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TransferMessageRequest); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_router_proto_msgTypes[5].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*DeviceIdentifier); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+		file_router_proto_msgTypes[6].Exporter = func(v interface{}, i int) interface{} {
+			switch v := v.(*TransferPushMessageReply); i {
+			case 0:
+				return &v.state
+			case 1:
+				return &v.sizeCache
+			case 2:
+				return &v.unknownFields
+			default:
+				return nil
+			}
+		}
+	}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: file_router_proto_rawDesc,
+			NumEnums:      1,
+			NumMessages:   9,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_router_proto_goTypes,
+		DependencyIndexes: file_router_proto_depIdxs,
+		EnumInfos:         file_router_proto_enumTypes,
+		MessageInfos:      file_router_proto_msgTypes,
+	}.Build()
+	File_router_proto = out.File
+	file_router_proto_rawDesc = nil
+	file_router_proto_goTypes = nil
+	file_router_proto_depIdxs = nil
+}
@@ -0,0 +1,253 @@
+package router
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	// APIKeyHeader 调用方的 API key，gRPC metadata 和 HTTP 请求头使用同一个名字
+	APIKeyHeader = "x-api-key"
+
+	MetricTenantRejected = "router_tenant_rejected"
+)
+
+// TenantConfig 一个 app 的租户配置
+type TenantConfig struct {
+	AppName string
+	// AppIndex 消息存储的命名空间，不同租户不能重复
+	AppIndex int
+	// KeyPrefix 该租户所有 Redis key 的前缀，例如 "{im}"，不能为空，也不能和其它租户相同或者互为前缀
+	KeyPrefix string
+	// RateLimit 不为空时替换 RouterServer.RateLimiter 的默认规则
+	RateLimit *RateLimitConfig
+	// APIKeys 允许调用该 app 的 API key，为空表示不校验
+	APIKeys []string
+	// AdminTokens 只能管理该 app 的管理接口 token，全局 token 仍然可以管理所有 app
+	AdminTokens []string
+	// StoreReliableMsg 不为空时覆盖全局的 IsStoreReliableMsg
+	StoreReliableMsg *bool
+	// Disabled 为 true 时拒绝该 app 的所有请求
+	Disabled bool
+}
+
+// TenantRegistry 按 AppName 管理租户，配置了 RouterServer.Tenants 之后未注册的 app 会被拒绝
+type TenantRegistry struct {
+	mu      sync.RWMutex
+	tenants map[string]*TenantConfig
+}
+
+func NewTenantRegistry(tenants []*TenantConfig) (*TenantRegistry, error) {
+	r := &TenantRegistry{}
+	if err := r.Replace(tenants); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// LoadTenantRegistry 从 JSON 文件加载租户，文件内容为 TenantConfig 数组
+func LoadTenantRegistry(path string) (*TenantRegistry, error) {
+	tenants, err := readTenantFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return NewTenantRegistry(tenants)
+}
+
+// Reload 重新读取租户文件，文件不合法时保留原有配置
+func (r *TenantRegistry) Reload(path string) error {
+	tenants, err := readTenantFile(path)
+	if err != nil {
+		return err
+	}
+	return r.Replace(tenants)
+}
+
+func readTenantFile(path string) ([]*TenantConfig, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var tenants []*TenantConfig
+	if err := json.Unmarshal(data, &tenants); err != nil {
+		return nil, fmt.Errorf("parse tenants %v: %v", path, err)
+	}
+	return tenants, nil
+}
+
+// Replace 校验 AppName、AppIndex 和 KeyPrefix 不重复后整体替换；
+// KeyPrefix 互为前缀时一个租户的 key 可能落在另一个租户的前缀下，同样拒绝
+func (r *TenantRegistry) Replace(tenants []*TenantConfig) error {
+	byName := make(map[string]*TenantConfig, len(tenants))
+	byIndex := make(map[int]string, len(tenants))
+	for _, t := range tenants {
+		if t == nil || len(t.AppName) == 0 {
+			return fmt.Errorf("tenant app name is empty")
+		}
+		if len(t.KeyPrefix) == 0 {
+			return fmt.Errorf("tenant %v key prefix is empty", t.AppName)
+		}
+		if _, ok := byName[t.AppName]; ok {
+			return fmt.Errorf("duplicate tenant %v", t.AppName)
+		}
+		if other, ok := byIndex[t.AppIndex]; ok {
+			return fmt.Errorf("tenant %v and %v share app index %d", other, t.AppName, t.AppIndex)
+		}
+		for _, other := range byName {
+			if strings.HasPrefix(t.KeyPrefix, other.KeyPrefix) || strings.HasPrefix(other.KeyPrefix, t.KeyPrefix) {
+				return fmt.Errorf("tenant %v key prefix %q conflicts with tenant %v key prefix %q", t.AppName, t.KeyPrefix, other.AppName, other.KeyPrefix)
+			}
+		}
+		byName[t.AppName] = t
+		byIndex[t.AppIndex] = t.AppName
+	}
+	r.mu.Lock()
+	r.tenants = byName
+	r.mu.Unlock()
+	return nil
+}
+
+// Tenant registry 为 nil 时返回 nil, nil，表示未开启多租户
+func (r *TenantRegistry) Tenant(appName string) (*TenantConfig, error) {
+	if r == nil {
+		return nil, nil
+	}
+	r.mu.RLock()
+	t, ok := r.tenants[appName]
+	r.mu.RUnlock()
+	if !ok {
+		return nil, &InvalidRequestError{Reason: fmt.Sprintf("unknown app %v", appName)}
+	}
+	if t.Disabled {
+		return nil, NewStatusError(CodePermissionDenied, fmt.Sprintf("app %v is disabled", appName))
+	}
+	return t, nil
+}
+
+// KeyPrefix 未开启多租户或者 app 未注册时返回空字符串
+func (r *TenantRegistry) KeyPrefix(appName string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	if t, ok := r.tenants[appName]; ok {
+		return t.KeyPrefix
+	}
+	return ""
+}
+
+// KeyPrefixes 返回所有租户的 key 前缀，按字典序排列
+func (r *TenantRegistry) KeyPrefixes() []string {
+	if r == nil {
+		return nil
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	prefixes := make([]string, 0, len(r.tenants))
+	for _, t := range r.tenants {
+		prefixes = append(prefixes, t.KeyPrefix)
+	}
+	sort.Strings(prefixes)
+	return prefixes
+}
+
+// keyPrefixOf 返回 key 开头的租户 KeyPrefix，没有匹配的租户时返回空字符串；
+// Replace 保证 KeyPrefix 互不为前缀，所以最多匹配一个租户
+func (r *TenantRegistry) keyPrefixOf(key string) string {
+	if r == nil {
+		return ""
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, t := range r.tenants {
+		if strings.HasPrefix(key, t.KeyPrefix) {
+			return t.KeyPrefix
+		}
+	}
+	return ""
+}
+
+// adminApp 返回 token 可以管理的 app，token 不属于任何租户时 ok 为 false
+func (r *TenantRegistry) adminApp(token string) (appName string, ok bool) {
+	if r == nil || len(token) == 0 {
+		return "", false
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, t := range r.tenants {
+		for _, k := range t.AdminTokens {
+			if len(k) > 0 && subtle.ConstantTimeCompare([]byte(k), []byte(token)) == 1 {
+				return t.AppName, true
+			}
+		}
+	}
+	return "", false
+}
+
+// checkAPIKey 校验请求 metadata 中的 API key，内部重放的请求和已经通过签名认证的请求不校验
+func (t *TenantConfig) checkAPIKey(ctx context.Context) error {
+	if t == nil || len(t.APIKeys) == 0 || isInternalCall(ctx) || isSignedCall(ctx) {
+		return nil
+	}
+	md, _ := MetadataFromIncomingContext(ctx)
+	key := md.Get(APIKeyHeader)
+	if len(key) == 0 {
+		return NewStatusError(CodeUnauthenticated, "missing api key")
+	}
+	for _, k := range t.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(k), []byte(key)) == 1 {
+			return nil
+		}
+	}
+	return NewStatusError(CodePermissionDenied, fmt.Sprintf("api key is not allowed for app %v", t.AppName))
+}
+
+type internalCallKey struct{}
+
+// internalCallContext 标记由 router 自己发起的调用（死信重放、定时消息），
+// 这些消息在第一次进入时已经校验过调用方
+func internalCallContext(ctx context.Context) context.Context {
+	return context.WithValue(ctx, internalCallKey{}, true)
+}
+
+func isInternalCall(ctx context.Context) bool {
+	v, _ := ctx.Value(internalCallKey{}).(bool)
+	return v
+}
+
+// tenantOf 查找请求所属的租户并校验调用方
+func (s *RouterServer) tenantOf(ctx context.Context, appName string) (*TenantConfig, error) {
+	t, err := s.Tenants.Tenant(appName)
+	if err == nil {
+		err = t.checkAPIKey(ctx)
+	}
+	if err != nil {
+		Metrics.Counter(MetricTenantRejected, 1)
+		return nil, err
+	}
+	return t, nil
+}
+
+// appIndex 租户的存储命名空间，未开启多租户时使用全局配置
+func (s *RouterServer) appIndex(appName string) int {
+	if s.Tenants != nil {
+		if t, err := s.Tenants.Tenant(appName); err == nil {
+			return t.AppIndex
+		}
+	}
+	return Get().AppIndex(appName)
+}
+
+func storeEnabled(tenant *TenantConfig) bool {
+	if tenant != nil && tenant.StoreReliableMsg != nil {
+		return *tenant.StoreReliableMsg
+	}
+	return Get().Service.IsStoreReliableMsg
+}
@@ -0,0 +1,48 @@
+package router
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTenantRegistryReplace(t *testing.T) {
+	testCases := []struct {
+		name    string
+		tenants []*TenantConfig
+		wantErr bool
+	}{
+		{name: "valid", tenants: []*TenantConfig{
+			{AppName: "im", AppIndex: 1, KeyPrefix: "{im}"},
+			{AppName: "live", AppIndex: 2, KeyPrefix: "{live}"},
+		}},
+		{name: "empty-app-name", tenants: []*TenantConfig{{AppIndex: 1, KeyPrefix: "{im}"}}, wantErr: true},
+		{name: "empty-key-prefix", tenants: []*TenantConfig{{AppName: "im", AppIndex: 1}}, wantErr: true},
+		{name: "duplicate-app-index", tenants: []*TenantConfig{
+			{AppName: "im", AppIndex: 1, KeyPrefix: "{im}"},
+			{AppName: "live", AppIndex: 1, KeyPrefix: "{live}"},
+		}, wantErr: true},
+		{name: "duplicate-key-prefix", tenants: []*TenantConfig{
+			{AppName: "im", AppIndex: 1, KeyPrefix: "{shared}"},
+			{AppName: "live", AppIndex: 2, KeyPrefix: "{shared}"},
+		}, wantErr: true},
+		{name: "nested-key-prefix", tenants: []*TenantConfig{
+			{AppName: "im", AppIndex: 1, KeyPrefix: "{im}"},
+			{AppName: "live", AppIndex: 2, KeyPrefix: "{im}live"},
+		}, wantErr: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r := newTestTenants(t)
+			err := r.Replace(tc.tenants)
+			if tc.wantErr {
+				assert.Error(t, err)
+				// 校验失败时保留原有配置
+				assert.Equal(t, []string{"{im}", "{live}"}, r.KeyPrefixes())
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, "{live}", r.KeyPrefix("live"))
+		})
+	}
+}
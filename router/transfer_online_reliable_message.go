@@ -8,6 +8,7 @@ import (
 	"fmt"
 	"runtime"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -23,6 +24,18 @@ const (
 
 var NoConnectionErr = errors.New("no conn available")
 
+// InvalidRequestError 请求参数不合法
+type InvalidRequestError struct {
+	Reason string
+}
+
+func (e *InvalidRequestError) Error() string { return e.Reason }
+
+func IsErrInvalidRequest(err error) bool {
+	var ire *InvalidRequestError
+	return errors.As(err, &ire)
+}
+
 // ============== Protobuf Mock ==============
 
 // Any 模拟 protobuf 的 Any 类型
@@ -83,6 +96,9 @@ type config struct {
 		IsNeedTTDB          bool
 		IsStoreReliableMsg  bool
 		DisablePingProcess  bool
+		// AsyncStoreReliableMsg 为 true 时消息在返回之后异步存储，存储失败调用方无法感知；
+		// 默认在返回之前同步存储，存储失败则本次调用失败
+		AsyncStoreReliableMsg bool
 	}
 }
 
@@ -113,6 +129,30 @@ func (applog) Infof(format string, args ...interface{})  { fmt.Printf(format+"\n
 
 var Applog = applog{}
 
+// metrics package mock
+type metrics struct {
+	mu       sync.Mutex
+	counters map[string]int64
+}
+
+func (m *metrics) Counter(name string, delta int64) {
+	m.mu.Lock()
+	m.counters[name] += delta
+	m.mu.Unlock()
+}
+
+func (m *metrics) Value(name string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.counters[name]
+}
+
+var Metrics = &metrics{counters: make(map[string]int64)}
+
+const (
+	MetricMsgExpired = "router_msg_expired"
+)
+
 // connector proto types mock
 type ClientSourceEnum int32
 
@@ -127,21 +167,28 @@ type PushContent struct {
 	Ticker     *I18N
 	Message    string
 	CreateTime int64
+	Silent     bool // 静默推送，客户端只同步数据不弹通知
 }
 
 func (p *PushContent) GetTitle() *I18N    { return p.Title }
 func (p *PushContent) GetValue() *I18N    { return p.Value }
 func (p *PushContent) GetTicker() *I18N   { return p.Ticker }
 func (p *PushContent) GetMessage() string { return p.Message }
+func (p *PushContent) GetSilent() bool    { return p.Silent }
 
 type I18N struct {
-	Value   string
-	Locales map[string]string
-	Params  []string
+	Value        string
+	Locales      map[string]string
+	Params       []string
+	IsCatalogKey bool // 为 true 时 Value 是翻译目录的 key，由 router 按设备 locale 解析
+
+	// optionalParams Params 末尾由 router 追加的参数个数，模板中没有对应的占位符时不参与格式化
+	optionalParams int
 }
 
-func (i *I18N) GetValue() string    { return i.Value }
-func (i *I18N) GetParams() []string { return i.Params }
+func (i *I18N) GetValue() string      { return i.Value }
+func (i *I18N) GetParams() []string   { return i.Params }
+func (i *I18N) GetIsCatalogKey() bool { return i.IsCatalogKey }
 
 type ChatMsg struct {
 	Ticker  string
@@ -163,22 +210,32 @@ type TransmitMessageRequest struct {
 	MsgTypeName     string
 	AppName         string
 	DeviceIdentifer string
+	ExpireAt        int64
 }
 
 // proto_router proto types mock
 type TransferMessageRequest struct {
-	ReceiverId      string
-	MsgId           string
-	MsgType         int32
-	MsgData         *Any
-	Push            *PushContent
-	DeviceIdPushes  []*DeviceIdPush
-	MsgTypeName     string
-	AppName         string
-	DeviceIdentifer string
-	Filters         map[string]string
-	LimitVersion    *LimitVersion
-	ForceLangs      []string
+	ReceiverId     string
+	MsgId          string
+	MsgType        int32
+	MsgData        *Any
+	Push           *PushContent
+	DeviceIdPushes []*DeviceIdPush
+	// DeviceIdPushesOnly 为 true 时只下发给 DeviceIdPushes 中列出的设备，不回退到通用 Push
+	DeviceIdPushesOnly bool
+	MsgTypeName        string
+	AppName            string
+	DeviceIdentifer    string
+	Filters            map[string]string
+	LimitVersion       *LimitVersion
+	ForceLangs         []string
+	ExpireAt           int64 // 过期时间，毫秒时间戳，0 表示不过期
+	TTLSeconds         int32 // 相对过期时间，ExpireAt 为 0 时按接收时间换算成 ExpireAt
+	Priority           MsgPriority
+	// CollapseKey 不为空时，同一个用户同一个 key 在合并窗口内只下发最新的一条推送
+	CollapseKey string
+	// DeliverAt 定时下发时间，毫秒时间戳，不晚于当前时间时立即下发
+	DeliverAt int64
 }
 
 func (r *TransferMessageRequest) GetReceiverId() string              { return r.ReceiverId }
@@ -188,6 +245,7 @@ func (r *TransferMessageRequest) GetMsgData() *Any                   { return r.
 func (r *TransferMessageRequest) GetPush() *PushContent              { return r.Push }
 func (r *TransferMessageRequest) GetDeviceIdPushes() []*DeviceIdPush { return r.DeviceIdPushes }
 func (r *TransferMessageRequest) GetFilters() map[string]string      { return r.Filters }
+func (r *TransferMessageRequest) GetExpireAt() int64                 { return r.ExpireAt }
 
 type DeviceIdPush struct {
 	DeviceIds []string
@@ -229,6 +287,7 @@ type ConnectorClientWrapper struct {
 	DeviceID  string
 	Locale    string
 	Source    string
+	Addr      string // connector 地址
 	UA        *UserAgent
 	Connector ConnectorClient
 }
@@ -274,6 +333,25 @@ type RouterRedisClient interface {
 	HCADSR(ctx context.Context, appID, userId, deviceID, source, addr string) (int64, error)
 }
 
+// TokenBucket 令牌桶参数，Rate 为每秒补充的令牌数，Burst 为桶容量
+type TokenBucket struct {
+	Key   string
+	Rate  float64
+	Burst int
+}
+
+// TokenTaker 可选，RouterRedisClient 实现该接口时令牌桶存放在 Redis 中，多个实例共享
+type TokenTaker interface {
+	// TakeTokens 所有令牌桶都有令牌时各取一个并返回 -1，否则一个令牌都不取，返回第一个没有令牌的桶的下标
+	TakeTokens(ctx context.Context, buckets []TokenBucket) (int, error)
+}
+
+// NonceRecorder 可选，RouterRedisClient 实现该接口时签名请求的 nonce 记录在 Redis 中，多个实例共享
+type NonceRecorder interface {
+	// SetNX key 不存在时写入并设置 ttl（SET NX PX），返回是否写入
+	SetNX(ctx context.Context, key string, ttl time.Duration) (bool, error)
+}
+
 // Default Redis client implementation
 type DefaultRouterRedisClient struct{}
 
@@ -294,6 +372,11 @@ type ReliableMsg interface {
 	InsertMsg(ctx context.Context, appID, userID int, seq int64, deviceIdentifier, msgID, msgData string) error
 }
 
+// ExpirableReliableMsg 支持过期时间的消息存储，存储到期后不再同步给客户端
+type ExpirableReliableMsg interface {
+	InsertMsgWithExpire(ctx context.Context, appID, userID int, seq int64, deviceIdentifier, msgID, msgData string, expireAt int64) error
+}
+
 type DefaultReliableMsg struct{}
 
 func (d *DefaultReliableMsg) InsertMsg(ctx context.Context, appID, userID int, seq int64, deviceIdentifier, msgID, msgData string) error {
@@ -332,17 +415,75 @@ func IsErrUserNotExist(err error) bool {
 // ============== RouterServer 完全照抄原始实现 ==============
 
 type RouterServer struct {
-	Store  RouterRedisClient
-	router Router
-	MsgDB  ReliableMsg
+	Store   RouterRedisClient
+	router  Router
+	MsgDB   ReliableMsg
+	Catalog I18NCatalog // 可选，为 nil 时不解析 I18N 中的目录 key
+	// PushLimits 可选，按平台限制推送负载大小
+	PushLimits PushPayloadLimits
+	// Scheduler 可选，为 nil 时每个请求单独起 goroutine 投递
+	Scheduler *DeliveryScheduler
+	// RateLimiter 可选，按 app 和接收者限流
+	RateLimiter *RateLimiter
+	// ErrorPolicy 可选，为 nil 时使用 DefaultErrorPolicy
+	ErrorPolicy *ErrorPolicy
+	Blacklist   *DeviceBlacklist
+	// DeadLetters 可选，记录无法存储或投递的消息
+	DeadLetters DeadLetterSink
+	// DNDStore 可选，用户免打扰设置
+	DNDStore DNDPreferenceStore
+	// NotificationPrefs 可选，用户按 MsgTypeName 的通知设置
+	NotificationPrefs NotificationPrefProvider
+	// Collapser 可选，按 CollapseKey 合并推送
+	Collapser *PushCollapser
+	// Delayed 可选，未配置时拒绝带 DeliverAt 的请求
+	Delayed *DelayedDelivery
+	// ContentFilter 可选，过滤本地化之后的推送文本和 MsgData
+	ContentFilter ContentFilter
+	// ContentFilterFailClosed 为 true 时过滤器出错按 reject 处理，默认放行原文
+	ContentFilterFailClosed bool
+	// Tenants 可选，配置后按 AppName 隔离配置、存储命名空间、Redis key、限流和调用方 API key
+	Tenants *TenantRegistry
+	// Auth 可选，校验调用方身份以及可以发送的 app 和 MsgType
+	Auth *CallerAuthenticator
+	// Keyring 可选，存储消息之前按 app 加密，同步和重放时通过 OpenStoredMsg 解密
+	Keyring *MsgKeyring
+
+	observers []RouterObserver
+
+	recentSeqs *recentSeqLog
+	// now 当前时间，为 nil 时使用 time.Now，测试中替换为固定时钟
+	now func() time.Time
 }
 
 func NewRouterServer(redisClient RouterRedisClient, msgDB ReliableMsg, router Router) *RouterServer {
 	return &RouterServer{
-		Store:  redisClient,
-		router: router,
-		MsgDB:  msgDB,
+		Store:      redisClient,
+		router:     router,
+		MsgDB:      msgDB,
+		Blacklist:  NewDeviceBlacklist(),
+		recentSeqs: newRecentSeqLog(),
+		now:        time.Now,
+	}
+}
+
+func (s *RouterServer) clock() time.Time {
+	if s.now == nil {
+		return time.Now()
+	}
+	return s.now()
+}
+
+// nowMs 当前毫秒时间戳
+func (s *RouterServer) nowMs() int64 {
+	return s.clock().UnixNano() / 1000000
+}
+
+func (s *RouterServer) errorPolicy() *ErrorPolicy {
+	if s.ErrorPolicy != nil {
+		return s.ErrorPolicy
 	}
+	return DefaultErrorPolicy
 }
 
 // ============== 以下代码完全照抄原始实现 ==============
@@ -357,23 +498,66 @@ func (s *RouterServer) TransferOnlineReliableMessage(ctx context.Context, in *Tr
 	var appIDInt, userIdInt int
 	var rpl = &TransferPushMessageReply{}
 	if len(in.ReceiverId) == 0 {
-		err := errors.New("receiver id is empty when transfer message")
+		err := &InvalidRequestError{Reason: "receiver id is empty when transfer message"}
 		Applog.Error(err)
 		return nil, err
 	}
 	userIdInt, err = strconv.Atoi(in.ReceiverId)
 	if err != nil {
-		err := errors.New("receiver id is not valid")
+		err := &InvalidRequestError{Reason: "receiver id is not valid"}
 		Applog.Error(err)
 		return nil, err
 	}
 	if len(in.GetMsgId()) == 0 {
-		err := fmt.Errorf("msgId is empty when transfer msg, uid: %+v", in.ReceiverId)
+		err := &InvalidRequestError{Reason: fmt.Sprintf("msgId is empty when transfer msg, uid: %+v", in.ReceiverId)}
+		Applog.Error(err)
+		return nil, err
+	}
+	if err := validateDeviceIdPushes(in); err != nil {
+		Applog.Error(err)
+		return nil, err
+	}
+	if ctx, err = s.authorizeCaller(ctx, in); err != nil {
+		Applog.Warnf("transfer msg rejected by caller auth, app: %v, msgId: %v, err: %v", in.AppName, in.MsgId, err)
+		return nil, err
+	}
+	tenant, err := s.tenantOf(ctx, in.AppName)
+	if err != nil {
+		Applog.Warnf("transfer msg rejected by tenant, app: %v, msgId: %v, err: %v", in.AppName, in.MsgId, err)
+		return nil, err
+	}
+	if err := s.filterMsgData(ctx, in); err != nil {
 		Applog.Error(err)
 		return nil, err
 	}
 
-	now := time.Now().UnixNano() / 1000000
+	now := s.nowMs()
+	if in.DeliverAt > now {
+		// 定时消息到期后重新进入本方法，限流、序列号和 TTL 都按下发时间计算
+		if s.Delayed == nil {
+			err := &InvalidRequestError{Reason: fmt.Sprintf("delayed delivery is not configured, msgId: %v", in.MsgId)}
+			Applog.Error(err)
+			return nil, err
+		}
+		if in.ExpireAt > 0 && in.DeliverAt >= in.ExpireAt {
+			err := &InvalidRequestError{Reason: fmt.Sprintf("deliverAt %v is not before expireAt %v, msgId: %v", in.DeliverAt, in.ExpireAt, in.MsgId)}
+			Applog.Error(err)
+			return nil, err
+		}
+		if err := s.Delayed.schedule(ctx, in, now); err != nil {
+			Applog.Errorf("schedule delayed msg err:%+v msgId: %v deliverAt: %v", err, in.MsgId, in.DeliverAt)
+			return nil, err
+		}
+		return rpl, nil
+	}
+	if in.ExpireAt == 0 && in.TTLSeconds > 0 {
+		in.ExpireAt = now + int64(in.TTLSeconds)*1000
+	}
+	if isMsgExpired(in, now) {
+		Metrics.Counter(MetricMsgExpired, 1)
+		Applog.Warnf("msg expired before transfer, msgId: %v, uid: %v, expireAt: %v", in.MsgId, in.ReceiverId, in.ExpireAt)
+		return rpl, nil
+	}
 	if in.GetPush() != nil {
 		in.Push.CreateTime = now
 	}
@@ -385,17 +569,43 @@ func (s *RouterServer) TransferOnlineReliableMessage(ctx context.Context, in *Tr
 		}
 	}
 
-	tm := time.Now()
+	tm := s.clock()
 
-	appIDInt = cfg.AppIndex(in.AppName)
+	appIDInt = s.appIndex(in.AppName)
+	storeMsg := storeEnabled(tenant)
+	deferred := false
+	if s.RateLimiter != nil {
+		if err := s.RateLimiter.AllowTenant(ctx, tenant, in.AppName, in.ReceiverId); err != nil {
+			if !s.RateLimiter.DeferToStorageFor(tenant) || !storeMsg {
+				Applog.Warnf("transfer msg rejected, msgId: %v, err: %v", in.MsgId, err)
+				return nil, err
+			}
+			deferred = true
+		}
+	}
 	seq, err := s.genTTDBSeq(ctx, in.AppName, in.ReceiverId, tm)
 	if err != nil {
 		Applog.Error(err)
 		return nil, err
 	}
+	if s.recentSeqs != nil {
+		s.recentSeqs.Record(in.AppName, in.ReceiverId, seq, in.MsgId, tm)
+	}
+	if deferred {
+		// 超出限流的消息只存储，由客户端下次同步时拉取
+		Metrics.Counter(MetricMsgDeferred, 1)
+		Applog.Warnf("transfer msg deferred to storage by rate limit, msgId: %v, uid: %v", in.MsgId, in.ReceiverId)
+		if err := s.persistReliableMsg(Tracing.PropagateContextWithServiceContext(ctx), in, appIDInt, userIdInt, seq); err != nil {
+			return nil, err
+		}
+		s.emitAccepted(ctx, in)
+		return rpl, nil
+	}
 
 	connectorWrappers := s.router.PickConnectors(ctx, in.AppName, in.ReceiverId, in.DeviceIdentifer, in.GetFilters())
+	connectorWrappers = filterDeviceIdPushTargets(in, indexDeviceIdPushes(in), connectorWrappers)
 	if len(connectorWrappers) == 0 {
+		s.emitAccepted(ctx, in)
 		return rpl, nil
 	}
 	for _, w := range connectorWrappers {
@@ -409,80 +619,224 @@ func (s *RouterServer) TransferOnlineReliableMessage(ctx context.Context, in *Tr
 	rpl.IsUserOnline = true
 	ctx = Tracing.PropagateContextWithServiceContext(ctx)
 	//TODO 一期不做消息存储
-	if cfg.Service.IsStoreReliableMsg {
-		go func() {
-			defer func() {
-				if r := recover(); r != nil {
-					buf := make([]byte, 1<<15)
-					n := runtime.Stack(buf, false)
-					err := fmt.Errorf("%v, STACK: %s", r, buf[0:n])
-					Applog.Errorf("TransferReliableMessage  panic :%+v", err)
-				}
-			}()
-			raw, err := proto.Marshal(in)
-			if err != nil {
-				Applog.Errorf("proto.Marshal err:%+v msg is :%+v appID is :%d userID is :%d, seq is :%d", err, *in, appIDInt, userIdInt, seq)
-				return
-			}
-			err = s.MsgDB.InsertMsg(ctx, appIDInt, userIdInt, seq, in.DeviceIdentifer, in.MsgId, base64.StdEncoding.EncodeToString(raw))
-			if err != nil {
-				Applog.Errorf("insert msgdb err:%+v msg is :%+v appID is :%d userID is :%d, seq is :%d", err, *in, appIDInt, userIdInt, seq)
-			}
-		}()
+	// persisted 消息已经落库或者已经交给异步存储，之后下发失败时调用方重试会重复存储
+	// stored 只在同步存储成功时为 true，异步存储可能失败，不能据此合并推送
+	persisted, stored := false, false
+	if storeMsg {
+		if err := s.persistReliableMsg(ctx, in, appIDInt, userIdInt, seq); err != nil {
+			return nil, err
+		}
+		persisted = true
+		stored = !cfg.Service.AsyncStoreReliableMsg
+	}
+	s.emitAccepted(ctx, in)
+	if err := s.collapseOrDispatch(ctx, in, connectorWrappers, stored); err != nil {
+		if !persisted {
+			return nil, err
+		}
+		// 消息已经落库或者由异步存储负责（失败时进入死信），客户端下次同步时可以拉取，不再让调用方重试
+		Metrics.Counter(MetricMsgDeferred, 1)
+	}
+	return rpl, nil
+}
+
+// dispatch 把投递任务交给 Scheduler，未配置 Scheduler 时直接异步投递
+func (s *RouterServer) dispatch(ctx context.Context, in *TransferMessageRequest, wrappers []*ConnectorClientWrapper) error {
+	if s.Scheduler == nil {
+		go runDeliveryTask(func() {
+			s.deliver(ctx, in, wrappers)
+		})
+		return nil
+	}
+	err := s.Scheduler.Submit(in.Priority, func() {
+		s.deliver(ctx, in, wrappers)
+	})
+	if err != nil {
+		Applog.Errorf("submit delivery err:%+v msgId: %v priority: %v", err, in.MsgId, in.Priority)
+	}
+	return err
+}
+
+// persistReliableMsg 默认同步存储，失败时返回错误；开启 AsyncStoreReliableMsg 后异步存储并直接返回 nil，
+// 失败的消息进入死信
+func (s *RouterServer) persistReliableMsg(ctx context.Context, in *TransferMessageRequest, appIDInt, userIdInt int, seq int64) error {
+	if !Get().Service.AsyncStoreReliableMsg {
+		return s.storeReliableMsg(ctx, in, appIDInt, userIdInt, seq)
 	}
 	go func() {
-		for _, wrapper := range connectorWrappers {
-			if in.LimitVersion != nil && s.isLimitVersion(wrapper, in.LimitVersion) {
-				Applog.Debugf("isLimitVersion msg is :%+v", *in)
-				continue
-			}
-			if s.isNotForcedLangs(wrapper.Locale, in.ForceLangs) {
-				continue
-			}
-			push := PushContent{}
-			originPush := s.getOriginPush(in, wrapper.DeviceID)
-			if originPush != nil {
-				push = processPush(*originPush, wrapper.Locale)
-			}
+		if err := s.storeReliableMsg(ctx, in, appIDInt, userIdInt, seq); err != nil {
+			dl := newDeadLetter(in, "", DeadLetterStageStore, err, 1, s.clock())
+			dl.Seq = seq
+			s.saveDeadLetter(ctx, dl)
+		}
+	}()
+	return nil
+}
+
+// storeReliableMsg 把消息序列化后写入消息存储，供客户端同步
+func (s *RouterServer) storeReliableMsg(ctx context.Context, in *TransferMessageRequest, appIDInt, userIdInt int, seq int64) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			buf := make([]byte, 1<<15)
+			n := runtime.Stack(buf, false)
+			err = fmt.Errorf("%v, STACK: %s", r, buf[0:n])
+			Applog.Errorf("TransferReliableMessage  panic :%+v", err)
+		}
+	}()
+	if isMsgExpired(in, s.nowMs()) {
+		Metrics.Counter(MetricMsgExpired, 1)
+		Applog.Warnf("msg expired before store, msgId: %v, uid: %v", in.MsgId, in.ReceiverId)
+		return nil
+	}
+	raw, err := proto.Marshal(in)
+	if err != nil {
+		Applog.Errorf("proto.Marshal err:%+v msg is :%+v appID is :%d userID is :%d, seq is :%d", err, *in, appIDInt, userIdInt, seq)
+		return err
+	}
+	msgData, err := s.sealStoredMsg(storedMsgBinding(in.AppName, userIdInt, in.MsgId), base64.StdEncoding.EncodeToString(raw))
+	if err != nil {
+		return err
+	}
+	if db, ok := s.MsgDB.(ExpirableReliableMsg); ok && in.ExpireAt > 0 {
+		err = db.InsertMsgWithExpire(ctx, appIDInt, userIdInt, seq, in.DeviceIdentifer, in.MsgId, msgData, in.ExpireAt)
+	} else {
+		err = s.MsgDB.InsertMsg(ctx, appIDInt, userIdInt, seq, in.DeviceIdentifer, in.MsgId, msgData)
+	}
+	if err != nil {
+		Applog.Errorf("insert msgdb err:%+v msg is :%+v appID is :%d userID is :%d, seq is :%d", err, *in, appIDInt, userIdInt, seq)
+	}
+	return err
+}
 
-			if ptypes.Is(in.MsgData, &ChatMsg{}) {
-				data, err := processChatMsg(in.GetMsgData(), push)
-				if err != nil {
-					Applog.Error(err)
-					return
+// deliver 依次向用户的在线设备下发消息
+func (s *RouterServer) deliver(ctx context.Context, in *TransferMessageRequest, connectorWrappers []*ConnectorClientWrapper) {
+	pushIndex := indexDeviceIdPushes(in)
+	dndPolicy := s.activeDNDPolicy(ctx, in)
+	prefs := s.notificationPrefs(ctx, in)
+	for _, wrapper := range connectorWrappers {
+		if in.LimitVersion != nil && s.isLimitVersion(wrapper, in.LimitVersion) {
+			Applog.Debugf("isLimitVersion msg is :%+v", *in)
+			s.emitDeviceSkipped(ctx, in, wrapper, SkipReasonLimitVersion)
+			continue
+		}
+		if s.isNotForcedLangs(wrapper.Locale, in.ForceLangs) {
+			s.emitDeviceSkipped(ctx, in, wrapper, SkipReasonForceLang)
+			continue
+		}
+		if isMsgExpired(in, s.nowMs()) {
+			Metrics.Counter(MetricMsgExpired, 1)
+			Applog.Warnf("msg expired before delivery, msgId: %v, deviceID: %v", in.MsgId, wrapper.DeviceID)
+			s.emitDeviceSkipped(ctx, in, wrapper, SkipReasonExpired)
+			continue
+		}
+		if s.Blacklist != nil && s.Blacklist.Contains(in.AppName, in.ReceiverId, wrapper.DeviceID) {
+			Applog.Debugf("skip blacklisted device %v, uid: %v, msgId: %v", wrapper.DeviceID, in.ReceiverId, in.MsgId)
+			s.emitDeviceSkipped(ctx, in, wrapper, SkipReasonBlacklisted)
+			continue
+		}
+		prefAction := prefs.actionFor(wrapper.DeviceID, in.MsgTypeName)
+		if prefAction == NotifyMute {
+			Metrics.Counter(MetricPushMutedByPref, 1)
+			Applog.Debugf("skip muted msg type %v, deviceID: %v, uid: %v, msgId: %v", in.MsgTypeName, wrapper.DeviceID, in.ReceiverId, in.MsgId)
+			s.emitDeviceSkipped(ctx, in, wrapper, SkipReasonMuted)
+			continue
+		}
+		limit := s.PushLimits.limitOf(wrapper)
+		if err := checkMsgDataLimit(in.GetMsgData(), limit); err != nil {
+			Applog.Warnf("skip device %v, msgId: %v, err: %v", wrapper.DeviceID, in.GetMsgId(), err)
+			s.emitDeviceSkipped(ctx, in, wrapper, SkipReasonPayloadLimit)
+			continue
+		}
+		push := PushContent{}
+		originPush := pushIndex.pushFor(in, wrapper.DeviceID)
+		if originPush != nil {
+			push = processPush(*originPush, wrapper.Locale, s.Catalog)
+			if s.ContentFilter != nil {
+				var res *ContentFilterResult
+				push, res = s.filterPush(ctx, push)
+				s.reportContentFilter(in, wrapper.DeviceID, "push", res)
+				if res.Action == ContentReject {
+					s.emitDeviceSkipped(ctx, in, wrapper, SkipReasonContentRejected)
+					continue
 				}
-				in.MsgData = data
 			}
-			err := wrapper.Connector.TransmitMessage(ctx, &TransmitMessageRequest{
-				UserId:          in.ReceiverId,
-				MsgId:           in.GetMsgId(),
-				MsgType:         in.GetMsgType(),
-				MsgData:         in.GetMsgData(),
-				Push:            &push,
-				MsgTypeName:     in.MsgTypeName,
-				AppName:         in.AppName,
-				DeviceIdentifer: wrapper.DeviceID,
-			})
+			push = applyPushLimit(push, limit)
+			push = applyDND(push, dndPolicy)
+			if prefAction == NotifySilent && !push.Silent {
+				Metrics.Counter(MetricPushSilencedByPref, 1)
+				push.Silent = true
+			}
+		}
+
+		if ptypes.Is(in.MsgData, &ChatMsg{}) {
+			data, err := processChatMsg(in.GetMsgData(), push)
 			if err != nil {
-				s.handleError(ctx, err, in.AppName, in.ReceiverId, wrapper.DeviceID, wrapper.Source)
+				Applog.Error(err)
+				s.putDeadLetter(ctx, in, wrapper.DeviceID, DeadLetterStageDeliver, err, 0)
+				s.emitDeliverResult(ctx, in, wrapper, err, 0)
+				return
 			}
+			in.MsgData = data
 		}
-	}()
-	return rpl, nil
+		req := &TransmitMessageRequest{
+			UserId:          in.ReceiverId,
+			MsgId:           in.GetMsgId(),
+			MsgType:         in.GetMsgType(),
+			MsgData:         in.GetMsgData(),
+			Push:            &push,
+			MsgTypeName:     in.MsgTypeName,
+			AppName:         in.AppName,
+			DeviceIdentifer: wrapper.DeviceID,
+			ExpireAt:        in.GetExpireAt(),
+		}
+		s.transmit(ctx, in, wrapper, req, 1)
+	}
 }
 
-func (s *RouterServer) getOriginPush(req *TransferMessageRequest, deviceId string) *PushContent {
-	if len(req.GetDeviceIdPushes()) > 0 {
-		for _, deviceIdPush := range req.GetDeviceIdPushes() {
-			for _, tmpDeviceId := range deviceIdPush.GetDeviceIds() {
-				if tmpDeviceId == deviceId {
-					return deviceIdPush.GetPush()
-				}
-			}
+// transmit 第 attempt 次向设备发送，需要重试时按退避时间重新排队，等待期间不占用投递 worker
+func (s *RouterServer) transmit(ctx context.Context, in *TransferMessageRequest, wrapper *ConnectorClientWrapper, req *TransmitMessageRequest, attempt int) {
+	err := wrapper.Connector.TransmitMessage(ctx, req)
+	if err != nil && s.handleError(ctx, err, in.AppName, in.ReceiverId, wrapper.DeviceID, wrapper.Source, wrapper.Addr, attempt) {
+		if isMsgExpired(in, s.nowMs()) {
+			Metrics.Counter(MetricMsgExpired, 1)
+			Applog.Warnf("msg expired before retry, msgId: %v, deviceID: %v", in.MsgId, wrapper.DeviceID)
+			s.emitDeviceSkipped(ctx, in, wrapper, SkipReasonExpired)
+			return
 		}
+		Metrics.Counter(MetricDeliverRetry, 1)
+		s.retryLater(ctx, in, wrapper, req, attempt, err)
+		return
+	}
+	s.finishTransmit(ctx, in, wrapper, err, attempt)
+}
+
+// retryLater 配置了 Scheduler 时退避结束后重新进入原优先级队列，否则在定时器中直接重试
+func (s *RouterServer) retryLater(ctx context.Context, in *TransferMessageRequest, wrapper *ConnectorClientWrapper, req *TransmitMessageRequest, attempt int, lastErr error) {
+	delay := s.errorPolicy().backoff(attempt)
+	retry := func() { s.transmit(ctx, in, wrapper, req, attempt+1) }
+	if s.Scheduler == nil {
+		time.AfterFunc(delay, retry)
+		return
+	}
+	dropped := func(err error) {
+		Applog.Errorf("requeue delivery retry err:%+v msgId: %v deviceID: %v", err, in.MsgId, wrapper.DeviceID)
+		s.finishTransmit(ctx, in, wrapper, lastErr, attempt)
+	}
+	if err := s.Scheduler.SubmitAfter(in.Priority, delay, retry, dropped); err != nil {
+		dropped(err)
+	}
+}
+
+func (s *RouterServer) finishTransmit(ctx context.Context, in *TransferMessageRequest, wrapper *ConnectorClientWrapper, err error, attempt int) {
+	if err != nil {
+		s.putDeliverDeadLetter(ctx, in, wrapper.DeviceID, err, attempt)
 	}
+	s.emitDeliverResult(ctx, in, wrapper, err, attempt)
+}
 
-	return req.GetPush()
+// isMsgExpired 判断消息在 now（毫秒时间戳）时是否已过期
+func isMsgExpired(in *TransferMessageRequest, now int64) bool {
+	return in.GetExpireAt() > 0 && in.GetExpireAt() <= now
 }
 
 /*
@@ -493,7 +847,7 @@ func (s *RouterServer) getOriginPush(req *TransferMessageRequest, deviceId strin
 
 func (s *RouterServer) genTTDBSeq(ctx context.Context, appID, userID string, tm time.Time) (int64, error) {
 	secTmStr := tm.Format("20060102150405")
-	seqKey := ReliableMsgSeqPre + appID + RedisInterval + userID + secTmStr
+	seqKey := s.Tenants.KeyPrefix(appID) + ReliableMsgSeqPre + appID + RedisInterval + userID + secTmStr
 	seq, err := s.Store.GenSequenceID(ctx, seqKey, SeqExpireSeconds)
 	if err != nil {
 		return 0, err
@@ -517,21 +871,33 @@ func processChatMsg(msgData *Any, push PushContent) (*Any, error) {
 	return ptypes.MarshalAny(&chatMsg)
 }
 
-func processPush(push PushContent, locale string) PushContent {
+func processPush(push PushContent, locale string, catalog I18NCatalog) PushContent {
 	if push.GetTitle() != nil {
-		push.Title = parseI18n(*push.Title, locale)
+		push.Title = parseI18n(*push.Title, locale, catalog)
 	}
 	if push.GetValue() != nil {
-		push.Value = parseI18n(*push.Value, locale)
+		push.Value = parseI18n(*push.Value, locale, catalog)
 	}
 	if push.GetTicker() != nil {
-		push.Ticker = parseI18n(*push.Ticker, locale)
+		push.Ticker = parseI18n(*push.Ticker, locale, catalog)
 	}
 	return push
 }
 
-func parseI18n(i18n I18N, locale string) *I18N {
+func parseI18n(i18n I18N, locale string, catalog I18NCatalog) *I18N {
 	var localeStr string
+	if i18n.GetIsCatalogKey() {
+		// 目录中找不到 key 时回退到请求内联的 Locales，key 本身不展示给用户
+		if s, ok := lookupCatalog(catalog, i18n.Value, locale); ok {
+			i18n.Locales = map[string]string{locale: s}
+		} else if len(i18n.Locales) == 0 {
+			err := fmt.Errorf("i18n key %v not found in catalog, locale %v", i18n.Value, locale)
+			Applog.Error(err)
+			return nil
+		}
+		i18n.Value = ""
+		i18n.IsCatalogKey = false
+	}
 	if s, ok := i18n.Locales[locale]; ok {
 		localeStr = s
 	} else if len(i18n.Value) > 0 {
@@ -543,9 +909,16 @@ func parseI18n(i18n I18N, locale string) *I18N {
 		Applog.Error(err)
 		return nil
 	}
-	if len(i18n.GetParams()) > 0 {
-		s := make([]interface{}, len(i18n.GetParams()))
-		for i, v := range i18n.GetParams() {
+	params := i18n.GetParams()
+	if i18n.optionalParams > 0 {
+		required := len(params) - i18n.optionalParams
+		if n := countFormatVerbs(localeStr); n < len(params) {
+			params = params[:max(required, n)]
+		}
+	}
+	if len(params) > 0 {
+		s := make([]interface{}, len(params))
+		for i, v := range params {
 			s[i] = v
 		}
 		i18n.Value = fmt.Sprintf(localeStr, s...)
@@ -554,12 +927,33 @@ func parseI18n(i18n I18N, locale string) *I18N {
 	}
 	i18n.Locales = nil
 	i18n.Params = nil
+	i18n.optionalParams = 0
 	return &i18n
 }
 
+// countFormatVerbs 返回模板中的格式化占位符个数，%% 不计入
+func countFormatVerbs(format string) int {
+	n := 0
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			continue
+		}
+		if i+1 < len(format) && format[i+1] == '%' {
+			i++
+			continue
+		}
+		n++
+	}
+	return n
+}
+
+// isLimitVersion 设备版本不在 limit 范围内时返回 true，没有 UA 的设备无法判断版本，同样不下发
 func (s *RouterServer) isLimitVersion(wrapper *ConnectorClientWrapper, limit *LimitVersion) bool {
 	var min, max string
 	ua := wrapper.UA
+	if ua == nil {
+		return true
+	}
 	switch ua.Source {
 	case CLIENT_SOURCE_ANDROID:
 		min = limit.MinAndroidVersion
@@ -618,15 +1012,56 @@ func (p *RouterServer) isNotForcedLangs(lang string, forcedLangs []string) bool
 	return false
 }
 
-func (s *RouterServer) handleError(ctx context.Context, err error, appID, userId, deviceID, source string) {
-	if IsErrUserNotExist(err) { //如果connector返回该错误，需要主动删除相应的路由信息
-		if userId == "0" { // ANONYMOUS_USER_ID_STRING mock
-			s.Store.HCAD(ctx, appID, userId, deviceID, source, "")
-		} else {
-			s.Store.HCADSR(ctx, appID, userId, deviceID, source, "")
+// handleError 按错误分类策略处理 connector 返回的错误，返回 true 表示需要重试，attempt 为已经尝试的次数
+func (s *RouterServer) handleError(ctx context.Context, err error, appID, userId, deviceID, source, addr string, attempt int) bool {
+	policy := s.errorPolicy()
+	class := policy.Classify(err)
+	switch policy.ActionOf(class) {
+	case ErrActionRetry:
+		if attempt <= policy.MaxRetries {
+			Applog.Warnf("transmit msg err: %v, class: %v, uid: %v, deviceID %v, attempt: %v, retry", err, class, userId, deviceID, attempt)
+			return true
 		}
-		Applog.Infof("delete router info, uid: %v, deviceID %v, source: %v by connector", userId, deviceID, source)
-	} else {
+		Applog.Errorf("transmit msg err: %v, class: %v, uid: %v, deviceID %v, give up after %v attempts", err, class, userId, deviceID, attempt)
+	case ErrActionDeleteRoute:
+		// 设备已失效时无条件删除；connector 不可用时只删除仍指向该 connector 的路由，设备重连到其它 connector 后的路由不受影响
+		if class != ErrClassConnectorGone {
+			addr = ""
+		}
+		s.deleteRoute(ctx, appID, userId, deviceID, source, addr, class)
+	case ErrActionBlacklistDevice:
+		if s.Blacklist != nil {
+			s.Blacklist.Add(appID, userId, deviceID, policy.BlacklistTTL)
+			Metrics.Counter(MetricDeviceBlacklisted, 1)
+		}
+		Applog.Warnf("blacklist device, uid: %v, deviceID %v, source: %v, class: %v, err: %v", userId, deviceID, source, class, err)
+	default:
 		Applog.Error(err)
 	}
+	return false
+}
+
+// compareAndDeleteRoute 匿名用户只有主路由，登录用户需要同时删除二级路由
+func (s *RouterServer) compareAndDeleteRoute(ctx context.Context, appID, userId, deviceID, source, addr string) (int64, error) {
+	if userId == AnonymousUserIDStr {
+		return s.Store.HCAD(ctx, appID, userId, deviceID, source, addr)
+	}
+	return s.Store.HCADSR(ctx, appID, userId, deviceID, source, addr)
+}
+
+func (s *RouterServer) deleteRoute(ctx context.Context, appID, userId, deviceID, source, addr string, class ErrorClass) {
+	n, err := s.compareAndDeleteRoute(ctx, appID, userId, deviceID, source, addr)
+	if err != nil {
+		Metrics.Counter(MetricRouteDeleteFailed, 1)
+		Applog.Errorf("delete router info err:%+v uid: %v, deviceID %v, source: %v, class: %v", err, userId, deviceID, source, class)
+		return
+	}
+	if n == 0 {
+		Metrics.Counter(MetricRouteDeleteMiss, 1)
+		Applog.Infof("router info already changed, uid: %v, deviceID %v, source: %v, addr: %v", userId, deviceID, source, addr)
+		return
+	}
+	Metrics.Counter(MetricRouteDeleted, 1)
+	Applog.Infof("delete router info, uid: %v, deviceID %v, source: %v, class: %v by connector", userId, deviceID, source, class)
+	s.emit(ctx, &RouterEvent{Type: EventRouteDeleted, AppName: appID, UserId: userId, DeviceID: deviceID, Source: source, Reason: class.String()})
 }
@@ -0,0 +1,386 @@
+package router
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// testNow 测试使用的固定时间
+var testNow = time.Unix(1700000000, 0)
+
+func testNowMs() int64 {
+	return testNow.UnixNano() / 1000000
+}
+
+// fakeConnector 记录收到的 TransmitMessage 请求，errs 依次作为每次调用的返回值，用完后返回 err
+type fakeConnector struct {
+	addr string
+	err  error
+	errs []error
+
+	mu   sync.Mutex
+	reqs []*TransmitMessageRequest
+}
+
+func (c *fakeConnector) TransmitMessage(ctx context.Context, req *TransmitMessageRequest) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.reqs = append(c.reqs, req)
+	if len(c.errs) > 0 {
+		err := c.errs[0]
+		c.errs = c.errs[1:]
+		return err
+	}
+	return c.err
+}
+
+func (c *fakeConnector) requests() []*TransmitMessageRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*TransmitMessageRequest(nil), c.reqs...)
+}
+
+// staticRouter 总是返回同一组设备
+type staticRouter []*ConnectorClientWrapper
+
+func (r staticRouter) PickConnectors(ctx context.Context, appName, userID, deviceIdentifier string, filters map[string]string) []*ConnectorClientWrapper {
+	var wrappers []*ConnectorClientWrapper
+	for _, w := range r {
+		if deviceIdentifier == "" || w.DeviceID == deviceIdentifier {
+			wrappers = append(wrappers, w)
+		}
+	}
+	return wrappers
+}
+
+type storedMsg struct {
+	appID    int
+	userID   int
+	seq      int64
+	msgID    string
+	msgData  string
+	expireAt int64
+}
+
+// memMsgDB 内存中的消息存储，err 非空时写入失败，limits 记录 ListMsgs 收到的 limit
+type memMsgDB struct {
+	err error
+
+	mu     sync.Mutex
+	msgs   []storedMsg
+	limits []int
+}
+
+func (d *memMsgDB) InsertMsg(ctx context.Context, appID, userID int, seq int64, deviceIdentifier, msgID, msgData string) error {
+	return d.InsertMsgWithExpire(ctx, appID, userID, seq, deviceIdentifier, msgID, msgData, 0)
+}
+
+func (d *memMsgDB) InsertMsgWithExpire(ctx context.Context, appID, userID int, seq int64, deviceIdentifier, msgID, msgData string, expireAt int64) error {
+	if d.err != nil {
+		return d.err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.msgs = append(d.msgs, storedMsg{appID: appID, userID: userID, seq: seq, msgID: msgID, msgData: msgData, expireAt: expireAt})
+	return nil
+}
+
+// ListMsgs 按写入顺序从新到旧返回用户的消息
+func (d *memMsgDB) ListMsgs(ctx context.Context, appID, userID int, limit int) ([]*StoredReliableMsg, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.limits = append(d.limits, limit)
+	var res []*StoredReliableMsg
+	for i := len(d.msgs) - 1; i >= 0 && len(res) < limit; i-- {
+		if m := d.msgs[i]; m.appID == appID && m.userID == userID {
+			res = append(res, &StoredReliableMsg{Seq: m.seq, MsgId: m.msgID, MsgData: m.msgData})
+		}
+	}
+	return res, nil
+}
+
+func (d *memMsgDB) stored() []storedMsg {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]storedMsg(nil), d.msgs...)
+}
+
+// recordingObserver 把事件写入带缓冲的 channel，测试按顺序等待
+type recordingObserver struct {
+	events chan *RouterEvent
+}
+
+func newRecordingObserver() *recordingObserver {
+	return &recordingObserver{events: make(chan *RouterEvent, 256)}
+}
+
+func (o *recordingObserver) OnRouterEvent(ctx context.Context, ev *RouterEvent) {
+	o.events <- ev
+}
+
+// next 等待下一个 typ 类型的事件，跳过其它类型
+func (o *recordingObserver) next(t *testing.T, typ RouterEventType) *RouterEvent {
+	t.Helper()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case ev := <-o.events:
+			if ev.Type == typ {
+				return ev
+			}
+		case <-timeout:
+			t.Fatalf("timeout waiting for %v event", typ)
+			return nil
+		}
+	}
+}
+
+// drain 返回已经收到的全部事件
+func (o *recordingObserver) drain() []*RouterEvent {
+	var evs []*RouterEvent
+	for {
+		select {
+		case ev := <-o.events:
+			evs = append(evs, ev)
+		default:
+			return evs
+		}
+	}
+}
+
+// withServiceConfig 修改全局配置，测试结束后还原
+func withServiceConfig(t *testing.T, fn func(c *config)) {
+	t.Helper()
+	old := mockCfg.Service
+	fn(mockCfg)
+	t.Cleanup(func() { mockCfg.Service = old })
+}
+
+type testServer struct {
+	*RouterServer
+	db       *memMsgDB
+	observer *recordingObserver
+}
+
+// newTestServer 返回使用固定时钟、内存存储和固定设备的 RouterServer
+func newTestServer(t *testing.T, wrappers ...*ConnectorClientWrapper) *testServer {
+	t.Helper()
+	db := &memMsgDB{}
+	s := NewRouterServer(&DefaultRouterRedisClient{}, db, staticRouter(wrappers))
+	s.now = func() time.Time { return testNow }
+	o := newRecordingObserver()
+	s.RegisterObserver(o)
+	return &testServer{RouterServer: s, db: db, observer: o}
+}
+
+func iosDevice(deviceID string, conn ConnectorClient) *ConnectorClientWrapper {
+	return &ConnectorClientWrapper{
+		DeviceID:  deviceID,
+		Locale:    DefaultLocale,
+		Source:    "ios",
+		Addr:      "10.0.0.1:80",
+		UA:        &UserAgent{Source: CLIENT_SOURCE_IOS, AppVersion: "1.0.0"},
+		Connector: conn,
+	}
+}
+
+func newTestRequest() *TransferMessageRequest {
+	return &TransferMessageRequest{
+		ReceiverId: "42",
+		MsgId:      "m1",
+		MsgType:    1,
+		AppName:    "im",
+		Push:       &PushContent{Title: &I18N{Value: "title"}, Value: &I18N{Value: "value"}},
+	}
+}
+
+// decodeStored 还原 storeReliableMsg 写入的 appName 下的请求
+func decodeStored(t *testing.T, s *RouterServer, appName string, msg storedMsg) *TransferMessageRequest {
+	t.Helper()
+	data, err := s.OpenStoredMsg(storedMsgBinding(appName, msg.userID, msg.msgID), msg.msgData)
+	assert.NoError(t, err)
+	raw, err := base64.StdEncoding.DecodeString(data)
+	assert.NoError(t, err)
+	var in TransferMessageRequest
+	assert.NoError(t, proto.Unmarshal(raw, &in))
+	return &in
+}
+
+func TestTransferOnlineReliableMessageTTL(t *testing.T) {
+	nowMs := testNowMs()
+	testCases := []struct {
+		name         string
+		expireAt     int64
+		ttlSeconds   int32
+		wantExpired  bool
+		wantExpireAt int64
+	}{
+		{name: "no-ttl", wantExpireAt: 0},
+		{name: "ttl-converted", ttlSeconds: 30, wantExpireAt: nowMs + 30000},
+		{name: "expire-at-wins-over-ttl", expireAt: nowMs + 5000, ttlSeconds: 30, wantExpireAt: nowMs + 5000},
+		{name: "already-expired", expireAt: nowMs, wantExpired: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withServiceConfig(t, func(c *config) { c.Service.IsStoreReliableMsg = true })
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			in := newTestRequest()
+			in.ExpireAt = tc.expireAt
+			in.TTLSeconds = tc.ttlSeconds
+			expired := Metrics.Value(MetricMsgExpired)
+
+			rpl, err := ts.TransferOnlineReliableMessage(context.Background(), in)
+			assert.NoError(t, err)
+			if tc.wantExpired {
+				assert.False(t, rpl.IsUserOnline)
+				assert.Equal(t, expired+1, Metrics.Value(MetricMsgExpired))
+				assert.Empty(t, ts.db.stored())
+				return
+			}
+			assert.True(t, rpl.IsUserOnline)
+			ts.observer.next(t, EventMsgDelivered)
+			reqs := conn.requests()
+			if assert.Len(t, reqs, 1) {
+				assert.Equal(t, tc.wantExpireAt, reqs[0].ExpireAt)
+				assert.Equal(t, nowMs, reqs[0].Push.CreateTime)
+			}
+			stored := ts.db.stored()
+			if assert.Len(t, stored, 1) {
+				assert.Equal(t, tc.wantExpireAt, stored[0].expireAt)
+			}
+		})
+	}
+}
+
+func TestDeliverSkipsExpiredMsg(t *testing.T) {
+	conn := &fakeConnector{}
+	ts := newTestServer(t, iosDevice("d1", conn))
+	in := newTestRequest()
+	in.ExpireAt = testNowMs() - 1
+	expired := Metrics.Value(MetricMsgExpired)
+
+	ts.deliver(context.Background(), in, []*ConnectorClientWrapper{iosDevice("d1", conn)})
+	ev := ts.observer.next(t, EventDeviceSkipped)
+	assert.Equal(t, SkipReasonExpired, ev.Reason)
+	assert.Empty(t, conn.requests())
+	assert.Equal(t, expired+1, Metrics.Value(MetricMsgExpired))
+}
+
+func TestStoreReliableMsgSkipsExpired(t *testing.T) {
+	ts := newTestServer(t)
+	in := newTestRequest()
+	in.ExpireAt = testNowMs()
+	assert.NoError(t, ts.storeReliableMsg(context.Background(), in, 0, 42, 1))
+	assert.Empty(t, ts.db.stored())
+
+	in.ExpireAt = testNowMs() + 1
+	assert.NoError(t, ts.storeReliableMsg(context.Background(), in, 0, 42, 1))
+	if stored := ts.db.stored(); assert.Len(t, stored, 1) {
+		assert.Equal(t, in.ExpireAt, stored[0].expireAt)
+		assert.Equal(t, "m1", decodeStored(t, ts.RouterServer, "im", stored[0]).MsgId)
+	}
+}
+
+func TestIsMsgExpired(t *testing.T) {
+	testCases := []struct {
+		name     string
+		expireAt int64
+		now      int64
+		want     bool
+	}{
+		{name: "never", expireAt: 0, now: 100, want: false},
+		{name: "before", expireAt: 101, now: 100, want: false},
+		{name: "at", expireAt: 100, now: 100, want: true},
+		{name: "after", expireAt: 99, now: 100, want: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.want, isMsgExpired(&TransferMessageRequest{ExpireAt: tc.expireAt}, tc.now))
+		})
+	}
+}
+
+func TestTransferStoresBeforeAck(t *testing.T) {
+	testCases := []struct {
+		name          string
+		store         bool
+		async         bool
+		dbErr         error
+		stopScheduler bool
+		wantErr       bool
+		wantStored    int // 返回时已经写入的消息数
+		wantSent      int
+		wantDeferred  bool
+	}{
+		{name: "sync-stored-before-return", store: true, wantStored: 1, wantSent: 1},
+		{name: "sync-store-failed-rejects", store: true, dbErr: errors.New("db down"), wantErr: true},
+		{name: "async-store-failure-not-reported", store: true, async: true, dbErr: errors.New("db down"), wantSent: 1},
+		{name: "storage-disabled", wantSent: 1},
+		{name: "dispatch-failed-after-store", store: true, stopScheduler: true, wantStored: 1, wantDeferred: true},
+		{name: "dispatch-failed-without-store", stopScheduler: true, wantErr: true},
+		// 已经交给异步存储的消息下发失败时不让调用方重试，避免重复存储
+		{name: "async-dispatch-failed-after-handoff", store: true, async: true, dbErr: errors.New("db down"), stopScheduler: true, wantDeferred: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			withServiceConfig(t, func(c *config) {
+				c.Service.IsStoreReliableMsg = tc.store
+				c.Service.AsyncStoreReliableMsg = tc.async
+			})
+			conn := &fakeConnector{}
+			ts := newTestServer(t, iosDevice("d1", conn))
+			ts.db.err = tc.dbErr
+			if tc.stopScheduler {
+				ds, _ := newTestScheduler(t, 1, nil)
+				ds.Stop()
+				ts.Scheduler = ds
+			}
+			deferred := Metrics.Value(MetricMsgDeferred)
+
+			rpl, err := ts.TransferOnlineReliableMessage(context.Background(), newTestRequest())
+			assert.Len(t, ts.db.stored(), tc.wantStored)
+			if tc.wantErr {
+				assert.Error(t, err)
+				assert.Nil(t, rpl)
+				assert.Empty(t, conn.requests())
+				return
+			}
+			assert.NoError(t, err)
+			assert.True(t, rpl.IsUserOnline)
+			if tc.wantSent > 0 {
+				ts.observer.next(t, EventMsgDelivered)
+			}
+			assert.Len(t, conn.requests(), tc.wantSent)
+			if tc.wantDeferred {
+				assert.Equal(t, deferred+1, Metrics.Value(MetricMsgDeferred))
+			}
+		})
+	}
+}
+
+func TestIsLimitVersion(t *testing.T) {
+	limit := &LimitVersion{MinIosVersion: "1.0.0", MinAndroidVersion: "2.0.0"}
+	testCases := []struct {
+		name string
+		ua   *UserAgent
+		want bool
+	}{
+		{name: "ios-in-range", ua: &UserAgent{Source: CLIENT_SOURCE_IOS, AppVersion: "1.0.0"}},
+		{name: "android-too-old", ua: &UserAgent{Source: CLIENT_SOURCE_ANDROID, AppVersion: "1.0.0"}, want: true},
+		{name: "unknown-source", ua: &UserAgent{AppVersion: "9.0.0"}, want: true},
+		{name: "nil-ua", want: true},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			ts := newTestServer(t)
+			assert.Equal(t, tc.want, ts.isLimitVersion(&ConnectorClientWrapper{DeviceID: "d1", UA: tc.ua}, limit))
+		})
+	}
+}